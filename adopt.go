@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	symlabels "github.com/yangyongzhi/sym-operator/pkg/labels"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const adoptCommand = "adopt"
+
+// runAdopt generates a migrate manifest for the releases which have been installed by helm directly,
+// the revisions of the running releases are filled into the status so applying it causes no redeploy.
+//
+//	sym-operator adopt -app foo -kubeconfig ~/.kube/config > foo-migrate.yaml
+func runAdopt(args []string) error {
+	fs := flag.NewFlagSet(adoptCommand, flag.ExitOnError)
+	appName := fs.String("app", "", "The app name of the releases to adopt.")
+	namespace := fs.String("namespace", "", "The namespace of the generated migrate, default to the namespace of the releases.")
+	output := fs.String("o", "", "The file to write the migrate manifest to, default to stdout.")
+	adoptKubeconfig := fs.String("kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
+	adoptMasterURL := fs.String("master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig.")
+	adoptTillerHost := fs.String("tiller-host", "", "The address of tiller to connect to directly instead of port-forwarding to the tiller pod.")
+	adoptTillerNamespace := fs.String("tiller-namespace", helm.DefaultTillerNamespace, "The namespace tiller is installed in.")
+	var adoptTillerTLS helm.TLSOptions
+	addTillerTLSFlags(fs, &adoptTillerTLS)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *appName == "" {
+		return errors.New("app name must be specified")
+	}
+
+	cfg, err := buildConfig(*adoptMasterURL, *adoptKubeconfig)
+	if err != nil {
+		return errors.Wrap(err, "build kubeconfig")
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "build kubernetes clientset")
+	}
+	symClient, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return errors.Wrap(err, "build symphony clientset")
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	helmClient, err := newTillerClient(cfg, kubeClient, *adoptTillerHost, *adoptTillerNamespace, adoptTillerTLS, stopCh)
+	if err != nil {
+		return errors.Wrap(err, "build helm client")
+	}
+	defer helmClient.Close()
+
+	migrate, err := adoptReleases(helmClient, kubeClient, symClient, *appName, *namespace)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := yaml.Marshal(migrate)
+	if err != nil {
+		return errors.Wrap(err, "marshal migrate")
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(manifest)
+		return err
+	}
+	return ioutil.WriteFile(*output, manifest, 0644)
+}
+
+// adoptReleases builds a migrate from the running releases of the app, the chart of the latest released one
+// is used as the chart of the migrate. The releases already managed by another migrate are not adopted.
+func adoptReleases(helmClient helm.ReleaseBackend, kubeClient kubernetes.Interface, symClient clientset.Interface,
+	appName, namespace string) (*v1.Migrate, error) {
+	runningRlses, err := helmClient.FilterReleases(symlabels.MakeHelmReleaseFilter(appName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "list releases of app %s", appName)
+	}
+	if len(runningRlses) == 0 {
+		return nil, errors.Errorf("can not find any release of app %s", appName)
+	}
+	if namespace == "" {
+		namespace = runningRlses[0].Namespace
+	}
+
+	migrate := &v1.Migrate{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: v1.ResourceKind},
+		ObjectMeta: metav1.ObjectMeta{
+			// The migrate is always found by the app name of the deployments.
+			Name:      appName,
+			Namespace: namespace,
+		},
+		Spec: v1.MigrateSpec{
+			AppName: appName,
+		},
+		Status: v1.MigrateStatus{
+			Finished:        constant.ConditionStatusFalse,
+			ReleaseRevision: map[string]int32{},
+		},
+	}
+
+	owners, err := releaseOwners(symClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var chartName string
+	for _, runningRls := range runningRlses {
+		if owner, ok := owners[runningRls.Name]; ok && owner != namespace+"/"+appName {
+			return nil, errors.Errorf("release %s is owned by migrate %s", runningRls.Name, owner)
+		}
+		rls, err := helmClient.GetReleaseByVersion(runningRls.Name, runningRls.Version)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get release %s version %d", runningRls.Name, runningRls.Version)
+		}
+
+		metadata := rls.GetChart().GetMetadata()
+		if migrate.Spec.Chart == nil {
+			chartBytes, err := helm.SaveChartByte(rls.GetChart())
+			if err != nil {
+				return nil, errors.Wrapf(err, "save chart of release %s", rls.Name)
+			}
+			migrate.Spec.Chart = chartBytes
+			chartName = fmt.Sprintf("%s-%s", metadata.GetName(), metadata.GetVersion())
+		} else if name := fmt.Sprintf("%s-%s", metadata.GetName(), metadata.GetVersion()); name != chartName {
+			log.WithFields(log.Fields{log.FieldApp: migrate.Spec.AppName, log.FieldRelease: rls.Name}).
+				Warningf("The release uses chart %s instead of %s, it will be upgraded once the migrate is changed", name, chartName)
+		}
+
+		replicas, err := releaseReplicas(kubeClient, rls.Namespace, rls.Name, metadata.GetName())
+		if err != nil {
+			return nil, err
+		}
+
+		migrate.Spec.Releases = append(migrate.Spec.Releases, &v1.ReleasesConfig{
+			Name:      rls.Name,
+			Namespace: rls.Namespace,
+			Replicas:  replicas,
+			Raw:       rls.GetConfig().GetRaw(),
+		})
+		migrate.Status.ReleaseRevision[rls.Name] = rls.Version
+	}
+
+	return migrate, nil
+}
+
+// releaseOwners returns the namespace/name of the migrate managing each release.
+func releaseOwners(symClient clientset.Interface) (map[string]string, error) {
+	migrates, err := symClient.DevopsV1().Migrates(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list migrates")
+	}
+	owners := map[string]string{}
+	for _, migrate := range migrates.Items {
+		for _, rls := range migrate.Spec.Releases {
+			owners[rls.Name] = migrate.Namespace + "/" + migrate.Name
+		}
+	}
+	return owners, nil
+}
+
+// releaseReplicas returns the current replica count of the primary deployment of the release, the one named after
+// the release or after the release and the chart. Without a primary deployment the largest replica count of the
+// deployments of the release is used, so the adopted migrate never scales a deployment down.
+func releaseReplicas(kubeClient kubernetes.Interface, namespace, rlsName, chartName string) (int32, error) {
+	selector := labels.SelectorFromSet(labels.Set{constant.ReleaseLabel: rlsName})
+	deployments, err := kubeClient.AppsV1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return 0, errors.Wrapf(err, "list deployments of release %s", rlsName)
+	}
+	if len(deployments.Items) == 0 {
+		log.WithFields(log.Fields{log.FieldRelease: rlsName}).Warning("Can not find any deployment of the release, replicas is set to 0")
+		return 0, nil
+	}
+
+	var replicas int32
+	for i := range deployments.Items {
+		deploy := &deployments.Items[i]
+		if deploy.Name == rlsName || deploy.Name == fmt.Sprintf("%s-%s", rlsName, chartName) {
+			return deploymentReplicas(deploy), nil
+		}
+		if r := deploymentReplicas(deploy); r > replicas {
+			replicas = r
+		}
+	}
+	if len(deployments.Items) > 1 {
+		log.WithFields(log.Fields{log.FieldRelease: rlsName}).
+			Warningf("Can not find the primary deployment of the release, replicas is set to the largest one %d", replicas)
+	}
+	return replicas, nil
+}
+
+// deploymentReplicas returns the desired replicas of the deployment, which defaults to 1.
+func deploymentReplicas(deploy *appsv1.Deployment) int32 {
+	if deploy.Spec.Replicas == nil {
+		return 1
+	}
+	return *deploy.Spec.Replicas
+}
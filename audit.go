@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
+	"github.com/yangyongzhi/sym-operator/pkg/config"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	symlabels "github.com/yangyongzhi/sym-operator/pkg/labels"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// auditTrail records the installs, the upgrades, the uninstalls and the rollbacks of the releases as ReleaseOperation
+// resources in the namespaces of their migrates, and garbage-collects the records beyond the retention.
+type auditTrail struct {
+	client clientset.Interface
+	config config.AuditConfiguration
+	// managedFields returns the managed fields of the migrate.
+	managedFields func(namespace, name string) ([]managedFieldsEntry, error)
+
+	mu sync.Mutex
+	// managers caches the last spec manager of each migrate by namespace/name, the managed fields are only got again
+	// once the resource version of the migrate has changed.
+	managers map[string]specManager
+}
+
+// specManager is the last spec manager of a migrate at the resource version.
+type specManager struct {
+	resourceVersion string
+	manager         string
+}
+
+func newAuditTrail(client clientset.Interface, cfg config.AuditConfiguration) *auditTrail {
+	return &auditTrail{client: client, config: cfg, managedFields: restManagedFields(client.DevopsV1().RESTClient()),
+		managers: map[string]specManager{}}
+}
+
+// releaseOperationsServed tells whether the ReleaseOperation resource has been installed, the operations can not be
+// recorded before its CRD is.
+func releaseOperationsServed(client discovery.DiscoveryInterface) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(v1.SchemeGroupVersion.String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "discover the resources of %s", v1.SchemeGroupVersion)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == "releaseoperations" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// managedFieldsEntry is an entry of metadata.managedFields, the vendored ObjectMeta predates them so they are decoded
+// from the raw migrate.
+type managedFieldsEntry struct {
+	Manager   string       `json:"manager"`
+	Operation string       `json:"operation"`
+	Time      *metav1.Time `json:"time,omitempty"`
+	// Fields are the fields owned by the manager, they are named fieldsV1 since Kubernetes 1.17.
+	Fields   json.RawMessage `json:"fields,omitempty"`
+	FieldsV1 json.RawMessage `json:"fieldsV1,omitempty"`
+}
+
+// restManagedFields gets the managed fields of the migrates from the API server.
+func restManagedFields(client rest.Interface) func(namespace, name string) ([]managedFieldsEntry, error) {
+	return func(namespace, name string) ([]managedFieldsEntry, error) {
+		data, err := client.Get().Namespace(namespace).Resource("migrates").Name(name).DoRaw()
+		if err != nil {
+			return nil, err
+		}
+		var object struct {
+			Metadata struct {
+				ManagedFields []managedFieldsEntry `json:"managedFields"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, errors.Wrap(err, "decode migrate")
+		}
+		return object.Metadata.ManagedFields, nil
+	}
+}
+
+// lastSpecManager returns the manager which has changed the spec last, it is empty if no manager owns a field of the
+// spec.
+func lastSpecManager(entries []managedFieldsEntry) string {
+	var latest *managedFieldsEntry
+	for i := range entries {
+		entry := &entries[i]
+		fields := entry.FieldsV1
+		if len(fields) == 0 {
+			fields = entry.Fields
+		}
+		if !bytes.Contains(fields, []byte(`"f:spec"`)) {
+			continue
+		}
+		if latest == nil || (entry.Time != nil && (latest.Time == nil || !entry.Time.Before(latest.Time))) {
+			latest = entry
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Manager
+}
+
+// changedByManager returns the manager which has changed the spec of the migrate last, it is empty if it is unknown.
+// The managed fields are got once per resource version of the migrate, however many releases a sync operates on.
+func (a *auditTrail) changedByManager(migrate *v1.Migrate) string {
+	key := migrate.Namespace + "/" + migrate.Name
+	a.mu.Lock()
+	cached, ok := a.managers[key]
+	a.mu.Unlock()
+	if ok && cached.resourceVersion == migrate.ResourceVersion {
+		return cached.manager
+	}
+
+	entries, err := a.managedFields(migrate.Namespace, migrate.Name)
+	if err != nil {
+		log.WithFields(log.Fields{log.FieldMigrate: key}).
+			Debugf("Get the managed fields of the migrate has an error : %s", err.Error())
+		return ""
+	}
+	manager := lastSpecManager(entries)
+	a.mu.Lock()
+	a.managers[key] = specManager{resourceVersion: migrate.ResourceVersion, manager: manager}
+	a.mu.Unlock()
+	return manager
+}
+
+// forgetManagers drops the cached spec managers, so the ones of the deleted migrates do not pile up.
+func (a *auditTrail) forgetManagers() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.managers = map[string]specManager{}
+}
+
+// expired returns the records of a migrate beyond the retention: the ones older than the max age, then all but the
+// latest max records.
+func (a *auditTrail) expired(records []v1.ReleaseOperation, now time.Time) []v1.ReleaseOperation {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Spec.StartTime.Equal(&records[j].Spec.StartTime) {
+			return records[j].Spec.StartTime.Before(&records[i].Spec.StartTime)
+		}
+		return records[i].Name > records[j].Name
+	})
+	var expired []v1.ReleaseOperation
+	for i, record := range records {
+		if (a.config.MaxRecords > 0 && i >= a.config.MaxRecords) ||
+			(a.config.MaxAge.Duration > 0 && now.Sub(record.Spec.StartTime.Time) > a.config.MaxAge.Duration) {
+			expired = append(expired, record)
+		}
+	}
+	return expired
+}
+
+// deleteExpired deletes the records of a migrate beyond the retention.
+func (a *auditTrail) deleteExpired(records []v1.ReleaseOperation) error {
+	for _, record := range a.expired(records, time.Now()) {
+		err := a.client.DevopsV1().ReleaseOperations(record.Namespace).Delete(record.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete release operation %s/%s", record.Namespace, record.Name)
+		}
+	}
+	return nil
+}
+
+// recordReleaseOperation writes the record of the operation on a release of the migrate, the records beyond the
+// retention are left to sweepReleaseOperations. The operation is not failed if it can not be recorded.
+func (c *Controller) recordReleaseOperation(migrate *v1.Migrate, spec v1.ReleaseOperationSpec) {
+	spec.Migrate, spec.MigrateUID, spec.Generation = migrate.Name, migrate.UID, migrate.Generation
+	spec.App = migrate.Spec.AppName
+	spec.ChangedByManager = c.audit.changedByManager(migrate)
+	if traceID := c.traceOf(migrate).TraceID(); traceID.IsValid() {
+		spec.TraceID = traceID.String()
+	}
+	record := &v1.ReleaseOperation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s-%s-%s-%s", spec.Release, strings.ToLower(string(spec.Operation)),
+				spec.StartTime.UTC().Format("20060102150405"), rand.String(5)),
+			Namespace: migrate.Namespace,
+			Labels: map[string]string{
+				symlabels.LabelCreatedBy:   symlabels.ControllerName,
+				symlabels.LabelMigrateName: migrate.Name,
+				symlabels.LabelAppName:     migrate.Spec.AppName,
+				symlabels.LabelReleaseName: spec.Release,
+			},
+		},
+		Spec: spec,
+	}
+
+	logger := c.logFor(migrate).WithField(log.FieldRelease, spec.Release)
+	err := c.traceWrite(migrate, "create", "releaseoperations", record.Namespace, record.Name, func() error {
+		_, err := c.symclientset.DevopsV1().ReleaseOperations(record.Namespace).Create(record)
+		return err
+	})
+	if err != nil {
+		logger.Warningf("Record the %s of the release has an error : %s", spec.Operation, err.Error())
+	}
+}
+
+// sweepReleaseOperations garbage-collects the records of all migrates synced by this replica, including the migrates
+// which have been deleted since.
+func (c *Controller) sweepReleaseOperations() {
+	c.audit.forgetManagers()
+	for _, namespace := range c.namespaces {
+		list, err := c.symclientset.DevopsV1().ReleaseOperations(namespace).List(metav1.ListOptions{
+			LabelSelector: symlabels.GetCrdLabelSelector(),
+		})
+		if err != nil {
+			log.Warningf("List the release operations has an error : %s", err.Error())
+			continue
+		}
+		byMigrate := map[string][]v1.ReleaseOperation{}
+		for _, record := range list.Items {
+			if c.shards != nil && !c.shards.Owns(record.Spec.App) {
+				continue
+			}
+			key := record.Namespace + "/" + record.Spec.Migrate
+			byMigrate[key] = append(byMigrate[key], record)
+		}
+		for key, records := range byMigrate {
+			if err := c.audit.deleteExpired(records); err != nil {
+				log.WithFields(log.Fields{log.FieldMigrate: key}).
+					Warningf("Garbage-collect the release operations has an error : %s", err.Error())
+			}
+		}
+	}
+}
+
+// audited records the operations of the backend on the releases of the migrate in the cluster in the audit trail and
+// the history, the backend is returned as is if the operations are recorded in neither.
+func (c *Controller) audited(migrate *v1.Migrate, cluster string, backend helm.ReleaseBackend) helm.ReleaseBackend {
+	if c.audit == nil && c.history == nil {
+		return backend
+	}
+	return &auditedBackend{ReleaseBackend: backend, controller: c, migrate: migrate, cluster: cluster}
+}
+
+// auditedBackend records the installs, the upgrades, the uninstalls and the rollbacks of the backend.
+type auditedBackend struct {
+	helm.ReleaseBackend
+	controller *Controller
+	migrate    *v1.Migrate
+	cluster    string
+}
+
+// spec describes the operation on the release which has started at the time and returned the release.
+func (b *auditedBackend) spec(operation v1.ReleaseOperationType, rlsName string, start time.Time, rls *release.Release,
+	err error) v1.ReleaseOperationSpec {
+	spec := v1.ReleaseOperationSpec{
+		Release:        rlsName,
+		Cluster:        b.cluster,
+		Operation:      operation,
+		Result:         v1.ReleaseOperationSucceeded,
+		StartTime:      metav1.NewTime(start),
+		CompletionTime: metav1.Now(),
+	}
+	if err != nil {
+		spec.Result, spec.Message = v1.ReleaseOperationFailed, err.Error()
+	}
+	if rls != nil {
+		spec.ReleaseNamespace, spec.Revision = rls.GetNamespace(), rls.GetVersion()
+		spec.ChartName, spec.ChartVersion = rls.GetChart().GetMetadata().GetName(), rls.GetChart().GetMetadata().GetVersion()
+	}
+	return spec
+}
+
+// withChart sets the chart of the operation from the archive unless the release has told it.
+func withChart(spec v1.ReleaseOperationSpec, chartBytes []byte) v1.ReleaseOperationSpec {
+	if spec.ChartName != "" {
+		return spec
+	}
+	if chart, err := chartutil.LoadArchive(bytes.NewReader(chartBytes)); err == nil {
+		spec.ChartName, spec.ChartVersion = chart.GetMetadata().GetName(), chart.GetMetadata().GetVersion()
+	}
+	return spec
+}
+
+// valuesDigest returns the sha256 digest of the values of a release.
+func valuesDigest(raw string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(raw)))
+}
+
+func (b *auditedBackend) InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*release.Release, error) {
+	start := time.Now()
+	rls, err := b.ReleaseBackend.InstallRelease(namespace, releaseName, chartBytes, raw)
+	spec := withChart(b.spec(v1.ReleaseOperationInstall, releaseName, start, rls, err), chartBytes)
+	spec.ReleaseNamespace, spec.ValuesDigest = namespace, valuesDigest(raw)
+	b.controller.recordOperation(b.migrate, spec)
+	return rls, err
+}
+
+func (b *auditedBackend) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error) {
+	start := time.Now()
+	rls, err := b.ReleaseBackend.UpdateRelease(rlsName, chartBytes, raw)
+	spec := withChart(b.spec(v1.ReleaseOperationUpgrade, rlsName, start, rls, err), chartBytes)
+	spec.ValuesDigest = valuesDigest(raw)
+	b.controller.recordOperation(b.migrate, spec)
+	return rls, err
+}
+
+func (b *auditedBackend) UninstallRelease(rlsName string) error {
+	start := time.Now()
+	err := b.ReleaseBackend.UninstallRelease(rlsName)
+	b.controller.recordOperation(b.migrate, b.spec(v1.ReleaseOperationUninstall, rlsName, start, nil, err))
+	return err
+}
+
+func (b *auditedBackend) RollbackRelease(rlsName string, version int32) (*release.Release, error) {
+	start := time.Now()
+	rls, err := b.ReleaseBackend.RollbackRelease(rlsName, version)
+	spec := b.spec(v1.ReleaseOperationRollback, rlsName, start, rls, err)
+	if rls != nil {
+		spec.ValuesDigest = valuesDigest(rls.GetConfig().GetRaw())
+	}
+	b.controller.recordOperation(b.migrate, spec)
+	return rls, err
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/fake"
+	"github.com/yangyongzhi/sym-operator/pkg/config"
+	symlabels "github.com/yangyongzhi/sym-operator/pkg/labels"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func managedFields(manager string, at time.Time, fields string) managedFieldsEntry {
+	t := metav1.NewTime(at)
+	return managedFieldsEntry{Manager: manager, Operation: "Update", Time: &t, FieldsV1: json.RawMessage(fields)}
+}
+
+func TestReleaseOperationsServed(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []metav1.APIResource
+		expected  bool
+	}{
+		{
+			name:      "crd installed",
+			resources: []metav1.APIResource{{Name: "migrates"}, {Name: "releaseoperations"}},
+			expected:  true,
+		},
+		{
+			name:      "crd missing",
+			resources: []metav1.APIResource{{Name: "migrates"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+				{GroupVersion: v1.SchemeGroupVersion.String(), APIResources: test.resources},
+			}
+			served, err := releaseOperationsServed(client.Discovery())
+			if err != nil {
+				t.Fatalf("probe release operations: %v", err)
+			}
+			if served != test.expected {
+				t.Errorf("expected served %t, got %t", test.expected, served)
+			}
+		})
+	}
+}
+
+func TestLastSpecManager(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		entries  []managedFieldsEntry
+		expected string
+	}{
+		{name: "no managed fields"},
+		{
+			name: "status only",
+			entries: []managedFieldsEntry{
+				managedFields("sym-operator", now, `{"f:status":{"f:finished":{}}}`),
+			},
+		},
+		{
+			name: "latest manager of the spec",
+			entries: []managedFieldsEntry{
+				managedFields("kubectl", now.Add(-time.Hour), `{"f:spec":{"f:releases":{}}}`),
+				managedFields("deployer", now.Add(-time.Minute), `{"f:spec":{"f:chart":{}}}`),
+				managedFields("sym-operator", now, `{"f:status":{"f:finished":{}}}`),
+			},
+			expected: "deployer",
+		},
+		{
+			name: "fields before kubernetes 1.17",
+			entries: []managedFieldsEntry{
+				{Manager: "kubectl", Operation: "Apply", Fields: json.RawMessage(`{"f:spec":{"f:appName":{}}}`)},
+			},
+			expected: "kubectl",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if manager := lastSpecManager(test.entries); manager != test.expected {
+				t.Errorf("expected the manager %q, got %q", test.expected, manager)
+			}
+		})
+	}
+}
+
+// releaseOperations returns the records in the namespace of the migrate, the oldest first.
+func releaseOperations(t *testing.T, f *fixture) []v1.ReleaseOperation {
+	list, err := f.client.DevopsV1().ReleaseOperations(metav1.NamespaceDefault).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list release operations: %v", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Spec.StartTime.Before(&list.Items[j].Spec.StartTime) })
+	return list.Items
+}
+
+func TestSyncRecordsReleaseOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		migrate  *v1.Migrate
+		running  []*release.Release
+		failures map[string]error
+		expected v1.ReleaseOperationSpec
+	}{
+		{
+			name:    "install",
+			migrate: newMigrate(blueRelease),
+			expected: v1.ReleaseOperationSpec{Release: blueRelease, ReleaseNamespace: metav1.NamespaceDefault,
+				Operation: v1.ReleaseOperationInstall, Result: v1.ReleaseOperationSucceeded, Revision: 1},
+		},
+		{
+			name:     "failed upgrade",
+			migrate:  withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}),
+			running:  []*release.Release{runningRelease(blueRelease, 2)},
+			failures: map[string]error{blueRelease: fmt.Errorf("timed out")},
+			expected: v1.ReleaseOperationSpec{Release: blueRelease, Operation: v1.ReleaseOperationUpgrade,
+				Result: v1.ReleaseOperationFailed, Message: "timed out"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.running...)
+			for rlsName, err := range test.failures {
+				f.backend.FailOn("update", rlsName, err)
+			}
+			test.migrate.Generation, test.migrate.UID = 3, "uid"
+			f.addMigrate(test.migrate)
+			c, i, k8sI := f.newController()
+			c.audit = newAuditTrail(f.client, config.Default().Audit)
+			c.audit.managedFields = func(namespace, name string) ([]managedFieldsEntry, error) {
+				return []managedFieldsEntry{managedFields("kubectl", time.Now(), `{"f:spec":{"f:releases":{}}}`)}, nil
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			i.Start(stopCh)
+			k8sI.Start(stopCh)
+
+			key := getKey(test.migrate, t)
+			if err := c.syncHandler(key); err != nil {
+				t.Fatalf("error syncing migrate: %v", err)
+			}
+
+			records := releaseOperations(t, f)
+			if len(records) != 1 {
+				t.Fatalf("expected one release operation, got %v", records)
+			}
+			record := records[0]
+			expectedLabels := map[string]string{symlabels.LabelCreatedBy: symlabels.ControllerName,
+				symlabels.LabelMigrateName: testApp, symlabels.LabelAppName: testApp, symlabels.LabelReleaseName: blueRelease}
+			if !reflect.DeepEqual(record.Labels, expectedLabels) {
+				t.Errorf("expected the labels %v, got %v", expectedLabels, record.Labels)
+			}
+			if record.Spec.StartTime.IsZero() || record.Spec.CompletionTime.Before(&record.Spec.StartTime) {
+				t.Errorf("expected the operation to complete after it has started, got %s and %s",
+					record.Spec.StartTime, record.Spec.CompletionTime)
+			}
+			if record.Spec.TraceID != c.syncStates.get(key).LastTraceID {
+				t.Errorf("expected the trace %s of the sync, got %s", c.syncStates.get(key).LastTraceID, record.Spec.TraceID)
+			}
+
+			expected := test.expected
+			expected.Migrate, expected.MigrateUID, expected.Generation, expected.ChangedByManager = testApp, "uid", 3, "kubectl"
+			expected.App, expected.ValuesDigest = testApp, valuesDigest("replicaCount: 2")
+			expected.StartTime, expected.CompletionTime, expected.TraceID =
+				record.Spec.StartTime, record.Spec.CompletionTime, record.Spec.TraceID
+			if !reflect.DeepEqual(record.Spec, expected) {
+				t.Errorf("expected the release operation\n%+v\ngot\n%+v", expected, record.Spec)
+			}
+		})
+	}
+}
+
+func TestSyncGetsManagedFieldsOnce(t *testing.T) {
+	// Both the green and the rz releases are pruned in the sync.
+	f := newFixture(t, runningRelease(blueRelease, 1), runningRelease(greenRelease, 1), runningRelease(rzRelease, 1))
+	migrate := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1})
+	migrate.Spec.PruneLimit = int32Ptr(2)
+	f.addMigrate(migrate)
+	c, i, k8sI := f.newController()
+	c.audit = newAuditTrail(f.client, config.Default().Audit)
+	var gets int
+	c.audit.managedFields = func(namespace, name string) ([]managedFieldsEntry, error) {
+		gets++
+		return []managedFieldsEntry{managedFields("kubectl", time.Now(), `{"f:spec":{"f:releases":{}}}`)}, nil
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
+
+	if err := c.syncHandler(getKey(migrate, t)); err != nil {
+		t.Fatalf("error syncing migrate: %v", err)
+	}
+
+	// The records are only garbage-collected by the sweep.
+	for _, action := range f.client.Actions() {
+		if action.GetResource().Resource == "releaseoperations" && action.GetVerb() != "create" {
+			t.Errorf("expected only the records to be created in the sync, got %s", action.GetVerb())
+		}
+	}
+
+	records := releaseOperations(t, f)
+	if len(records) != 2 {
+		t.Fatalf("expected both prunes to be recorded, got %v", records)
+	}
+	for _, record := range records {
+		if record.Spec.ChangedByManager != "kubectl" {
+			t.Errorf("expected release %s to be changed by kubectl, got %q", record.Spec.Release, record.Spec.ChangedByManager)
+		}
+	}
+	if gets != 1 {
+		t.Errorf("expected the managed fields to be got once in the sync, got %d times", gets)
+	}
+}
+
+func TestSyncWithoutAuditRecordsNothing(t *testing.T) {
+	f := newFixture(t)
+	migrate := newMigrate(blueRelease)
+	f.addMigrate(migrate)
+	f.run(migrate)
+
+	if records := releaseOperations(t, f); len(records) != 0 {
+		t.Errorf("expected no release operation, got %v", records)
+	}
+}
+
+func newReleaseOperation(name, migrate, app string, started time.Time) *v1.ReleaseOperation {
+	return &v1.ReleaseOperation{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault, Labels: map[string]string{
+			symlabels.LabelCreatedBy: symlabels.ControllerName, symlabels.LabelMigrateName: migrate, symlabels.LabelAppName: app,
+		}},
+		Spec: v1.ReleaseOperationSpec{Migrate: migrate, App: app, StartTime: metav1.NewTime(started)},
+	}
+}
+
+func recordNames(t *testing.T, f *fixture) []string {
+	var names []string
+	for _, record := range releaseOperations(t, f) {
+		names = append(names, record.Name)
+	}
+	return names
+}
+
+func TestReleaseOperationRetention(t *testing.T) {
+	now := time.Now()
+	f := newFixture(t)
+	f.objects = append(f.objects,
+		newReleaseOperation("demo-1", "demo", "demo", now.Add(-48*time.Hour)),
+		newReleaseOperation("demo-2", "demo", "demo", now.Add(-3*time.Hour)),
+		newReleaseOperation("demo-3", "demo", "demo", now.Add(-2*time.Hour)),
+		newReleaseOperation("demo-4", "demo", "demo", now.Add(-time.Hour)),
+		newReleaseOperation("other-1", "other", "other", now.Add(-48*time.Hour)),
+		newReleaseOperation("other-2", "other", "other", now.Add(-30*time.Minute)),
+	)
+	c, _, _ := f.newController()
+	c.audit = newAuditTrail(f.client, config.AuditConfiguration{
+		MaxRecords: 2,
+		MaxAge:     metav1.Duration{Duration: 24 * time.Hour},
+	})
+
+	// The records of the apps of the shards of other replicas are left to them.
+	c.shards = fakeShards{"demo": true}
+	c.sweepReleaseOperations()
+	if expected := []string{"other-1", "demo-3", "demo-4", "other-2"}; !reflect.DeepEqual(recordNames(t, f), expected) {
+		t.Errorf("expected the records %v, got %v", expected, recordNames(t, f))
+	}
+	c.shards = nil
+	c.sweepReleaseOperations()
+	if expected := []string{"demo-3", "demo-4", "other-2"}; !reflect.DeepEqual(recordNames(t, f), expected) {
+		t.Errorf("expected the records %v, got %v", expected, recordNames(t, f))
+	}
+}
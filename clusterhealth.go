@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
+	listers "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/cluster"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+// clusterHealthChecker probes the registered clusters and records the results in their status.
+type clusterHealthChecker struct {
+	symclientset   clientset.Interface
+	clustersLister listers.ClusterLister
+	clusters       cluster.Provider
+}
+
+func newClusterHealthChecker(symclientset clientset.Interface, clustersLister listers.ClusterLister,
+	clusters cluster.Provider) *clusterHealthChecker {
+	return &clusterHealthChecker{symclientset: symclientset, clustersLister: clustersLister, clusters: clusters}
+}
+
+// Run probes the registered clusters every interval until the stop channel is closed.
+func (h *clusterHealthChecker) Run(interval time.Duration, clustersSynced cache.InformerSynced, stopCh <-chan struct{}) {
+	if ok := cache.WaitForCacheSync(stopCh, clustersSynced); !ok {
+		log.Errorf("Failed to wait for the cache of clusters to sync")
+		return
+	}
+	wait.Until(h.checkAll, interval, stopCh)
+}
+
+func (h *clusterHealthChecker) checkAll() {
+	registered, err := h.clustersLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("List the registered clusters has an error : %s", err.Error())
+		return
+	}
+	for _, c := range registered {
+		if err := h.check(c); err != nil {
+			log.WithFields(log.Fields{log.FieldCluster: c.Namespace + "/" + c.Name}).WithError(err).Error("Update the status of the cluster has an error")
+		}
+	}
+}
+
+// check probes the cluster and writes its status, the cluster is not updated if the probe has changed nothing but
+// the probe time.
+func (h *clusterHealthChecker) check(registered *v1.Cluster) error {
+	status := h.probe(registered)
+	previous := registered.Status
+	previous.LastProbeTime = status.LastProbeTime
+	if reflect.DeepEqual(previous, status) {
+		return nil
+	}
+	if status.Healthy != registered.Status.Healthy {
+		log.WithFields(log.Fields{log.FieldCluster: registered.Namespace + "/" + registered.Name}).
+			Infof("The health of the cluster has been changed to %t : %s", status.Healthy, status.Message)
+	}
+
+	clusterCopy := registered.DeepCopy()
+	clusterCopy.Status = status
+	_, err := h.symclientset.DevopsV1().Clusters(registered.Namespace).UpdateStatus(clusterCopy)
+	return err
+}
+
+// probe checks whether the kubernetes API and tiller of the cluster are reachable.
+func (h *clusterHealthChecker) probe(registered *v1.Cluster) v1.ClusterStatus {
+	now := metav1.Now()
+	status := v1.ClusterStatus{LastProbeTime: &now}
+
+	target, err := h.clusters.Get(registered.Namespace, registered.Spec.KubeconfigSecret)
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+	version, err := target.KubeClient.Discovery().ServerVersion()
+	if err != nil {
+		status.Message = fmt.Sprintf("kubernetes API is unreachable : %s", err.Error())
+		return status
+	}
+	status.Reachable = true
+	status.KubernetesVersion = version.GitVersion
+
+	backend, release, err := target.HelmClients.Get(registered.Spec.TillerNamespace)
+	if err == nil {
+		defer release()
+		// The tiller-less backend has no version.
+		if versioned, ok := backend.(interface{ TillerVersion() (string, error) }); ok {
+			status.TillerVersion, err = versioned.TillerVersion()
+		} else {
+			err = backend.KeepLive()
+		}
+	}
+	if err != nil {
+		status.Message = fmt.Sprintf("tiller is unreachable : %s", err.Error())
+		return status
+	}
+
+	status.Healthy = true
+	return status
+}
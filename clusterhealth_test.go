@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/fake"
+	informers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+)
+
+func TestClusterHealthCheck(t *testing.T) {
+	reachable := newFakeCluster("reachable-kubeconfig", helmtest.NewFakeBackend())
+	reachable.KubeClient.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.13.4"}
+	tillerDown := helmtest.NewFakeBackend()
+	tillerDown.FailOn(helm.OperationKeepLive, "", fmt.Errorf("tiller pod is not running"))
+	clusters := fakeClusters{
+		"reachable-kubeconfig":   reachable,
+		"tiller-down-kubeconfig": newFakeCluster("tiller-down-kubeconfig", tillerDown),
+	}
+
+	tests := []struct {
+		name              string
+		registered        *v1.Cluster
+		expectedHealthy   bool
+		expectedReachable bool
+		expectedVersion   string
+	}{
+		{
+			name:              "healthy cluster",
+			registered:        newRegisteredCluster("reachable", "sh", false),
+			expectedHealthy:   true,
+			expectedReachable: true,
+			expectedVersion:   "v1.13.4",
+		},
+		{
+			name:              "tiller is unreachable",
+			registered:        newRegisteredCluster("tiller-down", "sh", true),
+			expectedReachable: true,
+		},
+		{
+			name:       "kubeconfig secret is missing",
+			registered: newRegisteredCluster("missing", "sh", true),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(test.registered)
+			i := informers.NewSharedInformerFactory(client, noResyncPeriodFunc())
+			i.Devops().V1().Clusters().Informer().GetIndexer().Add(test.registered)
+			checker := newClusterHealthChecker(client, i.Devops().V1().Clusters().Lister(), clusters)
+
+			checker.checkAll()
+
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "update" && action.GetSubresource() != "status" {
+					t.Errorf("expected the status to be written through the status subresource, got %v", action)
+				}
+			}
+			updated, err := client.DevopsV1().Clusters(metav1.NamespaceDefault).Get(test.registered.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get cluster: %v", err)
+			}
+			status := updated.Status
+			if status.Healthy != test.expectedHealthy || status.Reachable != test.expectedReachable {
+				t.Errorf("expected healthy %v and reachable %v, got %+v", test.expectedHealthy, test.expectedReachable, status)
+			}
+			if test.expectedVersion != "" && status.KubernetesVersion != test.expectedVersion {
+				t.Errorf("expected kubernetes version %s, got %s", test.expectedVersion, status.KubernetesVersion)
+			}
+			if !status.Healthy && status.Message == "" {
+				t.Errorf("expected a message explaining why the cluster is unhealthy")
+			}
+			if status.LastProbeTime == nil {
+				t.Errorf("expected the probe time to be recorded")
+			}
+
+			// The cluster is not written again while its status is unchanged.
+			i.Devops().V1().Clusters().Informer().GetIndexer().Update(updated)
+			client.ClearActions()
+			checker.checkAll()
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "update" {
+					t.Errorf("expected no update of the unchanged status, got %v", action)
+				}
+			}
+		})
+	}
+}
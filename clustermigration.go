@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The reasons of the events of the cluster migration.
+const (
+	ReasonClusterMigrationInstalled = "ClusterMigrationInstalled"
+	ReasonClusterMigrationVerified  = "ClusterMigrationVerified"
+	ReasonClusterMigrationSwitched  = "ClusterMigrationSwitched"
+	ReasonClusterMigrationCompleted = "ClusterMigrationCompleted"
+	ReasonClusterMigrationWaiting   = "ClusterMigrationWaiting"
+	ReasonClusterMigrationFailed    = "ClusterMigrationFailed"
+)
+
+var clusterMigrationReasons = map[v1.ClusterMigrationPhase]string{
+	v1.ClusterMigrationInstalled: ReasonClusterMigrationInstalled,
+	v1.ClusterMigrationVerified:  ReasonClusterMigrationVerified,
+	v1.ClusterMigrationSwitched:  ReasonClusterMigrationSwitched,
+	v1.ClusterMigrationCompleted: ReasonClusterMigrationCompleted,
+}
+
+// httpCheckClient sends the requests of the http checks.
+var httpCheckClient = &http.Client{Timeout: 5 * time.Second}
+
+// migrateCluster moves the releases of the migrate to the destination cluster step by step: install, verify, switch
+// the traffic and drain the source, the phase reached is checkpointed in the status so the migration resumes from
+// there after a restart.
+func (c *Controller) migrateCluster(migrate *v1.Migrate) error {
+	if migrate.Status.Finished == constant.ConditionStatusTrue {
+		c.logFor(migrate).Info("The cluster migration has finished, nothing to do")
+		return nil
+	}
+
+	config := migrate.Spec.ClusterMigration
+	if config == nil || config.SourceCluster == config.DestinationCluster {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ReasonClusterMigrationFailed,
+			"The source and the destination cluster of the cluster migration should be different")
+		return nil
+	}
+	source, err := c.clusterTarget(migrate, config.SourceCluster)
+	if err != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ErrHelmClient,
+			fmt.Sprintf("Can not connect to the source cluster [%s] : %s", config.SourceCluster, err.Error()))
+		return err
+	}
+	defer source.release()
+	destination, err := c.clusterTarget(migrate, config.DestinationCluster)
+	if err != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ErrHelmClient,
+			fmt.Sprintf("Can not connect to the destination cluster [%s] : %s", config.DestinationCluster, err.Error()))
+		return err
+	}
+	defer destination.release()
+
+	migrateCopy := migrate.DeepCopy()
+	status := migrateCopy.Status.ClusterMigration
+	if status == nil {
+		status = &v1.ClusterMigrationStatus{}
+		migrateCopy.Status.ClusterMigration = status
+	}
+
+	// Each step moves on to the next one once it has been done, the migration stops at the first step waiting
+	// for something and continues in the next sync.
+	for done := false; !done && status.Phase != v1.ClusterMigrationCompleted; {
+		switch status.Phase {
+		case "":
+			done = c.checkpoint(migrateCopy, v1.ClusterMigrationInstalled, c.installDestination(migrate, destination))
+		case v1.ClusterMigrationInstalled:
+			done = c.checkpoint(migrateCopy, v1.ClusterMigrationVerified, verifyDestination(migrate, destination))
+		case v1.ClusterMigrationVerified:
+			err := c.switchTraffic(migrateCopy)
+			if err == nil {
+				now := metav1.Now()
+				status.SwitchedTime = &now
+			}
+			done = c.checkpoint(migrateCopy, v1.ClusterMigrationSwitched, err)
+		case v1.ClusterMigrationSwitched:
+			done = c.checkpoint(migrateCopy, v1.ClusterMigrationCompleted, c.drainSource(migrateCopy, source))
+		default:
+			c.recorder.Event(migrate, corev1.EventTypeWarning, ReasonClusterMigrationFailed,
+				fmt.Sprintf("Unknown phase [%s] of the cluster migration", status.Phase))
+			return nil
+		}
+	}
+
+	now := metav1.Now()
+	migrateCopy.Status.LastUpdateTime = &now
+	if status.Phase == v1.ClusterMigrationCompleted {
+		migrateCopy.Status.Finished = constant.ConditionStatusTrue
+	} else {
+		migrateCopy.Status.Finished = constant.ConditionStatusFalse
+	}
+
+	return c.traceWrite(migrate, "update", "migrates", migrate.Namespace, migrate.Name, func() error {
+		_, err := c.symclientset.DevopsV1().Migrates(migrate.Namespace).Update(migrateCopy)
+		return err
+	})
+}
+
+// checkpoint moves the cluster migration to the phase if the step has no error, it returns true if the migration
+// should stop and wait for the next sync.
+func (c *Controller) checkpoint(migrateCopy *v1.Migrate, phase v1.ClusterMigrationPhase, err error) bool {
+	status := migrateCopy.Status.ClusterMigration
+	if err != nil {
+		status.Message = err.Error()
+		c.logFor(migrateCopy).WithField("phase", status.Phase).Infof("The cluster migration is waiting : %s", err.Error())
+		c.recorder.Event(migrateCopy, corev1.EventTypeNormal, ReasonClusterMigrationWaiting, status.Message)
+		return true
+	}
+
+	now := metav1.Now()
+	status.Phase = phase
+	status.LastTransitionTime = &now
+	status.Message = fmt.Sprintf("The cluster migration has reached phase %s", phase)
+	c.logFor(migrateCopy).WithField("phase", phase).Info("The cluster migration has reached the phase")
+	c.recorder.Event(migrateCopy, corev1.EventTypeNormal, clusterMigrationReasons[phase], status.Message)
+	return false
+}
+
+// installDestination installs the releases which are not running in the destination cluster yet.
+func (c *Controller) installDestination(migrate *v1.Migrate, destination *target) error {
+	running, err := runningReleaseNames(migrate, destination)
+	if err != nil {
+		return err
+	}
+	for _, rls := range migrate.Spec.Releases {
+		if running[rls.Name] {
+			continue
+		}
+		installed, err := destination.helmClient.InstallRelease(rls.Namespace, rls.Name, migrate.Spec.Chart, rls.Raw)
+		if err != nil {
+			return errors.Wrapf(err, "install release %s%s", rls.Name, destination.location())
+		}
+		c.recorder.Event(migrate, corev1.EventTypeNormal, SuccessInstalledStatus,
+			fmt.Sprintf("Install release [%s]%s successfully, version : %d", rls.Name, destination.location(), installed.Version))
+	}
+	return nil
+}
+
+// verifyDestination checks that the deployments of all releases are available in the destination cluster and
+// the http checks pass.
+func verifyDestination(migrate *v1.Migrate, destination *target) error {
+	selector := labels.SelectorFromSet(labels.Set{constant.AppLabel: migrate.Spec.AppName})
+	deployments, err := destination.listDeployments(migrate.Namespace, selector)
+	if err != nil {
+		return errors.Wrapf(err, "list deployments%s", destination.location())
+	}
+	for _, rls := range migrate.Spec.Releases {
+		available := false
+		for _, deploy := range deployments {
+			if deploy.Spec.Template.Labels[constant.ReleaseLabel] == rls.Name && deploymentAvailable(deploy, rls.Replicas) {
+				available = true
+			}
+		}
+		if !available {
+			return errors.Errorf("the deployment of release %s%s is not available yet", rls.Name, destination.location())
+		}
+	}
+
+	for _, check := range migrate.Spec.ClusterMigration.Checks {
+		if err := runHTTPCheck(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deploymentAvailable tells whether all replicas of the deployment are available and as many as expected.
+func deploymentAvailable(deploy *appsv1.Deployment, replicas int32) bool {
+	return deploy.Status.Replicas == deploy.Status.AvailableReplicas && deploy.Status.AvailableReplicas == replicas
+}
+
+func runHTTPCheck(check v1.HTTPCheck) error {
+	expected := int(check.ExpectedStatus)
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	resp, err := httpCheckClient.Get(check.URL)
+	if err != nil {
+		return errors.Wrapf(err, "check %s", check.URL)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != expected {
+		return errors.Errorf("check %s returns status %d, expected %d", check.URL, resp.StatusCode, expected)
+	}
+	return nil
+}
+
+// switchTraffic points the service or the configmap to the address of the destination, the address they pointed
+// to is recorded in the status before they are changed.
+func (c *Controller) switchTraffic(migrateCopy *v1.Migrate) error {
+	traffic := migrateCopy.Spec.ClusterMigration.Traffic
+	if traffic == nil {
+		return nil
+	}
+	status := migrateCopy.Status.ClusterMigration
+	namespace := migrateCopy.Namespace
+
+	if traffic.Service != "" {
+		svc, err := c.kubeclientset.CoreV1().Services(namespace).Get(traffic.Service, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "get service %s", traffic.Service)
+		}
+		// The service may have been switched by a previous sync whose status has not been saved.
+		if status.PreviousService == nil && svc.Spec.ExternalName != traffic.Address {
+			status.PreviousAddress = svc.Spec.ExternalName
+			if svc.Spec.Type != corev1.ServiceTypeExternalName {
+				status.PreviousAddress = svc.Spec.ClusterIP
+			}
+			status.PreviousService = &v1.ServiceSnapshot{Type: svc.Spec.Type, ClusterIP: svc.Spec.ClusterIP, Ports: svc.Spec.Ports}
+		}
+		svcCopy := svc.DeepCopy()
+		svcCopy.Spec.Type = corev1.ServiceTypeExternalName
+		svcCopy.Spec.ExternalName = traffic.Address
+		// An ExternalName service has neither a cluster IP nor node ports.
+		svcCopy.Spec.ClusterIP = ""
+		svcCopy.Spec.ExternalTrafficPolicy = ""
+		svcCopy.Spec.HealthCheckNodePort = 0
+		for i := range svcCopy.Spec.Ports {
+			svcCopy.Spec.Ports[i].NodePort = 0
+		}
+		err = c.traceWrite(migrateCopy, "update", "services", namespace, svcCopy.Name, func() error {
+			_, err := c.kubeclientset.CoreV1().Services(namespace).Update(svcCopy)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "update service %s", traffic.Service)
+		}
+	}
+	if traffic.ConfigMap != "" {
+		cm, err := c.kubeclientset.CoreV1().ConfigMaps(namespace).Get(traffic.ConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "get configmap %s", traffic.ConfigMap)
+		}
+		if status.PreviousAddress == "" {
+			status.PreviousAddress = cm.Data[traffic.Key]
+		}
+		cmCopy := cm.DeepCopy()
+		if cmCopy.Data == nil {
+			cmCopy.Data = map[string]string{}
+		}
+		cmCopy.Data[traffic.Key] = traffic.Address
+		err = c.traceWrite(migrateCopy, "update", "configmaps", namespace, cmCopy.Name, func() error {
+			_, err := c.kubeclientset.CoreV1().ConfigMaps(namespace).Update(cmCopy)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "update configmap %s", traffic.ConfigMap)
+		}
+	}
+	return nil
+}
+
+// drainSource uninstalls the releases from the source cluster once the drain period has passed.
+func (c *Controller) drainSource(migrateCopy *v1.Migrate, source *target) error {
+	status := migrateCopy.Status.ClusterMigration
+	drain := time.Duration(migrateCopy.Spec.ClusterMigration.DrainSeconds) * time.Second
+	if status.SwitchedTime != nil && time.Since(status.SwitchedTime.Time) < drain {
+		return errors.Errorf("draining the source releases until %s",
+			status.SwitchedTime.Add(drain).Format(time.RFC3339))
+	}
+
+	running, err := runningReleaseNames(migrateCopy, source)
+	if err != nil {
+		return err
+	}
+	for _, rls := range migrateCopy.Spec.Releases {
+		if !running[rls.Name] {
+			continue
+		}
+		if err := source.helmClient.UninstallRelease(rls.Name); err != nil {
+			return errors.Wrapf(err, "uninstall release %s%s", rls.Name, source.location())
+		}
+		c.recorder.Event(migrateCopy, corev1.EventTypeNormal, PrunedRelease,
+			fmt.Sprintf("Release [%s]%s has been uninstalled after the cluster migration", rls.Name, source.location()))
+	}
+	return nil
+}
+
+func runningReleaseNames(migrate *v1.Migrate, t *target) (map[string]bool, error) {
+	rlses, err := t.helmClient.FilterReleases(fmt.Sprintf("^%s(-gz|-rz).*(-%s|-%s)$", migrate.Spec.AppName, constant.BlueGroup, constant.GreenGroup))
+	if err != nil {
+		return nil, errors.Wrapf(err, "list releases%s", t.location())
+	}
+	running := map[string]bool{}
+	for _, rls := range rlses {
+		running[rls.Name] = true
+	}
+	return running, nil
+}
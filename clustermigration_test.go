@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	destinationCluster = "dest-kubeconfig"
+	oldAddress         = "demo.sh-01.example.com"
+	newAddress         = "demo.sh-02.example.com"
+)
+
+func newClusterMigration(config *v1.ClusterMigrationConfig, releases ...string) *v1.Migrate {
+	migrate := newMigrate(releases...)
+	migrate.Spec.Action = v1.MigrateActionClusterMigrate
+	config.DestinationCluster = destinationCluster
+	migrate.Spec.ClusterMigration = config
+	return migrate
+}
+
+func withClusterMigrationStatus(migrate *v1.Migrate, phase v1.ClusterMigrationPhase, switched time.Time) *v1.Migrate {
+	switchedTime := metav1.NewTime(switched)
+	migrate.Status.ClusterMigration = &v1.ClusterMigrationStatus{Phase: phase, SwitchedTime: &switchedTime}
+	return migrate
+}
+
+func newRecordConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns-records", Namespace: metav1.NamespaceDefault},
+		Data:       map[string]string{"demo": oldAddress},
+	}
+}
+
+func newExternalService() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: metav1.NamespaceDefault},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: oldAddress},
+	}
+}
+
+func TestMigrateCluster(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	recordTraffic := &v1.TrafficConfig{ConfigMap: "dns-records", Key: "demo", Address: newAddress}
+
+	tests := []struct {
+		name               string
+		migrate            *v1.Migrate
+		sourceRunning      []*release.Release
+		destinationDeploys []*apps.Deployment
+		// The actions expected to happen on the release backend of each cluster.
+		expectedSourceActions      []string
+		expectedDestinationActions []string
+		expectedPhase              v1.ClusterMigrationPhase
+		expectedAddress            string
+	}{
+		{
+			name: "migrate to destination and switch record",
+			migrate: newClusterMigration(&v1.ClusterMigrationConfig{
+				Checks: []v1.HTTPCheck{{URL: healthy.URL}}, Traffic: recordTraffic}, blueRelease),
+			sourceRunning:              []*release.Release{runningRelease(blueRelease, 1)},
+			destinationDeploys:         []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedSourceActions:      []string{"list", "uninstall/" + blueRelease},
+			expectedDestinationActions: []string{"list", "install/" + blueRelease},
+			expectedPhase:              v1.ClusterMigrationCompleted,
+			expectedAddress:            newAddress,
+		},
+		{
+			name:                       "wait for destination deployment",
+			migrate:                    newClusterMigration(&v1.ClusterMigrationConfig{Traffic: recordTraffic}, blueRelease),
+			sourceRunning:              []*release.Release{runningRelease(blueRelease, 1)},
+			destinationDeploys:         []*apps.Deployment{newDeployment(blueRelease, 1)},
+			expectedDestinationActions: []string{"list", "install/" + blueRelease},
+			expectedPhase:              v1.ClusterMigrationInstalled,
+			expectedAddress:            oldAddress,
+		},
+		{
+			name: "wait for failing check",
+			migrate: newClusterMigration(&v1.ClusterMigrationConfig{
+				Checks: []v1.HTTPCheck{{URL: unhealthy.URL}}, Traffic: recordTraffic}, blueRelease),
+			sourceRunning:              []*release.Release{runningRelease(blueRelease, 1)},
+			destinationDeploys:         []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedDestinationActions: []string{"list", "install/" + blueRelease},
+			expectedPhase:              v1.ClusterMigrationInstalled,
+			expectedAddress:            oldAddress,
+		},
+		{
+			name: "drain source before uninstalling",
+			migrate: withClusterMigrationStatus(newClusterMigration(&v1.ClusterMigrationConfig{
+				Traffic: recordTraffic, DrainSeconds: 600}, blueRelease), v1.ClusterMigrationSwitched, time.Now()),
+			sourceRunning:   []*release.Release{runningRelease(blueRelease, 1)},
+			expectedPhase:   v1.ClusterMigrationSwitched,
+			expectedAddress: oldAddress,
+		},
+		{
+			name: "resume from switched phase",
+			migrate: withClusterMigrationStatus(newClusterMigration(&v1.ClusterMigrationConfig{
+				Traffic: recordTraffic, DrainSeconds: 600}, blueRelease), v1.ClusterMigrationSwitched, time.Now().Add(-time.Hour)),
+			sourceRunning:         []*release.Release{runningRelease(blueRelease, 1)},
+			expectedSourceActions: []string{"list", "uninstall/" + blueRelease},
+			expectedPhase:         v1.ClusterMigrationCompleted,
+			expectedAddress:       oldAddress,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.sourceRunning...)
+			destination := helmtest.NewFakeBackend()
+			f.clusters = fakeClusters{destinationCluster: newFakeCluster(destinationCluster, destination, test.destinationDeploys...)}
+			f.kubeobjects = append(f.kubeobjects, newRecordConfigMap())
+			f.addMigrate(test.migrate)
+
+			updated := f.run(test.migrate)
+			checkActions(t, test.expectedSourceActions, f.backend.Actions)
+			checkActions(t, test.expectedDestinationActions, destination.Actions)
+			if updated == nil || updated.Status.ClusterMigration == nil {
+				t.Fatalf("expected the cluster migration status to be updated")
+			}
+			if phase := updated.Status.ClusterMigration.Phase; phase != test.expectedPhase {
+				t.Errorf("expected phase %s, got %s: %s", test.expectedPhase, phase, updated.Status.ClusterMigration.Message)
+			}
+
+			cm, err := f.kubeclient.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get("dns-records", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get configmap: %v", err)
+			}
+			if cm.Data["demo"] != test.expectedAddress {
+				t.Errorf("expected the record to point to %s, got %s", test.expectedAddress, cm.Data["demo"])
+			}
+		})
+	}
+}
+
+func newClusterIPService() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: metav1.NamespaceDefault},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, ClusterIP: "10.0.0.10",
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}}},
+	}
+}
+
+func TestMigrateClusterSwitchesService(t *testing.T) {
+	tests := []struct {
+		name            string
+		service         *corev1.Service
+		expectedAddress string
+		expectedService *v1.ServiceSnapshot
+	}{
+		{
+			name:            "external name service",
+			service:         newExternalService(),
+			expectedAddress: oldAddress,
+			expectedService: &v1.ServiceSnapshot{Type: corev1.ServiceTypeExternalName},
+		},
+		{
+			name:            "service with cluster IP",
+			service:         newClusterIPService(),
+			expectedAddress: "10.0.0.10",
+			expectedService: &v1.ServiceSnapshot{Type: corev1.ServiceTypeNodePort, ClusterIP: "10.0.0.10",
+				Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, runningRelease(blueRelease, 1))
+			destination := helmtest.NewFakeBackend()
+			f.clusters = fakeClusters{destinationCluster: newFakeCluster(destinationCluster, destination, newDeployment(blueRelease, 2))}
+			f.kubeobjects = append(f.kubeobjects, test.service)
+			migrate := newClusterMigration(&v1.ClusterMigrationConfig{
+				Traffic: &v1.TrafficConfig{Service: "demo", Address: newAddress}}, blueRelease)
+			f.addMigrate(migrate)
+
+			updated := f.run(migrate)
+			if updated == nil || updated.Status.ClusterMigration.Phase != v1.ClusterMigrationCompleted {
+				t.Fatalf("expected the cluster migration to be completed")
+			}
+			if previous := updated.Status.ClusterMigration.PreviousAddress; previous != test.expectedAddress {
+				t.Errorf("expected the previous address %s to be recorded, got %s", test.expectedAddress, previous)
+			}
+			if previous := updated.Status.ClusterMigration.PreviousService; !reflect.DeepEqual(previous, test.expectedService) {
+				t.Errorf("expected the previous service %+v to be recorded, got %+v", test.expectedService, previous)
+			}
+			svc, err := f.kubeclient.CoreV1().Services(metav1.NamespaceDefault).Get("demo", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get service: %v", err)
+			}
+			if svc.Spec.Type != corev1.ServiceTypeExternalName || svc.Spec.ExternalName != newAddress {
+				t.Errorf("expected the service to point to %s, got %s %s", newAddress, svc.Spec.Type, svc.Spec.ExternalName)
+			}
+			if svc.Spec.ClusterIP != "" {
+				t.Errorf("expected the cluster IP to be cleared, got %s", svc.Spec.ClusterIP)
+			}
+			for _, port := range svc.Spec.Ports {
+				if port.NodePort != 0 {
+					t.Errorf("expected the node port of %s to be cleared, got %d", port.Name, port.NodePort)
+				}
+			}
+			checkEvents(t, []string{ReasonClusterMigrationInstalled, ReasonClusterMigrationVerified,
+				ReasonClusterMigrationSwitched, ReasonClusterMigrationCompleted}, f.events())
+		})
+	}
+}
@@ -19,16 +19,21 @@ package main
 import (
 	"fmt"
 	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/cluster"
 	"github.com/yangyongzhi/sym-operator/pkg/constant"
 	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/history"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"github.com/yangyongzhi/sym-operator/pkg/metrics"
+	"github.com/yangyongzhi/sym-operator/pkg/tracing"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
-	appsinformers "k8s.io/client-go/informers/apps/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -36,17 +41,16 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"strings"
 	"time"
 
 	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
 	samplescheme "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/scheme"
-	informers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions/devops/v1"
 	listers "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
 )
 
 const controllerAgentName = "symphony-operator"
-const migrateNamespace = "default"
 
 const (
 	// SuccessSynced is used as part of the Event 'reason' when a Foo is synced
@@ -61,6 +65,7 @@ const (
 	FailInstall       = "FailInstall"
 	FailUpdate        = "FailUpdate"
 	ErrDeleteRelease  = "ErrDeleteRelease"
+	ErrHelmClient     = "ErrHelmClient"
 
 	// MessageResourceExists is the message used for Events when a resource
 	// fails to sync due to a Deployment already existing
@@ -77,61 +82,126 @@ type Controller struct {
 	kubeclientset kubernetes.Interface
 	symclientset  clientset.Interface
 
-	helmClient *helm.Client
+	// helmClients provides the helm client of the tiller each migrate is deployed by.
+	helmClients *helm.ClientPool
+	// clusters provides the clients of the clusters other than the one the operator runs in.
+	clusters cluster.Provider
 
 	deploymentsLister appslisters.DeploymentLister
 	deploymentsSynced cache.InformerSynced
 	symLister         listers.MigrateLister
 	symSynced         cache.InformerSynced
+	clustersLister    listers.ClusterLister
+	// clustersSynced is nil if the cluster registry is disabled.
+	clustersSynced cache.InformerSynced
+	// namespaces are the watched namespaces, it only holds metav1.NamespaceAll if all namespaces are watched.
+	namespaces []string
+
+	// shards tells which migrates are synced by this replica, it is nil if the migrates are not sharded.
+	shards shardOwner
+
+	// policy is the spec of the OperatorConfig in effect.
+	policy *livePolicy
+	// operatorConfigSynced is nil if no OperatorConfig is watched.
+	operatorConfigSynced cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
 	// means we can ensure we only process a fixed amount of resources at a
 	// time, and makes it easy to ensure we are never processing the same item
 	// simultaneously in two different workers.
-	workqueue workqueue.RateLimitingInterface
+	workqueue *trackingQueue
+	// syncStates holds the result of the last sync of each migrate for the debug endpoints.
+	syncStates *syncStates
 
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
+
+	// tracer traces each sync, traces holds the span of each migrate being synced.
+	tracer *tracing.Tracer
+	traces *activeTraces
+
+	// audit records the operations on the releases, they are not recorded if it is nil.
+	audit *auditTrail
+	// history persists the deployments for the DORA metrics, they are not persisted if it is nil.
+	history *history.Store
 }
 
 // NewController returns a new sample controller
 func NewController(
 	kubeclientset kubernetes.Interface,
-	symclientset clientset.Interface, helmClient *helm.Client,
-	deploymentInformer appsinformers.DeploymentInformer,
-	symInformer informers.MigrateInformer) *Controller {
+	symclientset clientset.Interface, helmClients *helm.ClientPool, clusters cluster.Provider,
+	watched []watchedInformers, rateLimiter workqueue.RateLimiter) *Controller {
 
 	// Create event broadcaster
 	// Add sym-migrate-controller types to the default Kubernetes Scheme so Events can be
 	// logged for sample-controller types.
 	utilruntime.Must(samplescheme.AddToScheme(scheme.Scheme))
-	klog.V(4).Info("Creating event broadcaster")
+	log.Debugf("Creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartLogging(log.WithComponent("events").Infof)
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
 
+	deploymentsLister, deploymentsSynced := deploymentListers{}, []cache.InformerSynced{}
+	symLister, symSynced := migrateListers{}, []cache.InformerSynced{}
+	clustersLister, clustersSynced := clusterListers{}, []cache.InformerSynced{}
+	var namespaces []string
+	for _, w := range watched {
+		namespaces = append(namespaces, w.namespace)
+		deploymentsLister[w.namespace] = w.deployments.Lister()
+		deploymentsSynced = append(deploymentsSynced, w.deployments.Informer().HasSynced)
+		symLister[w.namespace] = w.migrates.Lister()
+		symSynced = append(symSynced, w.migrates.Informer().HasSynced)
+		if w.clusters != nil {
+			clustersLister[w.namespace] = w.clusters.Lister()
+			clustersSynced = append(clustersSynced, w.clusters.Informer().HasSynced)
+		}
+	}
+	// No cluster is registered unless the clusters are watched.
+	var registrySynced cache.InformerSynced
+	if len(clustersSynced) > 0 {
+		registrySynced = allSynced(clustersSynced)
+	}
+
+	traces := newActiveTraces()
 	controller := &Controller{
 		kubeclientset:     kubeclientset,
 		symclientset:      symclientset,
-		helmClient:        helmClient,
-		deploymentsLister: deploymentInformer.Lister(),
-		deploymentsSynced: deploymentInformer.Informer().HasSynced,
-		symLister:         symInformer.Lister(),
-		symSynced:         symInformer.Informer().HasSynced,
-		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Sym"),
-		recorder:          recorder,
+		helmClients:       helmClients,
+		clusters:          clusters,
+		deploymentsLister: deploymentsLister,
+		deploymentsSynced: allSynced(deploymentsSynced),
+		symLister:         symLister,
+		symSynced:         allSynced(symSynced),
+		clustersLister:    clustersLister,
+		clustersSynced:    registrySynced,
+		namespaces:        namespaces,
+		policy:            &livePolicy{},
+		workqueue:         newTrackingQueue(workqueue.NewNamedRateLimitingQueue(rateLimiter, "Sym")),
+		syncStates:        newSyncStates(),
+		recorder:          newTracedRecorder(recorder, traces),
+		tracer:            tracing.NewTracer(nil),
+		traces:            traces,
 	}
 
-	klog.Info("Setting up event handlers")
+	log.Infof("Setting up event handlers")
+
+	for _, w := range watched {
+		controller.addEventHandlers(w)
+	}
 
+	return controller
+}
+
+// addEventHandlers enqueues the migrates once they or their deployments change in the watched namespace.
+func (c *Controller) addEventHandlers(w watchedInformers) {
 	// Set up an event handler for when Foo resources change
-	symInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueueMigrate,
+	w.migrates.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueMigrate,
 		UpdateFunc: func(old, new interface{}) {
-			controller.enqueueMigrate(new)
+			c.enqueueMigrate(new)
 		},
 	})
 
@@ -141,8 +211,8 @@ func NewController(
 	// processing. This way, we don't need to implement custom logic for
 	// handling Deployment resources. More info on this pattern:
 	// https://github.com/kubernetes/community/blob/8cafef897a22026d42f5e5bb3f104febe7e29830/contributors/devel/controllers.md
-	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.handleObject,
+	w.deployments.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleObject,
 		UpdateFunc: func(old, new interface{}) {
 			newDepl := new.(*appsv1.Deployment)
 			oldDepl := old.(*appsv1.Deployment)
@@ -151,12 +221,10 @@ func NewController(
 				// Two different versions of the same Deployment will always have different RVs.
 				return
 			}
-			controller.handleObject(new)
+			c.handleObject(new)
 		},
-		DeleteFunc: controller.handleObject,
+		DeleteFunc: c.handleObject,
 	})
-
-	return controller
 }
 
 // Run will set up the event handlers for types we are interested in, as well
@@ -168,22 +236,33 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer c.workqueue.ShutDown()
 
 	// Start the informer factories to begin populating the informer caches
-	klog.Info("Starting Symphony operator...")
+	log.Infof("Starting Symphony operator")
 	// Wait for the caches to be synced before starting workers
-	klog.Info("Waiting for informer caches to sync...")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.symSynced); !ok {
+	log.Infof("Waiting for informer caches to sync")
+	synced := []cache.InformerSynced{c.deploymentsSynced, c.symSynced}
+	if c.clustersSynced != nil {
+		synced = append(synced, c.clustersSynced)
+	}
+	if c.operatorConfigSynced != nil {
+		synced = append(synced, c.operatorConfigSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
-	klog.Info("Starting workers")
+	log.Infof("Starting workers")
 	// Launch two workers to process Foo resources
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
-	klog.Info("Started workers")
+	if c.audit != nil {
+		go wait.Until(c.sweepReleaseOperations, c.audit.config.RetentionInterval.Duration, stopCh)
+	}
+
+	log.Infof("Started workers")
 	<-stopCh
-	klog.Info("Shutting down workers")
+	log.Infof("Shutting down workers")
 
 	return nil
 }
@@ -239,7 +318,7 @@ func (c *Controller) processNextWorkItem() bool {
 		// Finally, if no error occurs we Forget this item so it does not
 		// get queued again until another change happens.
 		c.workqueue.Forget(obj)
-		klog.Infof("Successfully synced '%s'", key)
+		log.WithFields(log.Fields{log.FieldMigrate: key}).Info("Successfully synced")
 		return nil
 	}(obj)
 
@@ -255,6 +334,10 @@ func (c *Controller) processNextWorkItem() bool {
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than Foo.
 func (c *Controller) enqueueMigrate(obj interface{}) {
+	if migrate, ok := obj.(*v1.Migrate); ok && !c.ownsMigrate(migrate) {
+		c.logFor(migrate).Debug("The migrate belongs to a shard of another replica, ignore it")
+		return
+	}
 	var key string
 	var err error
 	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
@@ -267,8 +350,9 @@ func (c *Controller) enqueueMigrate(obj interface{}) {
 // syncHandler compares the actual state with the desired, and attempts to
 // converge the two. It then updates the Status block of the Foo resource
 // with the current status of the resource.
-func (c *Controller) syncHandler(key string) error {
-	klog.Infof("Start sync handler method, key : '%s'", key)
+func (c *Controller) syncHandler(key string) (err error) {
+	logger := log.WithFields(log.Fields{log.FieldMigrate: key})
+	logger.Info("Start syncing")
 
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
@@ -283,6 +367,7 @@ func (c *Controller) syncHandler(key string) error {
 		// The Foo resource may no longer exist, in which case we stop
 		// processing.
 		if errors.IsNotFound(err) {
+			c.syncStates.delete(key)
 			utilruntime.HandleError(fmt.Errorf("migrate '%s' in work queue no longer exists", key))
 			return nil
 		}
@@ -291,16 +376,15 @@ func (c *Controller) syncHandler(key string) error {
 	}
 
 	if migrate == nil {
-		klog.Infof("Can not find the migrate with key: '%s'", key)
+		logger.Info("Can not find the migrate")
 		return nil
 	}
 	if migrate.DeletionTimestamp != nil {
-		klog.Infof("The migrate has been deleted, key: '%s'", key)
+		logger.Info("The migrate has been deleted")
 		return nil
 	}
 
 	appName := migrate.Spec.AppName
-	klog.Infof("The appName of this migrate : '%s'", appName)
 	if appName == "" {
 		// We choose to absorb the error here as the worker would requeue the
 		// resource otherwise. Instead, the next time the resource is updated
@@ -308,16 +392,64 @@ func (c *Controller) syncHandler(key string) error {
 		utilruntime.HandleError(fmt.Errorf("%s: app name must be specified", key))
 		return nil
 	}
+	// The shard may have been released since the migrate was queued.
+	done, owned := c.beginSync(migrate)
+	if !owned {
+		logger.Info("The migrate belongs to a shard of another replica, skip it")
+		return nil
+	}
+	defer done()
+	c.observeChange(key, migrate)
+	start := time.Now()
+	span := c.tracer.Start("sync", key, tracing.String("migrate.action", syncAction(migrate)), tracing.String("app", appName))
+	c.traces.set(key, span)
+	defer func() {
+		c.traces.delete(key)
+		span.End(err)
+		c.syncStates.observe(key, start, err, span.TraceID().String())
+		metrics.ObserveSync(syncAction(migrate), err, start)
+	}()
+	// Nothing is changed while the operator is frozen, all migrates are synced again once the freeze is lifted.
+	if policy := c.policy.get(); policy.Freeze {
+		c.recorder.Event(migrate, corev1.EventTypeNormal, Frozen,
+			fmt.Sprintf("Skip syncing migrate [%s], the operator is frozen : %s", migrate.Name, policy.FreezeReason))
+		return nil
+	}
+
+	// The releases are converted to helm 3 instead of being deployed by tiller.
+	if migrate.Spec.Action == v1.MigrateActionConvert {
+		return c.convert(migrate)
+	}
+	// The releases are moved to another cluster instead of being reconciled in their target clusters.
+	if migrate.Spec.Action == v1.MigrateActionClusterMigrate {
+		return c.migrateCluster(migrate)
+	}
+	// The releases are moved to another namespace instead of being reconciled in their namespaces.
+	if migrate.Spec.Action == v1.MigrateActionRelocate {
+		return c.relocate(migrate)
+	}
+
+	targets, scheduled, err := c.resolveTargets(migrate)
+	if err != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ErrHelmClient,
+			fmt.Sprintf("Can not resolve the target clusters of migrate [%s] : %s", migrate.Name, err.Error()))
+		return err
+	}
+	defer releaseTargets(targets)
 
 	/*
 	 * 1.Insert the missing release
 	 * 2.Delete the redundant release
 	 * 3.Update the existing release
 	 */
-	revisions := c.reconcile(migrate)
+	revisions, kept, reconcileErr := c.reconcile(targets, migrate)
 
 	/* Refresh the status of migration.*/
-	c.syncStatus(migrate, revisions)
+	statusErr := c.syncStatus(targets, scheduled, migrate, revisions, kept)
+	// The migrate is requeued if any target cluster could not be synced, the others have been synced anyway.
+	if err := utilerrors.NewAggregate([]error{reconcileErr, statusErr}); err != nil {
+		return err
+	}
 
 	c.recorder.Event(migrate, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 	//+", "+strconv.Itoa(rand.Int())
@@ -344,127 +476,140 @@ func (c *Controller) handleObject(obj interface{}) {
 			utilruntime.HandleError(fmt.Errorf("error decoding object tombstone, invalid type"))
 			return
 		}
-		klog.Infof("Recovered deleted object '%s' from tombstone", object.GetName())
+		log.Infof("Recovered deleted object %s/%s from tombstone", object.GetNamespace(), object.GetName())
 	}
 
 	appName := object.GetLabels()[constant.AppLabel]
-	klog.Infof("Processing deployment: %s, app name: %s", object.GetName(), appName)
+	logger := log.WithFields(log.Fields{"deployment": object.GetNamespace() + "/" + object.GetName(), log.FieldApp: appName})
+	logger.Debug("Processing deployment")
 
 	// Find the migrate with the app name of this deployment in this namespace.
 	migrate, err := c.symLister.Migrates(object.GetNamespace()).Get(appName)
 	if err != nil {
-		klog.Infof("Find migrate task for deployment [%s] has an error: %s", object.GetName(), err.Error())
+		logger.WithError(err).Debug("Can not find the migrate of the deployment, ignore it")
 		return
 	}
 	if migrate == nil {
-		klog.Infof("Can not find a migrate task for deployment: %s, ignore it.", object.GetName())
+		logger.Debug("Can not find the migrate of the deployment, ignore it")
 		return
 	}
 	if migrate.DeletionTimestamp != nil {
-		klog.Infof("Perhaps the migrate which related with this deployment [%s] has been deleted, ignore it.", object.GetName())
+		logger.Debug("The migrate of the deployment has been deleted, ignore it")
 		return
 	}
 
-	klog.Infof("##### Enqueue ##### enqueue a migrate [%s] due to a event of the deployment [%s], app name: %s", migrate.Name, object.GetName(), appName)
+	logger.WithField(log.FieldMigrate, migrate.Namespace+"/"+migrate.Name).Info("Enqueue the migrate of the deployment")
 	c.enqueueMigrate(migrate)
 	return
 
 }
 
 /*
- * Reconcile the releases which are running in current cluster with the releases in the migration CRD.
+ * Reconcile the releases which are running in the target clusters with the releases in the migration CRD.
+ * A target whose releases can not be listed is skipped, the errors of all such targets are returned together.
+ * The reasons of the kept releases are returned as well, they are nil if the releases have not been pruned.
  */
-func (c *Controller) reconcile(migrate *v1.Migrate) map[string]int32 {
-	klog.Infof("##### Start to reconcile releases with migrate: '%s'", migrate.Name)
+func (c *Controller) reconcile(targets []*target, migrate *v1.Migrate) (map[string]int32, map[string]string, error) {
+	logger := c.logFor(migrate)
+	logger.Info("Start to reconcile the releases")
 	if migrate.Status.Finished == constant.ConditionStatusTrue {
-		klog.Infof("##### The status of migrate[%s] has been set as true, so no need to do anything.", migrate.Name)
-		return nil
+		logger.Info("The migrate has finished, nothing to reconcile")
+		return nil, nil, nil
+	}
+	if err := allowedChart(c.policy.get(), migrate.Spec.Chart); err != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, DisallowedChart,
+			fmt.Sprintf("Refuse to deploy the chart of migrate [%s] : %s", migrate.Name, err.Error()))
+		return nil, nil, nil
 	}
 
 	revisions := map[string]int32{}
 	migrateRlses := migrate.Spec.Releases
-	runningRlses, err := c.helmClient.FilterReleases(fmt.Sprintf("^%s(-gz|-rz).*(-%s|-%s)$", migrate.Spec.AppName, constant.BlueGroup, constant.GreenGroup))
-	if err != nil {
-		c.recorder.Event(migrate, corev1.EventTypeWarning, ErrDeleteRelease,
-			fmt.Sprintf("Can not find any running releases when you want to update [%s], error : %s", migrate.Name, err.Error()))
-		return nil
-	}
-
-	// At first, uninstall the un-defined release in the newest migration.
-	for _, runningRls := range runningRlses {
-		var foundDefinition = false
-		for _, migrateRls := range migrateRlses {
-			if runningRls.Name == migrateRls.Name {
-				foundDefinition = true
-			}
+	clusterRlses := map[string][]*release.Release{}
+	failed := map[string]bool{}
+	var errs []error
+	for _, t := range targets {
+		runningRlses, err := t.helmClient.FilterReleases(fmt.Sprintf("^%s(-gz|-rz).*(-%s|-%s)$", migrate.Spec.AppName, constant.BlueGroup, constant.GreenGroup))
+		if err != nil {
+			c.recorder.Event(migrate, corev1.EventTypeWarning, ErrDeleteRelease,
+				fmt.Sprintf("Can not find any running releases%s when you want to update [%s], error : %s", t.location(), migrate.Name, err.Error()))
+			failed[t.cluster] = true
+			errs = append(errs, fmt.Errorf("list the releases%s: %s", t.location(), err.Error()))
+			continue
 		}
 
-		if !foundDefinition {
-			klog.Infof("##### The running release [%s] has not been defind in migration, we should delete it.", runningRls.Name)
-			_, err := c.helmClient.UninstallRelease(runningRls.Name)
-			if err != nil {
-				c.recorder.Event(migrate, corev1.EventTypeWarning, ErrDeleteRelease,
-					fmt.Sprintf("##### Delete release [%s] has an error : %s", runningRls.Name, err.Error()))
-				continue
-			} else {
-				// Don't save the version of the deleted release into the status.
-				c.recorder.Event(migrate, corev1.EventTypeNormal, SuccessSynced,
-					fmt.Sprintf("##### Release [%s] has been deleted successfully.", runningRls.Name))
-			}
-
-			return revisions
+		clusterRlses[t.cluster] = runningRlses
+		c.syncStates.observeReleases(migrate.Namespace+"/"+migrate.Name, t.cluster, runningRlses)
+	}
+	kept := keptReleases(migrate, targets, clusterRlses, len(failed) == 0)
+	for _, t := range targets {
+		if failed[t.cluster] {
+			continue
+		}
+		// At first, prune the un-defined releases in the newest migration according to the prune policy.
+		if c.prune(t, migrate, targets, clusterRlses, kept) {
+			// Don't save the version of the deleted release into the status.
+			return revisions, kept, utilerrors.NewAggregate(errs)
 		}
 	}
 
 	// Secondly, update the release with the newest releases in the current migration.
 	for _, migrateRls := range migrateRlses {
 		var rlsIsExist = false
-		for _, runningRls := range runningRlses {
+		t := targetOf(targets, migrateRls.Name)
+		if failed[t.cluster] {
+			// The running releases of the cluster are unknown, the release is synced once they can be listed again.
+			continue
+		}
+		helmClient := t.helmClient
+		for _, runningRls := range clusterRlses[t.cluster] {
 			if migrateRls.Name == runningRls.Name {
 				rlsIsExist = true
-				klog.Infof("##### We already found the running release [%s] with current migration, then we will update it immediately.", migrateRls.Name)
+				rlsLogger := t.logFor(logger).WithField(log.FieldRelease, migrateRls.Name)
 				if migrate.Status.ReleaseRevision[migrateRls.Name] == runningRls.Version {
-					klog.Infof("##### Version of release in the status [%s] has been updated to version [%d], no need to do anything.",
-						migrateRls.Name, migrate.Status.ReleaseRevision[migrateRls.Name])
+					rlsLogger.Debugf("The release has been synced at revision %d", runningRls.Version)
 					break
 				}
 
 				// The version is not same as the one has been aved in status.
-				updateResponse, err := c.helmClient.UpdateRelease(migrateRls.Name, migrate.Spec.Chart, migrateRls.Raw)
+				rlsLogger.Infof("Update the release from revision %d", runningRls.Version)
+				updatedRls, err := helmClient.UpdateRelease(migrateRls.Name, migrate.Spec.Chart, migrateRls.Raw)
 				if err != nil {
 					c.recorder.Event(migrate, corev1.EventTypeWarning, ErrReleaseContent,
 						fmt.Sprintf("Update release [%s] has an error : %s", migrateRls.Name, err))
 				} else {
-					revisions[migrateRls.Name] = updateResponse.Release.Version
+					revisions[migrateRls.Name] = updatedRls.Version
 					c.recorder.Event(migrate, corev1.EventTypeNormal, SuccessUpdatedStatus,
-						fmt.Sprintf("Update release [%s] successfully, version : %d", migrateRls.Name, updateResponse.Release.Version))
+						fmt.Sprintf("Update release [%s] successfully, version : %d", migrateRls.Name, updatedRls.Version))
 				}
 
-				return revisions
+				return revisions, kept, utilerrors.NewAggregate(errs)
 			}
 		}
 
 		// If the release you want to update has not been exist, we install it first.
 		if !rlsIsExist {
-			klog.Infof("##### Can not find release [%s] when you want to update it, so you should use installing instead of updating.", migrateRls.Name)
-			installResponse, err := c.helmClient.InstallRelease(migrateRls.Namespace, migrateRls.Name, migrate.Spec.Chart, migrateRls.Raw)
+			t.logFor(logger).WithField(log.FieldRelease, migrateRls.Name).Info("Install the missing release")
+			installedRls, err := helmClient.InstallRelease(migrateRls.Namespace, migrateRls.Name, migrate.Spec.Chart, migrateRls.Raw)
 			if err != nil {
 				c.recorder.Event(migrate, corev1.EventTypeWarning, ErrReleaseContent,
 					fmt.Sprintf("Install release [%s] has an error : %s", migrateRls.Name, err))
 			} else {
-				revisions[migrateRls.Name] = installResponse.Release.Version
+				revisions[migrateRls.Name] = installedRls.Version
 				c.recorder.Event(migrate, corev1.EventTypeNormal, SuccessInstalledStatus,
-					fmt.Sprintf("Install release [%s] successfully, version : %d", migrateRls.Name, installResponse.Release.Version))
+					fmt.Sprintf("Install release [%s] successfully, version : %d", migrateRls.Name, installedRls.Version))
 			}
-			return revisions
+			return revisions, kept, utilerrors.NewAggregate(errs)
 		}
 	}
 
-	return revisions
+	return revisions, kept, utilerrors.NewAggregate(errs)
 }
 
-// Synchronize the status of migrate which has been set as a installing one
-func (c *Controller) syncStatus(migrate *v1.Migrate, revisions map[string]int32) error {
+// Synchronize the status of migrate which has been set as a installing one. The conditions of the releases in a
+// target whose deployments can not be listed become Unknown, the errors of such targets are returned with the one
+// of the update.
+func (c *Controller) syncStatus(targets []*target, scheduled map[string]string, migrate *v1.Migrate, revisions map[string]int32,
+	kept map[string]string) error {
 	migrateCopy := migrate.DeepCopy()
 	initialFinished := migrateCopy.Status.Finished
 	now := metav1.Now()
@@ -474,77 +619,43 @@ func (c *Controller) syncStatus(migrate *v1.Migrate, revisions map[string]int32)
 	//r, _ := labels.NewRequirement("app", selection.Equals, []string{appName})
 	labelSet := labels.Set{}
 	labelSet[constant.AppLabel] = migrateCopy.Spec.AppName
-	deployments, err := c.deploymentsLister.Deployments(migrate.GetNamespace()).List(labels.SelectorFromSet(labelSet))
-	// If an error occurs during Get/Create, we'll requeue the item so we can
-	// attempt processing again later. This could have been caused by a
-	// temporary network failure, or any other transient reason.
-	if err != nil {
-		klog.Infof("Can not find the deployments for migrate [%s], ignore it.", migrate.GetName())
-		return nil
-	}
-	if deployments != nil && len(deployments) > 0 {
-		klog.Infof("===== The deployments for migrate [%s] is not null or not empty, then update the status of the current migrate.", migrate.GetName())
-		for _, deploy := range deployments {
-			var message = ""
-			rlsName := deploy.Spec.Template.Labels[constant.ReleaseLabel]
-			conditionType := constant.ConcatConditionType(rlsName)
-
-			var currentRelease *v1.ReleasesConfig
-			for _, rls := range migrate.Spec.Releases {
-				if rls.Name == rlsName {
-					currentRelease = rls
-				}
-			}
-
-			if currentRelease == nil {
-				klog.Infof("===== Can not find release in Spec part with deployment's label %s, so wait for it to disappear.", rlsName)
-				continue
-			}
-
-			message = fmt.Sprintf("Deployment [%s]'s status: desired replica:%d, available:%d, Migrate replica count:%d",
-				deploy.GetName(), deploy.Status.Replicas, deploy.Status.AvailableReplicas, currentRelease.Replicas)
-			upsertCondition(migrateCopy, v1.MigrateCondition{
-				conditionType, constant.ConditionStatusFalse, now, now, "", message})
-			klog.Info("===== " + message)
-			if deploy.Status.Replicas == deploy.Status.AvailableReplicas && deploy.Status.AvailableReplicas == currentRelease.Replicas {
-				getRelease, err := c.helmClient.GetRelease(currentRelease.Name)
-				if err != nil {
-					klog.Infof("Find release [%s] has an error : %s", rlsName, err.Error())
-					c.recorder.Event(migrate, corev1.EventTypeWarning, ErrGetRelease,
-						fmt.Sprintf("Error - Get the release [%s] info : %s", rlsName, err))
-				} else {
-					if migrateCopy.Status.ReleaseRevision == nil {
-						message = fmt.Sprintf("The revision information in Status is null, maybe you don't update the release yet. migrate [%s]",
-							migrateCopy.Name)
-						klog.Info("===== " + message)
-						upsertCondition(migrateCopy, v1.MigrateCondition{conditionType, constant.ConditionStatusFalse, now, now, "", message})
-						continue
-					}
-
-					if getRelease != nil && getRelease.Version == migrateCopy.Status.ReleaseRevision[currentRelease.Name] {
-						upsertCondition(migrateCopy,
-							v1.MigrateCondition{conditionType, constant.ConditionStatusTrue, now, now, "", message})
-					} else {
-						message = fmt.Sprintf("The revision information  [%d] in Status is not equals to the revision  [%d] in helm, wait for the next updating.",
-							migrateCopy.Name)
-						klog.Info("===== " + message)
-						upsertCondition(migrateCopy, v1.MigrateCondition{
-							conditionType, constant.ConditionStatusFalse, now, now, "", message})
-					}
-				}
-			} else {
-				message = fmt.Sprintf("Waiting for the deployment [%s] is available if you want to update the Status of migrate [%s]",
-					migrateCopy.Name, deploy.Name)
-				klog.Info("===== " + message)
-			}
+	var deployments []*appsv1.Deployment
+	var errs []error
+	for _, t := range targets {
+		clusterDeployments, err := c.listTargetDeployments(t, migrate, labels.SelectorFromSet(labelSet))
+		// If an error occurs during Get/Create, we'll requeue the item so we can
+		// attempt processing again later. This could have been caused by a
+		// temporary network failure, or any other transient reason.
+		if err != nil {
+			t.logFor(c.logFor(migrate)).WithError(err).Warning("Can not list the deployments, the conditions of the cluster are unknown")
+			errs = append(errs, err)
+			unknownConditions(t, migrateCopy, err, now)
+			continue
 		}
+		deployments = append(deployments, clusterDeployments...)
+		c.syncDeploymentConditions(t, migrateCopy, clusterDeployments, now)
 	}
 
 	calFinalStatus(migrateCopy, deployments)
+	c.syncProgressDeadline(migrateCopy, initialFinished, now)
 	if initialFinished == constant.ConditionStatusFalse || migrateCopy.Status.Finished == constant.ConditionStatusFalse {
 		migrateCopy.Status.LastUpdateTime = &now
 	}
 
+	if len(scheduled) > 0 {
+		migrateCopy.Status.ReleaseClusters = scheduled
+	} else {
+		migrateCopy.Status.ReleaseClusters = nil
+	}
+
+	if kept != nil {
+		if len(kept) > 0 {
+			migrateCopy.Status.KeptReleases = kept
+		} else {
+			migrateCopy.Status.KeptReleases = nil
+		}
+	}
+
 	if revisions != nil && len(revisions) > 0 {
 		for key, value := range revisions {
 			if migrateCopy.Status.ReleaseRevision == nil {
@@ -555,9 +666,121 @@ func (c *Controller) syncStatus(migrate *v1.Migrate, revisions map[string]int32)
 		}
 	}
 
-	_, err = c.symclientset.DevopsV1().Migrates(migrate.Namespace).Update(migrateCopy)
+	errs = append(errs, c.traceWrite(migrate, "update", "migrates", migrate.Namespace, migrate.Name, func() error {
+		_, err := c.symclientset.DevopsV1().Migrates(migrate.Namespace).Update(migrateCopy)
+		return err
+	}))
+	return utilerrors.NewAggregate(errs)
+}
+
+// listTargetDeployments lists the deployments matching the selector in the namespaces of the releases in the target.
+func (c *Controller) listTargetDeployments(t *target, migrate *v1.Migrate, selector labels.Selector) ([]*appsv1.Deployment, error) {
+	var deployments []*appsv1.Deployment
+	for _, namespace := range releaseNamespaces(migrate) {
+		namespaceDeployments, err := t.listDeployments(namespace, selector)
+		if err != nil {
+			return nil, fmt.Errorf("list the deployments in namespace %s%s: %s", namespace, t.location(), err.Error())
+		}
+		deployments = append(deployments, namespaceDeployments...)
+	}
+	return deployments, nil
+}
+
+// unknownConditions marks the conditions of the releases in the target as Unknown, the migrate is not finished until
+// their deployments can be listed again.
+func unknownConditions(t *target, migrateCopy *v1.Migrate, err error, now metav1.Time) {
+	for _, rls := range migrateCopy.Spec.Releases {
+		if t.releases[rls.Name] {
+			upsertCondition(migrateCopy, newCondition(constant.ConcatConditionType(rls.Name), constant.ConditionStatusUnknown,
+				fmt.Sprintf("Can not list the deployments of release [%s] : %s", rls.Name, err.Error()), now))
+		}
+	}
+}
+
+// releaseNamespaces returns the namespaces which the releases of the migrate are deployed to, the namespace of the
+// migrate if it has no releases.
+func releaseNamespaces(migrate *v1.Migrate) []string {
+	seen := map[string]bool{}
+	var namespaces []string
+	for _, rls := range migrate.Spec.Releases {
+		namespace := rls.Namespace
+		if namespace == "" {
+			namespace = migrate.Namespace
+		}
+		if !seen[namespace] {
+			seen[namespace] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return []string{migrate.Namespace}
+	}
+	return namespaces
+}
+
+// syncDeploymentConditions updates the conditions of the releases with the deployments in the target cluster.
+func (c *Controller) syncDeploymentConditions(t *target, migrateCopy *v1.Migrate, deployments []*appsv1.Deployment, now metav1.Time) {
+	logger := t.logFor(c.logFor(migrateCopy))
+	if len(deployments) > 0 {
+		logger.Debugf("Update the status with %d deployments", len(deployments))
+	}
+	for _, deploy := range deployments {
+		var message = ""
+		rlsName := deploy.Spec.Template.Labels[constant.ReleaseLabel]
+		conditionType := constant.ConcatConditionType(rlsName)
+
+		var currentRelease *v1.ReleasesConfig
+		for _, rls := range migrateCopy.Spec.Releases {
+			if rls.Name == rlsName {
+				currentRelease = rls
+			}
+		}
+
+		if currentRelease == nil || !t.releases[rlsName] {
+			logger.WithField(log.FieldRelease, rlsName).Info("The release of the deployment is not in the spec, wait for it to disappear")
+			continue
+		}
+
+		message = fmt.Sprintf("Deployment [%s]'s status%s: desired replica:%d, available:%d, Migrate replica count:%d",
+			deploy.GetName(), t.location(), deploy.Status.Replicas, deploy.Status.AvailableReplicas, currentRelease.Replicas)
+		upsertCondition(migrateCopy, newCondition(conditionType, constant.ConditionStatusFalse, message, now))
+		rlsLogger := logger.WithField(log.FieldRelease, rlsName)
+		rlsLogger.Info(message)
+		if deploy.Status.Replicas == deploy.Status.AvailableReplicas && deploy.Status.AvailableReplicas == currentRelease.Replicas {
+			getRelease, err := t.helmClient.GetRelease(currentRelease.Name)
+			if err != nil {
+				rlsLogger.WithError(err).Error("Get the release has an error")
+				c.recorder.Event(migrateCopy, corev1.EventTypeWarning, ErrGetRelease,
+					fmt.Sprintf("Error - Get the release [%s] info : %s", rlsName, err))
+			} else {
+				if migrateCopy.Status.ReleaseRevision == nil {
+					message = fmt.Sprintf("The revision information in Status is null, maybe you don't update the release yet. migrate [%s]",
+						migrateCopy.Name)
+					rlsLogger.Info(message)
+					upsertCondition(migrateCopy, newCondition(conditionType, constant.ConditionStatusFalse, message, now))
+					continue
+				}
+
+				if getRelease != nil && getRelease.Version == migrateCopy.Status.ReleaseRevision[currentRelease.Name] {
+					upsertCondition(migrateCopy,
+						newCondition(conditionType, constant.ConditionStatusTrue, message, now))
+				} else {
+					message = fmt.Sprintf("The revision information  [%d] in Status is not equals to the revision  [%d] in helm, wait for the next updating.",
+						migrateCopy.Status.ReleaseRevision[currentRelease.Name], getRelease.GetVersion())
+					rlsLogger.Info(message)
+					upsertCondition(migrateCopy, newCondition(conditionType, constant.ConditionStatusFalse, message, now))
+				}
+			}
+		} else {
+			message = fmt.Sprintf("Waiting for the deployment [%s] is available if you want to update the Status of migrate [%s]",
+				deploy.Name, migrateCopy.Name)
+			rlsLogger.Info(message)
+		}
+	}
+}
 
-	return err
+func newCondition(conditionType, status, message string, now metav1.Time) v1.MigrateCondition {
+	return v1.MigrateCondition{Type: conditionType, Status: status, LastProbeTime: now, LastTransitionTime: now, Message: message}
 }
 
 // Updating or inserting a condition for this migrate
@@ -583,13 +806,21 @@ func upsertCondition(migrateCopy *v1.Migrate, condition v1.MigrateCondition) {
 
 // You should calculate the final status for this migrate after inserting (update) its conditions.
 func calFinalStatus(migrateCopy *v1.Migrate, deployments []*appsv1.Deployment) {
-	if len(migrateCopy.Status.Conditions) != len(migrateCopy.Spec.Releases) {
+	// Only the conditions of the deployments are taken into account.
+	var conditions []v1.MigrateCondition
+	for _, c := range migrateCopy.Status.Conditions {
+		if strings.HasPrefix(c.Type, constant.ConditionTypePrefix) {
+			conditions = append(conditions, c)
+		}
+	}
+
+	if len(conditions) != len(migrateCopy.Spec.Releases) {
 		migrateCopy.Status.Finished = constant.ConditionStatusFalse
 		return
 	}
 
-	for _, c := range migrateCopy.Status.Conditions {
-		if c.Status == constant.ConditionStatusFalse {
+	for _, c := range conditions {
+		if c.Status != constant.ConditionStatusTrue {
 			migrateCopy.Status.Finished = constant.ConditionStatusFalse
 			return
 		}
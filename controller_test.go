@@ -19,23 +19,30 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/diff"
 	kubeinformers "k8s.io/client-go/informers"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
 
-	samplecontroller "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
 	"github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/fake"
 	informers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions"
+	"github.com/yangyongzhi/sym-operator/pkg/cluster"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
 )
 
 var (
@@ -43,44 +50,102 @@ var (
 	noResyncPeriodFunc = func() time.Duration { return 0 }
 )
 
+const (
+	testApp      = "demo"
+	blueRelease  = "demo-gz01-blue"
+	greenRelease = "demo-gz01-green"
+	rzRelease    = "demo-rz01-blue"
+)
+
 type fixture struct {
 	t *testing.T
 
 	client     *fake.Clientset
 	kubeclient *k8sfake.Clientset
+	backend    *helmtest.FakeBackend
+	// helmClients is used instead of the backend if it has been set.
+	helmClients *helm.ClientPool
+	// clusters provides the target clusters other than the local one.
+	clusters cluster.Provider
+	// shards tells which migrates are synced, all of them if it is nil.
+	shards shardOwner
+	// watchNamespace is the only namespace watched by the informers, all namespaces are watched if it is empty.
+	watchNamespace string
+	// policy is the spec of the OperatorConfig in effect.
+	policy v1.OperatorConfigSpec
+	// expectSyncError tells that the sync is expected to fail, so the migrate is requeued.
+	expectSyncError bool
+	recorder        *record.FakeRecorder
 	// Objects to put in the store.
-	fooLister        []*samplecontroller.Foo
+	migrateLister    []*v1.Migrate
+	clusterLister    []*v1.Cluster
 	deploymentLister []*apps.Deployment
-	// Actions expected to happen on the client.
-	kubeactions []core.Action
-	actions     []core.Action
 	// Objects from here preloaded into NewSimpleFake.
 	kubeobjects []runtime.Object
 	objects     []runtime.Object
 }
 
-func newFixture(t *testing.T) *fixture {
+func newFixture(t *testing.T, running ...*release.Release) *fixture {
 	f := &fixture{}
 	f.t = t
 	f.objects = []runtime.Object{}
 	f.kubeobjects = []runtime.Object{}
+	f.backend = helmtest.NewFakeBackend(running...)
+	f.recorder = record.NewFakeRecorder(100)
 	return f
 }
 
-func newFoo(name string, replicas *int32) *samplecontroller.Foo {
-	return &samplecontroller.Foo{
-		TypeMeta: metav1.TypeMeta{APIVersion: samplecontroller.SchemeGroupVersion.String()},
+func newMigrate(releases ...string) *v1.Migrate {
+	migrate := &v1.Migrate{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String()},
 		ObjectMeta: metav1.ObjectMeta{
+			Name:      testApp,
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: v1.MigrateSpec{
+			AppName: testApp,
+		},
+		Status: v1.MigrateStatus{
+			Finished: constant.ConditionStatusFalse,
+		},
+	}
+	for _, name := range releases {
+		migrate.Spec.Releases = append(migrate.Spec.Releases, &v1.ReleasesConfig{
 			Name:      name,
 			Namespace: metav1.NamespaceDefault,
+			Replicas:  2,
+			Raw:       "replicaCount: 2",
+		})
+	}
+	return migrate
+}
+
+func newDeployment(rlsName string, available int32) *apps.Deployment {
+	return &apps.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: apps.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rlsName,
+			Namespace: metav1.NamespaceDefault,
+			Labels:    map[string]string{constant.AppLabel: testApp, constant.ReleaseLabel: rlsName},
+		},
+		Spec: apps.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{constant.AppLabel: testApp, constant.ReleaseLabel: rlsName},
+				},
+			},
 		},
-		Spec: samplecontroller.FooSpec{
-			DeploymentName: fmt.Sprintf("%s-deployment", name),
-			Replicas:       replicas,
+		Status: apps.DeploymentStatus{
+			Replicas:          2,
+			AvailableReplicas: available,
 		},
 	}
 }
 
+func runningRelease(name string, version int32) *release.Release {
+	return helmtest.NewFakeRelease(name, metav1.NamespaceDefault, version, release.Status_DEPLOYED)
+}
+
 func (f *fixture) newController() (*Controller, informers.SharedInformerFactory, kubeinformers.SharedInformerFactory) {
 	f.client = fake.NewSimpleClientset(f.objects...)
 	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
@@ -88,15 +153,29 @@ func (f *fixture) newController() (*Controller, informers.SharedInformerFactory,
 	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
 	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
 
-	c := NewController(f.kubeclient, f.client,
-		k8sI.Apps().V1().Deployments(), i.Migrate().V1().Foos())
+	if f.helmClients == nil {
+		f.helmClients = helm.NewSharedClientPool(f.backend)
+	}
+	c := NewController(f.kubeclient, f.client, f.helmClients, f.clusters, []watchedInformers{{
+		namespace:   f.watchNamespace,
+		deployments: k8sI.Apps().V1().Deployments(),
+		migrates:    i.Devops().V1().Migrates(),
+		clusters:    i.Devops().V1().Clusters(),
+	}}, workqueue.DefaultControllerRateLimiter())
 
-	c.foosSynced = alwaysReady
+	c.symSynced = alwaysReady
 	c.deploymentsSynced = alwaysReady
-	c.recorder = &record.FakeRecorder{}
+	c.clustersSynced = alwaysReady
+	c.recorder = newTracedRecorder(f.recorder, c.traces)
+	c.shards = f.shards
+	c.policy.set(f.policy)
 
-	for _, f := range f.fooLister {
-		i.Migrate().V1().Foos().Informer().GetIndexer().Add(f)
+	for _, m := range f.migrateLister {
+		i.Devops().V1().Migrates().Informer().GetIndexer().Add(m)
+	}
+
+	for _, registered := range f.clusterLister {
+		i.Devops().V1().Clusters().Informer().GetIndexer().Add(registered)
 	}
 
 	for _, d := range f.deploymentLister {
@@ -106,101 +185,55 @@ func (f *fixture) newController() (*Controller, informers.SharedInformerFactory,
 	return c, i, k8sI
 }
 
-func (f *fixture) run(fooName string) {
-	f.runController(fooName, true, false)
+func (f *fixture) addMigrate(migrate *v1.Migrate) {
+	f.migrateLister = append(f.migrateLister, migrate)
+	f.objects = append(f.objects, migrate)
 }
 
-func (f *fixture) runExpectError(fooName string) {
-	f.runController(fooName, true, true)
+func (f *fixture) addCluster(registered *v1.Cluster) {
+	f.clusterLister = append(f.clusterLister, registered)
+	f.objects = append(f.objects, registered)
 }
 
-func (f *fixture) runController(fooName string, startInformers bool, expectError bool) {
-	c, i, k8sI := f.newController()
-	if startInformers {
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-		i.Start(stopCh)
-		k8sI.Start(stopCh)
-	}
-
-	err := c.syncHandler(fooName)
-	if !expectError && err != nil {
-		f.t.Errorf("error syncing foo: %v", err)
-	} else if expectError && err == nil {
-		f.t.Error("expected error syncing foo, got nil")
-	}
+func (f *fixture) addDeployment(d *apps.Deployment) {
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+}
 
-	actions := filterInformerActions(f.client.Actions())
-	for i, action := range actions {
-		if len(f.actions) < i+1 {
-			f.t.Errorf("%d unexpected actions: %+v", len(actions)-len(f.actions), actions[i:])
-			break
-		}
+// run syncs the migrate and returns the migrate which has been updated by the controller, nil if it is not updated.
+func (f *fixture) run(migrate *v1.Migrate) *v1.Migrate {
+	c, i, k8sI := f.newController()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
 
-		expectedAction := f.actions[i]
-		checkAction(expectedAction, action, f.t)
+	err := c.syncHandler(getKey(migrate, f.t))
+	if err != nil && !f.expectSyncError {
+		f.t.Errorf("error syncing migrate: %v", err)
 	}
-
-	if len(f.actions) > len(actions) {
-		f.t.Errorf("%d additional expected actions:%+v", len(f.actions)-len(actions), f.actions[len(actions):])
+	if err == nil && f.expectSyncError {
+		f.t.Errorf("expected the migrate to be requeued")
 	}
 
-	k8sActions := filterInformerActions(f.kubeclient.Actions())
-	for i, action := range k8sActions {
-		if len(f.kubeactions) < i+1 {
-			f.t.Errorf("%d unexpected actions: %+v", len(k8sActions)-len(f.kubeactions), k8sActions[i:])
-			break
+	var updated *v1.Migrate
+	for _, action := range filterInformerActions(f.client.Actions()) {
+		if update, ok := action.(core.UpdateAction); ok && action.Matches("update", "migrates") {
+			updated = update.GetObject().(*v1.Migrate)
 		}
-
-		expectedAction := f.kubeactions[i]
-		checkAction(expectedAction, action, f.t)
-	}
-
-	if len(f.kubeactions) > len(k8sActions) {
-		f.t.Errorf("%d additional expected actions:%+v", len(f.kubeactions)-len(k8sActions), f.kubeactions[len(k8sActions):])
 	}
+	return updated
 }
 
-// checkAction verifies that expected and actual actions are equal and both have
-// same attached resources
-func checkAction(expected, actual core.Action, t *testing.T) {
-	if !(expected.Matches(actual.GetVerb(), actual.GetResource().Resource) && actual.GetSubresource() == expected.GetSubresource()) {
-		t.Errorf("Expected\n\t%#v\ngot\n\t%#v", expected, actual)
-		return
-	}
-
-	if reflect.TypeOf(actual) != reflect.TypeOf(expected) {
-		t.Errorf("Action has wrong type. Expected: %t. Got: %t", expected, actual)
-		return
-	}
-
-	switch a := actual.(type) {
-	case core.CreateAction:
-		e, _ := expected.(core.CreateAction)
-		expObject := e.GetObject()
-		object := a.GetObject()
-
-		if !reflect.DeepEqual(expObject, object) {
-			t.Errorf("Action %s %s has wrong object\nDiff:\n %s",
-				a.GetVerb(), a.GetResource().Resource, diff.ObjectGoPrintDiff(expObject, object))
-		}
-	case core.UpdateAction:
-		e, _ := expected.(core.UpdateAction)
-		expObject := e.GetObject()
-		object := a.GetObject()
-
-		if !reflect.DeepEqual(expObject, object) {
-			t.Errorf("Action %s %s has wrong object\nDiff:\n %s",
-				a.GetVerb(), a.GetResource().Resource, diff.ObjectGoPrintDiff(expObject, object))
-		}
-	case core.PatchAction:
-		e, _ := expected.(core.PatchAction)
-		expPatch := e.GetPatch()
-		patch := a.GetPatch()
-
-		if !reflect.DeepEqual(expPatch, patch) {
-			t.Errorf("Action %s %s has wrong patch\nDiff:\n %s",
-				a.GetVerb(), a.GetResource().Resource, diff.ObjectGoPrintDiff(expPatch, patch))
+// events returns the reasons of the recorded events.
+func (f *fixture) events() []string {
+	var reasons []string
+	for {
+		select {
+		case event := <-f.recorder.Events:
+			reasons = append(reasons, strings.SplitN(event, " ", 3)[1])
+		default:
+			return reasons
 		}
 	}
 }
@@ -212,8 +245,8 @@ func filterInformerActions(actions []core.Action) []core.Action {
 	ret := []core.Action{}
 	for _, action := range actions {
 		if len(action.GetNamespace()) == 0 &&
-			(action.Matches("list", "foos") ||
-				action.Matches("watch", "foos") ||
+			(action.Matches("list", "migrates") ||
+				action.Matches("watch", "migrates") ||
 				action.Matches("list", "deployments") ||
 				action.Matches("watch", "deployments")) {
 			continue
@@ -224,90 +257,836 @@ func filterInformerActions(actions []core.Action) []core.Action {
 	return ret
 }
 
-func (f *fixture) expectCreateDeploymentAction(d *apps.Deployment) {
-	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d))
+func getKey(migrate *v1.Migrate, t *testing.T) string {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(migrate)
+	if err != nil {
+		t.Errorf("Unexpected error getting key for migrate %v: %v", migrate.Name, err)
+		return ""
+	}
+	return key
 }
 
-func (f *fixture) expectUpdateDeploymentAction(d *apps.Deployment) {
-	f.kubeactions = append(f.kubeactions, core.NewUpdateAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d))
+func TestSyncReleases(t *testing.T) {
+	tests := []struct {
+		name     string
+		migrate  *v1.Migrate
+		running  []*release.Release
+		failures map[string]error
+		// expectError tells that the migrate is expected to be requeued.
+		expectError bool
+		// The actions expected to happen on the release backend.
+		expectedActions   []string
+		expectedRevisions map[string]int32
+		expectedEvents    []string
+	}{
+		{
+			name:              "install missing release",
+			migrate:           newMigrate(blueRelease),
+			expectedActions:   []string{"list", "install/" + blueRelease},
+			expectedRevisions: map[string]int32{blueRelease: 1},
+			expectedEvents:    []string{SuccessInstalledStatus, SuccessSynced},
+		},
+		{
+			name:              "update release with a new revision",
+			migrate:           withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}),
+			running:           []*release.Release{runningRelease(blueRelease, 2)},
+			expectedActions:   []string{"list", "update/" + blueRelease},
+			expectedRevisions: map[string]int32{blueRelease: 3},
+			expectedEvents:    []string{SuccessUpdatedStatus, SuccessSynced},
+		},
+		{
+			name:              "nothing to do if the revision has been synced",
+			migrate:           withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 2}),
+			running:           []*release.Release{runningRelease(blueRelease, 2)},
+			expectedActions:   []string{"list"},
+			expectedRevisions: map[string]int32{blueRelease: 2},
+			expectedEvents:    []string{SuccessSynced},
+		},
+		{
+			name:              "only one release is installed in a sync",
+			migrate:           newMigrate(blueRelease, greenRelease),
+			expectedActions:   []string{"list", "install/" + blueRelease},
+			expectedRevisions: map[string]int32{blueRelease: 1},
+			expectedEvents:    []string{SuccessInstalledStatus, SuccessSynced},
+		},
+		{
+			name:            "install failure is recorded",
+			migrate:         newMigrate(blueRelease),
+			failures:        map[string]error{"install/" + blueRelease: fmt.Errorf("tiller is down")},
+			expectedActions: []string{"list", "install/" + blueRelease},
+			expectedEvents:  []string{ErrReleaseContent, SuccessSynced},
+		},
+		{
+			name:            "update failure keeps the old revision",
+			migrate:         withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}),
+			running:         []*release.Release{runningRelease(blueRelease, 2)},
+			failures:        map[string]error{"update/" + blueRelease: fmt.Errorf("timed out")},
+			expectedActions: []string{"list", "update/" + blueRelease},
+			// The revision is not changed since the update has failed.
+			expectedRevisions: map[string]int32{blueRelease: 1},
+			expectedEvents:    []string{ErrReleaseContent, SuccessSynced},
+		},
+		{
+			name:            "list failure requeues the migrate",
+			migrate:         newMigrate(blueRelease),
+			failures:        map[string]error{"list": fmt.Errorf("tiller is down")},
+			expectError:     true,
+			expectedActions: []string{"list"},
+			expectedEvents:  []string{ErrDeleteRelease},
+		},
+		{
+			name:            "nothing to do for a finished migrate",
+			migrate:         withFinished(newMigrate(blueRelease)),
+			expectedActions: nil,
+			expectedEvents:  []string{SuccessSynced},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.running...)
+			for key, err := range test.failures {
+				parts := strings.SplitN(key, "/", 2)
+				f.backend.FailOn(parts[0], strings.Join(parts[1:], ""), err)
+			}
+			f.expectSyncError = test.expectError
+			f.addMigrate(test.migrate)
+
+			updated := f.run(test.migrate)
+			if updated == nil {
+				t.Fatalf("expected the migrate to be updated")
+			}
+
+			checkActions(t, test.expectedActions, f.backend.Actions)
+			if len(test.expectedRevisions) != 0 || len(updated.Status.ReleaseRevision) != 0 {
+				if !reflect.DeepEqual(test.expectedRevisions, updated.Status.ReleaseRevision) {
+					t.Errorf("expected revisions %v, got %v", test.expectedRevisions, updated.Status.ReleaseRevision)
+				}
+			}
+			checkEvents(t, test.expectedEvents, f.events())
+		})
+	}
 }
 
-func (f *fixture) expectUpdateFooStatusAction(foo *samplecontroller.Foo) {
-	action := core.NewUpdateAction(schema.GroupVersionResource{Resource: "foos"}, foo.Namespace, foo)
-	// TODO: Until #38113 is merged, we can't use Subresource
-	//action.Subresource = "status"
-	f.actions = append(f.actions, action)
+func TestPruneReleases(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        v1.PrunePolicyType
+		defaultPolicy v1.PrunePolicyType
+		limit         *int32
+		annotations   map[string]string
+		running       []*release.Release
+		protected     []string
+		// The releases expected to be uninstalled.
+		expectedPruned []string
+		expectedEvents []string
+	}{
+		{
+			name:           "delete undefined release by default",
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedPruned: []string{greenRelease},
+			expectedEvents: []string{PrunedRelease, SuccessSynced},
+		},
+		{
+			name:           "orphan undefined release",
+			policy:         v1.PrunePolicyOrphan,
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedEvents: []string{KeptRelease, SuccessSynced},
+		},
+		{
+			name:           "orphan undefined release by the default policy of the operator config",
+			defaultPolicy:  v1.PrunePolicyOrphan,
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedEvents: []string{KeptRelease, SuccessSynced},
+		},
+		{
+			name:           "the policy of the migrate overrides the default one",
+			policy:         v1.PrunePolicyDelete,
+			defaultPolicy:  v1.PrunePolicyOrphan,
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedPruned: []string{greenRelease},
+			expectedEvents: []string{PrunedRelease, SuccessSynced},
+		},
+		{
+			name:           "wait for confirmation",
+			policy:         v1.PrunePolicyConfirm,
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedEvents: []string{KeptRelease, SuccessSynced},
+		},
+		{
+			name:           "prune the confirmed release",
+			policy:         v1.PrunePolicyConfirm,
+			annotations:    map[string]string{constant.ConfirmPruneAnnotation: greenRelease},
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedPruned: []string{greenRelease},
+			expectedEvents: []string{PrunedRelease, SuccessSynced},
+		},
+		{
+			name:           "keep the protected release",
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			protected:      []string{greenRelease},
+			expectedEvents: []string{KeptRelease, SuccessSynced},
+		},
+		{
+			name: "refuse to prune if the limit is exceeded",
+			running: []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1),
+				runningRelease(rzRelease, 1)},
+			expectedEvents: []string{PruneLimitExceeded, KeptRelease, KeptRelease, SuccessSynced},
+		},
+		{
+			name:  "prune releases within the limit",
+			limit: int32Ptr(2),
+			running: []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1),
+				runningRelease(rzRelease, 1)},
+			expectedPruned: []string{greenRelease, rzRelease},
+			expectedEvents: []string{PrunedRelease, PrunedRelease, SuccessSynced},
+		},
+		{
+			name:           "prune nothing if the limit is negative",
+			limit:          int32Ptr(-1),
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedEvents: []string{InvalidPruneLimit, PruneLimitExceeded, KeptRelease, SuccessSynced},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.running...)
+			f.policy.DefaultPrunePolicy = test.defaultPolicy
+			migrate := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1})
+			migrate.Spec.PrunePolicy = test.policy
+			migrate.Spec.PruneLimit = test.limit
+			migrate.Annotations = test.annotations
+			f.addMigrate(migrate)
+			for _, name := range test.protected {
+				d := newDeployment(name, 2)
+				d.Annotations = map[string]string{constant.ProtectedAnnotation: "true"}
+				f.addDeployment(d)
+			}
+
+			f.run(migrate)
+
+			var pruned []string
+			for _, action := range f.backend.Actions {
+				if strings.HasPrefix(action, helm.OperationUninstall+"/") {
+					pruned = append(pruned, strings.TrimPrefix(action, helm.OperationUninstall+"/"))
+				}
+			}
+			if !reflect.DeepEqual(test.expectedPruned, pruned) {
+				t.Errorf("expected pruned releases %v, got %v", test.expectedPruned, pruned)
+			}
+			for _, name := range pruned {
+				if f.backend.Release(name) != nil {
+					t.Errorf("expected release %s to be uninstalled", name)
+				}
+			}
+			checkEvents(t, test.expectedEvents, f.events())
+		})
+	}
 }
 
-func getKey(foo *samplecontroller.Foo, t *testing.T) string {
-	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(foo)
-	if err != nil {
-		t.Errorf("Unexpected error getting key for foo %v: %v", foo.Name, err)
-		return ""
+func TestKeptReleaseRecordedOnce(t *testing.T) {
+	migrate := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1})
+	steps := []struct {
+		name    string
+		policy  v1.PrunePolicyType
+		running []*release.Release
+		// The reasons of the kept releases expected in the status.
+		expectedKept   map[string]string
+		expectedEvents []string
+	}{
+		{
+			name:           "record the kept release",
+			policy:         v1.PrunePolicyOrphan,
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedKept:   map[string]string{greenRelease: "prune policy is Orphan"},
+			expectedEvents: []string{KeptRelease, SuccessSynced},
+		},
+		{
+			name:           "no event if the release is kept for the same reason",
+			policy:         v1.PrunePolicyOrphan,
+			running:        []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedKept:   map[string]string{greenRelease: "prune policy is Orphan"},
+			expectedEvents: []string{SuccessSynced},
+		},
+		{
+			name:    "record the release kept for another reason",
+			policy:  v1.PrunePolicyConfirm,
+			running: []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			expectedKept: map[string]string{
+				greenRelease: fmt.Sprintf("waiting for confirmation in annotation %s", constant.ConfirmPruneAnnotation)},
+			expectedEvents: []string{KeptRelease, SuccessSynced},
+		},
+		{
+			name:           "forget the release which is not running any more",
+			policy:         v1.PrunePolicyConfirm,
+			running:        []*release.Release{runningRelease(blueRelease, 1)},
+			expectedEvents: []string{SuccessSynced},
+		},
+	}
+
+	for _, step := range steps {
+		f := newFixture(t, step.running...)
+		migrate.Spec.PrunePolicy = step.policy
+		f.addMigrate(migrate)
+		updated := f.run(migrate)
+		if updated == nil {
+			t.Fatalf("%s: expected the migrate to be updated", step.name)
+		}
+		if len(step.expectedKept) != 0 || len(updated.Status.KeptReleases) != 0 {
+			if !reflect.DeepEqual(step.expectedKept, updated.Status.KeptReleases) {
+				t.Errorf("%s: expected kept releases %v, got %v", step.name, step.expectedKept, updated.Status.KeptReleases)
+			}
+		}
+		checkEvents(t, step.expectedEvents, f.events())
+		migrate = updated
 	}
-	return key
 }
 
-func TestCreatesDeployment(t *testing.T) {
-	f := newFixture(t)
-	foo := newFoo("test", int32Ptr(1))
+func TestSyncStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		migrate     *v1.Migrate
+		running     []*release.Release
+		deployments []*apps.Deployment
+		// The status of the condition of each release.
+		expectedConditions map[string]string
+		expectedFinished   string
+	}{
+		{
+			name:               "finished when all deployments are available",
+			migrate:            withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}),
+			running:            []*release.Release{runningRelease(blueRelease, 1)},
+			deployments:        []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedConditions: map[string]string{blueRelease: constant.ConditionStatusTrue},
+			expectedFinished:   constant.ConditionStatusTrue,
+		},
+		{
+			name:               "not finished when deployment is not available",
+			migrate:            withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}),
+			running:            []*release.Release{runningRelease(blueRelease, 1)},
+			deployments:        []*apps.Deployment{newDeployment(blueRelease, 1)},
+			expectedConditions: map[string]string{blueRelease: constant.ConditionStatusFalse},
+			expectedFinished:   constant.ConditionStatusFalse,
+		},
+		{
+			name:               "not finished when a deployment is missing",
+			migrate:            withRevisions(newMigrate(blueRelease, greenRelease), map[string]int32{blueRelease: 1, greenRelease: 1}),
+			running:            []*release.Release{runningRelease(blueRelease, 1), runningRelease(greenRelease, 1)},
+			deployments:        []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedConditions: map[string]string{blueRelease: constant.ConditionStatusTrue},
+			expectedFinished:   constant.ConditionStatusFalse,
+		},
+		{
+			name:               "not finished when the revision is out of date",
+			migrate:            withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}),
+			running:            []*release.Release{runningRelease(blueRelease, 1), runningRelease(blueRelease, 2)},
+			deployments:        []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedConditions: map[string]string{blueRelease: constant.ConditionStatusFalse},
+			expectedFinished:   constant.ConditionStatusFalse,
+		},
+	}
 
-	f.fooLister = append(f.fooLister, foo)
-	f.objects = append(f.objects, foo)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.running...)
+			f.addMigrate(test.migrate)
+			for _, d := range test.deployments {
+				f.addDeployment(d)
+			}
 
-	expDeployment := newDeployment(foo)
-	f.expectCreateDeploymentAction(expDeployment)
-	f.expectUpdateFooStatusAction(foo)
+			updated := f.run(test.migrate)
+			if updated == nil {
+				t.Fatalf("expected the migrate to be updated")
+			}
 
-	f.run(getKey(foo, t))
+			conditions := map[string]string{}
+			for _, c := range updated.Status.Conditions {
+				conditions[strings.TrimPrefix(c.Type, constant.ConditionTypePrefix)] = c.Status
+			}
+			if !reflect.DeepEqual(test.expectedConditions, conditions) {
+				t.Errorf("expected conditions %v, got %v", test.expectedConditions, conditions)
+			}
+			if updated.Status.Finished != test.expectedFinished {
+				t.Errorf("expected finished %s, got %s", test.expectedFinished, updated.Status.Finished)
+			}
+		})
+	}
 }
 
-func TestDoNothing(t *testing.T) {
+func TestSyncWithTillerOfMigrate(t *testing.T) {
+	backends := map[string]*helmtest.FakeBackend{}
 	f := newFixture(t)
-	foo := newFoo("test", int32Ptr(1))
-	d := newDeployment(foo)
+	f.helmClients = helm.NewClientPool("kube-system", 0, func(namespace string, stopCh <-chan struct{}) (helm.ReleaseBackend, error) {
+		backends[namespace] = helmtest.NewFakeBackend()
+		return backends[namespace], nil
+	})
+	migrate := newMigrate(blueRelease)
+	migrate.Spec.TillerNamespace = "team-a"
+	f.addMigrate(migrate)
 
-	f.fooLister = append(f.fooLister, foo)
-	f.objects = append(f.objects, foo)
-	f.deploymentLister = append(f.deploymentLister, d)
-	f.kubeobjects = append(f.kubeobjects, d)
+	f.run(migrate)
 
-	f.expectUpdateFooStatusAction(foo)
-	f.run(getKey(foo, t))
+	if backends["team-a"] == nil || backends["team-a"].Release(blueRelease) == nil {
+		t.Errorf("expected the release to be installed by the tiller in team-a")
+	}
+	if backends["kube-system"] != nil {
+		t.Errorf("expected the default tiller not to be used")
+	}
+
+	f = newFixture(t)
+	f.helmClients = helm.NewClientPool("kube-system", 0, func(namespace string, stopCh <-chan struct{}) (helm.ReleaseBackend, error) {
+		return nil, fmt.Errorf("can not find a running tiller pod")
+	})
+	migrate = newMigrate(blueRelease)
+	migrate.Spec.TillerNamespace = "team-b"
+	f.addMigrate(migrate)
+	c, _, _ := f.newController()
+	if err := c.syncHandler(getKey(migrate, t)); err == nil {
+		t.Errorf("expected the migrate to be requeued if the tiller can not be reached")
+	}
+	checkEvents(t, []string{ErrHelmClient}, f.events())
 }
 
-func TestUpdateDeployment(t *testing.T) {
-	f := newFixture(t)
-	foo := newFoo("test", int32Ptr(1))
-	d := newDeployment(foo)
+// fakeClusters returns the clusters by the name of their kubeconfig secrets.
+type fakeClusters map[string]*cluster.Cluster
+
+func (f fakeClusters) Get(namespace, secretName string) (*cluster.Cluster, error) {
+	if c, ok := f[secretName]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("secret %s/%s not found", namespace, secretName)
+}
 
-	// Update replicas
-	foo.Spec.Replicas = int32Ptr(2)
-	expDeployment := newDeployment(foo)
+func newFakeCluster(name string, backend *helmtest.FakeBackend, deployments ...*apps.Deployment) *cluster.Cluster {
+	var objects []runtime.Object
+	for _, d := range deployments {
+		objects = append(objects, d)
+	}
+	return &cluster.Cluster{Name: name, KubeClient: k8sfake.NewSimpleClientset(objects...), HelmClients: helm.NewSharedClientPool(backend)}
+}
 
-	f.fooLister = append(f.fooLister, foo)
-	f.objects = append(f.objects, foo)
-	f.deploymentLister = append(f.deploymentLister, d)
-	f.kubeobjects = append(f.kubeobjects, d)
+func withTargetCluster(migrate *v1.Migrate, rlsName, cluster string) *v1.Migrate {
+	findReleaseConfig(migrate, rlsName).TargetCluster = cluster
+	return migrate
+}
+
+func TestSyncTargetClusters(t *testing.T) {
+	const remote = "prod-kubeconfig"
+	tests := []struct {
+		name          string
+		migrate       *v1.Migrate
+		localRunning  []*release.Release
+		remoteRunning []*release.Release
+		localDeploys  []*apps.Deployment
+		remoteDeploys []*apps.Deployment
+		// The errors injected into the operations on the release backend of the target cluster.
+		remoteFailures map[string]error
+		// The actions expected to happen on the release backend of each cluster.
+		expectedLocalActions  []string
+		expectedRemoteActions []string
+		expectedFinished      string
+	}{
+		{
+			name:                  "install release in target cluster",
+			migrate:               withTargetCluster(withRevisions(newMigrate(blueRelease, greenRelease), map[string]int32{blueRelease: 1}), greenRelease, remote),
+			localRunning:          []*release.Release{runningRelease(blueRelease, 1)},
+			localDeploys:          []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedLocalActions:  []string{"list"},
+			expectedRemoteActions: []string{"list", "install/" + greenRelease},
+			expectedFinished:      constant.ConditionStatusFalse,
+		},
+		{
+			name:                  "prune release moved to target cluster",
+			migrate:               withTargetCluster(withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}), blueRelease, remote),
+			localRunning:          []*release.Release{runningRelease(blueRelease, 1)},
+			remoteRunning:         []*release.Release{runningRelease(blueRelease, 1)},
+			remoteDeploys:         []*apps.Deployment{newDeployment(blueRelease, 2)},
+			expectedLocalActions:  []string{"list", "uninstall/" + blueRelease},
+			expectedRemoteActions: []string{"list"},
+			expectedFinished:      constant.ConditionStatusTrue,
+		},
+		{
+			name:                  "keep release moved to target cluster until it is available",
+			migrate:               withTargetCluster(withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1}), blueRelease, remote),
+			localRunning:          []*release.Release{runningRelease(blueRelease, 1)},
+			remoteRunning:         []*release.Release{runningRelease(blueRelease, 1)},
+			remoteDeploys:         []*apps.Deployment{newDeployment(blueRelease, 1)},
+			expectedLocalActions:  []string{"list"},
+			expectedRemoteActions: []string{"list"},
+			expectedFinished:      constant.ConditionStatusFalse,
+		},
+		{
+			name:                  "keep release moved to target cluster when its install fails",
+			migrate:               withTargetCluster(newMigrate(blueRelease), blueRelease, remote),
+			localRunning:          []*release.Release{runningRelease(blueRelease, 1)},
+			remoteFailures:        map[string]error{"install/" + blueRelease: fmt.Errorf("tiller is down")},
+			expectedLocalActions:  []string{"list"},
+			expectedRemoteActions: []string{"list", "install/" + blueRelease},
+			expectedFinished:      constant.ConditionStatusFalse,
+		},
+		{
+			name: "finished when deployments of all clusters are available",
+			migrate: withTargetCluster(withRevisions(newMigrate(blueRelease, greenRelease),
+				map[string]int32{blueRelease: 1, greenRelease: 1}), greenRelease, remote),
+			localRunning:          []*release.Release{runningRelease(blueRelease, 1)},
+			remoteRunning:         []*release.Release{runningRelease(greenRelease, 1)},
+			localDeploys:          []*apps.Deployment{newDeployment(blueRelease, 2)},
+			remoteDeploys:         []*apps.Deployment{newDeployment(greenRelease, 2)},
+			expectedLocalActions:  []string{"list"},
+			expectedRemoteActions: []string{"list"},
+			expectedFinished:      constant.ConditionStatusTrue,
+		},
+		{
+			name: "deployment in another cluster than the release is ignored",
+			migrate: withTargetCluster(withRevisions(newMigrate(greenRelease),
+				map[string]int32{greenRelease: 1}), greenRelease, remote),
+			remoteRunning:         []*release.Release{runningRelease(greenRelease, 1)},
+			localDeploys:          []*apps.Deployment{newDeployment(greenRelease, 2)},
+			expectedLocalActions:  []string{"list"},
+			expectedRemoteActions: []string{"list"},
+			expectedFinished:      constant.ConditionStatusFalse,
+		},
+	}
 
-	f.expectUpdateFooStatusAction(foo)
-	f.expectUpdateDeploymentAction(expDeployment)
-	f.run(getKey(foo, t))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.localRunning...)
+			remoteBackend := helmtest.NewFakeBackend(test.remoteRunning...)
+			for key, err := range test.remoteFailures {
+				parts := strings.SplitN(key, "/", 2)
+				remoteBackend.FailOn(parts[0], strings.Join(parts[1:], ""), err)
+			}
+			f.clusters = fakeClusters{remote: newFakeCluster(remote, remoteBackend, test.remoteDeploys...)}
+			f.addMigrate(test.migrate)
+			for _, d := range test.localDeploys {
+				f.addDeployment(d)
+			}
+
+			updated := f.run(test.migrate)
+			checkActions(t, test.expectedLocalActions, f.backend.Actions)
+			checkActions(t, test.expectedRemoteActions, remoteBackend.Actions)
+			if updated == nil {
+				t.Fatalf("expected the migrate to be updated")
+			}
+			if updated.Status.Finished != test.expectedFinished {
+				t.Errorf("expected finished %s, got %s", test.expectedFinished, updated.Status.Finished)
+			}
+		})
+	}
+}
+
+func TestSyncWithUnreachableTargetCluster(t *testing.T) {
+	const remote = "prod-kubeconfig"
+	f := newFixture(t, runningRelease(blueRelease, 2))
+	remoteBackend := helmtest.NewFakeBackend(runningRelease(greenRelease, 1))
+	remoteBackend.FailOn(helm.OperationList, "", fmt.Errorf("tiller is down"))
+	remoteCluster := newFakeCluster(remote, remoteBackend)
+	remoteCluster.KubeClient.(*k8sfake.Clientset).PrependReactor("list", "deployments",
+		func(action core.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("connection refused")
+		})
+	f.clusters = fakeClusters{remote: remoteCluster}
+	f.expectSyncError = true
+	migrate := withTargetCluster(withRevisions(newMigrate(blueRelease, greenRelease),
+		map[string]int32{blueRelease: 1, greenRelease: 1}), greenRelease, remote)
+	f.addMigrate(migrate)
+	f.addDeployment(newDeployment(blueRelease, 2))
+
+	updated := f.run(migrate)
+	// The release in the reachable cluster is still synced.
+	checkActions(t, []string{"list", "update/" + blueRelease}, f.backend.Actions)
+	checkActions(t, []string{"list"}, remoteBackend.Actions)
+	if updated == nil {
+		t.Fatalf("expected the migrate to be updated")
+	}
+	if expected := map[string]int32{blueRelease: 3, greenRelease: 1}; !reflect.DeepEqual(expected, updated.Status.ReleaseRevision) {
+		t.Errorf("expected revisions %v, got %v", expected, updated.Status.ReleaseRevision)
+	}
+	condition := findCondition(updated, constant.ConcatConditionType(greenRelease))
+	if condition == nil || condition.Status != constant.ConditionStatusUnknown {
+		t.Errorf("expected the condition of the release in the unreachable cluster to be unknown, got %v", condition)
+	}
+	if updated.Status.Finished != constant.ConditionStatusFalse {
+		t.Errorf("expected the migrate not to be finished, got %s", updated.Status.Finished)
+	}
+	checkEvents(t, []string{ErrDeleteRelease, SuccessUpdatedStatus}, f.events())
 }
 
-func TestNotControlledByUs(t *testing.T) {
+func TestSyncWithUnknownTargetCluster(t *testing.T) {
 	f := newFixture(t)
-	foo := newFoo("test", int32Ptr(1))
-	d := newDeployment(foo)
+	f.clusters = fakeClusters{}
+	migrate := withTargetCluster(newMigrate(blueRelease), blueRelease, "missing-kubeconfig")
+	f.addMigrate(migrate)
+	c, _, _ := f.newController()
+	if err := c.syncHandler(getKey(migrate, t)); err == nil {
+		t.Errorf("expected the migrate to be requeued if the target cluster can not be reached")
+	}
+	checkEvents(t, []string{ErrHelmClient}, f.events())
+	checkActions(t, nil, f.backend.Actions)
+}
 
-	d.ObjectMeta.OwnerReferences = []metav1.OwnerReference{}
+func newRegisteredCluster(name, ldc string, healthy bool) *v1.Cluster {
+	return &v1.Cluster{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault},
+		Spec:       v1.ClusterSpec{KubeconfigSecret: name + "-kubeconfig", LDC: ldc},
+		Status:     v1.ClusterStatus{Healthy: healthy, Reachable: healthy},
+	}
+}
 
-	f.fooLister = append(f.fooLister, foo)
-	f.objects = append(f.objects, foo)
-	f.deploymentLister = append(f.deploymentLister, d)
-	f.kubeobjects = append(f.kubeobjects, d)
+func withClusterSelector(migrate *v1.Migrate, rlsName string, selector map[string]string) *v1.Migrate {
+	findReleaseConfig(migrate, rlsName).ClusterSelector = &metav1.LabelSelector{MatchLabels: selector}
+	return migrate
+}
+
+func TestScheduleReleases(t *testing.T) {
+	unschedulable := newRegisteredCluster("sh-01", "sh", true)
+	unschedulable.Spec.Unschedulable = true
+	installed := []string{SuccessInstalledStatus, SuccessSynced}
+	tests := []struct {
+		name       string
+		migrate    *v1.Migrate
+		registered []*v1.Cluster
+		// The cluster which the release is expected to be installed in, empty if it can not be scheduled.
+		expectedCluster string
+		expectedEvents  []string
+	}{
+		{
+			name:            "schedule to healthy cluster",
+			migrate:         withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+			registered:      []*v1.Cluster{newRegisteredCluster("sh-02", "sh", false), newRegisteredCluster("sh-03", "sh", true)},
+			expectedCluster: "sh-03",
+			expectedEvents:  installed,
+		},
+		{
+			name:            "skip clusters in other ldc",
+			migrate:         withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+			registered:      []*v1.Cluster{newRegisteredCluster("bj-01", "bj", true), newRegisteredCluster("sh-03", "sh", true)},
+			expectedCluster: "sh-03",
+			expectedEvents:  installed,
+		},
+		{
+			name:           "skip unschedulable cluster",
+			migrate:        withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+			registered:     []*v1.Cluster{unschedulable},
+			expectedEvents: []string{ErrHelmClient},
+		},
+		{
+			name: "stay in scheduled cluster",
+			migrate: withScheduled(withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+				map[string]string{blueRelease: "sh-02"}),
+			registered:      []*v1.Cluster{newRegisteredCluster("sh-01", "sh", true), newRegisteredCluster("sh-02", "sh", true)},
+			expectedCluster: "sh-02",
+			expectedEvents:  installed,
+		},
+		{
+			name: "reschedule once the cluster is not selected",
+			migrate: withScheduled(withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+				map[string]string{blueRelease: "bj-01"}),
+			registered:      []*v1.Cluster{newRegisteredCluster("bj-01", "bj", true), newRegisteredCluster("sh-01", "sh", true)},
+			expectedCluster: "sh-01",
+			expectedEvents:  installed,
+		},
+		{
+			name: "reschedule once the cluster is unhealthy",
+			migrate: withScheduled(withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+				map[string]string{blueRelease: "sh-02"}),
+			registered:      []*v1.Cluster{newRegisteredCluster("sh-01", "sh", true), newRegisteredCluster("sh-02", "sh", false)},
+			expectedCluster: "sh-01",
+			expectedEvents:  []string{RescheduledRelease, SuccessInstalledStatus, SuccessSynced},
+		},
+		{
+			name: "reschedule once the cluster is unschedulable",
+			migrate: withScheduled(withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+				map[string]string{blueRelease: "sh-01"}),
+			registered:      []*v1.Cluster{unschedulable, newRegisteredCluster("sh-02", "sh", true)},
+			expectedCluster: "sh-02",
+			expectedEvents:  []string{RescheduledRelease, SuccessInstalledStatus, SuccessSynced},
+		},
+		{
+			name: "stay in unhealthy cluster if no other one is healthy",
+			migrate: withScheduled(withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"}),
+				map[string]string{blueRelease: "sh-02"}),
+			registered:      []*v1.Cluster{newRegisteredCluster("sh-02", "sh", false)},
+			expectedCluster: "sh-02",
+			expectedEvents:  installed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			backends := map[string]*helmtest.FakeBackend{}
+			clusters := fakeClusters{}
+			for _, registered := range test.registered {
+				f.addCluster(registered)
+				backends[registered.Name] = helmtest.NewFakeBackend()
+				clusters[registered.Spec.KubeconfigSecret] = newFakeCluster(registered.Spec.KubeconfigSecret, backends[registered.Name])
+			}
+			f.clusters = clusters
+			f.addMigrate(test.migrate)
+
+			if test.expectedCluster == "" {
+				c, _, _ := f.newController()
+				if err := c.syncHandler(getKey(test.migrate, t)); err == nil {
+					t.Errorf("expected the migrate to be requeued if no cluster can be scheduled")
+				}
+				checkEvents(t, test.expectedEvents, f.events())
+				return
+			}
+
+			updated := f.run(test.migrate)
+			for name, backend := range backends {
+				installed := backend.Release(blueRelease) != nil
+				if installed != (name == test.expectedCluster) {
+					t.Errorf("expected the release to be installed in %s only, installed in %s: %v", test.expectedCluster, name, installed)
+				}
+			}
+			if updated == nil || updated.Status.ReleaseClusters[blueRelease] != test.expectedCluster {
+				t.Errorf("expected the scheduled cluster %s to be recorded in status", test.expectedCluster)
+			}
+			checkEvents(t, test.expectedEvents, f.events())
+		})
+	}
+}
+
+// unlabelledDeployment is a deployment installed by helm before the operator, it only has the release label.
+func unlabelledDeployment(rlsName string, replicas int32) *apps.Deployment {
+	d := newDeployment(rlsName, replicas)
+	delete(d.Labels, constant.AppLabel)
+	d.Spec.Replicas = int32Ptr(replicas)
+	return d
+}
+
+// namedDeployment is another deployment of the release, such as the one of a dependency of the chart.
+func namedDeployment(rlsName, name string, replicas int32) *apps.Deployment {
+	d := unlabelledDeployment(rlsName, replicas)
+	d.Name = name
+	return d
+}
+
+func TestAdoptReleases(t *testing.T) {
+	legacy := newMigrate(blueRelease)
+	legacy.Name = "legacy"
+
+	tests := []struct {
+		name string
+		// The migrates which already exist.
+		migrates []runtime.Object
+		// The deployments of the release, a deployment named after it with 3 replicas if empty.
+		deployments      []runtime.Object
+		expectedErr      bool
+		expectedReplicas int32
+	}{
+		{
+			name:             "adopt unlabelled release",
+			expectedReplicas: 3,
+		},
+		{
+			name:             "adopt release of the same migrate again",
+			migrates:         []runtime.Object{newMigrate(blueRelease)},
+			expectedReplicas: 3,
+		},
+		{
+			name:        "refuse release owned by another migrate",
+			migrates:    []runtime.Object{legacy},
+			expectedErr: true,
+		},
+		{
+			name: "adopt the replicas of the primary deployment",
+			deployments: []runtime.Object{namedDeployment(blueRelease, "a-"+blueRelease+"-redis", 1),
+				namedDeployment(blueRelease, blueRelease+"-"+testApp, 3)},
+			expectedReplicas: 3,
+		},
+		{
+			name: "adopt the largest replicas without a primary deployment",
+			deployments: []runtime.Object{namedDeployment(blueRelease, blueRelease+"-web", 4),
+				namedDeployment(blueRelease, blueRelease+"-worker", 2)},
+			expectedReplicas: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rls := runningRelease(blueRelease, 2)
+			rls.Chart = &chart.Chart{Metadata: &chart.Metadata{Name: testApp, Version: "0.1.0"}}
+			rls.Config = &chart.Config{Raw: "replicaCount: 3"}
+			deployments := test.deployments
+			if len(deployments) == 0 {
+				deployments = []runtime.Object{unlabelledDeployment(blueRelease, 3)}
+			}
+			kubeClient := k8sfake.NewSimpleClientset(deployments...)
+
+			migrate, err := adoptReleases(helmtest.NewFakeBackend(rls), kubeClient, fake.NewSimpleClientset(test.migrates...),
+				testApp, "")
+			if test.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error adopting the release, got %v", migrate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("adopt releases: %v", err)
+			}
+			if migrate.Name != testApp || migrate.Namespace != metav1.NamespaceDefault || len(migrate.Spec.Chart) == 0 {
+				t.Errorf("expected migrate %s/%s with the chart of the release, got %s/%s", metav1.NamespaceDefault, testApp,
+					migrate.Namespace, migrate.Name)
+			}
+			if !reflect.DeepEqual(migrate.Status.ReleaseRevision, map[string]int32{blueRelease: 2}) {
+				t.Errorf("expected the revision of the running release to be recorded, got %v", migrate.Status.ReleaseRevision)
+			}
+			if len(migrate.Spec.Releases) != 1 || migrate.Spec.Releases[0].Replicas != test.expectedReplicas ||
+				migrate.Spec.Releases[0].Raw != "replicaCount: 3" {
+				t.Errorf("expected the release with %d replicas and its values, got %+v", test.expectedReplicas,
+					migrate.Spec.Releases)
+			}
+		})
+	}
+}
+
+func withScheduled(migrate *v1.Migrate, releaseClusters map[string]string) *v1.Migrate {
+	migrate.Status.ReleaseClusters = releaseClusters
+	return migrate
+}
+
+func withRevisions(migrate *v1.Migrate, revisions map[string]int32) *v1.Migrate {
+	migrate.Status.ReleaseRevision = revisions
+	return migrate
+}
+
+func withFinished(migrate *v1.Migrate) *v1.Migrate {
+	migrate.Status.Finished = constant.ConditionStatusTrue
+	return migrate
+}
 
-	f.runExpectError(getKey(foo, t))
+func checkActions(t *testing.T, expected, actual []string) {
+	if len(expected) == 0 && len(actual) == 0 {
+		return
+	}
+	// The gets are issued by the status calculation, they are not interesting here.
+	var mutations []string
+	for _, action := range actual {
+		if !strings.HasPrefix(action, helm.OperationGet+"/") {
+			mutations = append(mutations, action)
+		}
+	}
+	if !reflect.DeepEqual(expected, mutations) {
+		t.Errorf("expected actions %v, got %v", expected, mutations)
+	}
+}
+
+// checkEvents verifies that the expected reasons have been recorded in order, other events are ignored.
+func checkEvents(t *testing.T, expected, actual []string) {
+	i := 0
+	for _, reason := range actual {
+		if i < len(expected) && reason == expected[i] {
+			i++
+		}
+	}
+	if i != len(expected) {
+		t.Errorf("expected events %v, got %v", expected, actual)
+	}
 }
 
 func int32Ptr(i int32) *int32 { return &i }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/helm3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The reasons of the conversion conditions, they are also used as the steps of the conversion.
+const (
+	ReasonConverted     = "Converted"
+	ReasonVerified      = "Verified"
+	ReasonCleanedUp     = "CleanedUp"
+	ReasonConvertFailed = "ConvertFailed"
+	ReasonVerifyFailed  = "VerifyFailed"
+	ReasonCleanupFailed = "CleanupFailed"
+)
+
+// convert converts the releases of the migrate from helm 2 to helm 3 step by step: convert, verify and clean up,
+// the step each release has reached is recorded in its condition so the conversion resumes from there. Each release
+// is converted in the cluster it has been deployed to.
+func (c *Controller) convert(migrate *v1.Migrate) error {
+	if migrate.Status.Finished == constant.ConditionStatusTrue {
+		c.logFor(migrate).Info("The conversion has finished, nothing to do")
+		return nil
+	}
+
+	config := migrate.Spec.Convert
+	if config == nil {
+		config = &v1.ConvertConfig{}
+	}
+	maxHistory := int(config.MaxHistory)
+
+	migrateCopy := migrate.DeepCopy()
+	finished := true
+	for _, rls := range migrate.Spec.Releases {
+		conditionType := constant.ConcatConvertConditionType(rls.Name)
+		reason := ""
+		if condition := findCondition(migrateCopy, conditionType); condition != nil {
+			reason = condition.Reason
+		}
+
+		// Nothing is left to do for the release, so its cluster is not needed.
+		if reason == ReasonCleanedUp || (reason == ReasonVerified && !config.DeleteV2Releases) {
+			continue
+		}
+		converter, err := c.releaseConverter(migrate, rls, config.TillerNamespace)
+		if err != nil {
+			c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusFalse, ReasonConvertFailed, err.Error())
+			finished = false
+			continue
+		}
+
+		if reason == "" || reason == ReasonConvertFailed {
+			count, err := converter.Convert(rls.Name, maxHistory)
+			if err != nil {
+				c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusFalse, ReasonConvertFailed, err.Error())
+				finished = false
+				continue
+			}
+			reason = ReasonConverted
+			c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusFalse, reason,
+				fmt.Sprintf("%d versions of release [%s] have been converted", count, rls.Name))
+		}
+
+		if reason == ReasonConverted || reason == ReasonVerifyFailed {
+			if err := converter.Verify(rls.Name, maxHistory); err != nil {
+				c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusFalse, ReasonVerifyFailed, err.Error())
+				finished = false
+				continue
+			}
+			reason = ReasonVerified
+			c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusTrue, reason,
+				fmt.Sprintf("The helm 3 secrets of release [%s] have been verified", rls.Name))
+		}
+
+		if config.DeleteV2Releases && (reason == ReasonVerified || reason == ReasonCleanupFailed) {
+			if err := converter.CleanupV2(rls.Name); err != nil {
+				c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusFalse, ReasonCleanupFailed, err.Error())
+				finished = false
+				continue
+			}
+			c.updateConvertCondition(migrateCopy, conditionType, constant.ConditionStatusTrue, ReasonCleanedUp,
+				fmt.Sprintf("The tiller configmaps of release [%s] have been deleted", rls.Name))
+		}
+	}
+
+	now := metav1.Now()
+	migrateCopy.Status.LastUpdateTime = &now
+	if finished {
+		migrateCopy.Status.Finished = constant.ConditionStatusTrue
+	} else {
+		migrateCopy.Status.Finished = constant.ConditionStatusFalse
+	}
+
+	return c.traceWrite(migrate, "update", "migrates", migrate.Namespace, migrate.Name, func() error {
+		_, err := c.symclientset.DevopsV1().Migrates(migrate.Namespace).Update(migrateCopy)
+		return err
+	})
+}
+
+// releaseConverter returns the converter for the tiller of the cluster which the release has been deployed to. A
+// release scheduled by a cluster selector is converted in the cluster recorded in the status of the migrate, it is
+// refused if it has not been recorded as the cluster would be picked again. The tiller namespace of the conversion
+// overrides the one of the cluster and the migrate.
+func (c *Controller) releaseConverter(migrate *v1.Migrate, rls *v1.ReleasesConfig, tillerNamespace string) (*helm3.Converter, error) {
+	secretName, clusterTiller := rls.TargetCluster, migrate.Spec.TillerNamespace
+	if secretName == "" && rls.ClusterSelector != nil {
+		name, ok := migrate.Status.ReleaseClusters[rls.Name]
+		if !ok {
+			return nil, errors.Errorf("release %s has a cluster selector but has not been scheduled to any cluster", rls.Name)
+		}
+		if c.clustersSynced == nil {
+			return nil, errors.Errorf("release %s has a cluster selector but the cluster registry is disabled, see -cluster-registry",
+				rls.Name)
+		}
+		registered, err := c.clustersLister.Clusters(migrate.Namespace).Get(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cluster %s of release %s", name, rls.Name)
+		}
+		secretName = registered.Spec.KubeconfigSecret
+		if registered.Spec.TillerNamespace != "" {
+			clusterTiller = registered.Spec.TillerNamespace
+		}
+	}
+	if tillerNamespace == "" {
+		tillerNamespace = clusterTiller
+	}
+
+	if secretName == "" {
+		return helm3.NewConverter(c.kubeclientset, tillerNamespace), nil
+	}
+	if c.clusters == nil {
+		return nil, errors.Errorf("target cluster %s of release %s is not supported, multi-cluster is not enabled",
+			secretName, rls.Name)
+	}
+	cluster, err := c.clusters.Get(migrate.Namespace, secretName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "release %s", rls.Name)
+	}
+	return helm3.NewConverter(cluster.KubeClient, tillerNamespace), nil
+}
+
+func (c *Controller) updateConvertCondition(migrateCopy *v1.Migrate, conditionType, status, reason, message string) {
+	c.logFor(migrateCopy).WithField("reason", reason).Info(message)
+	now := metav1.Now()
+	upsertCondition(migrateCopy, v1.MigrateCondition{Type: conditionType, Status: status,
+		LastProbeTime: now, LastTransitionTime: now, Reason: reason, Message: message})
+
+	eventType := corev1.EventTypeNormal
+	if status == constant.ConditionStatusFalse && reason != ReasonConverted {
+		eventType = corev1.EventTypeWarning
+	}
+	c.recorder.Event(migrateCopy, eventType, reason, message)
+}
+
+func findCondition(migrate *v1.Migrate, conditionType string) *v1.MigrateCondition {
+	for i := range migrate.Status.Conditions {
+		if migrate.Status.Conditions[i].Type == conditionType {
+			return &migrate.Status.Conditions[i]
+		}
+	}
+	return nil
+}
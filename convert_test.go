@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	"github.com/yangyongzhi/sym-operator/pkg/helm3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func newConversion(config *v1.ConvertConfig, reasons map[string]string, releases ...string) *v1.Migrate {
+	migrate := newMigrate(releases...)
+	migrate.Spec.Action = v1.MigrateActionConvert
+	migrate.Spec.Convert = config
+	for name, reason := range reasons {
+		migrate.Status.Conditions = append(migrate.Status.Conditions, v1.MigrateCondition{
+			Type: constant.ConcatConvertConditionType(name), Status: constant.ConditionStatusTrue, Reason: reason})
+	}
+	return migrate
+}
+
+// newTillerConfigMap stores the release the same way as tiller.
+func newTillerConfigMap(t *testing.T, rls *release.Release) *corev1.ConfigMap {
+	data, err := helm.EncodeRelease(rls)
+	if err != nil {
+		t.Fatalf("encode release: %v", err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.v%d", rls.Name, rls.Version),
+			Namespace: helm3.DefaultTillerNamespace,
+			Labels:    map[string]string{"NAME": rls.Name, "OWNER": "TILLER"},
+		},
+		Data: map[string]string{"release": data},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name    string
+		migrate *v1.Migrate
+		// The versions of the release stored by tiller.
+		versions         []int32
+		expectedReason   string
+		expectedFinished string
+		// The actions expected to happen on the secrets and the configmaps, the gets are ignored.
+		expectedActions []string
+	}{
+		{
+			name:             "convert and verify",
+			migrate:          newConversion(nil, nil, blueRelease),
+			versions:         []int32{1, 2},
+			expectedReason:   ReasonVerified,
+			expectedFinished: constant.ConditionStatusTrue,
+			expectedActions:  []string{"list/configmaps", "create/secrets", "create/secrets", "list/configmaps"},
+		},
+		{
+			name:             "clean up once verified",
+			migrate:          newConversion(&v1.ConvertConfig{DeleteV2Releases: true}, nil, blueRelease),
+			versions:         []int32{1},
+			expectedReason:   ReasonCleanedUp,
+			expectedFinished: constant.ConditionStatusTrue,
+			expectedActions: []string{"list/configmaps", "create/secrets", "list/configmaps",
+				"list/configmaps", "delete/configmaps"},
+		},
+		{
+			name: "resume from the verified step",
+			migrate: newConversion(&v1.ConvertConfig{DeleteV2Releases: true},
+				map[string]string{blueRelease: ReasonVerified}, blueRelease),
+			versions:         []int32{1},
+			expectedReason:   ReasonCleanedUp,
+			expectedFinished: constant.ConditionStatusTrue,
+			expectedActions:  []string{"list/configmaps", "delete/configmaps"},
+		},
+		{
+			name:             "nothing to do once cleaned up",
+			migrate:          newConversion(&v1.ConvertConfig{DeleteV2Releases: true}, map[string]string{blueRelease: ReasonCleanedUp}, blueRelease),
+			expectedReason:   ReasonCleanedUp,
+			expectedFinished: constant.ConditionStatusTrue,
+		},
+		{
+			name:             "convert fails without tiller configmaps",
+			migrate:          newConversion(nil, nil, blueRelease),
+			expectedReason:   ReasonConvertFailed,
+			expectedFinished: constant.ConditionStatusFalse,
+			expectedActions:  []string{"list/configmaps"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.addMigrate(test.migrate)
+			for _, version := range test.versions {
+				f.kubeobjects = append(f.kubeobjects,
+					newTillerConfigMap(t, helmtest.NewFakeRelease(blueRelease, metav1.NamespaceDefault, version, release.Status_DEPLOYED)))
+			}
+
+			updated := f.run(test.migrate)
+			if updated == nil {
+				t.Fatalf("expected the migrate to be updated")
+			}
+			condition := findCondition(updated, constant.ConcatConvertConditionType(blueRelease))
+			if condition == nil || condition.Reason != test.expectedReason {
+				t.Errorf("expected reason %s, got %+v", test.expectedReason, condition)
+			}
+			if updated.Status.Finished != test.expectedFinished {
+				t.Errorf("expected finished %s, got %s", test.expectedFinished, updated.Status.Finished)
+			}
+
+			var actions []string
+			for _, action := range f.kubeclient.Actions() {
+				if action.GetResource().Resource == "secrets" || action.GetResource().Resource == "configmaps" {
+					actions = append(actions, action.GetVerb()+"/"+action.GetResource().Resource)
+				}
+			}
+			checkActions(t, test.expectedActions, actions)
+		})
+	}
+}
+
+func TestConvertInTargetCluster(t *testing.T) {
+	const remote = "prod-kubeconfig"
+	f := newFixture(t)
+	remoteCluster := newFakeCluster(remote, helmtest.NewFakeBackend())
+	f.clusters = fakeClusters{remote: remoteCluster}
+	migrate := withTargetCluster(newConversion(nil, nil, blueRelease), blueRelease, remote)
+	f.addMigrate(migrate)
+	configMap := newTillerConfigMap(t, helmtest.NewFakeRelease(blueRelease, metav1.NamespaceDefault, 1, release.Status_DEPLOYED))
+	if _, err := remoteCluster.KubeClient.CoreV1().ConfigMaps(configMap.Namespace).Create(configMap); err != nil {
+		t.Fatalf("create tiller configmap: %v", err)
+	}
+
+	updated := f.run(migrate)
+	if updated == nil {
+		t.Fatalf("expected the migrate to be updated")
+	}
+	if condition := findCondition(updated, constant.ConcatConvertConditionType(blueRelease)); condition == nil ||
+		condition.Reason != ReasonVerified {
+		t.Errorf("expected the release to be converted in its target cluster, got %+v", condition)
+	}
+	if _, err := remoteCluster.KubeClient.CoreV1().Secrets(metav1.NamespaceDefault).Get(helm3.SecretName(blueRelease, 1),
+		metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the helm 3 secret in the target cluster: %v", err)
+	}
+	for _, action := range f.kubeclient.Actions() {
+		if action.GetResource().Resource == "secrets" || action.GetResource().Resource == "configmaps" {
+			t.Errorf("expected nothing to be converted in the local cluster, got %s/%s", action.GetVerb(),
+				action.GetResource().Resource)
+		}
+	}
+}
+
+func TestConvertRefusesUnscheduledRelease(t *testing.T) {
+	f := newFixture(t)
+	migrate := withClusterSelector(newConversion(nil, nil, blueRelease), blueRelease, map[string]string{"ldc": "sh"})
+	f.addMigrate(migrate)
+	f.kubeobjects = append(f.kubeobjects,
+		newTillerConfigMap(t, helmtest.NewFakeRelease(blueRelease, metav1.NamespaceDefault, 1, release.Status_DEPLOYED)))
+
+	updated := f.run(migrate)
+	if updated == nil {
+		t.Fatalf("expected the migrate to be updated")
+	}
+	if condition := findCondition(updated, constant.ConcatConvertConditionType(blueRelease)); condition == nil ||
+		condition.Reason != ReasonConvertFailed {
+		t.Errorf("expected the release without a known cluster to fail, got %+v", condition)
+	}
+	if updated.Status.Finished != constant.ConditionStatusFalse {
+		t.Errorf("expected the conversion not to be finished, got %s", updated.Status.Finished)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/debug"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// The states of the keys in the work queue.
+const (
+	QueueStateQueued = "Queued"
+	// QueueStateWaiting is a key waiting for its backoff or delay before being queued.
+	QueueStateWaiting    = "Waiting"
+	QueueStateProcessing = "Processing"
+)
+
+// trackingQueue remembers the keys added to the work queue until a worker has processed them, the work queue itself
+// only tells its length.
+type trackingQueue struct {
+	workqueue.RateLimitingInterface
+
+	mu         sync.Mutex
+	pending    map[interface{}]queuedKey
+	processing map[interface{}]time.Time
+}
+
+type queuedKey struct {
+	state string
+	since time.Time
+}
+
+func newTrackingQueue(queue workqueue.RateLimitingInterface) *trackingQueue {
+	return &trackingQueue{
+		RateLimitingInterface: queue,
+		pending:               map[interface{}]queuedKey{},
+		processing:            map[interface{}]time.Time{},
+	}
+}
+
+// track records the key as pending in the state, a queued key stays queued until a worker gets it.
+func (q *trackingQueue) track(item interface{}, state string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if pending, ok := q.pending[item]; ok && (pending.state == QueueStateQueued || pending.state == state) {
+		return
+	}
+	q.pending[item] = queuedKey{state: state, since: time.Now()}
+}
+
+func (q *trackingQueue) Add(item interface{}) {
+	q.track(item, QueueStateQueued)
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *trackingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	q.track(item, QueueStateWaiting)
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *trackingQueue) AddRateLimited(item interface{}) {
+	q.track(item, QueueStateWaiting)
+	q.RateLimitingInterface.AddRateLimited(item)
+}
+
+func (q *trackingQueue) Get() (interface{}, bool) {
+	item, shutdown := q.RateLimitingInterface.Get()
+	if !shutdown {
+		q.mu.Lock()
+		delete(q.pending, item)
+		q.processing[item] = time.Now()
+		q.mu.Unlock()
+	}
+	return item, shutdown
+}
+
+func (q *trackingQueue) Done(item interface{}) {
+	q.mu.Lock()
+	delete(q.processing, item)
+	q.mu.Unlock()
+	q.RateLimitingInterface.Done(item)
+}
+
+// queueEntry is a key in the work queue as served by /debug/queue.
+type queueEntry struct {
+	Key     string    `json:"key"`
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+	Retries int       `json:"retries"`
+}
+
+// entries returns the keys in the work queue, the longest waiting first.
+func (q *trackingQueue) entries() []queueEntry {
+	q.mu.Lock()
+	entries := make([]queueEntry, 0, len(q.pending)+len(q.processing))
+	for item, pending := range q.pending {
+		entries = append(entries, queueEntry{Key: fmt.Sprint(item), State: pending.state, Since: pending.since})
+	}
+	for item, since := range q.processing {
+		entries = append(entries, queueEntry{Key: fmt.Sprint(item), State: QueueStateProcessing, Since: since})
+	}
+	q.mu.Unlock()
+
+	for i := range entries {
+		entries[i].Retries = q.NumRequeues(entries[i].Key)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Since.Before(entries[j].Since) })
+	return entries
+}
+
+// syncState is what the controller knows of the last sync of a migrate.
+type syncState struct {
+	LastSync     *time.Time `json:"lastSync,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastTraceID  string     `json:"lastTraceID,omitempty"`
+	// Releases are the releases listed by the last sync by the clusters, the cluster the operator runs in is "".
+	Releases map[string][]cachedRelease `json:"releases,omitempty"`
+}
+
+// cachedRelease is a release as listed by the last sync of its migrate.
+type cachedRelease struct {
+	Name     string `json:"name"`
+	Revision int32  `json:"revision"`
+	Status   string `json:"status"`
+}
+
+// syncStates holds the state of the last sync of each migrate by its namespace/name key.
+type syncStates struct {
+	mu     sync.RWMutex
+	states map[string]syncState
+}
+
+func newSyncStates() *syncStates {
+	return &syncStates{states: map[string]syncState{}}
+}
+
+// observe records the result of the sync of the migrate which started at the time.
+func (s *syncStates) observe(key string, start time.Time, err error, traceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[key]
+	state.LastSync, state.LastDuration, state.LastError, state.LastTraceID = &start, time.Since(start).String(), "", traceID
+	if err != nil {
+		state.LastError = err.Error()
+	}
+	s.states[key] = state
+}
+
+// observeReleases records the releases of the migrate listed in the cluster.
+func (s *syncStates) observeReleases(key, cluster string, rlses []*release.Release) {
+	cached := make([]cachedRelease, 0, len(rlses))
+	for _, rls := range rlses {
+		cached = append(cached, cachedRelease{Name: rls.GetName(), Revision: rls.GetVersion(),
+			Status: rls.GetInfo().GetStatus().GetCode().String()})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[key]
+	releases := map[string][]cachedRelease{cluster: cached}
+	for c, r := range state.Releases {
+		if c != cluster {
+			releases[c] = r
+		}
+	}
+	state.Releases = releases
+	s.states[key] = state
+}
+
+func (s *syncStates) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+}
+
+func (s *syncStates) get(key string) syncState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.states[key]
+}
+
+// migrateState is a migrate as served by /debug/migrates.
+type migrateState struct {
+	Key     string `json:"key"`
+	App     string `json:"app"`
+	Action  string `json:"action"`
+	Phase   string `json:"phase"`
+	Retries int    `json:"retries"`
+	syncState
+}
+
+// migrateStates returns the state of each migrate synced by this replica, or of the migrate of the key if it is set.
+func (c *Controller) migrateStates(key string) []migrateState {
+	migrates, err := c.symLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("List the migrates for the debug state has an error : %s", err.Error())
+		return nil
+	}
+	states := []migrateState{}
+	for _, migrate := range migrates {
+		migrateKey := migrate.Namespace + "/" + migrate.Name
+		if (key != "" && migrateKey != key) || !c.ownsMigrate(migrate) {
+			continue
+		}
+		states = append(states, migrateState{Key: migrateKey, App: migrate.Spec.AppName, Action: syncAction(migrate),
+			Phase: migratePhase(migrate), Retries: c.workqueue.NumRequeues(migrateKey), syncState: c.syncStates.get(migrateKey)})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// addDebugHandlers serves the state of the controller: the keys in the work queue, the last sync of each migrate, the
+// helm operations in progress and the releases listed by the last syncs. Each is served as HTML, or as JSON with
+// ?format=json.
+func (c *Controller) addDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/queue", debug.Handler(func(r *http.Request) (interface{}, debug.Table) {
+		entries := c.workqueue.entries()
+		table := debug.Table{Title: "Work queue", Columns: []string{"Key", "State", "Since", "Retries"}}
+		for _, entry := range entries {
+			table.Rows = append(table.Rows, []string{entry.Key, entry.State, since(entry.Since), strconv.Itoa(entry.Retries)})
+		}
+		return entries, table
+	}))
+	mux.HandleFunc("/debug/migrates", debug.Handler(func(r *http.Request) (interface{}, debug.Table) {
+		states := c.migrateStates(r.URL.Query().Get("key"))
+		table := debug.Table{Title: "Migrates",
+			Columns: []string{"Key", "App", "Action", "Phase", "Retries", "Last sync", "Duration", "Trace", "Last error"}}
+		for _, state := range states {
+			lastSync := ""
+			if state.LastSync != nil {
+				lastSync = since(*state.LastSync)
+			}
+			table.Rows = append(table.Rows, []string{state.Key, state.App, state.Action, state.Phase,
+				strconv.Itoa(state.Retries), lastSync, state.LastDuration, state.LastTraceID, state.LastError})
+		}
+		return states, table
+	}))
+	mux.HandleFunc("/debug/helm", debug.Handler(func(r *http.Request) (interface{}, debug.Table) {
+		operations := helm.RunningOperations()
+		table := debug.Table{Title: "Running helm operations", Columns: []string{"App", "Operation", "Release", "Running for"}}
+		for _, operation := range operations {
+			table.Rows = append(table.Rows, []string{operation.App, operation.Operation, operation.Release,
+				time.Since(operation.Started).Round(time.Millisecond).String()})
+		}
+		return operations, table
+	}))
+	mux.HandleFunc("/debug/releases", debug.Handler(func(r *http.Request) (interface{}, debug.Table) {
+		releases := map[string]map[string][]cachedRelease{}
+		table := debug.Table{Title: "Releases listed by the last syncs", Columns: []string{"Migrate", "Cluster", "Release", "Revision", "Status"}}
+		for _, state := range c.migrateStates(r.URL.Query().Get("key")) {
+			if len(state.Releases) == 0 {
+				continue
+			}
+			releases[state.Key] = state.Releases
+			var clusters []string
+			for cluster := range state.Releases {
+				clusters = append(clusters, cluster)
+			}
+			sort.Strings(clusters)
+			for _, cluster := range clusters {
+				location := cluster
+				if location == "" {
+					location = "(in-cluster)"
+				}
+				for _, rls := range state.Releases[cluster] {
+					table.Rows = append(table.Rows, []string{state.Key, location, rls.Name, strconv.Itoa(int(rls.Revision)), rls.Status})
+				}
+			}
+		}
+		return releases, table
+	}))
+}
+
+// since describes the time with how long ago it was.
+func since(t time.Time) string {
+	return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(t).Round(time.Second))
+}
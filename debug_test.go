@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestTrackingQueue(t *testing.T) {
+	q := newTrackingQueue(workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()))
+	defer q.ShutDown()
+	states := func() map[string]string {
+		states := map[string]string{}
+		for _, entry := range q.entries() {
+			states[entry.Key] = entry.State
+		}
+		return states
+	}
+
+	q.Add("default/blue")
+	q.AddAfter("default/green", time.Hour)
+	if expected := map[string]string{"default/blue": QueueStateQueued, "default/green": QueueStateWaiting}; !reflect.DeepEqual(states(), expected) {
+		t.Errorf("expected the keys %v, got %v", expected, states())
+	}
+
+	item, _ := q.Get()
+	// A key added again while it is processed is queued once it is done.
+	q.Add(item)
+	if expected := map[string]string{"default/blue": QueueStateQueued, "default/green": QueueStateWaiting}; !reflect.DeepEqual(states(), expected) {
+		t.Errorf("expected the keys %v, got %v", expected, states())
+	}
+	q.Done(item)
+	item, _ = q.Get()
+	q.AddRateLimited(item)
+	q.Done(item)
+
+	entries := q.entries()
+	for _, entry := range entries {
+		if entry.Key == "default/blue" && (entry.State != QueueStateWaiting || entry.Retries != 1) {
+			t.Errorf("expected default/blue waiting for its first retry, got %+v", entry)
+		}
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 keys in the queue, got %v", entries)
+	}
+}
+
+func TestDebugHandlers(t *testing.T) {
+	f := newFixture(t, runningRelease(blueRelease, 2))
+	synced := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 2})
+	failed := newMigrate(greenRelease)
+	failed.Name = "failed"
+	failed.Spec.Releases[0].TargetCluster = "gz01"
+	for _, migrate := range []*v1.Migrate{synced, failed} {
+		f.migrateLister = append(f.migrateLister, migrate)
+		f.objects = append(f.objects, migrate)
+	}
+	c, i, k8sI := f.newController()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
+	if err := c.syncHandler(getKey(synced, t)); err != nil {
+		t.Fatalf("error syncing migrate: %v", err)
+	}
+	if err := c.syncHandler(getKey(failed, t)); err == nil {
+		t.Fatalf("expected the sync with an unknown cluster to fail")
+	}
+
+	mux := http.NewServeMux()
+	c.addDebugHandlers(mux)
+	get := func(url string, v interface{}) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode %s: %v: %s", url, err, w.Body.String())
+		}
+	}
+
+	var states []migrateState
+	get("/debug/migrates?format=json", &states)
+	if len(states) != 2 {
+		t.Fatalf("expected the state of 2 migrates, got %+v", states)
+	}
+	for _, state := range states {
+		if state.LastSync == nil || state.LastTraceID == "" {
+			t.Errorf("expected the last sync of %s, got %+v", state.Key, state)
+		}
+		if (state.LastError != "") != (state.Key == getKey(failed, t)) {
+			t.Errorf("expected only the migrate with an unknown cluster to fail, got %+v", state)
+		}
+	}
+
+	var releases map[string]map[string][]cachedRelease
+	get("/debug/releases?format=json", &releases)
+	expected := map[string]map[string][]cachedRelease{
+		getKey(synced, t): {"": {{Name: blueRelease, Revision: 2, Status: release.Status_DEPLOYED.String()}}},
+	}
+	if !reflect.DeepEqual(releases, expected) {
+		t.Errorf("expected the releases %v, got %v", expected, releases)
+	}
+
+	var operations []interface{}
+	get("/debug/helm?format=json", &operations)
+	if len(operations) != 0 {
+		t.Errorf("expected no running helm operation, got %v", operations)
+	}
+	var entries []queueEntry
+	get("/debug/queue?format=json", &entries)
+	if len(entries) != 0 {
+		t.Errorf("expected no key in the queue, got %v", entries)
+	}
+}
@@ -18,18 +18,58 @@ set -o errexit
 set -o nounset
 set -o pipefail
 
-SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
-CODEGEN_PKG=${CODEGEN_PKG:-$(cd "${SCRIPT_ROOT}"; ls -d -1 ./vendor/k8s.io/code-generator 2>/dev/null || echo ../code-generator)}
-
-# generate the code with:
-# --output-base    because this script should also be able to run inside the vendor dir of
-#                  k8s.io/kubernetes. The output-base is needed for the generators to output into the vendor dir
-#                  instead of the $GOPATH directly. For normal projects this can be dropped.
-"${CODEGEN_PKG}"/generate-groups.sh "deepcopy,client,informer,lister" \
-  github.com/yangyongzhi/sym-operator/pkg/generated github.com/yangyongzhi/sym-operator/pkg/apis \
-  samplecontroller:v1alpha1 \
-  --output-base "$(dirname "${BASH_SOURCE[0]}")/../../.." \
-  --go-header-file "${SCRIPT_ROOT}"/hack/boilerplate.go.txt
-
-# To use your own boilerplate text append:
-#   --go-header-file "${SCRIPT_ROOT}"/hack/custom-boilerplate.go.txt
+SCRIPT_ROOT=$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)
+PACKAGE_NAME=github.com/yangyongzhi/sym-operator
+
+# The generated clients must match the vendored client-go v10.0.0, so the generators of the same kubernetes release
+# are used instead of the vendored k8s.io/code-generator, built with the gengo and x/tools revisions of the vendor dir.
+CODEGEN_VERSION=${CODEGEN_VERSION:-kubernetes-1.13.0}
+GENGO_VERSION=$(awk '$1 == "#" && $2 == "k8s.io/gengo" {print $3}' "${SCRIPT_ROOT}/vendor/modules.txt")
+TOOLS_VERSION=$(awk '$1 == "#" && $2 == "golang.org/x/tools" {print $3}' "${SCRIPT_ROOT}/vendor/modules.txt")
+
+_tmp=$(mktemp -d)
+cleanup() {
+  rm -rf "${_tmp}"
+}
+trap "cleanup" EXIT SIGINT
+
+mkdir -p "${_tmp}/tools" "${_tmp}/bin"
+(
+  cd "${_tmp}/tools"
+  cat > go.mod <<EOF
+module codegen
+
+go 1.12
+EOF
+  export GO111MODULE=on GOFLAGS=-mod=mod
+  go get -d "k8s.io/code-generator@${CODEGEN_VERSION}" "k8s.io/gengo@${GENGO_VERSION}" \
+    "golang.org/x/tools@${TOOLS_VERSION}" k8s.io/klog@v0.3.0 github.com/spf13/pflag@v1.0.3
+  for gen in deepcopy-gen client-gen lister-gen informer-gen; do
+    go build -o "${_tmp}/bin/${gen}" "k8s.io/code-generator/cmd/${gen}"
+  done
+)
+
+# The generators only read the packages from a GOPATH, so the repository is linked into a temporary one and its
+# vendor dir provides the dependencies.
+mkdir -p "${_tmp}/go/src/$(dirname "${PACKAGE_NAME}")"
+ln -s "${SCRIPT_ROOT}" "${_tmp}/go/src/${PACKAGE_NAME}"
+cd "${_tmp}/go/src/${PACKAGE_NAME}"
+export GO111MODULE=off GOPATH="${_tmp}/go" GOFLAGS=
+
+APIS="${PACKAGE_NAME}/pkg/apis/example/v1,${PACKAGE_NAME}/pkg/apis/devops/v1"
+COMMON_FLAGS=(--output-base "${_tmp}/go/src" --go-header-file "${SCRIPT_ROOT}/hack/boilerplate.go.txt")
+
+echo "Generating deepcopy funcs"
+"${_tmp}/bin/deepcopy-gen" --input-dirs "${APIS}" -O zz_generated.deepcopy --bounding-dirs "${PACKAGE_NAME}/pkg/apis" \
+  "${COMMON_FLAGS[@]}"
+echo "Generating clientset at ${PACKAGE_NAME}/pkg/client/clientset"
+"${_tmp}/bin/client-gen" --clientset-name versioned --input-base "" --input "${APIS}" \
+  --output-package "${PACKAGE_NAME}/pkg/client/clientset" "${COMMON_FLAGS[@]}"
+echo "Generating listers at ${PACKAGE_NAME}/pkg/client/listers"
+"${_tmp}/bin/lister-gen" --input-dirs "${APIS}" --output-package "${PACKAGE_NAME}/pkg/client/listers" \
+  "${COMMON_FLAGS[@]}"
+echo "Generating informers at ${PACKAGE_NAME}/pkg/client/informers"
+"${_tmp}/bin/informer-gen" --input-dirs "${APIS}" \
+  --versioned-clientset-package "${PACKAGE_NAME}/pkg/client/clientset/versioned" \
+  --listers-package "${PACKAGE_NAME}/pkg/client/listers" \
+  --output-package "${PACKAGE_NAME}/pkg/client/informers" "${COMMON_FLAGS[@]}"
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/config"
+	"github.com/yangyongzhi/sym-operator/pkg/health"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/monitor"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cachesSynced returns an error listing the informer caches which have not synced yet.
+func (c *Controller) cachesSynced() error {
+	caches := []struct {
+		name   string
+		synced cache.InformerSynced
+	}{
+		{"deployments", c.deploymentsSynced},
+		{"migrates", c.symSynced},
+		{"clusters", c.clustersSynced},
+		{"operatorconfig", c.operatorConfigSynced},
+	}
+	var unsynced []string
+	for _, cache := range caches {
+		if cache.synced != nil && !cache.synced() {
+			unsynced = append(unsynced, cache.name)
+		}
+	}
+	if len(unsynced) > 0 {
+		return errors.Errorf("the caches of %s have not synced", strings.Join(unsynced, ", "))
+	}
+	return nil
+}
+
+// newProbes returns the probes of the operator. A replica is ready once its caches have synced and it is leading,
+// or holding shards. It is live as long as the process serves the probes, the dependencies such as tiller and the
+// kubernetes API are only reported by /healthz/verbose.
+func newProbes(controller *Controller, kubeClient kubernetes.Interface, helmClients *helm.ClientPool,
+	isReady func() bool, sharded bool, cfg config.HealthConfiguration) monitor.Probes {
+	caches := health.Check{Name: "informer-caches", Func: controller.cachesSynced}
+	leader := health.Check{Name: "leader", Func: func() error {
+		if isReady() {
+			return nil
+		}
+		if sharded {
+			return errors.New("holding no shard")
+		}
+		return errors.New("standing by for the leadership")
+	}}
+	tiller := health.Check{Name: "tiller", Func: helmClients.KeepLive}
+	kubernetesAPI := health.Check{Name: "kubernetes-api", Func: func() error {
+		_, err := kubeClient.Discovery().ServerVersion()
+		return err
+	}}
+
+	all := []health.Check{health.Ping(), caches, leader, tiller, kubernetesAPI}
+	if cfg.WebhookCertFile != "" {
+		all = append(all, health.CertificateCheck("webhook-certificate", cfg.WebhookCertFile, cfg.CertMinValidity.Duration))
+	}
+	return monitor.Probes{
+		Readiness: []health.Check{caches, leader},
+		Liveness:  []health.Check{health.Ping()},
+		All:       all,
+		Timeout:   cfg.Timeout.Duration,
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/config"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/monitor"
+)
+
+func TestProbes(t *testing.T) {
+	f := newFixture(t)
+	c, _, _ := f.newController()
+	synced, leading := false, false
+	c.symSynced = func() bool { return synced }
+	// Tiller is down, it affects neither readiness nor liveness.
+	f.backend.FailOn(helm.OperationKeepLive, "", errors.New("tiller is down"))
+
+	mux := monitor.NewProbesMux(newProbes(c, f.kubeclient, f.helmClients, func() bool { return leading }, false,
+		config.HealthConfiguration{Timeout: config.Default().Health.Timeout}))
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	tests := []struct {
+		name              string
+		synced, leading   bool
+		expectedReadiness int
+	}{
+		{name: "caches not synced", leading: true, expectedReadiness: http.StatusServiceUnavailable},
+		{name: "standing by", synced: true, expectedReadiness: http.StatusServiceUnavailable},
+		{name: "leading", synced: true, leading: true, expectedReadiness: http.StatusOK},
+	}
+	for _, test := range tests {
+		synced, leading = test.synced, test.leading
+		if code := probe("/readiness"); code != test.expectedReadiness {
+			t.Errorf("%s: expected readiness %d, got %d", test.name, test.expectedReadiness, code)
+		}
+		if code := probe("/liveness"); code != http.StatusOK {
+			t.Errorf("%s: expected the process to be live, got %d", test.name, code)
+		}
+		// The verbose endpoint reports the failure of tiller.
+		if code := probe("/healthz/verbose"); code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected the verbose health to report tiller down, got %d", test.name, code)
+		}
+	}
+}
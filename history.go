@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/history"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+)
+
+// historyPruneInterval is the interval to delete the deployments beyond the retention of the history.
+const historyPruneInterval = time.Hour
+
+// observeChange records when the generation of the migrate is synced first, the lead time of its deployments starts
+// then.
+func (c *Controller) observeChange(key string, migrate *v1.Migrate) {
+	if c.history == nil {
+		return
+	}
+	if err := c.history.ObserveChange(key, migrate.Generation, time.Now()); err != nil {
+		c.logFor(migrate).Warningf("Record the change of the migrate has an error : %s", err.Error())
+	}
+}
+
+// recordOperation records the operation on a release of the migrate as a ReleaseOperation, and as a deployment of
+// the history unless it is an uninstall.
+func (c *Controller) recordOperation(migrate *v1.Migrate, spec v1.ReleaseOperationSpec) {
+	if c.audit != nil {
+		c.recordReleaseOperation(migrate, spec)
+	}
+	if c.history != nil && spec.Operation != v1.ReleaseOperationUninstall {
+		c.recordDeployment(migrate, spec)
+	}
+}
+
+// recordDeployment persists the outcome of the install, the upgrade or the rollback of a release of the migrate.
+// The operation is not failed if it can not be persisted.
+func (c *Controller) recordDeployment(migrate *v1.Migrate, spec v1.ReleaseOperationSpec) {
+	err := c.history.Record(history.Deployment{
+		App:        migrate.Spec.AppName,
+		Migrate:    migrate.Namespace + "/" + migrate.Name,
+		Release:    spec.Release,
+		Cluster:    spec.Cluster,
+		Operation:  string(spec.Operation),
+		Generation: migrate.Generation,
+		Succeeded:  spec.Result == v1.ReleaseOperationSucceeded,
+		Message:    spec.Message,
+		StartedAt:  spec.StartTime.Time,
+		FinishedAt: spec.CompletionTime.Time,
+	})
+	if err != nil {
+		c.logFor(migrate).WithField(log.FieldRelease, spec.Release).
+			Warningf("Persist the %s of the release to the history has an error : %s", spec.Operation, err.Error())
+	}
+}
+
+// pruneHistory deletes the deployments beyond the retention.
+func pruneHistory(store *history.Store, retention time.Duration) func() {
+	return func() {
+		if err := store.Prune(time.Now().Add(-retention)); err != nil {
+			log.Warningf("Prune the deployment history has an error : %s", err.Error())
+		}
+	}
+}
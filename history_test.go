@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/history"
+)
+
+// openHistory opens a history in a temporary directory, the returned function closes it and removes the directory.
+func openHistory(t *testing.T) (*history.Store, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	store, err := history.Open(filepath.Join(dir, "history.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("open history: %v", err)
+	}
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSyncRecordsDeployments(t *testing.T) {
+	store, closeHistory := openHistory(t)
+	defer closeHistory()
+
+	f := newFixture(t, runningRelease(blueRelease, 2))
+	f.backend.FailOn("update", blueRelease, fmt.Errorf("timed out"))
+	migrate := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1})
+	migrate.Generation = 3
+	f.addMigrate(migrate)
+	c, i, k8sI := f.newController()
+	c.history = store
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
+
+	start := time.Now()
+	key := getKey(migrate, t)
+	if err := c.syncHandler(key); err != nil {
+		t.Fatalf("error syncing migrate: %v", err)
+	}
+
+	deployments, err := store.Deployments(testApp, start)
+	if err != nil {
+		t.Fatalf("deployments: %v", err)
+	}
+	if len(deployments) != 1 {
+		t.Fatalf("expected one deployment, got %+v", deployments)
+	}
+	d := deployments[0]
+	if d.Migrate != key || d.Release != blueRelease || d.Operation != "Upgrade" || d.Generation != 3 ||
+		d.Succeeded || d.Message != "timed out" {
+		t.Errorf("expected the failed upgrade of %s, got %+v", blueRelease, d)
+	}
+	// The lead time starts when the generation is synced first.
+	if d.ChangedAt.Before(start) || d.FinishedAt.Before(d.ChangedAt) {
+		t.Errorf("expected the change to be observed by the sync, got %s and %s", d.ChangedAt, d.FinishedAt)
+	}
+}
+
+func TestUninstallIsNotADeployment(t *testing.T) {
+	store, closeHistory := openHistory(t)
+	defer closeHistory()
+
+	f := newFixture(t, runningRelease(blueRelease, 1))
+	c, _, _ := f.newController()
+	c.history = store
+	if err := c.audited(newMigrate(blueRelease), "", f.backend).UninstallRelease(blueRelease); err != nil {
+		t.Fatalf("uninstall: %v", err)
+	}
+
+	if apps, err := store.Apps(); err != nil || len(apps) != 0 {
+		t.Errorf("expected no deployment, got %v, %v", apps, err)
+	}
+}
@@ -18,30 +18,51 @@ package main
 
 import (
 	"flag"
-	"github.com/jasonlvhit/gocron"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/cluster"
+	"github.com/yangyongzhi/sym-operator/pkg/config"
 	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/history"
+	"github.com/yangyongzhi/sym-operator/pkg/leader"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
 	"github.com/yangyongzhi/sym-operator/pkg/monitor"
+	"github.com/yangyongzhi/sym-operator/pkg/shard"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"k8s.io/client-go/rest"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/klog"
 	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
-	informers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions"
 	"github.com/yangyongzhi/sym-operator/pkg/signals"
+	"github.com/yangyongzhi/sym-operator/pkg/tracing"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
+	masterURL       string
+	kubeconfig      string
+	tillerTLS       helm.TLSOptions
+	watchNamespaces string
+
+	clusterHealthCheckInterval time.Duration
+
+	configFile     string
+	operatorConfig = config.Default()
+
+	leaderElect    bool
+	leaderElection leader.Config
+	sharding       shard.Config
 )
 
 var (
@@ -50,88 +71,347 @@ var (
 )
 
 func main() {
-	// Enable logs
-	klog.InitFlags(flag.NewFlagSet(os.Args[0], flag.ExitOnError))
+	// The logs of the kubernetes client libraries are sent to the structured logger.
+	if err := log.RedirectKlog("0"); err != nil {
+		log.Fatalf("Error redirecting klog: %s", err.Error())
+	}
+
+	// Sub commands are handled before parsing the flags of the operator itself.
+	if len(os.Args) > 1 && os.Args[1] == adoptCommand {
+		if err := runAdopt(os.Args[2:]); err != nil {
+			log.Fatalf("Error adopting releases: %s", err.Error())
+		}
+		return
+	}
 
 	flag.Parse()
+	if err := config.Complete(flag.CommandLine, operatorConfig, configFile); err != nil {
+		log.Fatalf("Invalid configuration: %s", err.Error())
+	}
+	if err := log.Configure(operatorConfig.Logging.Format, operatorConfig.Logging.Level); err != nil {
+		log.Fatalf("Invalid configuration: %s", err.Error())
+	}
+	if err := log.RedirectKlog(strconv.Itoa(operatorConfig.Logging.KlogVerbosity)); err != nil {
+		log.Fatalf("Error redirecting klog: %s", err.Error())
+	}
+	log.Infof("Effective configuration:\n%s", operatorConfig)
 
-	// set up signals so we handle the first shutdown signal gracefully
-	stopCh := signals.SetupSignalHandler()
-	var cfg *rest.Config
-	var err error
-	if kubeconfig != "" {
-		cfg, err = clientcmd.BuildConfigFromFlags(masterURL, kubeconfig) // out of cluster config
-	} else {
-		cfg, err = rest.InClusterConfig()
+	if err := run(); err != nil {
+		log.Fatalf("Error running operator: %s", err.Error())
 	}
+}
 
+// run runs the operator until the shutdown signal is received or the leadership is lost, the clusters, the history
+// and the pending spans are cleaned up before it returns.
+func run() error {
+	// set up signals so we handle the first shutdown signal gracefully
+	stopCh := signals.SetupSignalHandler()
+	cfg, err := buildConfig(masterURL, kubeconfig)
 	if err != nil {
-		klog.Fatalf("Error building kubeconfig: %s", err.Error())
+		return errors.Wrap(err, "error building kubeconfig")
 	}
 
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+		return errors.Wrap(err, "error building kubernetes clientset")
 	}
 
-	helmClient, err := helm.NewClient(cfg, kubeClient)
+	helmClients, err := newHelmClientPool(cfg, kubeClient, operatorConfig.Tiller.Host, stopCh)
 	if err != nil {
-		klog.Fatalf("Error building helm client: %s", err.Error())
+		return errors.Wrap(err, "error building helm client")
 	}
+	// The tillers of the target clusters are always connected through port-forwards.
+	clusters := cluster.NewManager(kubeClient, func(cfg *rest.Config, kubeClient kubernetes.Interface,
+		stopCh <-chan struct{}) (*helm.ClientPool, error) {
+		return newHelmClientPool(cfg, kubeClient, "", stopCh)
+	})
+	defer clusters.Stop()
 
 	symClient, err := clientset.NewForConfig(cfg)
 	if err != nil {
-		klog.Fatalf("Error building symphony clientset: %s", err.Error())
+		return errors.Wrap(err, "error building symphony clientset")
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	symInformerFactory := informers.NewSharedInformerFactory(symClient, time.Second*30)
-
-	controller := NewController(kubeClient, symClient, helmClient,
-		kubeInformerFactory.Apps().V1().Deployments(),
-		//symInformerFactory.Example().V1().Foos()
-		symInformerFactory.Devops().V1().Migrates())
+	// The informers of the migrates, the deployments and the clusters only watch the namespaces if they are specified.
+	var namespaces []string
+	if watchNamespaces != "" {
+		namespaces = strings.Split(watchNamespaces, ",")
+	}
+	watched, startInformers := newWatchedInformers(kubeClient, symClient, namespaces, operatorConfig.ClusterRegistry,
+		operatorConfig.ResyncPeriod.Duration)
+	controller := NewController(kubeClient, symClient, helmClients, clusters, watched,
+		operatorConfig.RateLimiter.NewRateLimiter())
+	// Each sync is traced, the traces are exported to the collector if its endpoint is configured.
+	if tracingConfig := operatorConfig.Tracing; tracingConfig.OTLPEndpoint != "" {
+		controller.tracer = tracing.NewTracer(tracing.NewOTLPExporter(tracingConfig.OTLPEndpoint, tracingConfig.ServiceName,
+			tracingConfig.ExportInterval.Duration))
+		go controller.tracer.Run(tracingConfig.ExportInterval.Duration, stopCh)
+		defer controller.tracer.Flush()
+	}
+	// Each operation on the releases is recorded as a ReleaseOperation once its CRD has been installed.
+	if !operatorConfig.Audit.Disabled {
+		served, err := releaseOperationsServed(symClient.Discovery())
+		if err != nil {
+			return errors.Wrap(err, "error probing the ReleaseOperation resource")
+		}
+		if served {
+			controller.audit = newAuditTrail(symClient, operatorConfig.Audit)
+		} else {
+			log.Warningf("The ReleaseOperation CRD is not installed, the operations on the releases are not recorded")
+		}
+	}
+	// The deployments are persisted locally for the DORA metrics of the apps.
+	historyConfig := operatorConfig.History
+	if historyConfig.Path != "" {
+		store, err := history.Open(historyConfig.Path)
+		if err != nil {
+			return errors.Wrap(err, "error opening deployment history")
+		}
+		defer store.Close()
+		controller.history = store
+		go wait.Until(pruneHistory(store, historyConfig.Retention.Duration), historyPruneInterval, stopCh)
+		prometheus.MustRegister(history.NewCollector(store, historyConfig.Window.Duration))
+	}
+	if operatorConfig.OperatorConfig != "" {
+		informer, start := newOperatorConfigInformer(symClient, operatorConfig.OperatorConfig, operatorConfig.ResyncPeriod.Duration)
+		controller.watchOperatorConfig(informer)
+		start(stopCh)
+	}
 	// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh)
 	// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
-	kubeInformerFactory.Start(stopCh)
-	symInformerFactory.Start(stopCh)
+	// The informers are started before the election, so the standby replicas keep their caches warm.
+	startInformers(stopCh)
+
+	var sharder *shard.Sharder
+	if sharding.Shards > 0 {
+		sharding.Namespace, sharding.Name, sharding.Identity = leaderElection.Namespace, leaderElection.Name, leaderElection.Identity
+		sharder = shard.NewSharder(kubeClient.CoordinationV1beta1(), sharding, controller.enqueueShard)
+		controller.shards = sharder
+	}
 
-	// Start a cron scheduler
-	go func() { <-gocron.Start() }()
+	// runLeading runs the cluster health checker if the cluster registry is enabled, and the controller unless the
+	// migrates are sharded, until the leading channel is closed, i.e. the leadership is lost.
+	runLeading := func(leadingCh <-chan struct{}) {
+		if controller.clustersSynced != nil {
+			go newClusterHealthChecker(symClient, controller.clustersLister, clusters).
+				Run(clusterHealthCheckInterval, controller.clustersSynced, leadingCh)
+		}
+		if sharder != nil {
+			<-leadingCh
+			return
+		}
+		if err := controller.Run(operatorConfig.Workers, leadingCh); err != nil {
+			log.Errorf("Error running controller: %s", err.Error())
+		}
+	}
+	isReady := func() bool { return true }
+	var elector *leader.Elector
+	if leaderElect {
+		if elector, err = leader.NewElector(kubeClient, leaderElection, runLeading); err != nil {
+			return errors.Wrap(err, "error building leader elector")
+		}
+		isReady = elector.IsLeader
+	}
+	if sharder != nil {
+		isReady = sharder.Holding
+	}
+	// The standby replicas and the replicas not holding the shard of a migrate do not expose its gauges.
+	prometheus.MustRegister(newMigrateCollector(controller.symLister, func(migrate *v1.Migrate) bool {
+		return isReady() && controller.ownsMigrate(migrate)
+	}))
 
 	//Start a monitor for symphony operator
 	//monitorErrCh := make(chan error)
 	go func() {
-		mux := monitor.NewProbesMux(helmClient)
+		mux := monitor.NewProbesMux(newProbes(controller, kubeClient, helmClients, isReady, sharder != nil, operatorConfig.Health))
 
 		// Register gRPC server to prometheus to initialized matrix
 		//goprom.Register(rootServer)
 		monitor.AddPrometheusHandler(mux)
+		mux.HandleFunc("/debug/loglevel", log.LevelHandler())
+		if controller.history != nil {
+			mux.Handle("/api/v1/", history.Handler(controller.history, historyConfig.Window.Duration))
+		}
 
-		klog.Infof("Monitor server is listening on [%s]\n", monitor.TraceAdd)
-		if err := http.ListenAndServe(monitor.MonitorAddr, mux); err != nil {
+		log.Infof("Monitor server is listening on [%s]", operatorConfig.MonitorAddress)
+		if err := http.ListenAndServe(operatorConfig.MonitorAddress, mux); err != nil {
 			//monitorErrCh <- err
-			klog.Fatalf("Error start monitor server: %s", err.Error())
+			log.Fatalf("Error start monitor server: %s", err.Error())
 		}
 	}()
 	//select {
 	//case err := <-srvErrCh:
 	//	logger.Fatalf("Server died: %s", err)
 	//case err := <-monitorErrCh:
-	//	klog.Fatalf("Monitor server died: %s", err.Error())
+	//	log.Fatalf("Monitor server died: %s", err.Error())
 	//}
 
 	if *enableTracing {
-		monitor.StartTracing()
+		controller.addDebugHandlers(http.DefaultServeMux)
+		monitor.StartTracing(operatorConfig.TraceAddress)
 	}
 
-	if err = controller.Run(2, stopCh); err != nil {
-		klog.Fatalf("Error running controller: %s", err.Error())
+	if sharder != nil {
+		return runSharded(controller, sharder, elector, runLeading, stopCh)
 	}
+	if elector == nil {
+		runLeading(stopCh)
+		return nil
+	}
+	log.Infof("Campaigning for lease %s/%s as %s", leaderElection.Namespace, leaderElection.Name, leaderElection.Identity)
+	elector.Run(stopCh)
+	select {
+	case <-stopCh:
+		return nil
+	default:
+		// The workers have stopped, the replica exits once cleaned up and is restarted to stand by again.
+		return errors.Errorf("lost the leadership of lease %s/%s", leaderElection.Namespace, leaderElection.Name)
+	}
+}
+
+// runSharded runs the controller on every replica for the migrates of the shards it holds, only the leader runs
+// the cluster health checker, or the holder of shard 0 without leader election.
+func runSharded(controller *Controller, sharder *shard.Sharder, elector *leader.Elector,
+	runLeading func(<-chan struct{}), stopCh <-chan struct{}) error {
+	shardingDone := make(chan struct{})
+	go func() {
+		defer close(shardingDone)
+		sharder.Run(stopCh)
+	}()
+	if elector == nil {
+		// The replicas would race to update the status of the clusters if each of them probed them.
+		go runWhileHolding(func() bool { return sharder.Holds(0) }, runLeading, sharding.RenewPeriod, stopCh)
+	} else {
+		// A replica which has lost the leadership campaigns again while it keeps syncing its shards.
+		go wait.Until(func() { elector.Run(stopCh) }, leaderElection.RetryPeriod, stopCh)
+	}
+
+	if err := controller.Run(operatorConfig.Workers, stopCh); err != nil {
+		return errors.Wrap(err, "error running controller")
+	}
+	// The shards are released for the other replicas before exiting.
+	<-shardingDone
+	return nil
+}
 
+// leaderIdentity returns the hostname, i.e. the pod name, with a random suffix in case of a restarted container.
+func leaderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Error getting hostname: %s", err.Error())
+	}
+	return hostname + "_" + rand.String(5)
+}
+
+// buildConfig uses the out of cluster config if the kubeconfig has been specified, otherwise the in cluster one.
+func buildConfig(masterURL, kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
+	}
+	return rest.InClusterConfig()
+}
+
+// newHelmClientPool creates the helm clients of a cluster according to the flags, the clients are closed once the
+// stop channel is closed.
+func newHelmClientPool(cfg *rest.Config, kubeClient kubernetes.Interface, host string,
+	stopCh <-chan struct{}) (*helm.ClientPool, error) {
+	switch {
+	case operatorConfig.Tiller.Disabled:
+		localBackend, err := helm.NewLocalBackend(cfg, kubeClient, operatorConfig.Tiller.ReleaseNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return helm.NewSharedClientPool(localBackend), nil
+	case host != "":
+		tillerClient, err := newTillerClient(cfg, kubeClient, host, operatorConfig.Tiller.Namespace, tillerTLS, stopCh)
+		if err != nil {
+			return nil, err
+		}
+		return helm.NewSharedClientPool(tillerClient), nil
+	default:
+		helmClients := helm.NewClientPool(operatorConfig.Tiller.Namespace, operatorConfig.Tiller.ClientIdleTimeout.Duration,
+			func(namespace string, stopCh <-chan struct{}) (helm.ReleaseBackend, error) {
+				return newTillerClient(cfg, kubeClient, "", namespace, tillerTLS, stopCh)
+			})
+		// The client of the default tiller is created at first to fail fast.
+		_, release, err := helmClients.Get("")
+		if err != nil {
+			return nil, err
+		}
+		release()
+		go helmClients.Run(operatorConfig.Tiller.HealthCheckInterval.Duration, stopCh)
+		return helmClients, nil
+	}
+}
+
+// newTillerClient connects to tiller at the host directly if it has been specified, otherwise through a port-forward
+// to the tiller pod in the namespace.
+func newTillerClient(cfg *rest.Config, kubeClient kubernetes.Interface, host, namespace string,
+	tlsOpts helm.TLSOptions, stopCh <-chan struct{}) (*helm.Client, error) {
+	var tillerClient *helm.Client
+	if host != "" {
+		tillerClient = helm.NewClientWithHost(host)
+	} else {
+		var err error
+		if tillerClient, err = helm.NewClient(cfg, kubeClient, namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	if tlsOpts.Enabled() {
+		if err := helm.ConfigureTLS(tillerClient, kubeClient, tlsOpts, stopCh); err != nil {
+			tillerClient.Close()
+			return nil, err
+		}
+	}
+	return tillerClient, nil
+}
+
+// addTillerTLSFlags registers the flags of the tls connection to tiller, they mirror the tls flags of helm.
+func addTillerTLSFlags(fs *flag.FlagSet, opts *helm.TLSOptions) {
+	fs.BoolVar(&opts.Enable, "tiller-tls", false, "Enable TLS for the connection to tiller.")
+	fs.BoolVar(&opts.Verify, "tiller-tls-verify", false, "Enable TLS for the connection to tiller and verify its certificate.")
+	fs.StringVar(&opts.ServerName, "tiller-tls-hostname", "", "The server name used to verify the hostname of the certificate of tiller.")
+	fs.StringVar(&opts.CACertFile, "tiller-tls-ca-cert", "", "Path to the CA certificate used to verify tiller.")
+	fs.StringVar(&opts.CertFile, "tiller-tls-cert", "", "Path to the client certificate for mutual authentication with tiller.")
+	fs.StringVar(&opts.KeyFile, "tiller-tls-key", "", "Path to the key of the client certificate.")
+	fs.StringVar(&opts.Secret, "tiller-tls-secret", "", "The secret holding ca.crt, tls.crt and tls.key as namespace/name, "+
+		"the certificates are reloaded once it changes. Overrides the certificate files.")
 }
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "", "The comma separated namespaces to watch the migrates, "+
+		"the deployments and the clusters in. All namespaces are watched if it is empty.")
+	flag.StringVar(&configFile, "config", "", "Path to the configuration file of kind "+config.Kind+
+		", the flags and the environment variables override it.")
+	config.AddFlags(flag.CommandLine, operatorConfig)
+	addTillerTLSFlags(flag.CommandLine, &tillerTLS)
+	flag.DurationVar(&clusterHealthCheckInterval, "cluster-health-check-interval", time.Minute,
+		"The interval to probe the registered clusters, no release is scheduled to the unhealthy ones.")
+
+	leaderElectionNamespace := os.Getenv("POD_NAMESPACE")
+	if leaderElectionNamespace == "" {
+		leaderElectionNamespace = "kube-system"
+	}
+	flag.BoolVar(&leaderElect, "leader-elect", false,
+		"Elect a leader on a lease before running the controller, required when running multiple replicas.")
+	flag.StringVar(&leaderElection.Namespace, "leader-elect-namespace", leaderElectionNamespace,
+		"The namespace of the lease, defaults to the namespace of the pod.")
+	flag.StringVar(&leaderElection.Name, "leader-elect-name", "sym-operator", "The name of the lease.")
+	flag.StringVar(&leaderElection.Identity, "leader-elect-identity", leaderIdentity(),
+		"The identity of this replica in the lease, defaults to the hostname with a random suffix.")
+	flag.DurationVar(&leaderElection.LeaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"The duration the standby replicas wait before taking over the lease which has not been renewed.")
+	flag.DurationVar(&leaderElection.RenewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"The duration the leader retries renewing the lease before it gives up the leadership.")
+	flag.DurationVar(&leaderElection.RetryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"The interval between the attempts to acquire or renew the lease.")
+	flag.IntVar(&sharding.Shards, "shards", 0, "Shard the migrates by app name across the replicas, each replica "+
+		"syncs only the migrates of the shards it holds. 0 disables sharding and only the leader syncs the migrates.")
+	flag.DurationVar(&sharding.LeaseDuration, "shard-lease-duration", 15*time.Second,
+		"The duration after which the shards of a replica which has stopped renewing them are taken over.")
+	flag.DurationVar(&sharding.RenewPeriod, "shard-renew-period", 5*time.Second,
+		"The interval to renew the shard leases and rebalance the shards across the replicas.")
 }
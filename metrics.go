@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	listers "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"github.com/yangyongzhi/sym-operator/pkg/metrics"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The phases of the migrates which are not a cluster migration or a relocation in progress.
+const (
+	PhaseProgressing = "Progressing"
+	PhaseStalled     = "Stalled"
+	PhaseFinished    = "Finished"
+)
+
+var (
+	migrationsDesc = prometheus.NewDesc(metrics.Namespace+"_migrations",
+		"The number of the migrates by the action and the phase.", []string{"action", "phase"}, nil)
+	releaseRevisionDesc = prometheus.NewDesc(metrics.Namespace+"_release_revision",
+		"The revision of each release deployed by the migrates.", []string{"namespace", "app", "release"}, nil)
+)
+
+// syncAction returns the action label of the migrate, the migrates without a special action are reconciled.
+func syncAction(migrate *v1.Migrate) string {
+	switch migrate.Spec.Action {
+	case v1.MigrateActionConvert, v1.MigrateActionClusterMigrate, v1.MigrateActionRelocate:
+		return string(migrate.Spec.Action)
+	}
+	return "Reconcile"
+}
+
+// migratePhase returns the phase of the cluster migration or the relocation of the migrate, otherwise whether its
+// releases are finished, stalled beyond the progress deadline or still progressing.
+func migratePhase(migrate *v1.Migrate) string {
+	switch {
+	case migrate.Spec.Action == v1.MigrateActionClusterMigrate && migrate.Status.ClusterMigration != nil &&
+		migrate.Status.ClusterMigration.Phase != "":
+		return string(migrate.Status.ClusterMigration.Phase)
+	case migrate.Spec.Action == v1.MigrateActionRelocate && migrate.Status.Relocation != nil &&
+		migrate.Status.Relocation.Phase != "":
+		return string(migrate.Status.Relocation.Phase)
+	case migrate.Status.Finished == constant.ConditionStatusTrue:
+		return PhaseFinished
+	}
+	for _, condition := range migrate.Status.Conditions {
+		if condition.Type == constant.ProgressDeadlineConditionType && condition.Status == constant.ConditionStatusTrue {
+			return PhaseStalled
+		}
+	}
+	return PhaseProgressing
+}
+
+// migrateCollector exposes the gauges derived from the migrates in the cache when scraped: the migrates by action and
+// phase, and the revision of each release deployed by them. Only the migrates synced by this replica are exposed.
+type migrateCollector struct {
+	lister listers.MigrateLister
+	synced func(migrate *v1.Migrate) bool
+}
+
+func newMigrateCollector(lister listers.MigrateLister, synced func(migrate *v1.Migrate) bool) *migrateCollector {
+	return &migrateCollector{lister: lister, synced: synced}
+}
+
+func (m *migrateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- migrationsDesc
+	ch <- releaseRevisionDesc
+}
+
+func (m *migrateCollector) Collect(ch chan<- prometheus.Metric) {
+	migrates, err := m.lister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("List the migrates for the metrics has an error : %s", err.Error())
+		return
+	}
+	type actionPhase struct{ action, phase string }
+	counts := map[actionPhase]int{}
+	for _, migrate := range migrates {
+		if !m.synced(migrate) {
+			continue
+		}
+		counts[actionPhase{syncAction(migrate), migratePhase(migrate)}]++
+		for rlsName, revision := range migrate.Status.ReleaseRevision {
+			ch <- prometheus.MustNewConstMetric(releaseRevisionDesc, prometheus.GaugeValue, float64(revision),
+				migrate.Namespace, migrate.Spec.AppName, rlsName)
+		}
+	}
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(migrationsDesc, prometheus.GaugeValue, float64(count), key.action, key.phase)
+	}
+}
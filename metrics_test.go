@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	listers "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMigratePhase(t *testing.T) {
+	stalled := newMigrate(blueRelease)
+	stalled.Status.Conditions = []v1.MigrateCondition{newCondition(constant.ProgressDeadlineConditionType,
+		constant.ConditionStatusTrue, "", metav1.Now())}
+	relocating := newMigrate(blueRelease)
+	relocating.Spec.Action = v1.MigrateActionRelocate
+	relocating.Status.Relocation = &v1.RelocationStatus{Phase: v1.RelocationInstalled}
+
+	tests := []struct {
+		name          string
+		migrate       *v1.Migrate
+		expectedPhase string
+	}{
+		{name: "progressing", migrate: newMigrate(blueRelease), expectedPhase: PhaseProgressing},
+		{name: "finished", migrate: withFinished(newMigrate(blueRelease)), expectedPhase: PhaseFinished},
+		{name: "stalled", migrate: stalled, expectedPhase: PhaseStalled},
+		{name: "relocating", migrate: relocating, expectedPhase: string(v1.RelocationInstalled)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if phase := migratePhase(test.migrate); phase != test.expectedPhase {
+				t.Errorf("expected phase %s, got %s", test.expectedPhase, phase)
+			}
+		})
+	}
+}
+
+func TestMigrateCollector(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	finished := withRevisions(withFinished(newMigrate(blueRelease)), map[string]int32{blueRelease: 3})
+	other := newMigrate(blueRelease)
+	other.Name, other.Spec.AppName = "other", "other"
+	indexer.Add(finished)
+	indexer.Add(other)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newMigrateCollector(listers.NewMigrateLister(indexer), func(migrate *v1.Migrate) bool {
+		return migrate.Spec.AppName == testApp
+	}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range m.GetLabel() {
+				key += "," + label.GetName() + "=" + label.GetValue()
+			}
+			values[key] = m.GetGauge().GetValue()
+		}
+	}
+	expected := map[string]float64{
+		"sym_operator_migrations,action=Reconcile,phase=Finished":                         1,
+		"sym_operator_release_revision,app=demo,namespace=default,release=demo-gz01-blue": 3,
+	}
+	if len(values) != len(expected) {
+		t.Errorf("expected the metrics %v, got %v", expected, values)
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, values[key])
+		}
+	}
+}
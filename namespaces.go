@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
+	informers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions"
+	devopsinformers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions/devops/v1"
+	listers "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchedInformers are the informers of a watched namespace, of all namespaces if the namespace is empty. The
+// clusters are nil unless the cluster registry is enabled.
+type watchedInformers struct {
+	namespace   string
+	deployments appsinformers.DeploymentInformer
+	migrates    devopsinformers.MigrateInformer
+	clusters    devopsinformers.ClusterInformer
+}
+
+// newWatchedInformers creates the informers of each namespace with factories filtered by the namespace, the
+// informers of all namespaces if no namespace is specified. The clusters are only watched if the cluster registry is
+// enabled, so the Cluster CRD is not required otherwise. The returned function starts the factories.
+func newWatchedInformers(kubeClient kubernetes.Interface, symClient clientset.Interface, namespaces []string,
+	clusterRegistry bool, resync time.Duration) ([]watchedInformers, func(stopCh <-chan struct{})) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var watched []watchedInformers
+	var starts []func(stopCh <-chan struct{})
+	for _, namespace := range namespaces {
+		kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resync,
+			kubeinformers.WithNamespace(namespace))
+		symInformerFactory := informers.NewSharedInformerFactoryWithOptions(symClient, resync,
+			informers.WithNamespace(namespace))
+		w := watchedInformers{
+			namespace:   namespace,
+			deployments: kubeInformerFactory.Apps().V1().Deployments(),
+			migrates:    symInformerFactory.Devops().V1().Migrates(),
+		}
+		if clusterRegistry {
+			w.clusters = symInformerFactory.Devops().V1().Clusters()
+		}
+		watched = append(watched, w)
+		starts = append(starts, kubeInformerFactory.Start, symInformerFactory.Start)
+	}
+	return watched, func(stopCh <-chan struct{}) {
+		for _, start := range starts {
+			start(stopCh)
+		}
+	}
+}
+
+// allSynced returns a function which tells whether all the informers have synced.
+func allSynced(synced []cache.InformerSynced) cache.InformerSynced {
+	return func() bool {
+		for _, s := range synced {
+			if !s() {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// emptyIndexer backs the listers of the namespaces which are not watched, nothing is found in them.
+var emptyIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+// migrateListers lists the migrates from the lister of each watched namespace.
+type migrateListers map[string]listers.MigrateLister
+
+func (l migrateListers) List(selector labels.Selector) ([]*v1.Migrate, error) {
+	var ret []*v1.Migrate
+	for _, lister := range l {
+		migrates, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, migrates...)
+	}
+	return ret, nil
+}
+
+func (l migrateListers) Migrates(namespace string) listers.MigrateNamespaceLister {
+	if lister, ok := l[namespace]; ok {
+		return lister.Migrates(namespace)
+	}
+	if lister, ok := l[metav1.NamespaceAll]; ok {
+		return lister.Migrates(namespace)
+	}
+	return listers.NewMigrateLister(emptyIndexer).Migrates(namespace)
+}
+
+// clusterListers lists the clusters from the lister of each watched namespace.
+type clusterListers map[string]listers.ClusterLister
+
+func (l clusterListers) List(selector labels.Selector) ([]*v1.Cluster, error) {
+	var ret []*v1.Cluster
+	for _, lister := range l {
+		clusters, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, clusters...)
+	}
+	return ret, nil
+}
+
+func (l clusterListers) Clusters(namespace string) listers.ClusterNamespaceLister {
+	if lister, ok := l[namespace]; ok {
+		return lister.Clusters(namespace)
+	}
+	if lister, ok := l[metav1.NamespaceAll]; ok {
+		return lister.Clusters(namespace)
+	}
+	return listers.NewClusterLister(emptyIndexer).Clusters(namespace)
+}
+
+// deploymentListers lists the deployments from the lister of each watched namespace.
+type deploymentListers map[string]appslisters.DeploymentLister
+
+func (l deploymentListers) List(selector labels.Selector) ([]*appsv1.Deployment, error) {
+	var ret []*appsv1.Deployment
+	for _, lister := range l {
+		deployments, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, deployments...)
+	}
+	return ret, nil
+}
+
+func (l deploymentListers) Deployments(namespace string) appslisters.DeploymentNamespaceLister {
+	lister, ok := l.lister(namespace)
+	if !ok {
+		return unwatchedDeploymentLister{namespace: namespace}
+	}
+	return lister.Deployments(namespace)
+}
+
+func (l deploymentListers) GetDeploymentsForReplicaSet(rs *appsv1.ReplicaSet) ([]*appsv1.Deployment, error) {
+	lister, ok := l.lister(rs.Namespace)
+	if !ok {
+		return nil, &namespaceNotWatchedError{namespace: rs.Namespace}
+	}
+	return lister.GetDeploymentsForReplicaSet(rs)
+}
+
+func (l deploymentListers) lister(namespace string) (appslisters.DeploymentLister, bool) {
+	if lister, ok := l[namespace]; ok {
+		return lister, true
+	}
+	lister, ok := l[metav1.NamespaceAll]
+	return lister, ok
+}
+
+// namespaceNotWatchedError is returned when listing the deployments of a namespace which is not watched, as finding
+// nothing there would read as the deployments do not exist.
+type namespaceNotWatchedError struct {
+	namespace string
+}
+
+func (e *namespaceNotWatchedError) Error() string {
+	return fmt.Sprintf("namespace %s is not watched", e.namespace)
+}
+
+func isNamespaceNotWatched(err error) bool {
+	_, ok := err.(*namespaceNotWatchedError)
+	return ok
+}
+
+// unwatchedDeploymentLister fails to list the deployments of a namespace which is not watched.
+type unwatchedDeploymentLister struct {
+	namespace string
+}
+
+func (l unwatchedDeploymentLister) List(selector labels.Selector) ([]*appsv1.Deployment, error) {
+	return nil, &namespaceNotWatchedError{namespace: l.namespace}
+}
+
+func (l unwatchedDeploymentLister) Get(name string) (*appsv1.Deployment, error) {
+	return nil, &namespaceNotWatchedError{namespace: l.namespace}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/fake"
+	listers "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func newNamespaceMigrateLister(t *testing.T, namespace string) listers.MigrateLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	migrate := &v1.Migrate{ObjectMeta: metav1.ObjectMeta{Name: testApp, Namespace: namespace}}
+	if err := indexer.Add(migrate); err != nil {
+		t.Fatalf("add migrate: %v", err)
+	}
+	return listers.NewMigrateLister(indexer)
+}
+
+func TestWatchedNamespaceListers(t *testing.T) {
+	l := migrateListers{
+		"team-a": newNamespaceMigrateLister(t, "team-a"),
+		"team-b": newNamespaceMigrateLister(t, "team-b"),
+	}
+
+	migrates, err := l.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("list migrates: %v", err)
+	}
+	if len(migrates) != 2 {
+		t.Errorf("expected the migrates of both namespaces, got %d", len(migrates))
+	}
+	for _, namespace := range []string{"team-a", "team-b"} {
+		if migrate, err := l.Migrates(namespace).Get(testApp); err != nil || migrate.Namespace != namespace {
+			t.Errorf("expected the migrate in namespace %s, got %v: %v", namespace, migrate, err)
+		}
+	}
+	if _, err := l.Migrates("team-c").Get(testApp); !errors.IsNotFound(err) {
+		t.Errorf("expected no migrate in the namespace which is not watched, got %v", err)
+	}
+
+	all := migrateListers{metav1.NamespaceAll: newNamespaceMigrateLister(t, "team-c")}
+	if _, err := all.Migrates("team-c").Get(testApp); err != nil {
+		t.Errorf("expected the migrate from the lister of all namespaces, got %v", err)
+	}
+}
+
+func TestClusterRegistryIsOptional(t *testing.T) {
+	for _, clusterRegistry := range []bool{false, true} {
+		watched, _ := newWatchedInformers(k8sfake.NewSimpleClientset(), fake.NewSimpleClientset(),
+			[]string{"team-a", "team-b"}, clusterRegistry, 0)
+		c := NewController(k8sfake.NewSimpleClientset(), fake.NewSimpleClientset(), helm.NewSharedClientPool(helmtest.NewFakeBackend()),
+			nil, watched, workqueue.DefaultControllerRateLimiter())
+		// Without the registry the Cluster CRD is not required, so nothing waits for the clusters to sync.
+		if (c.clustersSynced != nil) != clusterRegistry {
+			t.Errorf("expected the clusters to be watched %v, got %v", clusterRegistry, c.clustersSynced != nil)
+		}
+		if clusterRegistry {
+			continue
+		}
+		migrate := withClusterSelector(newMigrate(blueRelease), blueRelease, map[string]string{"ldc": "sh"})
+		if _, err := c.scheduleRelease(migrate, findReleaseConfig(migrate, blueRelease)); err == nil {
+			t.Errorf("expected a cluster selector to be refused without the cluster registry")
+		}
+	}
+}
+
+func TestPruneReleaseOutsideWatchedNamespaces(t *testing.T) {
+	const releaseNamespace = "team-b"
+	for _, protected := range []bool{true, false} {
+		f := newFixture(t, runningRelease(blueRelease, 1),
+			helmtest.NewFakeRelease(greenRelease, releaseNamespace, 1, release.Status_DEPLOYED))
+		f.watchNamespace = metav1.NamespaceDefault
+		migrate := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1})
+		f.addMigrate(migrate)
+		// The deployment can only be found through the API, as its namespace is not watched.
+		deployment := newDeployment(greenRelease, 2)
+		deployment.Namespace = releaseNamespace
+		if protected {
+			deployment.Annotations = map[string]string{constant.ProtectedAnnotation: "true"}
+		}
+		f.kubeobjects = append(f.kubeobjects, deployment)
+
+		c, _, _ := f.newController()
+		if _, err := c.deploymentsLister.Deployments(releaseNamespace).List(labels.Everything()); !isNamespaceNotWatched(err) {
+			t.Errorf("expected listing the namespace which is not watched to fail, got %v", err)
+		}
+
+		f.run(migrate)
+
+		pruned := false
+		for _, action := range f.backend.Actions {
+			pruned = pruned || action == helm.OperationUninstall+"/"+greenRelease
+		}
+		if pruned == protected {
+			t.Errorf("expected the release outside the watched namespaces to be pruned %v as it is protected %v", !protected, protected)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	clientset "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
+	informers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions"
+	devopsinformers "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+const (
+	AppliedConfig            = "AppliedConfig"
+	RejectedConfig           = "RejectedConfig"
+	Frozen                   = "Frozen"
+	DisallowedChart          = "DisallowedChart"
+	ProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+)
+
+// livePolicy holds the spec of the OperatorConfig in effect, it is replaced as a whole once a change is accepted.
+type livePolicy struct {
+	mu   sync.RWMutex
+	spec v1.OperatorConfigSpec
+}
+
+func (p *livePolicy) get() v1.OperatorConfigSpec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return *p.spec.DeepCopy()
+}
+
+// set replaces the spec in effect, it returns true if the spec has been changed.
+func (p *livePolicy) set(spec v1.OperatorConfigSpec) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if equality.Semantic.DeepEqual(p.spec, spec) {
+		return false
+	}
+	p.spec = *spec.DeepCopy()
+	return true
+}
+
+// newOperatorConfigInformer creates the informer of the OperatorConfig with the namespace/name key, the returned
+// function starts it.
+func newOperatorConfigInformer(symClient clientset.Interface, key string, resync time.Duration) (
+	devopsinformers.OperatorConfigInformer, func(stopCh <-chan struct{})) {
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	factory := informers.NewSharedInformerFactoryWithOptions(symClient, resync, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	return factory.Devops().V1().OperatorConfigs(), factory.Start
+}
+
+// watchOperatorConfig applies the OperatorConfig watched by the informer live, the default policies are restored
+// once it is deleted. The workers wait for the informer to sync, so the policies are in effect from the first sync.
+func (c *Controller) watchOperatorConfig(informer devopsinformers.OperatorConfigInformer) {
+	c.operatorConfigSynced = informer.Informer().HasSynced
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.applyOperatorConfig(obj.(*v1.OperatorConfig))
+		},
+		UpdateFunc: func(old, new interface{}) {
+			c.applyOperatorConfig(new.(*v1.OperatorConfig))
+		},
+		DeleteFunc: func(obj interface{}) {
+			log.Infof("The operator config has been deleted, restore the default policies")
+			if c.policy.set(v1.OperatorConfigSpec{}) {
+				c.enqueueAll()
+			}
+		},
+	})
+}
+
+// applyOperatorConfig validates the OperatorConfig and puts it in effect, a rejected one leaves the previous one in
+// effect. The result is reported in the status of the OperatorConfig.
+func (c *Controller) applyOperatorConfig(config *v1.OperatorConfig) {
+	status := v1.OperatorConfigStatus{ObservedGeneration: config.Generation, Accepted: true}
+	if err := validateOperatorConfig(&config.Spec); err != nil {
+		status.Accepted = false
+		status.Message = err.Error()
+		// The rejection has been recorded if it is reported in the status already, e.g. on resync.
+		if config.Status.Accepted || config.Status.ObservedGeneration != config.Generation ||
+			config.Status.Message != status.Message {
+			c.recorder.Event(config, corev1.EventTypeWarning, RejectedConfig,
+				fmt.Sprintf("Reject the operator config, the one in effect is kept : %s", err.Error()))
+		}
+	} else if c.policy.set(config.Spec) {
+		log.WithFields(log.Fields{"operator_config": config.Namespace + "/" + config.Name}).Infof("Applied the operator config : %+v", config.Spec)
+		c.recorder.Event(config, corev1.EventTypeNormal, AppliedConfig, "The operator config has been applied")
+		// The migrates are synced again with the new policies, e.g. once a freeze is lifted.
+		c.enqueueAll()
+	}
+	inEffect := c.policy.get()
+	status.InEffect = &inEffect
+
+	status.LastUpdateTime = config.Status.LastUpdateTime
+	if equality.Semantic.DeepEqual(status, config.Status) {
+		return
+	}
+	now := metav1.Now()
+	status.LastUpdateTime = &now
+	configCopy := config.DeepCopy()
+	configCopy.Status = status
+	// The status is updated through the status subresource, so it does not bump the generation.
+	if _, err := c.symclientset.DevopsV1().OperatorConfigs(config.Namespace).UpdateStatus(configCopy); err != nil {
+		log.WithFields(log.Fields{"operator_config": config.Namespace + "/" + config.Name}).WithError(err).
+			Error("Update the status of the operator config has an error")
+	}
+}
+
+// validateOperatorConfig rejects the invalid spec.
+func validateOperatorConfig(spec *v1.OperatorConfigSpec) error {
+	var errs []error
+	switch spec.DefaultPrunePolicy {
+	case "", v1.PrunePolicyDelete, v1.PrunePolicyOrphan, v1.PrunePolicyConfirm:
+	default:
+		errs = append(errs, errors.Errorf("defaultPrunePolicy should be one of %s, %s and %s, got %q",
+			v1.PrunePolicyDelete, v1.PrunePolicyOrphan, v1.PrunePolicyConfirm, spec.DefaultPrunePolicy))
+	}
+	if seconds := spec.DefaultProgressDeadlineSeconds; seconds != nil && *seconds <= 0 {
+		errs = append(errs, errors.Errorf("defaultProgressDeadlineSeconds should be positive, got %d", *seconds))
+	}
+	for _, pattern := range spec.AllowedChartSources {
+		if _, err := path.Match(pattern, ""); err != nil || strings.TrimSpace(pattern) == "" {
+			errs = append(errs, errors.Errorf("allowedChartSources has an invalid pattern %q", pattern))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// enqueueAll enqueues all the migrates, it is used once the policies have been changed.
+func (c *Controller) enqueueAll() {
+	migrates, err := c.symLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("List the migrates has an error : %s", err.Error())
+		return
+	}
+	for _, migrate := range migrates {
+		c.enqueueMigrate(migrate)
+	}
+}
+
+// allowedChart returns an error if the chart matches none of the allowed sources, any chart is allowed if no source
+// is specified. The home and the sources in the metadata of the chart are matched.
+func allowedChart(spec v1.OperatorConfigSpec, chartBytes []byte) error {
+	if len(spec.AllowedChartSources) == 0 {
+		return nil
+	}
+	chart, err := chartutil.LoadArchive(bytes.NewReader(chartBytes))
+	if err != nil {
+		return errors.Wrap(err, "load chart")
+	}
+	metadata := chart.GetMetadata()
+	sources := append([]string{metadata.GetHome()}, metadata.GetSources()...)
+	for _, source := range sources {
+		if source == "" {
+			continue
+		}
+		for _, pattern := range spec.AllowedChartSources {
+			if matched, _ := path.Match(pattern, source); matched {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf("the sources %v of chart %s match none of the allowed sources %v",
+		sources, metadata.GetName(), spec.AllowedChartSources)
+}
+
+// progressDeadline returns the progress deadline of the migrate, 0 means no deadline.
+func progressDeadline(migrate *v1.Migrate, defaultSeconds *int32) time.Duration {
+	seconds := migrate.Spec.ProgressDeadlineSeconds
+	if seconds == nil {
+		seconds = defaultSeconds
+	}
+	if seconds == nil {
+		return 0
+	}
+	return time.Duration(*seconds) * time.Second
+}
+
+// syncProgressDeadline reports the migrate as stalled once its releases have not become available within the
+// progress deadline since the rollout started, the start time is reset once a finished migrate is rolled out again.
+func (c *Controller) syncProgressDeadline(migrateCopy *v1.Migrate, initialFinished string, now metav1.Time) {
+	if migrateCopy.Status.Finished == constant.ConditionStatusFalse &&
+		(migrateCopy.Status.StartTime == nil || initialFinished == constant.ConditionStatusTrue) {
+		migrateCopy.Status.StartTime = &now
+	}
+
+	var stalled *v1.MigrateCondition
+	for i := range migrateCopy.Status.Conditions {
+		if migrateCopy.Status.Conditions[i].Type == constant.ProgressDeadlineConditionType {
+			stalled = &migrateCopy.Status.Conditions[i]
+		}
+	}
+	deadline := progressDeadline(migrateCopy, c.policy.get().DefaultProgressDeadlineSeconds)
+	if migrateCopy.Status.Finished == constant.ConditionStatusFalse && deadline > 0 &&
+		now.Sub(migrateCopy.Status.StartTime.Time) > deadline {
+		message := fmt.Sprintf("The releases of migrate [%s] have not become available within %s since %s",
+			migrateCopy.Name, deadline, migrateCopy.Status.StartTime.Format(time.RFC3339))
+		if stalled == nil || stalled.Status != constant.ConditionStatusTrue {
+			c.recorder.Event(migrateCopy, corev1.EventTypeWarning, ProgressDeadlineExceeded, message)
+		}
+		upsertCondition(migrateCopy, newCondition(constant.ProgressDeadlineConditionType, constant.ConditionStatusTrue, message, now))
+	} else if stalled != nil && stalled.Status == constant.ConditionStatusTrue {
+		upsertCondition(migrateCopy, newCondition(constant.ProgressDeadlineConditionType, constant.ConditionStatusFalse,
+			"The releases are progressing within the deadline", now))
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core "k8s.io/client-go/testing"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestValidateOperatorConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  v1.OperatorConfigSpec
+		valid bool
+	}{
+		{name: "empty", valid: true},
+		{name: "all policies", spec: v1.OperatorConfigSpec{DefaultPrunePolicy: v1.PrunePolicyConfirm,
+			DefaultProgressDeadlineSeconds: int32Ptr(600), Freeze: true, FreezeReason: "release window",
+			AllowedChartSources: []string{"https://charts.dmall.com/*"}}, valid: true},
+		{name: "unknown prune policy", spec: v1.OperatorConfigSpec{DefaultPrunePolicy: "Purge"}},
+		{name: "zero progress deadline", spec: v1.OperatorConfigSpec{DefaultProgressDeadlineSeconds: int32Ptr(0)}},
+		{name: "invalid chart source", spec: v1.OperatorConfigSpec{AllowedChartSources: []string{"https://charts.dmall.com/["}}},
+		{name: "empty chart source", spec: v1.OperatorConfigSpec{AllowedChartSources: []string{""}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateOperatorConfig(&test.spec); (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func newOperatorConfig(generation int64, spec v1.OperatorConfigSpec) *v1.OperatorConfig {
+	return &v1.OperatorConfig{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Name: "sym-operator", Namespace: "kube-system", Generation: generation},
+		Spec:       spec,
+	}
+}
+
+// updatedOperatorConfigStatus returns the status of the last OperatorConfig updated by the controller.
+func (f *fixture) updatedOperatorConfigStatus() *v1.OperatorConfigStatus {
+	var status *v1.OperatorConfigStatus
+	for _, action := range f.client.Actions() {
+		if update, ok := action.(core.UpdateAction); ok && action.Matches("update", "operatorconfigs") {
+			status = &update.GetObject().(*v1.OperatorConfig).Status
+		}
+	}
+	return status
+}
+
+func TestApplyOperatorConfig(t *testing.T) {
+	f := newFixture(t)
+	accepted := newOperatorConfig(1, v1.OperatorConfigSpec{DefaultPrunePolicy: v1.PrunePolicyOrphan, Freeze: true})
+	f.objects = append(f.objects, accepted)
+	c, _, _ := f.newController()
+
+	c.applyOperatorConfig(accepted)
+	if policy := c.policy.get(); !reflect.DeepEqual(policy, accepted.Spec) {
+		t.Errorf("expected the policy %+v in effect, got %+v", accepted.Spec, policy)
+	}
+	status := f.updatedOperatorConfigStatus()
+	if status == nil || !status.Accepted || status.ObservedGeneration != 1 || !reflect.DeepEqual(status.InEffect, &accepted.Spec) {
+		t.Fatalf("expected the accepted config to be reported in effect, got %+v", status)
+	}
+	checkEvents(t, []string{AppliedConfig}, f.events())
+
+	// The rejected change leaves the previous config in effect.
+	rejected := newOperatorConfig(2, v1.OperatorConfigSpec{DefaultPrunePolicy: "Purge"})
+	rejected.Status = *status
+	c.applyOperatorConfig(rejected)
+	if policy := c.policy.get(); !reflect.DeepEqual(policy, accepted.Spec) {
+		t.Errorf("expected the previous policy %+v to stay in effect, got %+v", accepted.Spec, policy)
+	}
+	status = f.updatedOperatorConfigStatus()
+	if status.Accepted || status.ObservedGeneration != 2 || status.Message == "" || !reflect.DeepEqual(status.InEffect, &accepted.Spec) {
+		t.Errorf("expected the rejection to be reported with the previous config in effect, got %+v", status)
+	}
+	checkEvents(t, []string{RejectedConfig}, f.events())
+
+	// The rejection is not recorded again once it has been reported.
+	rejected.Status = *status
+	updates := len(f.client.Actions())
+	c.applyOperatorConfig(rejected)
+	if events := f.events(); len(events) != 0 {
+		t.Errorf("expected no event for the reported rejection, got %v", events)
+	}
+	if len(f.client.Actions()) != updates {
+		t.Errorf("expected the status not to be updated again")
+	}
+}
+
+func TestSyncFrozen(t *testing.T) {
+	f := newFixture(t, runningRelease(greenRelease, 1))
+	f.policy.Freeze = true
+	migrate := newMigrate(blueRelease)
+	f.addMigrate(migrate)
+
+	if updated := f.run(migrate); updated != nil {
+		t.Errorf("expected the frozen migrate not to be updated")
+	}
+	checkActions(t, nil, f.backend.Actions)
+	checkEvents(t, []string{Frozen}, f.events())
+}
+
+func TestAllowedChartSources(t *testing.T) {
+	chartBytes, err := helm.SaveChartByte(&chart.Chart{
+		Metadata: &chart.Metadata{Name: "demo", Version: "0.1.0", ApiVersion: "v1", Home: "https://charts.dmall.com/demo",
+			Sources: []string{"https://git.dmall.com/demo"}},
+	})
+	if err != nil {
+		t.Fatalf("save chart: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		sources         []string
+		expectedActions []string
+		expectedEvents  []string
+	}{
+		{
+			name:            "any chart is allowed by default",
+			expectedActions: []string{"list", "install/" + blueRelease},
+			expectedEvents:  []string{SuccessInstalledStatus, SuccessSynced},
+		},
+		{
+			name:            "chart from an allowed source",
+			sources:         []string{"https://github.com/*", "https://git.dmall.com/*"},
+			expectedActions: []string{"list", "install/" + blueRelease},
+			expectedEvents:  []string{SuccessInstalledStatus, SuccessSynced},
+		},
+		{
+			name:           "chart from another source",
+			sources:        []string{"https://github.com/*"},
+			expectedEvents: []string{DisallowedChart, SuccessSynced},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.policy.AllowedChartSources = test.sources
+			migrate := newMigrate(blueRelease)
+			migrate.Spec.Chart = chartBytes
+			f.addMigrate(migrate)
+
+			f.run(migrate)
+			checkActions(t, test.expectedActions, f.backend.Actions)
+			checkEvents(t, test.expectedEvents, f.events())
+		})
+	}
+}
+
+func TestProgressDeadline(t *testing.T) {
+	tests := []struct {
+		name            string
+		deadline        *int32
+		defaultDeadline *int32
+		started         time.Duration
+		// The expected status of the progress deadline condition, empty if it is not expected.
+		expectedStatus string
+		expectedEvents []string
+	}{
+		{
+			name:           "no deadline",
+			started:        time.Hour,
+			expectedEvents: []string{SuccessSynced},
+		},
+		{
+			name:           "progressing within the deadline",
+			deadline:       int32Ptr(600),
+			started:        time.Minute,
+			expectedEvents: []string{SuccessSynced},
+		},
+		{
+			name:           "deadline of the migrate exceeded",
+			deadline:       int32Ptr(600),
+			started:        time.Hour,
+			expectedStatus: constant.ConditionStatusTrue,
+			expectedEvents: []string{ProgressDeadlineExceeded, SuccessSynced},
+		},
+		{
+			name:            "default deadline of the operator config exceeded",
+			defaultDeadline: int32Ptr(600),
+			started:         time.Hour,
+			expectedStatus:  constant.ConditionStatusTrue,
+			expectedEvents:  []string{ProgressDeadlineExceeded, SuccessSynced},
+		},
+		{
+			name:            "the deadline of the migrate overrides the default one",
+			deadline:        int32Ptr(7200),
+			defaultDeadline: int32Ptr(600),
+			started:         time.Hour,
+			expectedEvents:  []string{SuccessSynced},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, runningRelease(blueRelease, 1))
+			f.policy.DefaultProgressDeadlineSeconds = test.defaultDeadline
+			migrate := withRevisions(newMigrate(blueRelease), map[string]int32{blueRelease: 1})
+			migrate.Spec.ProgressDeadlineSeconds = test.deadline
+			started := metav1.NewTime(time.Now().Add(-test.started))
+			migrate.Status.StartTime = &started
+			f.addMigrate(migrate)
+			// The deployment is not available yet.
+			f.addDeployment(newDeployment(blueRelease, 1))
+
+			updated := f.run(migrate)
+			if updated == nil {
+				t.Fatalf("expected the migrate to be updated")
+			}
+			var status string
+			for _, condition := range updated.Status.Conditions {
+				if condition.Type == constant.ProgressDeadlineConditionType {
+					status = condition.Status
+				}
+			}
+			if status != test.expectedStatus {
+				t.Errorf("expected the progress deadline condition %q, got %q", test.expectedStatus, status)
+			}
+			if !updated.Status.StartTime.Equal(&started) {
+				t.Errorf("expected the start time to be kept, got %v", updated.Status.StartTime)
+			}
+			checkEvents(t, test.expectedEvents, f.events())
+		})
+	}
+}
+
+func TestProgressDeadlineResetOnRollout(t *testing.T) {
+	f := newFixture(t)
+	f.policy.DefaultProgressDeadlineSeconds = int32Ptr(600)
+	// The releases of the finished migrate are gone, so it is rolled out again.
+	migrate := withFinished(newMigrate(blueRelease))
+	started := metav1.NewTime(time.Now().Add(-time.Hour))
+	migrate.Status.StartTime = &started
+	f.addMigrate(migrate)
+
+	updated := f.run(migrate)
+	if updated == nil || updated.Status.StartTime == nil || !updated.Status.StartTime.After(started.Time) {
+		t.Fatalf("expected the start time of the new rollout to be recorded, got %+v", updated)
+	}
+	for _, condition := range updated.Status.Conditions {
+		if condition.Type == constant.ProgressDeadlineConditionType {
+			t.Errorf("expected the new rollout not to be stalled, got %+v", condition)
+		}
+	}
+}
@@ -43,6 +43,12 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&Migrate{},
 		&MigrateList{},
+		&Cluster{},
+		&ClusterList{},
+		&OperatorConfig{},
+		&OperatorConfigList{},
+		&ReleaseOperation{},
+		&ReleaseOperationList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -1,7 +1,9 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // +genclient
@@ -31,6 +33,27 @@ type MigrateSpec struct {
 	Meta     map[string]string `json:"meta,omitempty"`
 	Chart    []byte            `json:"chart,omitempty"`
 	Releases []*ReleasesConfig `json:"releases,omitempty"`
+	// PrunePolicy decides what to do with the running releases of this app which are
+	// not defined in Releases, default to the one of the OperatorConfig, then Delete.
+	PrunePolicy PrunePolicyType `json:"prunePolicy,omitempty"`
+	// PruneLimit is the max count of releases which can be pruned in one sync, nothing
+	// will be pruned if there are more releases waiting for pruning, default to 1. A negative
+	// limit is treated as 0 with a warning event.
+	PruneLimit *int32 `json:"pruneLimit,omitempty"`
+	// ProgressDeadlineSeconds is how long the releases may take to become available before the migrate is reported
+	// as stalled, default to the one of the OperatorConfig, no deadline if neither is set.
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// TillerNamespace is the namespace of the tiller which deploys the releases, default to the tiller
+	// namespace of the operator.
+	TillerNamespace string `json:"tillerNamespace,omitempty"`
+	// Convert configures the conversion of the releases from helm 2 to helm 3, it only works with the Convert action.
+	Convert *ConvertConfig `json:"convert,omitempty"`
+	// ClusterMigration configures the migration of the releases between clusters, it only works with the
+	// ClusterMigrate action.
+	ClusterMigration *ClusterMigrationConfig `json:"clusterMigration,omitempty"`
+	// Relocation configures the relocation of the releases to another namespace, it only works with the
+	// Relocate action.
+	Relocation *RelocationConfig `json:"relocation,omitempty"`
 }
 
 type MigrateActionType string
@@ -39,6 +62,83 @@ const (
 	MigrateActionInstall MigrateActionType = "Install"
 	MigrateActionUpdate  MigrateActionType = "Update"
 	MigrateActionDelete  MigrateActionType = "Delete"
+	// MigrateActionConvert converts the releases from the tiller configmaps to the helm 3 release secrets
+	// instead of installing or updating them.
+	MigrateActionConvert MigrateActionType = "Convert"
+	// MigrateActionClusterMigrate moves the releases from the source cluster to the destination cluster and
+	// switches the traffic over.
+	MigrateActionClusterMigrate MigrateActionType = "ClusterMigrate"
+	// MigrateActionRelocate moves the releases to another namespace of the cluster the operator runs in.
+	MigrateActionRelocate MigrateActionType = "Relocate"
+)
+
+// ConvertConfig
+type ConvertConfig struct {
+	// TillerNamespace is where tiller stores its release configmaps, default to the TillerNamespace of the registered
+	// cluster the release has been scheduled to, then the one of the spec, then kube-system. The releases are
+	// converted in their target clusters.
+	TillerNamespace string `json:"tillerNamespace,omitempty"`
+	// MaxHistory is the max count of the latest versions to convert for each release, 0 means all versions.
+	MaxHistory int32 `json:"maxHistory,omitempty"`
+	// DeleteV2Releases deletes the tiller configmaps once the helm 3 secrets have been verified.
+	DeleteV2Releases bool `json:"deleteV2Releases,omitempty"`
+}
+
+// ClusterMigrationConfig
+type ClusterMigrationConfig struct {
+	// SourceCluster and DestinationCluster are the kubeconfig secrets in the namespace of the migrate, empty means
+	// the cluster the operator runs in.
+	SourceCluster      string `json:"sourceCluster,omitempty"`
+	DestinationCluster string `json:"destinationCluster,omitempty"`
+	// Checks must pass before the traffic is switched to the destination cluster.
+	Checks []HTTPCheck `json:"checks,omitempty"`
+	// Traffic is switched once the releases in the destination cluster are healthy, nothing is switched if it is nil.
+	Traffic *TrafficConfig `json:"traffic,omitempty"`
+	// DrainSeconds is how long to wait after switching the traffic before the source releases are uninstalled.
+	DrainSeconds int32 `json:"drainSeconds,omitempty"`
+}
+
+// HTTPCheck
+type HTTPCheck struct {
+	URL string `json:"url"`
+	// ExpectedStatus is the expected status code of the response, default to 200.
+	ExpectedStatus int32 `json:"expectedStatus,omitempty"`
+}
+
+// TrafficConfig points the traffic of the app to the destination cluster, the service or the configmap is in the
+// namespace of the migrate in the cluster the operator runs in.
+type TrafficConfig struct {
+	// Service is turned into an ExternalName service pointing to the address, its previous type, cluster IP and
+	// ports are recorded in the status.
+	Service string `json:"service,omitempty"`
+	// ConfigMap holds a DNS-style record at the key which is set to the address.
+	ConfigMap string `json:"configMap,omitempty"`
+	Key       string `json:"key,omitempty"`
+	// Address is the address of the app in the destination cluster.
+	Address string `json:"address"`
+}
+
+// RelocationConfig
+type RelocationConfig struct {
+	// TargetNamespace is the namespace which the releases are moved to.
+	TargetNamespace string `json:"targetNamespace"`
+	// ReleaseNames maps the names of the releases to the new names in the target namespace, a release not in it
+	// is renamed by inserting the target namespace before its group, e.g. app-gz01-blue to app-gz01-ns-blue.
+	ReleaseNames map[string]string `json:"releaseNames,omitempty"`
+	// TimeoutSeconds is how long to wait for the new releases to be available before rolling back, default to 600.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+type PrunePolicyType string
+
+const (
+	// PrunePolicyDelete purges the undefined releases.
+	PrunePolicyDelete PrunePolicyType = "Delete"
+	// PrunePolicyOrphan leaves the undefined releases running.
+	PrunePolicyOrphan PrunePolicyType = "Orphan"
+	// PrunePolicyConfirm only purges the undefined releases which have been listed
+	// in the confirm-prune annotation of the migrate.
+	PrunePolicyConfirm PrunePolicyType = "Confirm"
 )
 
 // ReleasesConfig
@@ -49,6 +149,14 @@ type ReleasesConfig struct {
 	Raw       string            `json:"raw,omitempty"`
 	Values    map[string]string `json:"values,omitempty"`
 	Meta      map[string]string `json:"meta,omitempty"`
+	// TargetCluster is the secret in the namespace of the migrate holding the kubeconfig of the cluster
+	// which the release is deployed to, default to the cluster the operator runs in. Once it has been changed, the
+	// release is only pruned from its previous cluster when its deployments are available in the new one.
+	TargetCluster string `json:"targetCluster,omitempty"`
+	// ClusterSelector selects the registered clusters which the release can be scheduled to, the release stays in
+	// the cluster once it has been scheduled, until the cluster becomes unhealthy or unschedulable and another one
+	// can be picked. It is ignored if TargetCluster has been set.
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
 }
 
 // MigrateStatus
@@ -58,8 +166,82 @@ type MigrateStatus struct {
 	Conditions      []MigrateCondition `json:"conditions,omitempty"`
 	StartTime       *metav1.Time       `json:"startTime,omitempty"`
 	LastUpdateTime  *metav1.Time       `json:"lastUpdateTime,omitempty"`
+	// ReleaseClusters records the registered cluster each release has been scheduled to.
+	ReleaseClusters map[string]string `json:"releaseClusters,omitempty"`
+	// KeptReleases records why each running release which is not defined in the migrate is kept, by release name.
+	// The event of a kept release is only recorded once the reason changes.
+	KeptReleases map[string]string `json:"keptReleases,omitempty"`
+	// ClusterMigration checkpoints the steps of the cluster migration so that it resumes from there.
+	ClusterMigration *ClusterMigrationStatus `json:"clusterMigration,omitempty"`
+	// Relocation checkpoints the steps of the relocation so that it resumes from there.
+	Relocation *RelocationStatus `json:"relocation,omitempty"`
 }
 
+// ClusterMigrationStatus
+type ClusterMigrationStatus struct {
+	Phase   ClusterMigrationPhase `json:"phase,omitempty"`
+	Message string                `json:"message,omitempty"`
+	// SwitchedTime is when the traffic was switched, the source releases are drained since then.
+	SwitchedTime *metav1.Time `json:"switchedTime,omitempty"`
+	// PreviousAddress is the address the traffic pointed to before switching, it is kept for rolling back by hand.
+	// It is the external name or the cluster IP of the service, or the record of the configmap.
+	PreviousAddress string `json:"previousAddress,omitempty"`
+	// PreviousService is the part of the spec of the service which has been changed by switching the traffic,
+	// restoring it rolls the service back.
+	PreviousService    *ServiceSnapshot `json:"previousService,omitempty"`
+	LastTransitionTime *metav1.Time     `json:"lastTransitionTime,omitempty"`
+}
+
+// ServiceSnapshot
+type ServiceSnapshot struct {
+	Type      corev1.ServiceType   `json:"type,omitempty"`
+	ClusterIP string               `json:"clusterIP,omitempty"`
+	Ports     []corev1.ServicePort `json:"ports,omitempty"`
+}
+
+// RelocationStatus
+type RelocationStatus struct {
+	Phase   RelocationPhase `json:"phase,omitempty"`
+	Message string          `json:"message,omitempty"`
+	// Releases maps the names of the releases to their names in the target namespace.
+	Releases map[string]string `json:"releases,omitempty"`
+	// The configmaps and the secrets copied to the target namespace, they are deleted once rolling back.
+	CopiedConfigMaps []string `json:"copiedConfigMaps,omitempty"`
+	CopiedSecrets    []string `json:"copiedSecrets,omitempty"`
+	// InstalledTime is when the new releases were installed, they are rolled back if they are not available
+	// within the timeout since then.
+	InstalledTime      *metav1.Time `json:"installedTime,omitempty"`
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+type RelocationPhase string
+
+const (
+	// RelocationCopied means the referenced configmaps and secrets have been copied to the target namespace.
+	RelocationCopied RelocationPhase = "Copied"
+	// RelocationInstalled means the new releases have been installed in the target namespace.
+	RelocationInstalled RelocationPhase = "Installed"
+	// RelocationAvailable means the deployments of the new releases are available.
+	RelocationAvailable RelocationPhase = "Available"
+	// RelocationCompleted means the old releases have been uninstalled.
+	RelocationCompleted RelocationPhase = "Completed"
+	// RelocationRolledBack means the new releases and the copies have been deleted since they never became available.
+	RelocationRolledBack RelocationPhase = "RolledBack"
+)
+
+type ClusterMigrationPhase string
+
+const (
+	// ClusterMigrationInstalled means the releases have been installed in the destination cluster.
+	ClusterMigrationInstalled ClusterMigrationPhase = "Installed"
+	// ClusterMigrationVerified means the releases in the destination cluster are available and the checks passed.
+	ClusterMigrationVerified ClusterMigrationPhase = "Verified"
+	// ClusterMigrationSwitched means the traffic has been switched to the destination cluster.
+	ClusterMigrationSwitched ClusterMigrationPhase = "Switched"
+	// ClusterMigrationCompleted means the source releases have been uninstalled.
+	ClusterMigrationCompleted ClusterMigrationPhase = "Completed"
+)
+
 type MigrateCondition struct {
 	Type               string      `json:"type"`
 	Status             string      `json:"status"`
@@ -68,3 +250,169 @@ type MigrateCondition struct {
 	Reason             string      `json:"reason,omitempty"`
 	Message            string      `json:"message,omitempty"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Cluster is a registered cluster which the releases can be scheduled to.
+type Cluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ClusterSpec   `json:"spec,omitempty"`
+	Status            ClusterStatus `json:"status,omitempty"`
+}
+
+// ClusterList
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Cluster `json:"items"`
+}
+
+// ClusterSpec
+type ClusterSpec struct {
+	// KubeconfigSecret is the secret in the namespace of the cluster holding the kubeconfig.
+	KubeconfigSecret string `json:"kubeconfigSecret"`
+	// LDC and AZ are exposed as the ldc and az labels of the cluster to the cluster selectors of the releases.
+	LDC string `json:"ldc,omitempty"`
+	AZ  string `json:"az,omitempty"`
+	// TillerNamespace is the namespace of the tiller in the cluster, default to the TillerNamespace of the migrate.
+	TillerNamespace string `json:"tillerNamespace,omitempty"`
+	// Unschedulable stops scheduling new releases to the cluster, the releases in it are still managed.
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// ClusterStatus
+type ClusterStatus struct {
+	// Healthy is true if both the kubernetes API and tiller are reachable, no release is scheduled to an
+	// unhealthy cluster.
+	Healthy bool `json:"healthy"`
+	// Reachable is true if the kubernetes API is reachable.
+	Reachable         bool   `json:"reachable"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	TillerVersion     string `json:"tillerVersion,omitempty"`
+	Message           string `json:"message,omitempty"`
+	// LastProbeTime is the time of the probe which has last changed the status, the status is not written again
+	// as long as the probes find the same.
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// OperatorConfig holds the policies of the operator, they are applied live once changed.
+type OperatorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              OperatorConfigSpec   `json:"spec,omitempty"`
+	Status            OperatorConfigStatus `json:"status,omitempty"`
+}
+
+// OperatorConfigList
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type OperatorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OperatorConfig `json:"items"`
+}
+
+// OperatorConfigSpec
+type OperatorConfigSpec struct {
+	// DefaultPrunePolicy is the prune policy of the migrates which have not specified one, default to Delete.
+	DefaultPrunePolicy PrunePolicyType `json:"defaultPrunePolicy,omitempty"`
+	// DefaultProgressDeadlineSeconds is the progress deadline of the migrates which have not specified one.
+	DefaultProgressDeadlineSeconds *int32 `json:"defaultProgressDeadlineSeconds,omitempty"`
+	// Freeze stops installing, updating and pruning the releases of all migrates until it is lifted.
+	Freeze       bool   `json:"freeze,omitempty"`
+	FreezeReason string `json:"freezeReason,omitempty"`
+	// AllowedChartSources are the glob patterns of the home and the sources in the metadata of the charts which
+	// can be deployed, a chart matching none of them is refused. All charts are allowed if it is empty.
+	AllowedChartSources []string `json:"allowedChartSources,omitempty"`
+}
+
+// OperatorConfigStatus
+type OperatorConfigStatus struct {
+	// ObservedGeneration is the generation of the spec which has been validated last.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Accepted is false if the last spec has been rejected, the previous one stays in effect.
+	Accepted bool   `json:"accepted"`
+	Message  string `json:"message,omitempty"`
+	// InEffect is the spec which is applied by the operator.
+	InEffect       *OperatorConfigSpec `json:"inEffect,omitempty"`
+	LastUpdateTime *metav1.Time        `json:"lastUpdateTime,omitempty"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ReleaseOperation records an install, an upgrade, an uninstall or a rollback of a release by the operator. It is
+// written once the operation has returned and never changed, the old records are garbage-collected by the retention
+// of the operator.
+type ReleaseOperation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ReleaseOperationSpec `json:"spec,omitempty"`
+}
+
+// ReleaseOperationList
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type ReleaseOperationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReleaseOperation `json:"items"`
+}
+
+// ReleaseOperationSpec
+type ReleaseOperationSpec struct {
+	// Migrate is the name of the migrate in the namespace of the record.
+	Migrate    string    `json:"migrate"`
+	MigrateUID types.UID `json:"migrateUID,omitempty"`
+	// Generation is the generation of the migrate whose spec has been applied.
+	Generation int64 `json:"generation"`
+	// ChangedByManager is the field manager which last changed the spec of the migrate, e.g. kubectl, as recorded in
+	// its managedFields. It names the client, not the user, and is empty if the API server does not track the managed
+	// fields.
+	ChangedByManager string `json:"changedByManager,omitempty"`
+
+	App     string `json:"app"`
+	Release string `json:"release"`
+	// ReleaseNamespace is the namespace the release is installed to, it is only known for the installs.
+	ReleaseNamespace string `json:"releaseNamespace,omitempty"`
+	// Cluster is the kubeconfig secret of the cluster of the release, it is empty for the cluster the operator runs
+	// in.
+	Cluster   string                 `json:"cluster,omitempty"`
+	Operation ReleaseOperationType   `json:"operation"`
+	Result    ReleaseOperationResult `json:"result"`
+	// Message is the error of a failed operation.
+	Message string `json:"message,omitempty"`
+
+	ChartName    string `json:"chartName,omitempty"`
+	ChartVersion string `json:"chartVersion,omitempty"`
+	// ValuesDigest is the sha256 digest of the values of the release, it is empty for the uninstalls.
+	ValuesDigest string `json:"valuesDigest,omitempty"`
+	// Revision is the version of the release once the operation has succeeded.
+	Revision int32 `json:"revision,omitempty"`
+
+	StartTime      metav1.Time `json:"startTime"`
+	CompletionTime metav1.Time `json:"completionTime"`
+	// TraceID is the trace of the sync which has run the operation.
+	TraceID string `json:"traceID,omitempty"`
+}
+
+type ReleaseOperationType string
+
+const (
+	ReleaseOperationInstall   ReleaseOperationType = "Install"
+	ReleaseOperationUpgrade   ReleaseOperationType = "Upgrade"
+	ReleaseOperationUninstall ReleaseOperationType = "Uninstall"
+	ReleaseOperationRollback  ReleaseOperationType = "Rollback"
+)
+
+type ReleaseOperationResult string
+
+const (
+	ReleaseOperationSucceeded ReleaseOperationResult = "Succeeded"
+	ReleaseOperationFailed    ReleaseOperationResult = "Failed"
+)
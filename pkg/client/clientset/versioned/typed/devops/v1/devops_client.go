@@ -16,7 +16,10 @@ import (
 
 type DevopsV1Interface interface {
 	RESTClient() rest.Interface
+	ClustersGetter
 	MigratesGetter
+	OperatorConfigsGetter
+	ReleaseOperationsGetter
 }
 
 // DevopsV1Client is used to interact with features provided by the devops.dmall.com group.
@@ -24,10 +27,22 @@ type DevopsV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *DevopsV1Client) Clusters(namespace string) ClusterInterface {
+	return newClusters(c, namespace)
+}
+
 func (c *DevopsV1Client) Migrates(namespace string) MigrateInterface {
 	return newMigrates(c, namespace)
 }
 
+func (c *DevopsV1Client) OperatorConfigs(namespace string) OperatorConfigInterface {
+	return newOperatorConfigs(c, namespace)
+}
+
+func (c *DevopsV1Client) ReleaseOperations(namespace string) ReleaseOperationInterface {
+	return newReleaseOperations(c, namespace)
+}
+
 // NewForConfig creates a new DevopsV1Client for the given config.
 func NewForConfig(c *rest.Config) (*DevopsV1Client, error) {
 	config := *c
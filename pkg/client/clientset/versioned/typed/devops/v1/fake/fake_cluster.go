@@ -0,0 +1,129 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	devopsv1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeClusters implements ClusterInterface
+type FakeClusters struct {
+	Fake *FakeDevopsV1
+	ns   string
+}
+
+var clustersResource = schema.GroupVersionResource{Group: "devops.dmall.com", Version: "v1", Resource: "clusters"}
+
+var clustersKind = schema.GroupVersionKind{Group: "devops.dmall.com", Version: "v1", Kind: "Cluster"}
+
+// Get takes name of the cluster, and returns the corresponding cluster object, and an error if there is any.
+func (c *FakeClusters) Get(name string, options v1.GetOptions) (result *devopsv1.Cluster, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(clustersResource, c.ns, name), &devopsv1.Cluster{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.Cluster), err
+}
+
+// List takes label and field selectors, and returns the list of Clusters that match those selectors.
+func (c *FakeClusters) List(opts v1.ListOptions) (result *devopsv1.ClusterList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(clustersResource, clustersKind, c.ns, opts), &devopsv1.ClusterList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &devopsv1.ClusterList{ListMeta: obj.(*devopsv1.ClusterList).ListMeta}
+	for _, item := range obj.(*devopsv1.ClusterList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested clusters.
+func (c *FakeClusters) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(clustersResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a cluster and creates it.  Returns the server's representation of the cluster, and an error, if there is any.
+func (c *FakeClusters) Create(cluster *devopsv1.Cluster) (result *devopsv1.Cluster, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(clustersResource, c.ns, cluster), &devopsv1.Cluster{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.Cluster), err
+}
+
+// Update takes the representation of a cluster and updates it. Returns the server's representation of the cluster, and an error, if there is any.
+func (c *FakeClusters) Update(cluster *devopsv1.Cluster) (result *devopsv1.Cluster, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(clustersResource, c.ns, cluster), &devopsv1.Cluster{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.Cluster), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeClusters) UpdateStatus(cluster *devopsv1.Cluster) (*devopsv1.Cluster, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(clustersResource, "status", c.ns, cluster), &devopsv1.Cluster{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.Cluster), err
+}
+
+// Delete takes name of the cluster and deletes it. Returns an error if one occurs.
+func (c *FakeClusters) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(clustersResource, c.ns, name), &devopsv1.Cluster{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeClusters) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(clustersResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &devopsv1.ClusterList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched cluster.
+func (c *FakeClusters) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *devopsv1.Cluster, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(clustersResource, c.ns, name, pt, data, subresources...), &devopsv1.Cluster{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.Cluster), err
+}
@@ -17,10 +17,22 @@ type FakeDevopsV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeDevopsV1) Clusters(namespace string) v1.ClusterInterface {
+	return &FakeClusters{c, namespace}
+}
+
 func (c *FakeDevopsV1) Migrates(namespace string) v1.MigrateInterface {
 	return &FakeMigrates{c, namespace}
 }
 
+func (c *FakeDevopsV1) OperatorConfigs(namespace string) v1.OperatorConfigInterface {
+	return &FakeOperatorConfigs{c, namespace}
+}
+
+func (c *FakeDevopsV1) ReleaseOperations(namespace string) v1.ReleaseOperationInterface {
+	return &FakeReleaseOperations{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeDevopsV1) RESTClient() rest.Interface {
@@ -120,7 +120,7 @@ func (c *FakeMigrates) DeleteCollection(options *v1.DeleteOptions, listOptions v
 // Patch applies the patch and returns the patched migrate.
 func (c *FakeMigrates) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *devopsv1.Migrate, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewPatchSubresourceAction(migratesResource, c.ns, name, data, subresources...), &devopsv1.Migrate{})
+		Invokes(testing.NewPatchSubresourceAction(migratesResource, c.ns, name, pt, data, subresources...), &devopsv1.Migrate{})
 
 	if obj == nil {
 		return nil, err
@@ -0,0 +1,129 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	devopsv1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeOperatorConfigs implements OperatorConfigInterface
+type FakeOperatorConfigs struct {
+	Fake *FakeDevopsV1
+	ns   string
+}
+
+var operatorconfigsResource = schema.GroupVersionResource{Group: "devops.dmall.com", Version: "v1", Resource: "operatorconfigs"}
+
+var operatorconfigsKind = schema.GroupVersionKind{Group: "devops.dmall.com", Version: "v1", Kind: "OperatorConfig"}
+
+// Get takes name of the operatorConfig, and returns the corresponding operatorConfig object, and an error if there is any.
+func (c *FakeOperatorConfigs) Get(name string, options v1.GetOptions) (result *devopsv1.OperatorConfig, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(operatorconfigsResource, c.ns, name), &devopsv1.OperatorConfig{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.OperatorConfig), err
+}
+
+// List takes label and field selectors, and returns the list of OperatorConfigs that match those selectors.
+func (c *FakeOperatorConfigs) List(opts v1.ListOptions) (result *devopsv1.OperatorConfigList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(operatorconfigsResource, operatorconfigsKind, c.ns, opts), &devopsv1.OperatorConfigList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &devopsv1.OperatorConfigList{ListMeta: obj.(*devopsv1.OperatorConfigList).ListMeta}
+	for _, item := range obj.(*devopsv1.OperatorConfigList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested operatorConfigs.
+func (c *FakeOperatorConfigs) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(operatorconfigsResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a operatorConfig and creates it.  Returns the server's representation of the operatorConfig, and an error, if there is any.
+func (c *FakeOperatorConfigs) Create(operatorConfig *devopsv1.OperatorConfig) (result *devopsv1.OperatorConfig, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(operatorconfigsResource, c.ns, operatorConfig), &devopsv1.OperatorConfig{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.OperatorConfig), err
+}
+
+// Update takes the representation of a operatorConfig and updates it. Returns the server's representation of the operatorConfig, and an error, if there is any.
+func (c *FakeOperatorConfigs) Update(operatorConfig *devopsv1.OperatorConfig) (result *devopsv1.OperatorConfig, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(operatorconfigsResource, c.ns, operatorConfig), &devopsv1.OperatorConfig{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.OperatorConfig), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeOperatorConfigs) UpdateStatus(operatorConfig *devopsv1.OperatorConfig) (*devopsv1.OperatorConfig, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(operatorconfigsResource, "status", c.ns, operatorConfig), &devopsv1.OperatorConfig{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.OperatorConfig), err
+}
+
+// Delete takes name of the operatorConfig and deletes it. Returns an error if one occurs.
+func (c *FakeOperatorConfigs) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(operatorconfigsResource, c.ns, name), &devopsv1.OperatorConfig{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeOperatorConfigs) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(operatorconfigsResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &devopsv1.OperatorConfigList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched operatorConfig.
+func (c *FakeOperatorConfigs) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *devopsv1.OperatorConfig, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(operatorconfigsResource, c.ns, name, pt, data, subresources...), &devopsv1.OperatorConfig{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.OperatorConfig), err
+}
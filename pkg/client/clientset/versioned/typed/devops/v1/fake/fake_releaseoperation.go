@@ -0,0 +1,117 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	devopsv1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeReleaseOperations implements ReleaseOperationInterface
+type FakeReleaseOperations struct {
+	Fake *FakeDevopsV1
+	ns   string
+}
+
+var releaseoperationsResource = schema.GroupVersionResource{Group: "devops.dmall.com", Version: "v1", Resource: "releaseoperations"}
+
+var releaseoperationsKind = schema.GroupVersionKind{Group: "devops.dmall.com", Version: "v1", Kind: "ReleaseOperation"}
+
+// Get takes name of the releaseOperation, and returns the corresponding releaseOperation object, and an error if there is any.
+func (c *FakeReleaseOperations) Get(name string, options v1.GetOptions) (result *devopsv1.ReleaseOperation, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(releaseoperationsResource, c.ns, name), &devopsv1.ReleaseOperation{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.ReleaseOperation), err
+}
+
+// List takes label and field selectors, and returns the list of ReleaseOperations that match those selectors.
+func (c *FakeReleaseOperations) List(opts v1.ListOptions) (result *devopsv1.ReleaseOperationList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(releaseoperationsResource, releaseoperationsKind, c.ns, opts), &devopsv1.ReleaseOperationList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &devopsv1.ReleaseOperationList{ListMeta: obj.(*devopsv1.ReleaseOperationList).ListMeta}
+	for _, item := range obj.(*devopsv1.ReleaseOperationList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested releaseOperations.
+func (c *FakeReleaseOperations) Watch(opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(releaseoperationsResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a releaseOperation and creates it.  Returns the server's representation of the releaseOperation, and an error, if there is any.
+func (c *FakeReleaseOperations) Create(releaseOperation *devopsv1.ReleaseOperation) (result *devopsv1.ReleaseOperation, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(releaseoperationsResource, c.ns, releaseOperation), &devopsv1.ReleaseOperation{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.ReleaseOperation), err
+}
+
+// Update takes the representation of a releaseOperation and updates it. Returns the server's representation of the releaseOperation, and an error, if there is any.
+func (c *FakeReleaseOperations) Update(releaseOperation *devopsv1.ReleaseOperation) (result *devopsv1.ReleaseOperation, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(releaseoperationsResource, c.ns, releaseOperation), &devopsv1.ReleaseOperation{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.ReleaseOperation), err
+}
+
+// Delete takes name of the releaseOperation and deletes it. Returns an error if one occurs.
+func (c *FakeReleaseOperations) Delete(name string, options *v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(releaseoperationsResource, c.ns, name), &devopsv1.ReleaseOperation{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeReleaseOperations) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(releaseoperationsResource, c.ns, listOptions)
+
+	_, err := c.Fake.Invokes(action, &devopsv1.ReleaseOperationList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched releaseOperation.
+func (c *FakeReleaseOperations) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *devopsv1.ReleaseOperation, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(releaseoperationsResource, c.ns, name, pt, data, subresources...), &devopsv1.ReleaseOperation{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*devopsv1.ReleaseOperation), err
+}
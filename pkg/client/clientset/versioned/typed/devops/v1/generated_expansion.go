@@ -7,4 +7,10 @@ Copyright The Symphony Authors.
 
 package v1
 
+type ClusterExpansion interface{}
+
 type MigrateExpansion interface{}
+
+type OperatorConfigExpansion interface{}
+
+type ReleaseOperationExpansion interface{}
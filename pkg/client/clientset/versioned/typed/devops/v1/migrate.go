@@ -8,6 +8,8 @@ Copyright The Symphony Authors.
 package v1
 
 import (
+	"time"
+
 	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
 	scheme "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/scheme"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -65,11 +67,16 @@ func (c *migrates) Get(name string, options metav1.GetOptions) (result *v1.Migra
 
 // List takes label and field selectors, and returns the list of Migrates that match those selectors.
 func (c *migrates) List(opts metav1.ListOptions) (result *v1.MigrateList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
 	result = &v1.MigrateList{}
 	err = c.client.Get().
 		Namespace(c.ns).
 		Resource("migrates").
 		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
 		Do().
 		Into(result)
 	return
@@ -77,11 +84,16 @@ func (c *migrates) List(opts metav1.ListOptions) (result *v1.MigrateList, err er
 
 // Watch returns a watch.Interface that watches the requested migrates.
 func (c *migrates) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
 	opts.Watch = true
 	return c.client.Get().
 		Namespace(c.ns).
 		Resource("migrates").
 		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
 		Watch()
 }
 
@@ -139,10 +151,15 @@ func (c *migrates) Delete(name string, options *metav1.DeleteOptions) error {
 
 // DeleteCollection deletes a collection of objects.
 func (c *migrates) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("migrates").
 		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
 		Body(options).
 		Do().
 		Error()
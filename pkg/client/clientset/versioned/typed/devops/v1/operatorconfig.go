@@ -0,0 +1,180 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"time"
+
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	scheme "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// OperatorConfigsGetter has a method to return a OperatorConfigInterface.
+// A group's client should implement this interface.
+type OperatorConfigsGetter interface {
+	OperatorConfigs(namespace string) OperatorConfigInterface
+}
+
+// OperatorConfigInterface has methods to work with OperatorConfig resources.
+type OperatorConfigInterface interface {
+	Create(*v1.OperatorConfig) (*v1.OperatorConfig, error)
+	Update(*v1.OperatorConfig) (*v1.OperatorConfig, error)
+	UpdateStatus(*v1.OperatorConfig) (*v1.OperatorConfig, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.OperatorConfig, error)
+	List(opts metav1.ListOptions) (*v1.OperatorConfigList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.OperatorConfig, err error)
+	OperatorConfigExpansion
+}
+
+// operatorConfigs implements OperatorConfigInterface
+type operatorConfigs struct {
+	client rest.Interface
+	ns     string
+}
+
+// newOperatorConfigs returns a OperatorConfigs
+func newOperatorConfigs(c *DevopsV1Client, namespace string) *operatorConfigs {
+	return &operatorConfigs{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the operatorConfig, and returns the corresponding operatorConfig object, and an error if there is any.
+func (c *operatorConfigs) Get(name string, options metav1.GetOptions) (result *v1.OperatorConfig, err error) {
+	result = &v1.OperatorConfig{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of OperatorConfigs that match those selectors.
+func (c *operatorConfigs) List(opts metav1.ListOptions) (result *v1.OperatorConfigList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.OperatorConfigList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested operatorConfigs.
+func (c *operatorConfigs) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch()
+}
+
+// Create takes the representation of a operatorConfig and creates it.  Returns the server's representation of the operatorConfig, and an error, if there is any.
+func (c *operatorConfigs) Create(operatorConfig *v1.OperatorConfig) (result *v1.OperatorConfig, err error) {
+	result = &v1.OperatorConfig{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		Body(operatorConfig).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a operatorConfig and updates it. Returns the server's representation of the operatorConfig, and an error, if there is any.
+func (c *operatorConfigs) Update(operatorConfig *v1.OperatorConfig) (result *v1.OperatorConfig, err error) {
+	result = &v1.OperatorConfig{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		Name(operatorConfig.Name).
+		Body(operatorConfig).
+		Do().
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *operatorConfigs) UpdateStatus(operatorConfig *v1.OperatorConfig) (result *v1.OperatorConfig, err error) {
+	result = &v1.OperatorConfig{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		Name(operatorConfig.Name).
+		SubResource("status").
+		Body(operatorConfig).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the operatorConfig and deletes it. Returns an error if one occurs.
+func (c *operatorConfigs) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *operatorConfigs) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched operatorConfig.
+func (c *operatorConfigs) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.OperatorConfig, err error) {
+	result = &v1.OperatorConfig{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("operatorconfigs").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
@@ -0,0 +1,163 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	"time"
+
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	scheme "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// ReleaseOperationsGetter has a method to return a ReleaseOperationInterface.
+// A group's client should implement this interface.
+type ReleaseOperationsGetter interface {
+	ReleaseOperations(namespace string) ReleaseOperationInterface
+}
+
+// ReleaseOperationInterface has methods to work with ReleaseOperation resources.
+type ReleaseOperationInterface interface {
+	Create(*v1.ReleaseOperation) (*v1.ReleaseOperation, error)
+	Update(*v1.ReleaseOperation) (*v1.ReleaseOperation, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error
+	Get(name string, options metav1.GetOptions) (*v1.ReleaseOperation, error)
+	List(opts metav1.ListOptions) (*v1.ReleaseOperationList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.ReleaseOperation, err error)
+	ReleaseOperationExpansion
+}
+
+// releaseOperations implements ReleaseOperationInterface
+type releaseOperations struct {
+	client rest.Interface
+	ns     string
+}
+
+// newReleaseOperations returns a ReleaseOperations
+func newReleaseOperations(c *DevopsV1Client, namespace string) *releaseOperations {
+	return &releaseOperations{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the releaseOperation, and returns the corresponding releaseOperation object, and an error if there is any.
+func (c *releaseOperations) Get(name string, options metav1.GetOptions) (result *v1.ReleaseOperation, err error) {
+	result = &v1.ReleaseOperation{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do().
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of ReleaseOperations that match those selectors.
+func (c *releaseOperations) List(opts metav1.ListOptions) (result *v1.ReleaseOperationList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1.ReleaseOperationList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do().
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested releaseOperations.
+func (c *releaseOperations) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch()
+}
+
+// Create takes the representation of a releaseOperation and creates it.  Returns the server's representation of the releaseOperation, and an error, if there is any.
+func (c *releaseOperations) Create(releaseOperation *v1.ReleaseOperation) (result *v1.ReleaseOperation, err error) {
+	result = &v1.ReleaseOperation{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		Body(releaseOperation).
+		Do().
+		Into(result)
+	return
+}
+
+// Update takes the representation of a releaseOperation and updates it. Returns the server's representation of the releaseOperation, and an error, if there is any.
+func (c *releaseOperations) Update(releaseOperation *v1.ReleaseOperation) (result *v1.ReleaseOperation, err error) {
+	result = &v1.ReleaseOperation{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		Name(releaseOperation.Name).
+		Body(releaseOperation).
+		Do().
+		Into(result)
+	return
+}
+
+// Delete takes name of the releaseOperation and deletes it. Returns an error if one occurs.
+func (c *releaseOperations) Delete(name string, options *metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		Name(name).
+		Body(options).
+		Do().
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *releaseOperations) DeleteCollection(options *metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do().
+		Error()
+}
+
+// Patch applies the patch and returns the patched releaseOperation.
+func (c *releaseOperations) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1.ReleaseOperation, err error) {
+	result = &v1.ReleaseOperation{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("releaseoperations").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do().
+		Into(result)
+	return
+}
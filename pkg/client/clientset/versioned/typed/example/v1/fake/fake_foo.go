@@ -120,7 +120,7 @@ func (c *FakeFoos) DeleteCollection(options *v1.DeleteOptions, listOptions v1.Li
 // Patch applies the patch and returns the patched foo.
 func (c *FakeFoos) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *examplev1.Foo, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewPatchSubresourceAction(foosResource, c.ns, name, data, subresources...), &examplev1.Foo{})
+		Invokes(testing.NewPatchSubresourceAction(foosResource, c.ns, name, pt, data, subresources...), &examplev1.Foo{})
 
 	if obj == nil {
 		return nil, err
@@ -13,8 +13,14 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// Clusters returns a ClusterInformer.
+	Clusters() ClusterInformer
 	// Migrates returns a MigrateInformer.
 	Migrates() MigrateInformer
+	// OperatorConfigs returns a OperatorConfigInformer.
+	OperatorConfigs() OperatorConfigInformer
+	// ReleaseOperations returns a ReleaseOperationInformer.
+	ReleaseOperations() ReleaseOperationInformer
 }
 
 type version struct {
@@ -28,7 +34,22 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// Clusters returns a ClusterInformer.
+func (v *version) Clusters() ClusterInformer {
+	return &clusterInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // Migrates returns a MigrateInformer.
 func (v *version) Migrates() MigrateInformer {
 	return &migrateInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// OperatorConfigs returns a OperatorConfigInformer.
+func (v *version) OperatorConfigs() OperatorConfigInformer {
+	return &operatorConfigInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
+// ReleaseOperations returns a ReleaseOperationInformer.
+func (v *version) ReleaseOperations() ReleaseOperationInformer {
+	return &releaseOperationInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	time "time"
+
+	devopsv1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	versioned "github.com/yangyongzhi/sym-operator/pkg/client/clientset/versioned"
+	internalinterfaces "github.com/yangyongzhi/sym-operator/pkg/client/informers/externalversions/internalinterfaces"
+	v1 "github.com/yangyongzhi/sym-operator/pkg/client/listers/devops/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// ReleaseOperationInformer provides access to a shared informer and lister for
+// ReleaseOperations.
+type ReleaseOperationInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1.ReleaseOperationLister
+}
+
+type releaseOperationInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewReleaseOperationInformer constructs a new informer for ReleaseOperation type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewReleaseOperationInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredReleaseOperationInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredReleaseOperationInformer constructs a new informer for ReleaseOperation type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredReleaseOperationInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.DevopsV1().ReleaseOperations(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.DevopsV1().ReleaseOperations(namespace).Watch(options)
+			},
+		},
+		&devopsv1.ReleaseOperation{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *releaseOperationInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredReleaseOperationInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *releaseOperationInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&devopsv1.ReleaseOperation{}, f.defaultInformer)
+}
+
+func (f *releaseOperationInformer) Lister() v1.ReleaseOperationLister {
+	return v1.NewReleaseOperationLister(f.Informer().GetIndexer())
+}
@@ -43,8 +43,14 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=devops.dmall.com, Version=v1
+	case v1.SchemeGroupVersion.WithResource("clusters"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Devops().V1().Clusters().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("migrates"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Devops().V1().Migrates().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("operatorconfigs"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Devops().V1().OperatorConfigs().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("releaseoperations"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Devops().V1().ReleaseOperations().Informer()}, nil
 
 		// Group=example.dmall.com, Version=v1
 	case examplev1.SchemeGroupVersion.WithResource("foos"):
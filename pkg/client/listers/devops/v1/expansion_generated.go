@@ -7,6 +7,14 @@ Copyright The Symphony Authors.
 
 package v1
 
+// ClusterListerExpansion allows custom methods to be added to
+// ClusterLister.
+type ClusterListerExpansion interface{}
+
+// ClusterNamespaceListerExpansion allows custom methods to be added to
+// ClusterNamespaceLister.
+type ClusterNamespaceListerExpansion interface{}
+
 // MigrateListerExpansion allows custom methods to be added to
 // MigrateLister.
 type MigrateListerExpansion interface{}
@@ -14,3 +22,19 @@ type MigrateListerExpansion interface{}
 // MigrateNamespaceListerExpansion allows custom methods to be added to
 // MigrateNamespaceLister.
 type MigrateNamespaceListerExpansion interface{}
+
+// OperatorConfigListerExpansion allows custom methods to be added to
+// OperatorConfigLister.
+type OperatorConfigListerExpansion interface{}
+
+// OperatorConfigNamespaceListerExpansion allows custom methods to be added to
+// OperatorConfigNamespaceLister.
+type OperatorConfigNamespaceListerExpansion interface{}
+
+// ReleaseOperationListerExpansion allows custom methods to be added to
+// ReleaseOperationLister.
+type ReleaseOperationListerExpansion interface{}
+
+// ReleaseOperationNamespaceListerExpansion allows custom methods to be added to
+// ReleaseOperationNamespaceLister.
+type ReleaseOperationNamespaceListerExpansion interface{}
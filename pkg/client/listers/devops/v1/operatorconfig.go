@@ -0,0 +1,83 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// OperatorConfigLister helps list OperatorConfigs.
+type OperatorConfigLister interface {
+	// List lists all OperatorConfigs in the indexer.
+	List(selector labels.Selector) (ret []*v1.OperatorConfig, err error)
+	// OperatorConfigs returns an object that can list and get OperatorConfigs.
+	OperatorConfigs(namespace string) OperatorConfigNamespaceLister
+	OperatorConfigListerExpansion
+}
+
+// operatorConfigLister implements the OperatorConfigLister interface.
+type operatorConfigLister struct {
+	indexer cache.Indexer
+}
+
+// NewOperatorConfigLister returns a new OperatorConfigLister.
+func NewOperatorConfigLister(indexer cache.Indexer) OperatorConfigLister {
+	return &operatorConfigLister{indexer: indexer}
+}
+
+// List lists all OperatorConfigs in the indexer.
+func (s *operatorConfigLister) List(selector labels.Selector) (ret []*v1.OperatorConfig, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.OperatorConfig))
+	})
+	return ret, err
+}
+
+// OperatorConfigs returns an object that can list and get OperatorConfigs.
+func (s *operatorConfigLister) OperatorConfigs(namespace string) OperatorConfigNamespaceLister {
+	return operatorConfigNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// OperatorConfigNamespaceLister helps list and get OperatorConfigs.
+type OperatorConfigNamespaceLister interface {
+	// List lists all OperatorConfigs in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1.OperatorConfig, err error)
+	// Get retrieves the OperatorConfig from the indexer for a given namespace and name.
+	Get(name string) (*v1.OperatorConfig, error)
+	OperatorConfigNamespaceListerExpansion
+}
+
+// operatorConfigNamespaceLister implements the OperatorConfigNamespaceLister
+// interface.
+type operatorConfigNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all OperatorConfigs in the indexer for a given namespace.
+func (s operatorConfigNamespaceLister) List(selector labels.Selector) (ret []*v1.OperatorConfig, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.OperatorConfig))
+	})
+	return ret, err
+}
+
+// Get retrieves the OperatorConfig from the indexer for a given namespace and name.
+func (s operatorConfigNamespaceLister) Get(name string) (*v1.OperatorConfig, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("operatorconfig"), name)
+	}
+	return obj.(*v1.OperatorConfig), nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright The Symphony Authors.
+
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ReleaseOperationLister helps list ReleaseOperations.
+type ReleaseOperationLister interface {
+	// List lists all ReleaseOperations in the indexer.
+	List(selector labels.Selector) (ret []*v1.ReleaseOperation, err error)
+	// ReleaseOperations returns an object that can list and get ReleaseOperations.
+	ReleaseOperations(namespace string) ReleaseOperationNamespaceLister
+	ReleaseOperationListerExpansion
+}
+
+// releaseOperationLister implements the ReleaseOperationLister interface.
+type releaseOperationLister struct {
+	indexer cache.Indexer
+}
+
+// NewReleaseOperationLister returns a new ReleaseOperationLister.
+func NewReleaseOperationLister(indexer cache.Indexer) ReleaseOperationLister {
+	return &releaseOperationLister{indexer: indexer}
+}
+
+// List lists all ReleaseOperations in the indexer.
+func (s *releaseOperationLister) List(selector labels.Selector) (ret []*v1.ReleaseOperation, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.ReleaseOperation))
+	})
+	return ret, err
+}
+
+// ReleaseOperations returns an object that can list and get ReleaseOperations.
+func (s *releaseOperationLister) ReleaseOperations(namespace string) ReleaseOperationNamespaceLister {
+	return releaseOperationNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// ReleaseOperationNamespaceLister helps list and get ReleaseOperations.
+type ReleaseOperationNamespaceLister interface {
+	// List lists all ReleaseOperations in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1.ReleaseOperation, err error)
+	// Get retrieves the ReleaseOperation from the indexer for a given namespace and name.
+	Get(name string) (*v1.ReleaseOperation, error)
+	ReleaseOperationNamespaceListerExpansion
+}
+
+// releaseOperationNamespaceLister implements the ReleaseOperationNamespaceLister
+// interface.
+type releaseOperationNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all ReleaseOperations in the indexer for a given namespace.
+func (s releaseOperationNamespaceLister) List(selector labels.Selector) (ret []*v1.ReleaseOperation, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1.ReleaseOperation))
+	})
+	return ret, err
+}
+
+// Get retrieves the ReleaseOperation from the indexer for a given namespace and name.
+func (s releaseOperationNamespaceLister) Get(name string) (*v1.ReleaseOperation, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1.Resource("releaseoperation"), name)
+	}
+	return obj.(*v1.ReleaseOperation), nil
+}
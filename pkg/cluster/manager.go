@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/k8sclient"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+var logger = log.WithComponent("cluster")
+
+// KubeconfigKey is the key of the kubeconfig in the secret of a target cluster.
+const KubeconfigKey = "kubeconfig"
+
+// secretsSyncTimeout bounds the wait for the secrets of a namespace to be synced, such as when they can not be listed.
+const secretsSyncTimeout = 10 * time.Second
+
+// Cluster holds the clients of a cluster which the releases are deployed to.
+type Cluster struct {
+	// Name is the name of the secret holding the kubeconfig of the cluster.
+	Name        string
+	KubeClient  kubernetes.Interface
+	HelmClients *helm.ClientPool
+
+	// checksum of the kubeconfig, the clients are rebuilt once it changes.
+	checksum string
+	stopCh   chan struct{}
+}
+
+// Provider returns the clients of the target clusters.
+type Provider interface {
+	// Get returns the cluster whose kubeconfig is held by the secret in the namespace.
+	Get(namespace, secretName string) (*Cluster, error)
+}
+
+// HelmClientsFactory creates the helm clients of a cluster, they are closed once the stop channel is closed.
+type HelmClientsFactory func(cfg *rest.Config, kubeClient kubernetes.Interface, stopCh <-chan struct{}) (*helm.ClientPool, error)
+
+// Manager caches the clients of the target clusters. The kubeconfig secrets are read from the cluster the operator
+// runs in through an informer of each namespace they are asked for, which is started at the first time.
+type Manager struct {
+	kubeClient     kubernetes.Interface
+	newHelmClients HelmClientsFactory
+	// stopCh stops the informers of the secrets.
+	stopCh chan struct{}
+	// syncTimeout is how long a Get waits for the secrets of the namespace to be synced.
+	syncTimeout time.Duration
+
+	mu       sync.Mutex
+	secrets  map[string]cache.SharedIndexInformer
+	clusters map[string]*Cluster
+	// building holds a channel for each cluster whose clients are being built, it is closed once they are done.
+	building map[string]chan struct{}
+}
+
+// NewManager creates a manager which reads the kubeconfig secrets with the kube client.
+func NewManager(kubeClient kubernetes.Interface, newHelmClients HelmClientsFactory) *Manager {
+	return &Manager{
+		kubeClient:     kubeClient,
+		newHelmClients: newHelmClients,
+		stopCh:         make(chan struct{}),
+		syncTimeout:    secretsSyncTimeout,
+		secrets:        map[string]cache.SharedIndexInformer{},
+		clusters:       map[string]*Cluster{},
+		building:       map[string]chan struct{}{},
+	}
+}
+
+// Get returns the clients of the cluster, they are created at the first time and rebuilt once the kubeconfig changes.
+// The clients are built without holding the lock so a slow cluster does not block the others, the concurrent
+// callers for the same cluster wait for them.
+func (m *Manager) Get(namespace, secretName string) (*Cluster, error) {
+	secret, err := m.getSecret(namespace, secretName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get kubeconfig secret %s/%s", namespace, secretName)
+	}
+	kubeconfig := secret.Data[KubeconfigKey]
+	if len(kubeconfig) == 0 {
+		return nil, errors.Errorf("secret %s/%s has no %s", namespace, secretName, KubeconfigKey)
+	}
+	sum := sha256.Sum256(kubeconfig)
+	checksum := hex.EncodeToString(sum[:])
+
+	key := namespace + "/" + secretName
+	m.mu.Lock()
+	for {
+		if cluster, ok := m.clusters[key]; ok && cluster.checksum == checksum {
+			m.mu.Unlock()
+			return cluster, nil
+		}
+		building, ok := m.building[key]
+		if !ok {
+			break
+		}
+		m.mu.Unlock()
+		<-building
+		m.mu.Lock()
+	}
+	if _, ok := m.clusters[key]; ok {
+		logger.Infof("The kubeconfig of cluster [%s] has been changed, rebuild its clients.", key)
+	}
+	done := make(chan struct{})
+	m.building[key] = done
+	m.mu.Unlock()
+
+	cluster, err := m.newCluster(key, secretName, kubeconfig, checksum)
+
+	m.mu.Lock()
+	delete(m.building, key)
+	close(done)
+	var stale *Cluster
+	if err == nil {
+		select {
+		case <-m.stopCh:
+			stale, cluster, err = cluster, nil, errors.Errorf("the clients of cluster %s have been stopped", key)
+		default:
+			stale = m.clusters[key]
+			m.clusters[key] = cluster
+		}
+	}
+	m.mu.Unlock()
+
+	if stale != nil {
+		close(stale.stopCh)
+	}
+	return cluster, err
+}
+
+// getSecret reads the secret from the informer of its namespace, the informer is started and synced at the first time.
+func (m *Manager) getSecret(namespace, name string) (*corev1.Secret, error) {
+	m.mu.Lock()
+	informer, ok := m.secrets[namespace]
+	if !ok {
+		informer = coreinformers.NewSecretInformer(m.kubeClient, namespace, 0,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		m.secrets[namespace] = informer
+		go informer.Run(m.stopCh)
+	}
+	m.mu.Unlock()
+
+	// The informer never syncs if the secrets can not be listed, the key is requeued instead of blocking the worker.
+	timeoutCh := make(chan struct{})
+	timer := time.AfterFunc(m.syncTimeout, func() { close(timeoutCh) })
+	synced := cache.WaitForCacheSync(timeoutCh, informer.HasSynced)
+	timer.Stop()
+	if !synced {
+		return nil, errors.Errorf("the secrets of namespace %s have not been synced in %s", namespace, m.syncTimeout)
+	}
+	return corelisters.NewSecretLister(informer.GetIndexer()).Secrets(namespace).Get(name)
+}
+
+func (m *Manager) newCluster(key, secretName string, kubeconfig []byte, checksum string) (*Cluster, error) {
+	cfg, err := k8sclient.NewClientConfig(kubeconfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load kubeconfig of cluster %s", key)
+	}
+	kubeClient, err := k8sclient.NewClientFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	stopCh := make(chan struct{})
+	helmClients, err := m.newHelmClients(cfg, kubeClient, stopCh)
+	if err != nil {
+		close(stopCh)
+		return nil, errors.Wrapf(err, "create helm clients of cluster %s", key)
+	}
+
+	logger.Infof("Created the clients of cluster [%s], host : %s", key, cfg.Host)
+	return &Cluster{
+		Name:        secretName,
+		KubeClient:  kubeClient,
+		HelmClients: helmClients,
+		checksum:    checksum,
+		stopCh:      stopCh,
+	}, nil
+}
+
+// Stop closes the clients of all clusters and stops watching the secrets.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+	}
+	for key, cluster := range m.clusters {
+		close(cluster.stopCh)
+		delete(m.clusters, key)
+	}
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	core "k8s.io/client-go/testing"
+)
+
+func newKubeconfig(server string) []byte {
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: target
+  cluster:
+    server: %s
+contexts:
+- name: target
+  context:
+    cluster: target
+    user: target
+current-context: target
+users:
+- name: target
+  user:
+    token: secret-token
+`, server))
+}
+
+func newKubeconfigSecret(kubeconfig []byte) *corev1.Secret {
+	return newNamedKubeconfigSecret("prod", kubeconfig)
+}
+
+func newNamedKubeconfigSecret(name string, kubeconfig []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault},
+		Data:       map[string][]byte{KubeconfigKey: kubeconfig},
+	}
+}
+
+func TestManagerGet(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(newKubeconfigSecret(newKubeconfig("https://prod.example.com")))
+	var hosts []string
+	var stopChs []<-chan struct{}
+	m := NewManager(kubeClient, func(cfg *rest.Config, _ kubernetes.Interface, stopCh <-chan struct{}) (*helm.ClientPool, error) {
+		hosts = append(hosts, cfg.Host)
+		stopChs = append(stopChs, stopCh)
+		return helm.NewSharedClientPool(helmtest.NewFakeBackend()), nil
+	})
+
+	first, err := m.Get(metav1.NamespaceDefault, "prod")
+	if err != nil {
+		t.Fatalf("get cluster: %v", err)
+	}
+	second, err := m.Get(metav1.NamespaceDefault, "prod")
+	if err != nil {
+		t.Fatalf("get cluster: %v", err)
+	}
+	if first != second || len(hosts) != 1 {
+		t.Errorf("expected the clients to be cached, created %d times", len(hosts))
+	}
+
+	// Rotate the kubeconfig.
+	if _, err := kubeClient.CoreV1().Secrets(metav1.NamespaceDefault).Update(
+		newKubeconfigSecret(newKubeconfig("https://prod-2.example.com"))); err != nil {
+		t.Fatalf("update secret: %v", err)
+	}
+	// The new kubeconfig is picked up once the informer has received it.
+	var third *Cluster
+	if err := wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		third, err = m.Get(metav1.NamespaceDefault, "prod")
+		return third != first, err
+	}); err != nil {
+		t.Fatalf("expected the clients to be rebuilt with the new kubeconfig: %v", err)
+	}
+	if len(hosts) != 2 || hosts[1] != "https://prod-2.example.com" {
+		t.Errorf("expected the clients to be rebuilt with the new kubeconfig, hosts: %v", hosts)
+	}
+	for _, action := range kubeClient.Actions() {
+		if action.GetVerb() == "get" {
+			t.Errorf("expected the secrets to be read from the informer, got %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+	select {
+	case <-stopChs[0]:
+	default:
+		t.Errorf("expected the previous clients to be stopped")
+	}
+
+	m.Stop()
+	select {
+	case <-stopChs[1]:
+	default:
+		t.Errorf("expected the clients to be stopped")
+	}
+}
+
+func TestManagerGetInvalidSecret(t *testing.T) {
+	empty := newKubeconfigSecret(nil)
+	empty.Name = "empty"
+	invalid := newKubeconfigSecret([]byte("not a kubeconfig"))
+	invalid.Name = "invalid"
+	m := NewManager(fake.NewSimpleClientset(empty, invalid),
+		func(*rest.Config, kubernetes.Interface, <-chan struct{}) (*helm.ClientPool, error) {
+			t.Errorf("expected no clients to be created")
+			return nil, nil
+		})
+
+	for _, name := range []string{"missing", "empty", "invalid"} {
+		if _, err := m.Get(metav1.NamespaceDefault, name); err == nil {
+			t.Errorf("expected an error for secret %s", name)
+		}
+	}
+}
+
+func TestManagerDoesNotBlockOnSlowCluster(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		newNamedKubeconfigSecret("slow", newKubeconfig("https://slow.example.com")),
+		newNamedKubeconfigSecret("prod", newKubeconfig("https://prod.example.com")))
+	block := make(chan struct{})
+	m := NewManager(kubeClient, func(cfg *rest.Config, _ kubernetes.Interface, stopCh <-chan struct{}) (*helm.ClientPool, error) {
+		if cfg.Host == "https://slow.example.com" {
+			<-block
+		}
+		return helm.NewSharedClientPool(helmtest.NewFakeBackend()), nil
+	})
+	defer m.Stop()
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := m.Get(metav1.NamespaceDefault, "slow")
+		slow <- err
+	}()
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := m.Get(metav1.NamespaceDefault, "prod")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("get cluster: %v", err)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("expected the clients of another cluster not to wait for the slow one")
+	}
+
+	close(block)
+	if err := <-slow; err != nil {
+		t.Errorf("get slow cluster: %v", err)
+	}
+}
+
+func TestManagerGetUnsyncedSecrets(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("list", "secrets", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("secrets is forbidden")
+	})
+	m := NewManager(kubeClient, func(*rest.Config, kubernetes.Interface, <-chan struct{}) (*helm.ClientPool, error) {
+		t.Errorf("expected no clients to be created")
+		return nil, nil
+	})
+	m.syncTimeout = 100 * time.Millisecond
+	defer m.Stop()
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := m.Get(metav1.NamespaceDefault, "prod")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err == nil {
+			t.Errorf("expected an error if the secrets have not been synced")
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("expected the get not to wait for the secrets forever")
+	}
+}
@@ -0,0 +1,410 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the version of the configuration file.
+	APIVersion = "sym-operator.dmall.com/v1alpha1"
+	// Kind is the kind of the configuration file.
+	Kind = "OperatorConfiguration"
+
+	// EnvPrefix prefixes the environment variables of the flags, e.g. SYM_OPERATOR_WORKERS for -workers.
+	EnvPrefix = "SYM_OPERATOR_"
+)
+
+// OperatorConfiguration holds the runtime tunables of the operator.
+type OperatorConfiguration struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// Workers is the number of the workers syncing the migrates concurrently.
+	Workers int `json:"workers"`
+	// ResyncPeriod is the resync period of the informers.
+	ResyncPeriod metav1.Duration `json:"resyncPeriod"`
+	// RateLimiter is the rate limiter of the work queue.
+	RateLimiter RateLimiterConfiguration `json:"rateLimiter"`
+
+	// MonitorAddress is the listen address of the probes and the metrics.
+	MonitorAddress string `json:"monitorAddress"`
+	// TraceAddress is the listen address of the tracing and the debug endpoints.
+	TraceAddress string `json:"traceAddress"`
+	// OperatorConfig is the namespace/name of the OperatorConfig resource whose policies are applied live, none is
+	// watched if it is empty.
+	OperatorConfig string `json:"operatorConfig,omitempty"`
+	// ClusterRegistry watches the Cluster resources to schedule the releases by their cluster selectors and probes
+	// the health of the clusters, the Cluster CRD should be installed then.
+	ClusterRegistry bool `json:"clusterRegistry,omitempty"`
+
+	Tiller  TillerConfiguration  `json:"tiller"`
+	Health  HealthConfiguration  `json:"health"`
+	Tracing TracingConfiguration `json:"tracing"`
+	Logging LoggingConfiguration `json:"logging"`
+	Audit   AuditConfiguration   `json:"audit"`
+	History HistoryConfiguration `json:"history"`
+}
+
+// RateLimiterConfiguration configures the per-item exponential backoff and the overall token bucket of the work
+// queue, the failed migrates are retried after base delay * 2^retries capped by max delay.
+type RateLimiterConfiguration struct {
+	BaseDelay metav1.Duration `json:"baseDelay"`
+	MaxDelay  metav1.Duration `json:"maxDelay"`
+	QPS       float64         `json:"qps"`
+	Burst     int             `json:"burst"`
+}
+
+// TillerConfiguration configures the connections to tiller.
+type TillerConfiguration struct {
+	// Disabled renders the charts in-process and applies them without tiller.
+	Disabled bool `json:"disabled"`
+	// ReleaseNamespace is the namespace to store the release secrets in when tiller is disabled.
+	ReleaseNamespace string `json:"releaseNamespace"`
+	// Host is the address of tiller to connect to directly instead of port-forwarding to the tiller pod.
+	Host string `json:"host,omitempty"`
+	// Namespace is the namespace of the default tiller.
+	Namespace string `json:"namespace"`
+	// ClientIdleTimeout closes the helm clients of the tillers other than the default one once they are idle.
+	ClientIdleTimeout metav1.Duration `json:"clientIdleTimeout"`
+	// HealthCheckInterval is the interval to keep the helm clients alive, the unhealthy ones are recreated.
+	HealthCheckInterval metav1.Duration `json:"healthCheckInterval"`
+}
+
+// HealthConfiguration configures the health checks.
+type HealthConfiguration struct {
+	// Timeout fails a health check which has not returned in time.
+	Timeout metav1.Duration `json:"timeout"`
+	// WebhookCertFile is the serving certificate of the webhook, it is not checked if it is empty.
+	WebhookCertFile string `json:"webhookCertFile,omitempty"`
+	// CertMinValidity fails the certificate check once the certificate expires within it.
+	CertMinValidity metav1.Duration `json:"certMinValidity"`
+}
+
+// TracingConfiguration configures the export of the traces of the syncs.
+type TracingConfiguration struct {
+	// OTLPEndpoint is the OTLP HTTP endpoint of the collector, e.g. http://otel-collector:4318. The traces are only
+	// shown on /debug/requests of the trace address if it is empty.
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+	// ServiceName is the service name of the exported traces.
+	ServiceName string `json:"serviceName"`
+	// ExportInterval is the interval to export the ended spans, an export taking longer is abandoned.
+	ExportInterval metav1.Duration `json:"exportInterval"`
+}
+
+// LoggingConfiguration configures the structured logger.
+type LoggingConfiguration struct {
+	// Format is json or text.
+	Format string `json:"format"`
+	// Level is the initial level, e.g. info or debug, it can be changed at runtime through /debug/loglevel.
+	Level string `json:"level"`
+	// KlogVerbosity is the verbosity of the logs of the kubernetes client libraries.
+	KlogVerbosity int `json:"klogVerbosity"`
+}
+
+// AuditConfiguration configures the ReleaseOperation records of the installs, the upgrades, the uninstalls and the
+// rollbacks of the releases.
+type AuditConfiguration struct {
+	// Disabled stops recording the operations, they are not recorded either if the ReleaseOperation CRD is missing
+	// at startup.
+	Disabled bool `json:"disabled"`
+	// MaxRecords is the count of the latest records kept for each migrate, 0 keeps all of them.
+	MaxRecords int `json:"maxRecords"`
+	// MaxAge deletes the records older than it, 0 keeps them whatever their age.
+	MaxAge metav1.Duration `json:"maxAge"`
+	// RetentionInterval is the interval to garbage-collect the records of all migrates, including the deleted ones. The
+	// records are only garbage-collected then, not after each operation.
+	RetentionInterval metav1.Duration `json:"retentionInterval"`
+}
+
+// HistoryConfiguration configures the local history of the deployments the DORA metrics of the apps are computed from.
+type HistoryConfiguration struct {
+	// Path is the bbolt file of the history, e.g. on a persistent volume, the history is disabled if it is empty.
+	Path string `json:"path,omitempty"`
+	// Window is the period the DORA metrics are computed over by default.
+	Window metav1.Duration `json:"window"`
+	// Retention deletes the deployments older than it, it should not be shorter than the window.
+	Retention metav1.Duration `json:"retention"`
+}
+
+// Default returns the configuration used if neither the file, the environment nor the flags set anything.
+func Default() *OperatorConfiguration {
+	return &OperatorConfiguration{
+		APIVersion:   APIVersion,
+		Kind:         Kind,
+		Workers:      2,
+		ResyncPeriod: metav1.Duration{Duration: 30 * time.Second},
+		RateLimiter: RateLimiterConfiguration{
+			BaseDelay: metav1.Duration{Duration: 5 * time.Millisecond},
+			MaxDelay:  metav1.Duration{Duration: 1000 * time.Second},
+			QPS:       10,
+			Burst:     100,
+		},
+		MonitorAddress: ":44100",
+		TraceAddress:   ":44101",
+		Tiller: TillerConfiguration{
+			ReleaseNamespace:    "kube-system",
+			Namespace:           helm.DefaultTillerNamespace,
+			ClientIdleTimeout:   metav1.Duration{Duration: 30 * time.Minute},
+			HealthCheckInterval: metav1.Duration{Duration: time.Minute},
+		},
+		Health: HealthConfiguration{
+			Timeout:         metav1.Duration{Duration: 5 * time.Second},
+			CertMinValidity: metav1.Duration{Duration: 7 * 24 * time.Hour},
+		},
+		Tracing: TracingConfiguration{
+			ServiceName:    "sym-operator",
+			ExportInterval: metav1.Duration{Duration: 5 * time.Second},
+		},
+		Logging: LoggingConfiguration{
+			Format: "json",
+			Level:  "info",
+		},
+		Audit: AuditConfiguration{
+			MaxRecords:        100,
+			MaxAge:            metav1.Duration{Duration: 90 * 24 * time.Hour},
+			RetentionInterval: metav1.Duration{Duration: time.Hour},
+		},
+		History: HistoryConfiguration{
+			Window:    metav1.Duration{Duration: 30 * 24 * time.Hour},
+			Retention: metav1.Duration{Duration: 90 * 24 * time.Hour},
+		},
+	}
+}
+
+// AddFlags registers the flags of the configuration, their defaults are the ones of the configuration.
+func AddFlags(fs *flag.FlagSet, c *OperatorConfiguration) {
+	fs.IntVar(&c.Workers, "workers", c.Workers, "The number of the workers syncing the migrates concurrently.")
+	fs.DurationVar(&c.ResyncPeriod.Duration, "resync-period", c.ResyncPeriod.Duration, "The resync period of the informers.")
+	fs.DurationVar(&c.RateLimiter.BaseDelay.Duration, "rate-limiter-base-delay", c.RateLimiter.BaseDelay.Duration,
+		"The delay before retrying a failed migrate the first time, it doubles with each retry.")
+	fs.DurationVar(&c.RateLimiter.MaxDelay.Duration, "rate-limiter-max-delay", c.RateLimiter.MaxDelay.Duration,
+		"The maximum delay before retrying a failed migrate.")
+	fs.Float64Var(&c.RateLimiter.QPS, "rate-limiter-qps", c.RateLimiter.QPS, "The overall rate of the migrates taken from the work queue.")
+	fs.IntVar(&c.RateLimiter.Burst, "rate-limiter-burst", c.RateLimiter.Burst, "The burst of the migrates taken from the work queue.")
+	fs.StringVar(&c.MonitorAddress, "monitor-address", c.MonitorAddress, "The listen address of the probes and the metrics.")
+	fs.StringVar(&c.TraceAddress, "trace-address", c.TraceAddress, "The listen address of the tracing and the debug endpoints.")
+	fs.StringVar(&c.OperatorConfig, "operator-config", c.OperatorConfig,
+		"The namespace/name of the OperatorConfig resource whose policies are applied live, none is watched if it is empty.")
+	fs.BoolVar(&c.ClusterRegistry, "cluster-registry", c.ClusterRegistry,
+		"Watch the Cluster resources to schedule the releases by their cluster selectors, the Cluster CRD should be installed.")
+
+	fs.BoolVar(&c.Tiller.Disabled, "tiller-less", c.Tiller.Disabled, "Render the charts in-process and apply them without tiller.")
+	fs.StringVar(&c.Tiller.ReleaseNamespace, "release-namespace", c.Tiller.ReleaseNamespace,
+		"The namespace to store the release secrets in when running without tiller.")
+	fs.StringVar(&c.Tiller.Host, "tiller-host", c.Tiller.Host,
+		"The address of tiller to connect to directly instead of port-forwarding to the tiller pod.")
+	fs.StringVar(&c.Tiller.Namespace, "tiller-namespace", c.Tiller.Namespace,
+		"The namespace of the default tiller, it is used for the migrates which have not specified spec.tillerNamespace.")
+	fs.DurationVar(&c.Tiller.ClientIdleTimeout.Duration, "tiller-client-idle-timeout", c.Tiller.ClientIdleTimeout.Duration,
+		"The helm client of a tiller other than the default one is closed once it has not been used for this long.")
+	fs.DurationVar(&c.Tiller.HealthCheckInterval.Duration, "tiller-health-check-interval", c.Tiller.HealthCheckInterval.Duration,
+		"The interval to check the health of the helm clients, the unhealthy ones are recreated.")
+
+	fs.DurationVar(&c.Health.Timeout.Duration, "health-check-timeout", c.Health.Timeout.Duration,
+		"A health check fails if it has not returned within the timeout.")
+	fs.StringVar(&c.Health.WebhookCertFile, "webhook-cert-file", c.Health.WebhookCertFile,
+		"Path to the serving certificate of the webhook whose validity is checked, it is not checked if empty.")
+	fs.DurationVar(&c.Health.CertMinValidity.Duration, "webhook-cert-min-validity", c.Health.CertMinValidity.Duration,
+		"The certificate check fails once the webhook certificate expires within this duration.")
+
+	fs.StringVar(&c.Tracing.OTLPEndpoint, "tracing-otlp-endpoint", c.Tracing.OTLPEndpoint,
+		"The OTLP HTTP endpoint of the collector to export the traces to, e.g. http://otel-collector:4318, none are exported if empty.")
+	fs.StringVar(&c.Tracing.ServiceName, "tracing-service-name", c.Tracing.ServiceName, "The service name of the exported traces.")
+	fs.DurationVar(&c.Tracing.ExportInterval.Duration, "tracing-export-interval", c.Tracing.ExportInterval.Duration,
+		"The interval to export the ended spans to the collector.")
+
+	fs.StringVar(&c.Logging.Format, "log-format", c.Logging.Format, "The format of the logs, json or text.")
+	fs.StringVar(&c.Logging.Level, "log-level", c.Logging.Level,
+		"The initial level of the logs, e.g. info or debug, it can be changed at runtime through /debug/loglevel.")
+	fs.IntVar(&c.Logging.KlogVerbosity, "klog-verbosity", c.Logging.KlogVerbosity,
+		"The verbosity of the logs of the kubernetes client libraries.")
+
+	fs.BoolVar(&c.Audit.Disabled, "audit-disabled", c.Audit.Disabled,
+		"Stop recording the operations on the releases as ReleaseOperation resources.")
+	fs.IntVar(&c.Audit.MaxRecords, "audit-max-records", c.Audit.MaxRecords,
+		"The count of the latest ReleaseOperation records kept for each migrate, 0 keeps all of them.")
+	fs.DurationVar(&c.Audit.MaxAge.Duration, "audit-max-age", c.Audit.MaxAge.Duration,
+		"The ReleaseOperation records older than this are deleted, 0 keeps them whatever their age.")
+	fs.DurationVar(&c.Audit.RetentionInterval.Duration, "audit-retention-interval", c.Audit.RetentionInterval.Duration,
+		"The interval to garbage-collect the ReleaseOperation records of all migrates.")
+
+	fs.StringVar(&c.History.Path, "history-path", c.History.Path,
+		"The bbolt file to persist the deployments to for the DORA metrics, e.g. on a persistent volume, disabled if empty.")
+	fs.DurationVar(&c.History.Window.Duration, "history-window", c.History.Window.Duration,
+		"The period the DORA metrics are computed over by default.")
+	fs.DurationVar(&c.History.Retention.Duration, "history-retention", c.History.Retention.Duration,
+		"The deployments older than this are deleted from the history.")
+}
+
+// Complete builds the effective configuration once the flags have been parsed: the defaults are overridden by the
+// file if it is specified, then by the environment variables of the flags, then by the flags set explicitly.
+// The environment variables apply to all flags of the flag set, e.g. SYM_OPERATOR_LEADER_ELECT for -leader-elect.
+func Complete(fs *flag.FlagSet, c *OperatorConfiguration, file string) error {
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if file != "" {
+		loaded, err := Load(file)
+		if err != nil {
+			return err
+		}
+		*c = *loaded
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := explicit[f.Name]; ok {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, errors.Wrapf(err, "environment variable %s", EnvName(f.Name)))
+			}
+		}
+	})
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			errs = append(errs, errors.Wrapf(err, "flag -%s", name))
+		}
+	}
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	return Validate(c)
+}
+
+// EnvName returns the environment variable of the flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Load reads the configuration file over the defaults, unknown fields are rejected.
+func Load(file string) (*OperatorConfiguration, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read configuration file %s", file)
+	}
+	c := Default()
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, errors.Wrapf(err, "decode configuration file %s", file)
+	}
+	if c.APIVersion != APIVersion || c.Kind != Kind {
+		return nil, errors.Errorf("configuration file %s should be of apiVersion %s and kind %s, got %s %s",
+			file, APIVersion, Kind, c.APIVersion, c.Kind)
+	}
+	return c, nil
+}
+
+// Validate rejects the invalid configuration.
+func Validate(c *OperatorConfiguration) error {
+	var errs []error
+	if c.Workers < 1 {
+		errs = append(errs, errors.Errorf("workers should be at least 1, got %d", c.Workers))
+	}
+	if c.ResyncPeriod.Duration < 0 {
+		errs = append(errs, errors.Errorf("resyncPeriod should not be negative, got %s", c.ResyncPeriod.Duration))
+	}
+	if c.RateLimiter.BaseDelay.Duration <= 0 || c.RateLimiter.MaxDelay.Duration < c.RateLimiter.BaseDelay.Duration {
+		errs = append(errs, errors.Errorf("rateLimiter.baseDelay should be positive and not longer than maxDelay, got %s and %s",
+			c.RateLimiter.BaseDelay.Duration, c.RateLimiter.MaxDelay.Duration))
+	}
+	if c.RateLimiter.QPS <= 0 || c.RateLimiter.Burst < 1 {
+		errs = append(errs, errors.Errorf("rateLimiter.qps and rateLimiter.burst should be positive, got %v and %d",
+			c.RateLimiter.QPS, c.RateLimiter.Burst))
+	}
+	if _, _, err := net.SplitHostPort(c.MonitorAddress); err != nil {
+		errs = append(errs, errors.Wrap(err, "monitorAddress"))
+	}
+	if _, _, err := net.SplitHostPort(c.TraceAddress); err != nil {
+		errs = append(errs, errors.Wrap(err, "traceAddress"))
+	}
+	if c.MonitorAddress == c.TraceAddress {
+		errs = append(errs, errors.Errorf("monitorAddress and traceAddress should be different, got %s", c.MonitorAddress))
+	}
+	if c.OperatorConfig != "" {
+		if parts := strings.Split(c.OperatorConfig, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, errors.Errorf("operatorConfig should be namespace/name, got %s", c.OperatorConfig))
+		}
+	}
+
+	if c.Tiller.Disabled && c.Tiller.Host != "" {
+		errs = append(errs, errors.New("tiller.host should not be set when tiller is disabled"))
+	}
+	if c.Tiller.Disabled && c.Tiller.ReleaseNamespace == "" {
+		errs = append(errs, errors.New("tiller.releaseNamespace should be set when tiller is disabled"))
+	}
+	if c.Tiller.Namespace == "" {
+		errs = append(errs, errors.New("tiller.namespace should be set"))
+	}
+	if c.Tiller.ClientIdleTimeout.Duration <= 0 || c.Tiller.HealthCheckInterval.Duration <= 0 {
+		errs = append(errs, errors.Errorf("tiller.clientIdleTimeout and tiller.healthCheckInterval should be positive, got %s and %s",
+			c.Tiller.ClientIdleTimeout.Duration, c.Tiller.HealthCheckInterval.Duration))
+	}
+	if c.Health.Timeout.Duration <= 0 || c.Health.CertMinValidity.Duration < 0 {
+		errs = append(errs, errors.Errorf("health.timeout should be positive and health.certMinValidity should not be negative, got %s and %s",
+			c.Health.Timeout.Duration, c.Health.CertMinValidity.Duration))
+	}
+	if c.Tracing.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.Tracing.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.Errorf("tracing.otlpEndpoint should be an http or https URL, got %s", c.Tracing.OTLPEndpoint))
+		}
+	}
+	if c.Tracing.ServiceName == "" || c.Tracing.ExportInterval.Duration <= 0 {
+		errs = append(errs, errors.Errorf("tracing.serviceName should be set and tracing.exportInterval should be positive, got %q and %s",
+			c.Tracing.ServiceName, c.Tracing.ExportInterval.Duration))
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		errs = append(errs, errors.Errorf("logging.format should be json or text, got %s", c.Logging.Format))
+	}
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, errors.Wrap(err, "logging.level"))
+	}
+	if c.Logging.KlogVerbosity < 0 {
+		errs = append(errs, errors.Errorf("logging.klogVerbosity should not be negative, got %d", c.Logging.KlogVerbosity))
+	}
+	if c.Audit.MaxRecords < 0 || c.Audit.MaxAge.Duration < 0 || c.Audit.RetentionInterval.Duration <= 0 {
+		errs = append(errs, errors.Errorf("audit.maxRecords and audit.maxAge should not be negative and audit.retentionInterval should be positive, got %d, %s and %s",
+			c.Audit.MaxRecords, c.Audit.MaxAge.Duration, c.Audit.RetentionInterval.Duration))
+	}
+	if c.History.Window.Duration <= 0 || c.History.Retention.Duration < c.History.Window.Duration {
+		errs = append(errs, errors.Errorf("history.window should be positive and history.retention should not be shorter, got %s and %s",
+			c.History.Window.Duration, c.History.Retention.Duration))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// String returns the configuration as yaml.
+func (c *OperatorConfiguration) String() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("%+v", *c)
+	}
+	return string(data)
+}
+
+// NewRateLimiter returns the rate limiter of the work queue, it is the default controller rate limiter with the
+// configured settings.
+func (c RateLimiterConfiguration) NewRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(c.BaseDelay.Duration, c.MaxDelay.Duration),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(c.QPS), c.Burst)},
+	)
+}
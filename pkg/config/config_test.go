@@ -0,0 +1,141 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	return file
+}
+
+func TestComplete(t *testing.T) {
+	file := writeFile(t, `apiVersion: sym-operator.dmall.com/v1alpha1
+kind: OperatorConfiguration
+workers: 4
+resyncPeriod: 1m
+monitorAddress: ":9000"
+tiller:
+  namespace: tiller
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+	os.Setenv(EnvName("workers"), "8")
+	defer os.Unsetenv(EnvName("workers"))
+	os.Setenv(EnvName("tiller-namespace"), "tiller-from-env")
+	defer os.Unsetenv(EnvName("tiller-namespace"))
+
+	c := Default()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddFlags(fs, c)
+	if err := fs.Parse([]string{"-tiller-namespace=tiller-from-flag"}); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	if err := Complete(fs, c, file); err != nil {
+		t.Fatalf("complete configuration: %v", err)
+	}
+
+	if c.ResyncPeriod.Duration != time.Minute || c.MonitorAddress != ":9000" {
+		t.Errorf("expected the settings of the file, got resync period %s and monitor address %s",
+			c.ResyncPeriod.Duration, c.MonitorAddress)
+	}
+	if c.Workers != 8 {
+		t.Errorf("expected the environment variable to override the file, got %d workers", c.Workers)
+	}
+	if c.Tiller.Namespace != "tiller-from-flag" {
+		t.Errorf("expected the flag to override the environment variable, got tiller namespace %s", c.Tiller.Namespace)
+	}
+	if c.TraceAddress != Default().TraceAddress {
+		t.Errorf("expected the default trace address, got %s", c.TraceAddress)
+	}
+}
+
+func TestLoadRejectsInvalidFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "unknown field",
+			content:  "apiVersion: sym-operator.dmall.com/v1alpha1\nkind: OperatorConfiguration\nworker: 4\n",
+			expected: "unknown field",
+		},
+		{
+			name:     "wrong kind",
+			content:  "apiVersion: v1\nkind: ConfigMap\n",
+			expected: "should be of apiVersion",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := writeFile(t, test.content)
+			defer os.RemoveAll(filepath.Dir(file))
+			_, err := Load(file)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected an error containing %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *OperatorConfiguration)
+		valid  bool
+	}{
+		{name: "default", modify: func(c *OperatorConfiguration) {}, valid: true},
+		{name: "no worker", modify: func(c *OperatorConfiguration) { c.Workers = 0 }},
+		{name: "base delay longer than max delay", modify: func(c *OperatorConfiguration) {
+			c.RateLimiter.BaseDelay.Duration = time.Hour
+		}},
+		{name: "invalid address", modify: func(c *OperatorConfiguration) { c.MonitorAddress = "44100" }},
+		{name: "same addresses", modify: func(c *OperatorConfiguration) { c.TraceAddress = c.MonitorAddress }},
+		{name: "operator config", modify: func(c *OperatorConfiguration) { c.OperatorConfig = "kube-system/sym-operator" },
+			valid: true},
+		{name: "operator config without namespace", modify: func(c *OperatorConfiguration) { c.OperatorConfig = "sym-operator" }},
+		{name: "no health check timeout", modify: func(c *OperatorConfiguration) { c.Health.Timeout.Duration = 0 }},
+		{name: "otlp endpoint", modify: func(c *OperatorConfiguration) { c.Tracing.OTLPEndpoint = "http://otel-collector:4318" },
+			valid: true},
+		{name: "text logs", modify: func(c *OperatorConfiguration) { c.Logging.Format = "text" }, valid: true},
+		{name: "xml logs", modify: func(c *OperatorConfiguration) { c.Logging.Format = "xml" }},
+		{name: "unknown log level", modify: func(c *OperatorConfiguration) { c.Logging.Level = "verbose" }},
+		{name: "audit records kept forever", modify: func(c *OperatorConfiguration) {
+			c.Audit.MaxRecords, c.Audit.MaxAge.Duration = 0, 0
+		}, valid: true},
+		{name: "negative audit max records", modify: func(c *OperatorConfiguration) { c.Audit.MaxRecords = -1 }},
+		{name: "cluster registry", modify: func(c *OperatorConfiguration) { c.ClusterRegistry = true }, valid: true},
+		{name: "history", modify: func(c *OperatorConfiguration) { c.History.Path = "/var/lib/sym-operator/history.db" },
+			valid: true},
+		{name: "history retention shorter than the window", modify: func(c *OperatorConfiguration) {
+			c.History.Retention.Duration = 24 * time.Hour
+		}},
+		{name: "otlp endpoint without scheme", modify: func(c *OperatorConfiguration) { c.Tracing.OTLPEndpoint = "otel-collector:4318" }},
+		{name: "tiller host without tiller", modify: func(c *OperatorConfiguration) {
+			c.Tiller.Disabled = true
+			c.Tiller.Host = "tiller:44134"
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Default()
+			test.modify(c)
+			if err := Validate(c); (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
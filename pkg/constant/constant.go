@@ -2,18 +2,35 @@ package constant
 
 const (
 	ConditionTypePrefix = "OK_"
+	// The condition types of the conversion from helm 2 to helm 3.
+	ConvertConditionTypePrefix = "Helm3_"
+	// The condition type reporting that the releases have not become available within the progress deadline.
+	ProgressDeadlineConditionType = "ProgressDeadlineExceeded"
 
 	BlueGroup  = "blue"
 	GreenGroup = "green"
 
 	ConditionStatusTrue  = "True"
 	ConditionStatusFalse = "False"
+	// The status of a condition which can not be probed, such as the releases in an unreachable cluster.
+	ConditionStatusUnknown = "Unknown"
 
 	AppLabel     = "app"
 	GroupLabel   = "sym-group"
 	ReleaseLabel = "release"
+
+	// A release can not be pruned if any deployment of it has this annotation with "true".
+	ProtectedAnnotation = "devops.dmall.com/protected"
+	// The comma separated release names which are confirmed to be pruned under the Confirm policy.
+	ConfirmPruneAnnotation = "devops.dmall.com/confirm-prune"
+
+	DefaultPruneLimit = 1
 )
 
 func ConcatConditionType(group string) string {
 	return ConditionTypePrefix + group
 }
+
+func ConcatConvertConditionType(rlsName string) string {
+	return ConvertConditionTypePrefix + rlsName
+}
@@ -0,0 +1,75 @@
+package debug
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+)
+
+var logger = log.WithComponent("debug")
+
+// Table is the HTML rendering of a snapshot, one row per item.
+type Table struct {
+	Title   string
+	Columns []string
+	Rows    [][]string
+}
+
+// Snapshot returns the state to serve, the data is encoded as JSON and the table is rendered as HTML.
+type Snapshot func(r *http.Request) (data interface{}, table Table)
+
+// Handler serves the snapshot as JSON if the format query parameter is json or the request accepts JSON, otherwise
+// as a HTML table.
+func Handler(snapshot Snapshot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, table := snapshot(r)
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(data); err != nil {
+				logger.Errorf("Encode the debug state of %s has an error : %s", r.URL.Path, err.Error())
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tableTemplate.Execute(w, table); err != nil {
+			logger.Errorf("Render the debug state of %s has an error : %s", r.URL.Path, err.Error())
+		}
+	}
+}
+
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+var tableTemplate = template.Must(template.New("table").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>{{.Title}}</title>
+    <style>
+      table { border-collapse: collapse; font-family: monospace; }
+      th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; vertical-align: top; }
+    </style>
+  </head>
+  <body>
+    <h1>{{.Title}}</h1>
+    <p><a href="?format=json">JSON</a> | <a href="/">index</a></p>
+    {{if .Rows}}
+    <table>
+      <tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+      {{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+      {{end}}
+    </table>
+    {{else}}
+    <p>None.</p>
+    {{end}}
+  </body>
+</html>
+`))
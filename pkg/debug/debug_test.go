@@ -0,0 +1,55 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	handler := Handler(func(r *http.Request) (interface{}, Table) {
+		data := map[string]string{"key": "default/<demo>"}
+		return data, Table{Title: "Migrates", Columns: []string{"Key"}, Rows: [][]string{{data["key"]}}}
+	})
+
+	tests := []struct {
+		name                string
+		url                 string
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{name: "html", url: "/debug/migrates", expectedContentType: "text/html; charset=utf-8",
+			expectedBody: "<td>default/&lt;demo&gt;</td>"},
+		{name: "json format", url: "/debug/migrates?format=json", expectedContentType: "application/json"},
+		{name: "accept json", url: "/debug/migrates", accept: "application/json", expectedContentType: "application/json"},
+		{name: "html format overrides accept", url: "/debug/migrates?format=html", accept: "application/json",
+			expectedContentType: "text/html; charset=utf-8", expectedBody: "<th>Key</th>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.url, nil)
+			if test.accept != "" {
+				r.Header.Set("Accept", test.accept)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if contentType := w.Header().Get("Content-Type"); contentType != test.expectedContentType {
+				t.Fatalf("expected content type %s, got %s", test.expectedContentType, contentType)
+			}
+			if test.expectedContentType == "application/json" {
+				var data map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil || data["key"] != "default/<demo>" {
+					t.Errorf("expected the data in JSON, got %s: %v", w.Body.String(), err)
+				}
+				return
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("expected %q in the page, got %s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
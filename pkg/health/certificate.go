@@ -0,0 +1,37 @@
+package health
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CertificateCheck fails if the first certificate in the PEM file is not valid yet, or expires within the minimum
+// validity. The file is read on each check, so a renewed certificate is picked up.
+func CertificateCheck(name, file string, minValidity time.Duration) Check {
+	return Check{Name: name, Func: func() error {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "read certificate %s", file)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return errors.Errorf("no certificate found in %s", file)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrapf(err, "parse certificate %s", file)
+		}
+		now := time.Now()
+		if now.Before(cert.NotBefore) {
+			return errors.Errorf("certificate %s is not valid before %s", file, cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.Add(minValidity).After(cert.NotAfter) {
+			return errors.Errorf("certificate %s expires at %s, within %s", file, cert.NotAfter.Format(time.RFC3339), minValidity)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,123 @@
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+)
+
+var logger = log.WithComponent("health")
+
+// Check is a named health check, it returns an error if the checked subject is unhealthy.
+type Check struct {
+	Name string
+	Func func() error
+}
+
+// Result is the result of a check.
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Ping passes as long as the process is able to serve the probes.
+func Ping() Check {
+	return Check{Name: "ping", Func: func() error { return nil }}
+}
+
+// Run runs the checks concurrently, a check which has not returned within the timeout fails. The results are in the
+// order of the checks.
+func Run(checks []Check, timeout time.Duration) []Result {
+	results := make([]Result, len(checks))
+	done := make(chan int, len(checks))
+	for i, check := range checks {
+		results[i].Name = check.Name
+		go func(i int, check Check) {
+			start := time.Now()
+			err := check.Func()
+			results[i].Err, results[i].Duration = err, time.Since(start)
+			done <- i
+		}(i, check)
+	}
+
+	finished := make([]bool, len(checks))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for remaining := len(checks); remaining > 0; remaining-- {
+		select {
+		case i := <-done:
+			finished[i] = true
+		case <-timer.C:
+			// The checks still running are reported as timed out, their results are discarded.
+			ret := make([]Result, len(checks))
+			for i := range checks {
+				ret[i] = Result{Name: checks[i].Name, Err: errors.Errorf("timed out after %s", timeout), Duration: timeout}
+			}
+			for {
+				select {
+				case i := <-done:
+					finished[i] = true
+				default:
+					for i := range checks {
+						if finished[i] {
+							ret[i] = results[i]
+						}
+					}
+					return ret
+				}
+			}
+		}
+	}
+	return results
+}
+
+// Handler serves the results of the checks, the status is 200 if all the checks pass, otherwise 503. The result of
+// each check is listed if the verbose query parameter is set or any check fails.
+func Handler(checks []Check, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, verbose := r.URL.Query()["verbose"]
+		serve(w, Run(checks, timeout), verbose)
+	}
+}
+
+// VerboseHandler serves the results of the checks with the result of each check listed.
+func VerboseHandler(checks []Check, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serve(w, Run(checks, timeout), true)
+	}
+}
+
+func serve(w http.ResponseWriter, results []Result, verbose bool) {
+	var out bytes.Buffer
+	failed := false
+	for _, result := range results {
+		if result.Err != nil {
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed (%s): %s\n", result.Name, result.Duration.Round(time.Millisecond), result.Err.Error())
+			logger.Debugf("Health check %s failed: %s", result.Name, result.Err.Error())
+		} else {
+			fmt.Fprintf(&out, "[+]%s ok (%s)\n", result.Name, result.Duration.Round(time.Millisecond))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		out.WriteString("health check failed\n")
+		w.Write(out.Bytes())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if verbose {
+		out.WriteString("health check passed\n")
+		w.Write(out.Bytes())
+		return
+	}
+	w.Write([]byte("ok"))
+}
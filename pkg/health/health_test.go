@@ -0,0 +1,134 @@
+package health
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	results := Run([]Check{
+		Ping(),
+		{Name: "failing", Func: func() error { return fmt.Errorf("unreachable") }},
+		{Name: "slow", Func: func() error {
+			<-release
+			return nil
+		}},
+	}, 100*time.Millisecond)
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %v", results)
+	}
+	if results[0].Name != "ping" || results[0].Err != nil {
+		t.Errorf("expected ping to pass, got %+v", results[0])
+	}
+	if results[1].Name != "failing" || results[1].Err == nil || results[1].Err.Error() != "unreachable" {
+		t.Errorf("expected the failing check to fail, got %+v", results[1])
+	}
+	if results[2].Name != "slow" || results[2].Err == nil || !strings.Contains(results[2].Err.Error(), "timed out") {
+		t.Errorf("expected the slow check to time out, got %+v", results[2])
+	}
+}
+
+func TestHandler(t *testing.T) {
+	healthy := false
+	checks := []Check{Ping(), {Name: "caches", Func: func() error {
+		if !healthy {
+			return fmt.Errorf("not synced")
+		}
+		return nil
+	}}}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		url            string
+		healthy        bool
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{name: "failing", handler: Handler(checks, time.Second), url: "/readyz",
+			expectedStatus: http.StatusServiceUnavailable, expectedBody: []string{"[+]ping ok", "[-]caches failed", "not synced"}},
+		{name: "passing", handler: Handler(checks, time.Second), url: "/readyz", healthy: true,
+			expectedStatus: http.StatusOK, expectedBody: []string{"ok"}},
+		{name: "passing verbose", handler: Handler(checks, time.Second), url: "/readyz?verbose", healthy: true,
+			expectedStatus: http.StatusOK, expectedBody: []string{"[+]ping ok", "[+]caches ok", "health check passed"}},
+		{name: "verbose handler", handler: VerboseHandler(checks, time.Second), url: "/healthz/verbose", healthy: true,
+			expectedStatus: http.StatusOK, expectedBody: []string{"[+]ping ok", "[+]caches ok"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			healthy = test.healthy
+			w := httptest.NewRecorder()
+			test.handler(w, httptest.NewRequest(http.MethodGet, test.url, nil))
+			if w.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, w.Code)
+			}
+			for _, expected := range test.expectedBody {
+				if !strings.Contains(w.Body.String(), expected) {
+					t.Errorf("expected %q in the body, got %q", expected, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func writeCertificate(t *testing.T, dir string, notBefore, notAfter time.Time) string {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "sym-operator-webhook"},
+		NotBefore: notBefore, NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	file := filepath.Join(dir, fmt.Sprintf("%d.crt", notAfter.Unix()))
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	return file
+}
+
+func TestCertificateCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		file  string
+		valid bool
+	}{
+		{name: "valid", file: writeCertificate(t, dir, now.Add(-time.Hour), now.Add(30*24*time.Hour)), valid: true},
+		{name: "expiring", file: writeCertificate(t, dir, now.Add(-time.Hour), now.Add(24*time.Hour))},
+		{name: "expired", file: writeCertificate(t, dir, now.Add(-48*time.Hour), now.Add(-time.Hour))},
+		{name: "missing", file: filepath.Join(dir, "missing.crt")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CertificateCheck("webhook-certificate", test.file, 7*24*time.Hour).Func()
+			if (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got %v", test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package helm
+
+import (
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// ReleaseBackend manages the releases of the charts, the controller only talks to the releases through it.
+// There are two implementations: Client which talks to tiller and LocalBackend which works without tiller.
+type ReleaseBackend interface {
+	// InstallRelease installs the chart as a new release with the raw values.
+	InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*release.Release, error)
+	// UpdateRelease upgrades the release to the chart with the raw values.
+	UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error)
+	// UninstallRelease deletes the release and purges its history.
+	UninstallRelease(rlsName string) error
+	// GetRelease returns the latest version of the release, nil if it can not be found.
+	GetRelease(releaseName string) (*release.Release, error)
+	// GetReleaseByVersion returns the version of the release, the latest one if version is 0.
+	GetReleaseByVersion(releaseName string, version int32) (*release.Release, error)
+	// FilterReleases returns the latest version of the releases whose names match the regex.
+	FilterReleases(regex string) ([]*release.Release, error)
+	// RollbackRelease rolls the release back to the version, a new version is created for it.
+	RollbackRelease(rlsName string, version int32) (*release.Release, error)
+	// ReleaseHistory returns at most max versions of the release, the newest first.
+	ReleaseHistory(rlsName string, max int32) ([]*release.Release, error)
+	// KeepLive checks whether the backend is still available.
+	KeepLive() error
+}
+
+// The operations of a ReleaseBackend, they label the metrics and the logs of the instrumented backends.
+const (
+	OperationInstall   = "install"
+	OperationUpdate    = "update"
+	OperationUninstall = "uninstall"
+	OperationGet       = "get"
+	OperationList      = "list"
+	OperationRollback  = "rollback"
+	OperationHistory   = "history"
+	OperationKeepLive  = "keeplive"
+)
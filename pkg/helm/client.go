@@ -2,34 +2,50 @@ package helm
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
-	"github.com/golang/glog"
 	"github.com/goph/emperror"
-	"github.com/jasonlvhit/gocron"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
 	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/helm"
 	helmapi "k8s.io/helm/pkg/helm"
-	"k8s.io/helm/pkg/helm/portforwarder"
-	"k8s.io/helm/pkg/kube"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 	"k8s.io/helm/pkg/proto/hapi/release"
 	rls "k8s.io/helm/pkg/proto/hapi/services"
-	"k8s.io/klog"
 	"os"
 	"strings"
+	"sync"
 )
 
-// minutes
-const keepLiveInteval = 5
+var logger = log.WithComponent("helm")
 
-// Client encapsulates a Helm Client and a Tunnel for that client to interact with the Tiller pod
+// seconds
+const defaultConnectTimeout = 5
+
+// Client encapsulates a Helm Client and a Tunnel for that client to interact with the Tiller pod,
+// the tunnel is rebuilt once the tiller can not be reached through it.
 type Client struct {
-	*kube.Tunnel
-	*helm.Client
+	mu     sync.RWMutex
+	client *helm.Client
+	host   string
+	// closer closes the current tunnel, nil if tiller is reached at a plain address.
+	closer func()
+	// tlsConfig is used to connect to tiller if it has been set.
+	tlsConfig *tls.Config
+
+	reconnectMu sync.Mutex
+	// dial rebuilds the tunnel, nil if tiller is reached at a plain address.
+	dial           tillerDialer
+	backoff        wait.Backoff
+	connectTimeout int64
+	// tunnelLabels identify the tunnel in the metrics, they are only set if there is a tunnel.
+	tunnelLabels prometheus.Labels
 }
 
 // ReleaseNotFoundError is returned when a Helm related operation is executed on
@@ -42,120 +58,272 @@ func (e *ReleaseNotFoundError) Error() string {
 	return fmt.Sprintf("release not found: %s", e.HelmError)
 }
 
-// NewClient
-func NewClient(cfg *restclient.Config, kubeClient *kubernetes.Clientset) (*Client, error) {
-	glog.Info("create kubernetes tunnel")
-	tillerTunnel, err := portforwarder.New("kube-system", kubeClient, cfg)
+// NewClient creates a client which reaches the tiller in the namespace through a port-forward.
+func NewClient(cfg *restclient.Config, kubeClient kubernetes.Interface, tillerNamespace string) (*Client, error) {
+	if tillerNamespace == "" {
+		tillerNamespace = DefaultTillerNamespace
+	}
+	logger.Infof("create kubernetes tunnel to tiller in namespace:%s", tillerNamespace)
+	return newTunnelClient(portForwardDialer(cfg, kubeClient, tillerNamespace),
+		prometheus.Labels{"cluster": cfg.Host, "tiller_namespace": tillerNamespace}, defaultReconnectBackoff, defaultConnectTimeout)
+}
+
+func newTunnelClient(dial tillerDialer, tunnelLabels prometheus.Labels, backoff wait.Backoff, connectTimeout int64) (*Client, error) {
+	symHelmClient := &Client{dial: dial, tunnelLabels: tunnelLabels, backoff: backoff, connectTimeout: connectTimeout}
+	host, closer, err := dial()
 	if err != nil {
-		return nil, emperror.Wrap(err, "failed to create kubernetes tunnel")
+		tunnelUp.With(tunnelLabels).Set(0)
+		return nil, err
 	}
+	logger.Infof("created kubernetes tunnel on address:%s", host)
+	symHelmClient.connect(host, closer)
+	return symHelmClient, nil
+}
 
-	tillerTunnelAddress := fmt.Sprintf("localhost:%d", tillerTunnel.Local)
-	glog.Infof("created kubernetes tunnel on address:%s", tillerTunnelAddress)
+// NewClientWithHost creates a client which connects to the tiller at the address directly instead of
+// through a port-forward, e.g. a tiller running out of the cluster or a fake one in tests.
+func NewClientWithHost(host string) *Client {
+	logger.Infof("connect to tiller on address:%s", host)
+	symHelmClient := &Client{connectTimeout: defaultConnectTimeout}
+	symHelmClient.connect(host, nil)
+	return symHelmClient
+}
 
-	hClient := helm.NewClient(helm.Host(tillerTunnelAddress))
-	symHelmClient := &Client{Tunnel: tillerTunnel, Client: hClient}
+// Close closes the tunnel to tiller if there is one.
+func (helmClient *Client) Close() {
+	helmClient.mu.Lock()
+	defer helmClient.mu.Unlock()
+	if helmClient.closer != nil {
+		helmClient.closer()
+		helmClient.closer = nil
+	}
+	if helmClient.dial != nil {
+		tunnelUp.Delete(helmClient.tunnelLabels)
+	}
+}
 
-	// keep the tunnel live.
-	gocron.Every(keepLiveInteval).Minutes().Do(symHelmClient.Ping)
+// connect switches to the tiller at the address, the previous tunnel is closed.
+func (helmClient *Client) connect(host string, closer func()) {
+	helmClient.mu.Lock()
+	defer helmClient.mu.Unlock()
+	if helmClient.closer != nil {
+		helmClient.closer()
+	}
+	helmClient.host = host
+	helmClient.closer = closer
+	helmClient.client = helmClient.newHelmClient()
+	if helmClient.dial != nil {
+		tunnelUp.With(helmClient.tunnelLabels).Set(1)
+	}
+}
 
-	return symHelmClient, nil
+// SetTLSConfig makes the client connect to tiller with TLS, it takes effect from the next request.
+func (helmClient *Client) SetTLSConfig(cfg *tls.Config) {
+	helmClient.mu.Lock()
+	defer helmClient.mu.Unlock()
+	helmClient.tlsConfig = cfg
+	helmClient.client = helmClient.newHelmClient()
+}
+
+func (helmClient *Client) newHelmClient() *helm.Client {
+	opts := []helm.Option{helm.Host(helmClient.host), helm.ConnectTimeout(helmClient.connectTimeout)}
+	if helmClient.tlsConfig != nil {
+		opts = append(opts, helm.WithTLS(helmClient.tlsConfig))
+	}
+	return helm.NewClient(opts...)
+}
+
+func (helmClient *Client) current() *helm.Client {
+	helmClient.mu.RLock()
+	defer helmClient.mu.RUnlock()
+	return helmClient.client
+}
+
+// do runs the read-only operation against tiller, if tiller can not be reached the tunnel is rebuilt and the
+// operation is retried once.
+func (helmClient *Client) do(op func(client *helm.Client) error) error {
+	return helmClient.call(op, true)
+}
+
+// mutate runs the operation changing a release against tiller, if tiller can not be reached the tunnel is rebuilt
+// but the operation is not retried: tiller may have handled it before the connection broke, so the release is
+// listed again by the next sync before it is retried.
+func (helmClient *Client) mutate(op func(client *helm.Client) error) error {
+	return helmClient.call(op, false)
+}
+
+func (helmClient *Client) call(op func(client *helm.Client) error, retry bool) error {
+	client := helmClient.current()
+	err := op(client)
+	if err == nil || helmClient.dial == nil || !isUnreachable(err) {
+		return err
+	}
+
+	logger.Warningf("Tiller can not be reached through the tunnel, rebuild it : %s", err.Error())
+	if rerr := helmClient.reconnect(client); rerr != nil {
+		return emperror.Wrap(err, rerr.Error())
+	}
+	if !retry {
+		return err
+	}
+	return op(helmClient.current())
 }
 
+// Ping tiller and rebuild the tunnel if it has died.
 func (helmClient *Client) Ping() {
-	err := helmClient.Client.PingTiller()
+	err := helmClient.do(func(client *helm.Client) error { return client.PingTiller() })
 	if err != nil {
-		klog.Errorf("Ping tiller has an error in a keeping alive process, %s", err.Error())
+		logger.Errorf("Ping tiller has an error in a keeping alive process, %s", err.Error())
 		return
 	}
-	klog.Infof("Ping tiller")
+	logger.Infof("Ping tiller")
 }
 
 func (helmClient *Client) KeepLive() error {
-	versionResponse, err := helmClient.Client.GetVersion()
+	version, err := helmClient.TillerVersion()
 	if err != nil {
-		klog.Errorf("Get version has an error in a keeping live process, %s", err.Error())
+		logger.Errorf("Get version has an error in a keeping live process, %s", err.Error())
 		return err
 	}
-	klog.Infof("Keep the helm client tunnel alive regularly by sending getVersion request, version : %s", versionResponse.Version.SemVer)
+	logger.Infof("Keep the helm client tunnel alive regularly by sending getVersion request, version : %s", version)
 	return nil
 }
 
+// TillerVersion returns the semantic version of tiller.
+func (helmClient *Client) TillerVersion() (string, error) {
+	var versionResponse *rls.GetVersionResponse
+	err := helmClient.do(func(client *helm.Client) (err error) {
+		versionResponse, err = client.GetVersion()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return versionResponse.GetVersion().GetSemVer(), nil
+}
+
 //
 func SaveChartByte(c *chart.Chart) ([]byte, error) {
 	filename, err := chartutil.Save(c, "/tmp")
 	if err != nil {
-		glog.Infof("err:%#v", err)
+		logger.Debugf("err:%#v", err)
 		return nil, errors.Wrap(err, "save tmp chart fail")
 	}
 	defer os.Remove(filename)
 
 	chartByte, err := ioutil.ReadFile(filename)
 	if err != nil {
-		glog.Infof("err:%#v", err)
+		logger.Debugf("err:%#v", err)
 		return nil, errors.Wrap(err, "read tmp chart to byte fail")
 	}
 
-	glog.Infof("name:%s, filename:%s", c.GetMetadata().Name, filename)
+	logger.Debugf("name:%s, filename:%s", c.GetMetadata().Name, filename)
 	return chartByte, nil
 }
 
 // Install a release.
-func (helmClient *Client) InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*rls.InstallReleaseResponse, error) {
+func (helmClient *Client) InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*release.Release, error) {
 	//releaseResponse.GetRelease().Chart
 	requestedChart, err := chartutil.LoadArchive(bytes.NewReader(chartBytes))
 	if err != nil {
-		glog.Infof("Load archive when you want to install a release has an error : %s", err.Error())
+		logger.Errorf("Load archive when you want to install a release has an error : %s", err.Error())
 		return nil, err
 	} else {
-		response, err := helmClient.InstallReleaseFromChart(requestedChart, namespace,
-			helmapi.ReleaseName(releaseName), helmapi.ValueOverrides([]byte(raw)))
+		var response *rls.InstallReleaseResponse
+		err = helmClient.mutate(func(client *helm.Client) (err error) {
+			response, err = client.InstallReleaseFromChart(requestedChart, namespace,
+				helmapi.ReleaseName(releaseName), helmapi.ValueOverrides([]byte(raw)))
+			return err
+		})
 		if err != nil {
-			glog.Infof("Installing a release [%s] has an error : %s", releaseName, err.Error())
+			logger.WithField(log.FieldRelease, releaseName).Errorf("Installing a release [%s] has an error : %s", releaseName, err.Error())
 			return nil, err
 		}
 
-		return response, nil
+		return response.Release, nil
 	}
 }
 
 // Update a release.
-func (helmClient *Client) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*rls.UpdateReleaseResponse, error) {
+func (helmClient *Client) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error) {
 	requestedChart, err := chartutil.LoadArchive(bytes.NewReader(chartBytes))
 	if err != nil {
-		glog.Infof("Load archive when you want to update a release has an error : %s", err.Error())
+		logger.Errorf("Load archive when you want to update a release has an error : %s", err.Error())
 		return nil, err
 	} else {
-		updateResponse, err := helmClient.UpdateReleaseFromChart(rlsName, requestedChart, helmapi.UpdateValueOverrides([]byte(raw)))
+		var updateResponse *rls.UpdateReleaseResponse
+		err = helmClient.mutate(func(client *helm.Client) (err error) {
+			updateResponse, err = client.UpdateReleaseFromChart(rlsName, requestedChart, helmapi.UpdateValueOverrides([]byte(raw)))
+			return err
+		})
 		if err != nil {
-			glog.Infof("Updating a release [%s] has an error : %s", rlsName, err.Error())
+			logger.WithField(log.FieldRelease, rlsName).Errorf("Updating a release [%s] has an error : %s", rlsName, err.Error())
 			return nil, err
 		}
 
-		return updateResponse, nil
+		return updateResponse.Release, nil
 	}
 }
 
 // Delete a release.
-func (helmClient *Client) UninstallRelease(rlsName string) (*rls.UninstallReleaseResponse, error) {
-	deleteResponse, err := helmClient.DeleteRelease(rlsName, helmapi.DeletePurge(true))
+func (helmClient *Client) UninstallRelease(rlsName string) error {
+	err := helmClient.mutate(func(client *helm.Client) error {
+		_, err := client.DeleteRelease(rlsName, helmapi.DeletePurge(true))
+		return err
+	})
+	if err != nil {
+		logger.WithField(log.FieldRelease, rlsName).Errorf("Delete the release [%s] has an error : %s", rlsName, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Rollback a release to the version.
+func (helmClient *Client) RollbackRelease(rlsName string, version int32) (*release.Release, error) {
+	var rollbackResponse *rls.RollbackReleaseResponse
+	err := helmClient.mutate(func(client *helm.Client) (err error) {
+		rollbackResponse, err = client.RollbackRelease(rlsName, helmapi.RollbackVersion(version))
+		return err
+	})
 	if err != nil {
-		glog.Infof("Delete the release [%s] has an error : %s", rlsName, err.Error())
+		logger.WithField(log.FieldRelease, rlsName).Errorf("Rollback the release [%s] to version [%d] has an error : %s", rlsName, version, err.Error())
 		return nil, err
 	}
 
-	return deleteResponse, nil
+	return rollbackResponse.Release, nil
+}
+
+// ReleaseHistory returns the versions of a release, the newest first.
+func (helmClient *Client) ReleaseHistory(rlsName string, max int32) ([]*release.Release, error) {
+	var historyResponse *rls.GetHistoryResponse
+	err := helmClient.do(func(client *helm.Client) (err error) {
+		historyResponse, err = client.ReleaseHistory(rlsName, helmapi.WithMaxHistory(max))
+		return err
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, &ReleaseNotFoundError{HelmError: err}
+		}
+		return nil, err
+	}
+
+	return historyResponse.Releases, nil
 }
 
 // GetReleaseByVersion returns the details of a helm release version.
-func (helmClient *Client) GetReleaseByVersion(releaseName string, version int32) (*rls.GetReleaseContentResponse, error) {
+func (helmClient *Client) GetReleaseByVersion(releaseName string, version int32) (*release.Release, error) {
 	ops := []helmapi.ContentOption{}
 
 	if version != 0 {
 		ops = append(ops, helm.ContentReleaseVersion(version))
 	}
 
-	rlsInfo, err := helmClient.ReleaseContent(releaseName, ops...)
+	var rlsInfo *rls.GetReleaseContentResponse
+	err := helmClient.do(func(client *helm.Client) (err error) {
+		rlsInfo, err = client.ReleaseContent(releaseName, ops...)
+		return err
+	})
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			return nil, &ReleaseNotFoundError{HelmError: err}
@@ -163,7 +331,7 @@ func (helmClient *Client) GetReleaseByVersion(releaseName string, version int32)
 		return nil, err
 	}
 
-	return rlsInfo, nil
+	return rlsInfo.Release, nil
 }
 
 // Find the release with its name, return the just only one.
@@ -184,19 +352,19 @@ func (helmClient *Client) GetRelease(releaseName string) (*release.Release, erro
 	}
 
 	ops = append(ops, helmapi.ReleaseListFilter(releaseName))
-	listResponse, err := helmClient.ListReleases(ops...)
+	listResponse, err := helmClient.listReleases(ops...)
 	if err != nil {
 		// if the release is not exist, we should install it.
 		return nil, err
 	}
 
 	if listResponse == nil {
-		glog.Infof("The list response is nil, [%s]", releaseName)
+		logger.Debugf("The list response is nil, [%s]", releaseName)
 		return nil, nil
 	}
 
-	if listResponse.Releases == nil || len(listResponse.Releases) < 0 {
-		glog.Infof("Can not find any release named [%s]", releaseName)
+	if len(listResponse.Releases) == 0 {
+		logger.WithField(log.FieldRelease, releaseName).Debugf("Can not find any release named [%s]", releaseName)
 		return nil, nil
 	}
 
@@ -221,21 +389,29 @@ func (helmClient *Client) FilterReleases(regex string) ([]*release.Release, erro
 	}
 
 	ops = append(ops, helmapi.ReleaseListFilter(regex))
-	listResponse, err := helmClient.ListReleases(ops...)
+	listResponse, err := helmClient.listReleases(ops...)
 	if err != nil {
 		// if the release is not exist, we should install it.
 		return nil, err
 	}
 
 	if listResponse == nil {
-		glog.Infof("The list response is nil, [%s]", regex)
+		logger.Debugf("The list response is nil, [%s]", regex)
 		return nil, nil
 	}
 
-	if listResponse.Releases == nil || len(listResponse.Releases) < 0 {
-		glog.Infof("Can not find any release named [%s]", regex)
+	if len(listResponse.Releases) == 0 {
+		logger.Debugf("Can not find any release named [%s]", regex)
 		return nil, nil
 	}
 
 	return listResponse.Releases, nil
 }
+
+func (helmClient *Client) listReleases(ops ...helmapi.ReleaseListOption) (listResponse *rls.ListReleasesResponse, err error) {
+	err = helmClient.do(func(client *helm.Client) (err error) {
+		listResponse, err = client.ListReleases(ops...)
+		return err
+	})
+	return listResponse, err
+}
@@ -0,0 +1,299 @@
+package helm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const testNamespace = "default"
+
+// newTestClient creates a client of a new fake tiller, the tiller is stopped when the returned func is called.
+func newTestClient(t *testing.T) (*Client, func()) {
+	addr, stop := startFakeTiller(t)
+	return NewClientWithHost(addr), stop
+}
+
+func newTestChart(t *testing.T) []byte {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "demo", Version: "0.1.0", ApiVersion: "v1"},
+		Templates: []*chart.Template{
+			{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n")},
+		},
+		Values: &chart.Config{Raw: "replicaCount: 1\n"},
+	}
+	chartBytes, err := SaveChartByte(c)
+	if err != nil {
+		t.Fatalf("save chart: %v", err)
+	}
+	return chartBytes
+}
+
+func installTestRelease(t *testing.T, client *Client, chartBytes []byte, name string) *release.Release {
+	rls, err := client.InstallRelease(testNamespace, name, chartBytes, "replicaCount: 2")
+	if err != nil {
+		t.Fatalf("install release %s: %v", name, err)
+	}
+	return rls
+}
+
+func TestClientInstallRelease(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	chartBytes := newTestChart(t)
+
+	rls := installTestRelease(t, client, chartBytes, "demo-gz01-blue")
+	if rls.Version != 1 || rls.Namespace != testNamespace {
+		t.Errorf("expected version 1 in namespace %s, got version %d in %s", testNamespace, rls.Version, rls.Namespace)
+	}
+	if rls.GetConfig().GetRaw() != "replicaCount: 2" {
+		t.Errorf("expected the values to be sent to tiller, got %q", rls.GetConfig().GetRaw())
+	}
+	if rls.GetChart().GetMetadata().GetName() != "demo" {
+		t.Errorf("expected chart demo, got %q", rls.GetChart().GetMetadata().GetName())
+	}
+
+	if _, err := client.InstallRelease(testNamespace, "demo-gz01-blue", chartBytes, ""); err == nil {
+		t.Errorf("expected an error when installing an existing release")
+	}
+
+	got, err := client.GetRelease("demo-gz01-blue")
+	if err != nil {
+		t.Fatalf("get release: %v", err)
+	}
+	if got == nil || got.Version != 1 {
+		t.Errorf("expected version 1 of the release, got %v", got)
+	}
+}
+
+func TestClientUpdateRelease(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	chartBytes := newTestChart(t)
+	installTestRelease(t, client, chartBytes, "demo-gz01-blue")
+
+	rls, err := client.UpdateRelease("demo-gz01-blue", chartBytes, "replicaCount: 3")
+	if err != nil {
+		t.Fatalf("update release: %v", err)
+	}
+	if rls.Version != 2 || rls.GetConfig().GetRaw() != "replicaCount: 3" {
+		t.Errorf("expected version 2 with the new values, got version %d with %q", rls.Version, rls.GetConfig().GetRaw())
+	}
+
+	previous, err := client.GetReleaseByVersion("demo-gz01-blue", 1)
+	if err != nil {
+		t.Fatalf("get release by version: %v", err)
+	}
+	if code := previous.GetInfo().GetStatus().GetCode(); code != release.Status_SUPERSEDED {
+		t.Errorf("expected version 1 to be superseded, got %s", code)
+	}
+
+	if _, err := client.UpdateRelease("demo-gz02-blue", chartBytes, ""); err == nil {
+		t.Errorf("expected an error when updating a missing release")
+	}
+}
+
+func TestClientUninstallRelease(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	installTestRelease(t, client, newTestChart(t), "demo-gz01-blue")
+
+	if err := client.UninstallRelease("demo-gz01-blue"); err != nil {
+		t.Fatalf("uninstall release: %v", err)
+	}
+
+	rlses, err := client.FilterReleases("^demo")
+	if err != nil {
+		t.Fatalf("filter releases: %v", err)
+	}
+	if len(rlses) != 0 {
+		t.Errorf("expected no release after uninstalling, got %d", len(rlses))
+	}
+
+	_, err = client.GetReleaseByVersion("demo-gz01-blue", 0)
+	if _, ok := err.(*ReleaseNotFoundError); !ok {
+		t.Errorf("expected a ReleaseNotFoundError for the purged release, got %v", err)
+	}
+	if err := client.UninstallRelease("demo-gz01-blue"); err == nil {
+		t.Errorf("expected an error when uninstalling a missing release")
+	}
+}
+
+func TestClientFilterReleases(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	chartBytes := newTestChart(t)
+	for _, name := range []string{"demo-gz01-blue", "demo-rz01-green", "demo-gz01-canary", "other-gz01-blue"} {
+		installTestRelease(t, client, chartBytes, name)
+	}
+
+	rlses, err := client.FilterReleases("^demo(-gz|-rz).*(-blue|-green)$")
+	if err != nil {
+		t.Fatalf("filter releases: %v", err)
+	}
+
+	// The last released one comes first.
+	expected := []string{"demo-rz01-green", "demo-gz01-blue"}
+	if len(rlses) != len(expected) {
+		t.Fatalf("expected releases %v, got %d releases", expected, len(rlses))
+	}
+	for i, name := range expected {
+		if rlses[i].Name != name {
+			t.Errorf("expected release %s at %d, got %s", name, i, rlses[i].Name)
+		}
+	}
+
+	rlses, err = client.FilterReleases("^nothing$")
+	if err != nil || rlses != nil {
+		t.Errorf("expected no release and no error, got %v, %v", rlses, err)
+	}
+}
+
+func TestClientRollbackAndHistory(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	chartBytes := newTestChart(t)
+	installTestRelease(t, client, chartBytes, "demo-gz01-blue")
+	if _, err := client.UpdateRelease("demo-gz01-blue", chartBytes, "replicaCount: 3"); err != nil {
+		t.Fatalf("update release: %v", err)
+	}
+
+	rls, err := client.RollbackRelease("demo-gz01-blue", 1)
+	if err != nil {
+		t.Fatalf("rollback release: %v", err)
+	}
+	if rls.Version != 3 || rls.GetConfig().GetRaw() != "replicaCount: 2" {
+		t.Errorf("expected version 3 with the values of version 1, got version %d with %q", rls.Version, rls.GetConfig().GetRaw())
+	}
+
+	history, err := client.ReleaseHistory("demo-gz01-blue", 2)
+	if err != nil {
+		t.Fatalf("release history: %v", err)
+	}
+	if len(history) != 2 || history[0].Version != 3 || history[1].Version != 2 {
+		t.Errorf("expected the newest 2 versions, got %v", history)
+	}
+
+	_, err = client.ReleaseHistory("demo-gz02-blue", 0)
+	if _, ok := err.(*ReleaseNotFoundError); !ok {
+		t.Errorf("expected a ReleaseNotFoundError for a missing release, got %v", err)
+	}
+}
+
+func TestClientKeepLive(t *testing.T) {
+	client, stop := newTestClient(t)
+	defer stop()
+	if err := client.KeepLive(); err != nil {
+		t.Errorf("keep live: %v", err)
+	}
+	if version, err := client.TillerVersion(); err != nil || version != fakeTillerVersion {
+		t.Errorf("expected tiller version %s, got %q, %v", fakeTillerVersion, version, err)
+	}
+	// There is no tunnel to close.
+	client.Close()
+}
+
+// sequenceDialer reaches the tillers one by one each time the tunnel is rebuilt.
+func sequenceDialer(hosts ...string) (tillerDialer, *int) {
+	dials := 0
+	return func() (string, func(), error) {
+		if dials >= len(hosts) {
+			return "", nil, fmt.Errorf("can not find a running tiller pod")
+		}
+		host := hosts[dials]
+		dials++
+		return host, func() {}, nil
+	}, &dials
+}
+
+func tunnelIsUp(t *testing.T, tunnelLabels prometheus.Labels) float64 {
+	m := &dto.Metric{}
+	if err := tunnelUp.With(tunnelLabels).Write(m); err != nil {
+		t.Fatalf("write metric: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestClientRebuildsDeadTunnel(t *testing.T) {
+	deadAddr, stop := startFakeTiller(t)
+	defer stop()
+	liveAddr, stopLive := startFakeTiller(t)
+	defer stopLive()
+	dial, dials := sequenceDialer(deadAddr, liveAddr)
+	tunnelLabels := prometheus.Labels{"cluster": "rebuild", "tiller_namespace": DefaultTillerNamespace}
+	client, err := newTunnelClient(dial, tunnelLabels, wait.Backoff{Duration: time.Millisecond, Steps: 3}, 1)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	stop()
+	// The read is retried on the rebuilt tunnel.
+	if _, err := client.FilterReleases(""); err != nil {
+		t.Errorf("expected the releases to be listed on the new tiller: %v", err)
+	}
+	if *dials != 2 {
+		t.Errorf("expected the tunnel to be rebuilt once, got %d dials", *dials)
+	}
+	if up := tunnelIsUp(t, tunnelLabels); up != 1 {
+		t.Errorf("expected the tunnel to be up, got %v", up)
+	}
+}
+
+func TestClientDoesNotRetryMutations(t *testing.T) {
+	deadAddr, stop := startFakeTiller(t)
+	defer stop()
+	liveAddr, stopLive := startFakeTiller(t)
+	defer stopLive()
+	dial, dials := sequenceDialer(deadAddr, liveAddr)
+	client, err := newTunnelClient(dial, prometheus.Labels{"cluster": "mutate", "tiller_namespace": DefaultTillerNamespace},
+		wait.Backoff{Duration: time.Millisecond, Steps: 3}, 1)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	stop()
+	chartBytes := newTestChart(t)
+	// Tiller may have handled the install before the connection broke, so it is not sent again.
+	if _, err := client.InstallRelease("default", "demo-gz01-blue", chartBytes, "replicaCount: 2"); err == nil {
+		t.Errorf("expected the install to fail on the dead tunnel")
+	}
+	if *dials != 2 {
+		t.Errorf("expected the tunnel to be rebuilt for the next request, got %d dials", *dials)
+	}
+	if releases, err := client.FilterReleases(""); err != nil || len(releases) != 0 {
+		t.Errorf("expected the install not to be retried on the new tiller, got %v, %v", releases, err)
+	}
+
+	rls := installTestRelease(t, client, chartBytes, "demo-gz01-blue")
+	if rls.Version != 1 {
+		t.Errorf("expected the release to be installed on the new tiller, got version %d", rls.Version)
+	}
+}
+
+func TestClientGivesUpRebuildingTunnel(t *testing.T) {
+	deadAddr, stop := startFakeTiller(t)
+	defer stop()
+	dial, dials := sequenceDialer(deadAddr)
+	tunnelLabels := prometheus.Labels{"cluster": "give-up", "tiller_namespace": DefaultTillerNamespace}
+	client, err := newTunnelClient(dial, tunnelLabels, wait.Backoff{Duration: time.Millisecond, Steps: 3}, 1)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	stop()
+	if err := client.KeepLive(); err == nil {
+		t.Errorf("expected an error if the tunnel can not be rebuilt")
+	}
+	if *dials != 1 {
+		t.Errorf("expected no other tiller to be reached, got %d dials", *dials)
+	}
+	if up := tunnelIsUp(t, tunnelLabels); up != 0 {
+		t.Errorf("expected the tunnel to be down, got %v", up)
+	}
+}
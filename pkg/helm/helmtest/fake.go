@@ -0,0 +1,235 @@
+// Package helmtest provides an in-memory helm.ReleaseBackend for the tests of the packages using the releases.
+package helmtest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// FakeBackend is an in-memory helm.ReleaseBackend for tests, it simulates the versions and the statuses of the
+// releases without rendering or applying the charts.
+type FakeBackend struct {
+	mu sync.Mutex
+	// releases holds the versions of each release, the oldest first.
+	releases map[string][]*release.Release
+	// errors makes the operations fail, keyed by "<operation>/<release name>", or "<operation>" for all releases.
+	errors map[string]error
+	// Actions records the operations which have been called, such as "install/foo".
+	Actions []string
+}
+
+// NewFakeBackend creates a fake backend with the running releases.
+func NewFakeBackend(rlses ...*release.Release) *FakeBackend {
+	f := &FakeBackend{releases: map[string][]*release.Release{}, errors: map[string]error{}}
+	for _, rls := range rlses {
+		f.releases[rls.Name] = append(f.releases[rls.Name], rls)
+	}
+	return f
+}
+
+// NewFakeRelease creates a release with the version and the status.
+func NewFakeRelease(name, namespace string, version int32, code release.Status_Code) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   version,
+		Config:    &chart.Config{},
+		Info:      &release.Info{Status: &release.Status{Code: code}},
+	}
+}
+
+// FailOn makes the operation on the release fail with the error, an empty release name means all releases.
+func (f *FakeBackend) FailOn(operation, rlsName string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[actionKey(operation, rlsName)] = err
+}
+
+// Release returns the latest version of the release, nil if it does not exist.
+func (f *FakeBackend) Release(rlsName string) *release.Release {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.latest(rlsName)
+}
+
+func (f *FakeBackend) InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*release.Release, error) {
+	if err := f.invoke(helm.OperationInstall, releaseName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.latest(releaseName) != nil {
+		return nil, errors.Errorf("a release named %s already exists", releaseName)
+	}
+	rls := NewFakeRelease(releaseName, namespace, 1, release.Status_DEPLOYED)
+	rls.Config.Raw = raw
+	f.releases[releaseName] = append(f.releases[releaseName], rls)
+	return rls, nil
+}
+
+func (f *FakeBackend) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error) {
+	if err := f.invoke(helm.OperationUpdate, rlsName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.upgrade(rlsName, raw)
+}
+
+func (f *FakeBackend) UninstallRelease(rlsName string) error {
+	if err := f.invoke(helm.OperationUninstall, rlsName); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.latest(rlsName) == nil {
+		return notFound(rlsName)
+	}
+	delete(f.releases, rlsName)
+	return nil
+}
+
+func (f *FakeBackend) GetRelease(releaseName string) (*release.Release, error) {
+	if err := f.invoke(helm.OperationGet, releaseName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.latest(releaseName), nil
+}
+
+func (f *FakeBackend) GetReleaseByVersion(releaseName string, version int32) (*release.Release, error) {
+	if err := f.invoke(helm.OperationGet, releaseName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if version == 0 {
+		if rls := f.latest(releaseName); rls != nil {
+			return rls, nil
+		}
+		return nil, notFound(releaseName)
+	}
+	for _, rls := range f.releases[releaseName] {
+		if rls.Version == version {
+			return rls, nil
+		}
+	}
+	return nil, notFound(releaseName)
+}
+
+func (f *FakeBackend) FilterReleases(regex string) ([]*release.Release, error) {
+	if err := f.invoke(helm.OperationList, ""); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+	var rlses []*release.Release
+	for name := range f.releases {
+		if re.MatchString(name) {
+			rlses = append(rlses, f.latest(name))
+		}
+	}
+	sort.Slice(rlses, func(i, j int) bool { return rlses[i].Name < rlses[j].Name })
+	return rlses, nil
+}
+
+func (f *FakeBackend) RollbackRelease(rlsName string, version int32) (*release.Release, error) {
+	if err := f.invoke(helm.OperationRollback, rlsName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, rls := range f.releases[rlsName] {
+		if rls.Version == version {
+			return f.upgrade(rlsName, rls.GetConfig().GetRaw())
+		}
+	}
+	return nil, notFound(rlsName)
+}
+
+func (f *FakeBackend) ReleaseHistory(rlsName string, max int32) ([]*release.Release, error) {
+	if err := f.invoke(helm.OperationHistory, rlsName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	versions := f.releases[rlsName]
+	if len(versions) == 0 {
+		return nil, notFound(rlsName)
+	}
+	var history []*release.Release
+	for i := len(versions) - 1; i >= 0 && (max <= 0 || len(history) < int(max)); i-- {
+		history = append(history, versions[i])
+	}
+	return history, nil
+}
+
+func (f *FakeBackend) KeepLive() error {
+	return f.invoke(helm.OperationKeepLive, "")
+}
+
+// upgrade supersedes the latest version of the release with a new deployed one.
+func (f *FakeBackend) upgrade(rlsName string, raw string) (*release.Release, error) {
+	current := f.latest(rlsName)
+	if current == nil {
+		return nil, notFound(rlsName)
+	}
+
+	current.Info.Status.Code = release.Status_SUPERSEDED
+	rls := NewFakeRelease(rlsName, current.Namespace, current.Version+1, release.Status_DEPLOYED)
+	rls.Config.Raw = raw
+	f.releases[rlsName] = append(f.releases[rlsName], rls)
+	return rls, nil
+}
+
+func (f *FakeBackend) latest(rlsName string) *release.Release {
+	versions := f.releases[rlsName]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+// invoke records the action and returns the injected error of it.
+func (f *FakeBackend) invoke(operation, rlsName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := actionKey(operation, rlsName)
+	f.Actions = append(f.Actions, key)
+	if err, ok := f.errors[key]; ok {
+		return err
+	}
+	return f.errors[operation]
+}
+
+func actionKey(operation, rlsName string) string {
+	if rlsName == "" {
+		return operation
+	}
+	return fmt.Sprintf("%s/%s", operation, rlsName)
+}
+
+func notFound(rlsName string) error {
+	return &helm.ReleaseNotFoundError{HelmError: errors.Errorf("release: %q not found", rlsName)}
+}
@@ -0,0 +1,147 @@
+package helm
+
+import (
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"github.com/yangyongzhi/sym-operator/pkg/metrics"
+	"github.com/yangyongzhi/sym-operator/pkg/tracing"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// instrumentedBackend records the latency of each operation of the backend, and counts the installs, the updates and
+// the uninstalls of the releases of the app. Each operation is traced as a child span of the parent span, and listed by
+// RunningOperations until it returns. The operations are logged with the app, the release and the reconcile ID of the
+// parent span.
+type instrumentedBackend struct {
+	backend ReleaseBackend
+	app     string
+	parent  *tracing.Span
+}
+
+// Instrument wraps the backend to record the metrics of its operations on the releases of the app, the operations are
+// traced within the parent span unless it is nil.
+func Instrument(backend ReleaseBackend, app string, parent *tracing.Span) ReleaseBackend {
+	return &instrumentedBackend{backend: backend, app: app, parent: parent}
+}
+
+// inflight is an operation of the backend in progress.
+type inflight struct {
+	id      uint64
+	release string
+	span    *tracing.Span
+	start   time.Time
+}
+
+// start starts the span of the operation on the release and lists it in the running operations, the release name is
+// empty for the operations on no release.
+func (b *instrumentedBackend) start(operation, rlsName string) *inflight {
+	attributes := []tracing.Attribute{tracing.String("app", b.app)}
+	if rlsName != "" {
+		attributes = append(attributes, tracing.String("helm.release", rlsName))
+	}
+	start := time.Now()
+	return &inflight{
+		id:      running.add(RunningOperation{App: b.app, Operation: operation, Release: rlsName, Started: start}),
+		release: rlsName,
+		span:    b.parent.StartChild("helm."+operation, attributes...),
+		start:   start,
+	}
+}
+
+func (b *instrumentedBackend) observe(op *inflight, operation string, err error) {
+	running.remove(op.id)
+	op.span.End(err)
+	duration := time.Since(op.start)
+	metrics.HelmOperationDuration.WithLabelValues(operation, metrics.Result(err)).Observe(duration.Seconds())
+	switch operation {
+	case OperationInstall, OperationUpdate, OperationUninstall:
+		metrics.ReleaseOperations.WithLabelValues(b.app, operation, metrics.Result(err)).Inc()
+	}
+
+	entry := b.logFor(op, operation)
+	switch {
+	case err != nil:
+		entry.Errorf("Helm %s has an error after %s : %s", operation, duration.Round(time.Millisecond), err.Error())
+	case operation == OperationInstall || operation == OperationUpdate || operation == OperationUninstall ||
+		operation == OperationRollback:
+		entry.Infof("Helm %s succeeded in %s", operation, duration.Round(time.Millisecond))
+	default:
+		entry.Debugf("Helm %s succeeded in %s", operation, duration.Round(time.Millisecond))
+	}
+}
+
+// logFor returns the logger of the operation, the reconcile ID is the trace ID of the parent span.
+func (b *instrumentedBackend) logFor(op *inflight, operation string) *log.Entry {
+	fields := log.Fields{log.FieldComponent: "helm", log.FieldApp: b.app, log.FieldOperation: operation}
+	if op.release != "" {
+		fields[log.FieldRelease] = op.release
+	}
+	if traceID := b.parent.TraceID(); traceID.IsValid() {
+		fields[log.FieldReconcileID] = traceID.String()
+	}
+	return log.WithFields(fields)
+}
+
+func (b *instrumentedBackend) InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*release.Release, error) {
+	op := b.start(OperationInstall, releaseName)
+	rls, err := b.backend.InstallRelease(namespace, releaseName, chartBytes, raw)
+	b.observe(op, OperationInstall, err)
+	return rls, err
+}
+
+func (b *instrumentedBackend) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error) {
+	op := b.start(OperationUpdate, rlsName)
+	rls, err := b.backend.UpdateRelease(rlsName, chartBytes, raw)
+	b.observe(op, OperationUpdate, err)
+	return rls, err
+}
+
+func (b *instrumentedBackend) UninstallRelease(rlsName string) error {
+	op := b.start(OperationUninstall, rlsName)
+	err := b.backend.UninstallRelease(rlsName)
+	b.observe(op, OperationUninstall, err)
+	return err
+}
+
+func (b *instrumentedBackend) GetRelease(releaseName string) (*release.Release, error) {
+	op := b.start(OperationGet, releaseName)
+	rls, err := b.backend.GetRelease(releaseName)
+	b.observe(op, OperationGet, err)
+	return rls, err
+}
+
+func (b *instrumentedBackend) GetReleaseByVersion(releaseName string, version int32) (*release.Release, error) {
+	op := b.start(OperationGet, releaseName)
+	rls, err := b.backend.GetReleaseByVersion(releaseName, version)
+	b.observe(op, OperationGet, err)
+	return rls, err
+}
+
+func (b *instrumentedBackend) FilterReleases(regex string) ([]*release.Release, error) {
+	op := b.start(OperationList, "")
+	rlses, err := b.backend.FilterReleases(regex)
+	b.observe(op, OperationList, err)
+	return rlses, err
+}
+
+func (b *instrumentedBackend) RollbackRelease(rlsName string, version int32) (*release.Release, error) {
+	op := b.start(OperationRollback, rlsName)
+	rls, err := b.backend.RollbackRelease(rlsName, version)
+	b.observe(op, OperationRollback, err)
+	return rls, err
+}
+
+func (b *instrumentedBackend) ReleaseHistory(rlsName string, max int32) ([]*release.Release, error) {
+	op := b.start(OperationHistory, rlsName)
+	rlses, err := b.backend.ReleaseHistory(rlsName, max)
+	b.observe(op, OperationHistory, err)
+	return rlses, err
+}
+
+func (b *instrumentedBackend) KeepLive() error {
+	op := b.start(OperationKeepLive, "")
+	err := b.backend.KeepLive()
+	b.observe(op, OperationKeepLive, err)
+	return err
+}
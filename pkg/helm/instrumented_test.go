@@ -0,0 +1,129 @@
+package helm_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	"github.com/yangyongzhi/sym-operator/pkg/metrics"
+	"github.com/yangyongzhi/sym-operator/pkg/tracing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func releaseOperations(t *testing.T, app, operation, result string) float64 {
+	m := &dto.Metric{}
+	if err := metrics.ReleaseOperations.WithLabelValues(app, operation, result).Write(m); err != nil {
+		t.Fatalf("write metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestInstrument(t *testing.T) {
+	backend := helmtest.NewFakeBackend()
+	backend.FailOn(helm.OperationUpdate, "demo-gz01-blue", fmt.Errorf("timed out"))
+	exporter := &recordingExporter{}
+	tracer := tracing.NewTracer(exporter)
+	sync := tracer.Start("sync", "default/demo")
+	instrumented := helm.Instrument(backend, "instrumented-demo", sync)
+
+	if _, err := instrumented.InstallRelease(metav1.NamespaceDefault, "demo-gz01-blue", nil, ""); err != nil {
+		t.Fatalf("install release: %v", err)
+	}
+	if _, err := instrumented.UpdateRelease("demo-gz01-blue", nil, ""); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	if _, err := instrumented.FilterReleases("^demo"); err != nil {
+		t.Fatalf("filter releases: %v", err)
+	}
+
+	for _, c := range []struct {
+		operation, result string
+		expected          float64
+	}{
+		{helm.OperationInstall, metrics.ResultSuccess, 1},
+		{helm.OperationUpdate, metrics.ResultError, 1},
+		{helm.OperationUpdate, metrics.ResultSuccess, 0},
+		// Only the operations changing the releases are counted.
+		{helm.OperationList, metrics.ResultSuccess, 0},
+	} {
+		if value := releaseOperations(t, "instrumented-demo", c.operation, c.result); value != c.expected {
+			t.Errorf("expected %v %s operations with result %s, got %v", c.expected, c.operation, c.result, value)
+		}
+	}
+	if expected := []string{"install/demo-gz01-blue", "update/demo-gz01-blue", "list"}; !reflect.DeepEqual(backend.Actions, expected) {
+		t.Errorf("expected the operations %v to reach the backend, got %v", expected, backend.Actions)
+	}
+
+	sync.End(nil)
+	tracer.Flush()
+	var spans []string
+	for _, span := range exporter.spans {
+		if span.TraceID != sync.TraceID() {
+			t.Errorf("expected span %s in the trace %s, got %s", span.Name, sync.TraceID(), span.TraceID)
+		}
+		spans = append(spans, fmt.Sprintf("%s:%v", span.Name, span.Err != nil))
+	}
+	if expected := []string{"helm.install:false", "helm.update:true", "helm.list:false", "default/demo:false"}; !reflect.DeepEqual(spans, expected) {
+		t.Errorf("expected the spans %v, got %v", expected, spans)
+	}
+}
+
+type recordingExporter struct {
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) ExportSpans(spans []tracing.SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// blockingBackend blocks the updates until they are released.
+type blockingBackend struct {
+	*helmtest.FakeBackend
+	updating chan struct{}
+	release  chan struct{}
+}
+
+func (b *blockingBackend) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error) {
+	b.updating <- struct{}{}
+	<-b.release
+	return b.FakeBackend.UpdateRelease(rlsName, chartBytes, raw)
+}
+
+func TestRunningOperations(t *testing.T) {
+	backend := &blockingBackend{FakeBackend: helmtest.NewFakeBackend(), updating: make(chan struct{}),
+		release: make(chan struct{})}
+	instrumented := helm.Instrument(backend, "running-demo", nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		instrumented.UpdateRelease("running-demo-gz01-blue", nil, "")
+	}()
+	<-backend.updating
+
+	var found bool
+	for _, operation := range helm.RunningOperations() {
+		if operation.App == "running-demo" {
+			found = true
+			if operation.Operation != helm.OperationUpdate || operation.Release != "running-demo-gz01-blue" || operation.Started.IsZero() {
+				t.Errorf("expected the update of running-demo-gz01-blue to be running, got %+v", operation)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the update to be running, got %v", helm.RunningOperations())
+	}
+
+	close(backend.release)
+	<-done
+	for _, operation := range helm.RunningOperations() {
+		if operation.App == "running-demo" {
+			t.Errorf("expected no running operation once the update returned, got %+v", operation)
+		}
+	}
+}
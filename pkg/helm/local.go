@@ -0,0 +1,585 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/releaseutil"
+	"k8s.io/helm/pkg/renderutil"
+	"sigs.k8s.io/yaml"
+)
+
+const hookAnnotation = "helm.sh/hook"
+
+// installOrder is the order in which the manifests are applied, the same as tiller,
+// the kinds not listed here are applied at last.
+var installOrder = []string{
+	"Namespace",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ServiceAccount",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// LocalBackend renders the charts in-process and applies the manifests with the kubernetes client,
+// the releases are stored as secrets so tiller is not needed at all. The hooks of the charts are not supported.
+type LocalBackend struct {
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	storage       *SecretStorage
+
+	mapperLock sync.Mutex
+	mapper     meta.RESTMapper
+}
+
+// NewLocalBackend creates a tiller-less backend which stores the releases in the storage namespace.
+func NewLocalBackend(cfg *restclient.Config, kubeClient kubernetes.Interface, storageNamespace string) (*LocalBackend, error) {
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dynamic client")
+	}
+
+	return &LocalBackend{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		storage:       NewSecretStorage(kubeClient, storageNamespace),
+	}, nil
+}
+
+// Install a release.
+func (b *LocalBackend) InstallRelease(namespace string, releaseName string, chartBytes []byte, raw string) (*release.Release, error) {
+	requestedChart, err := chartutil.LoadArchive(bytes.NewReader(chartBytes))
+	if err != nil {
+		logger.Errorf("Load archive when you want to install a release has an error : %s", err.Error())
+		return nil, err
+	}
+
+	history, err := b.storage.History(releaseName)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > 0 {
+		return nil, errors.Errorf("a release named %s already exists", releaseName)
+	}
+
+	rls := newRelease(releaseName, namespace, requestedChart, raw, 1, "Install complete")
+	return rls, b.deploy(rls, nil)
+}
+
+// Update a release.
+func (b *LocalBackend) UpdateRelease(rlsName string, chartBytes []byte, raw string) (*release.Release, error) {
+	requestedChart, err := chartutil.LoadArchive(bytes.NewReader(chartBytes))
+	if err != nil {
+		logger.Errorf("Load archive when you want to update a release has an error : %s", err.Error())
+		return nil, err
+	}
+
+	current, err := b.GetReleaseByVersion(rlsName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rls := newRelease(rlsName, current.Namespace, requestedChart, raw, current.Version+1, "Upgrade complete")
+	rls.Info.FirstDeployed = current.GetInfo().GetFirstDeployed()
+	return rls, b.deploy(rls, current)
+}
+
+// Rollback a release to the version.
+func (b *LocalBackend) RollbackRelease(rlsName string, version int32) (*release.Release, error) {
+	target, err := b.storage.Get(rlsName, version)
+	if err != nil {
+		return nil, err
+	}
+	current, err := b.GetReleaseByVersion(rlsName, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rls := newRelease(rlsName, current.Namespace, target.Chart, target.GetConfig().GetRaw(), current.Version+1,
+		fmt.Sprintf("Rollback to %d", version))
+	rls.Info.FirstDeployed = current.GetInfo().GetFirstDeployed()
+	return rls, b.deploy(rls, current)
+}
+
+// Delete a release, all of its resources and versions are purged.
+func (b *LocalBackend) UninstallRelease(rlsName string) error {
+	current, err := b.GetReleaseByVersion(rlsName, 0)
+	if err != nil {
+		logger.WithField(log.FieldRelease, rlsName).Errorf("Delete the release [%s] has an error : %s", rlsName, err.Error())
+		return err
+	}
+
+	objs, err := parseManifest(current.Manifest)
+	if err != nil {
+		return err
+	}
+	for i := len(objs) - 1; i >= 0; i-- {
+		if err := b.delete(objs[i], current.Namespace); err != nil {
+			logger.WithField(log.FieldRelease, rlsName).Errorf("Delete the release [%s] has an error : %s", rlsName, err.Error())
+			return err
+		}
+	}
+
+	return b.storage.Delete(rlsName)
+}
+
+// Find the release with its name.
+func (b *LocalBackend) GetRelease(releaseName string) (*release.Release, error) {
+	rls, err := b.GetReleaseByVersion(releaseName, 0)
+	if _, ok := err.(*ReleaseNotFoundError); ok {
+		logger.WithField(log.FieldRelease, releaseName).Debugf("Can not find any release named [%s]", releaseName)
+		return nil, nil
+	}
+	return rls, err
+}
+
+// GetReleaseByVersion returns the version of a release, the latest one if version is 0.
+func (b *LocalBackend) GetReleaseByVersion(releaseName string, version int32) (*release.Release, error) {
+	if version != 0 {
+		return b.storage.Get(releaseName, version)
+	}
+
+	history, err := b.storage.History(releaseName)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, &ReleaseNotFoundError{HelmError: errors.Errorf("release: %q not found", releaseName)}
+	}
+	return history[0], nil
+}
+
+// Filter the releases what you want to find, the last released one comes first.
+func (b *LocalBackend) FilterReleases(regex string) ([]*release.Release, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := b.storage.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	var rlses []*release.Release
+	for _, rls := range latest {
+		code := rls.GetInfo().GetStatus().GetCode()
+		if code == release.Status_SUPERSEDED || code == release.Status_DELETED || code == release.Status_UNKNOWN {
+			continue
+		}
+		if re.MatchString(rls.Name) {
+			rlses = append(rlses, rls)
+		}
+	}
+
+	releaseutil.Reverse(rlses, releaseutil.SortByDate)
+	return rlses, nil
+}
+
+// ReleaseHistory returns the versions of a release, the newest first.
+func (b *LocalBackend) ReleaseHistory(rlsName string, max int32) ([]*release.Release, error) {
+	history, err := b.storage.History(rlsName)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, &ReleaseNotFoundError{HelmError: errors.Errorf("release: %q not found", rlsName)}
+	}
+
+	if max > 0 && int(max) < len(history) {
+		history = history[:max]
+	}
+	return history, nil
+}
+
+// KeepLive checks whether the kubernetes API is reachable.
+func (b *LocalBackend) KeepLive() error {
+	_, err := b.kubeClient.Discovery().ServerVersion()
+	return err
+}
+
+func newRelease(name, namespace string, c *chart.Chart, raw string, version int32, description string) *release.Release {
+	now := ptypes.TimestampNow()
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     c,
+		Config:    &chart.Config{Raw: raw},
+		Version:   version,
+		Info: &release.Info{
+			FirstDeployed: now,
+			LastDeployed:  now,
+			Description:   description,
+			Status:        &release.Status{Code: release.Status_PENDING_INSTALL},
+		},
+	}
+}
+
+// deploy renders the release and applies its manifests, the resources which only exist in the previous version
+// are deleted. The release is stored as deployed or failed according to the result.
+func (b *LocalBackend) deploy(rls *release.Release, previous *release.Release) error {
+	if previous != nil {
+		rls.Info.Status.Code = release.Status_PENDING_UPGRADE
+	}
+	if err := b.render(rls); err != nil {
+		return err
+	}
+	if err := b.storage.Create(rls); err != nil {
+		return err
+	}
+
+	err := b.apply(rls, previous)
+	if err != nil {
+		rls.Info.Status.Code = release.Status_FAILED
+		rls.Info.Description = fmt.Sprintf("Release %s failed: %s", rls.Name, err.Error())
+		logger.WithField(log.FieldRelease, rls.Name).Errorf("Deploy the release [%s] version [%d] has an error : %s", rls.Name, rls.Version, err.Error())
+	} else {
+		rls.Info.Status.Code = release.Status_DEPLOYED
+	}
+	if updateErr := b.storage.Update(rls); updateErr != nil {
+		return updateErr
+	}
+
+	if err == nil && previous != nil {
+		previous.Info.Status.Code = release.Status_SUPERSEDED
+		if err := b.storage.Update(previous); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// render renders the chart of the release and fills its manifest and notes.
+func (b *LocalBackend) render(rls *release.Release) error {
+	options := renderutil.Options{
+		ReleaseOptions: chartutil.ReleaseOptions{
+			Name:      rls.Name,
+			Time:      rls.Info.LastDeployed,
+			Namespace: rls.Namespace,
+			IsInstall: rls.Version == 1,
+			IsUpgrade: rls.Version > 1,
+			Revision:  int(rls.Version),
+		},
+	}
+	if version, err := b.kubeClient.Discovery().ServerVersion(); err == nil {
+		options.KubeVersion = fmt.Sprintf("%s.%s", version.Major, strings.TrimSuffix(version.Minor, "+"))
+	}
+
+	rendered, err := renderutil.Render(rls.Chart, rls.Config, options)
+	if err != nil {
+		return errors.Wrapf(err, "render release %s", rls.Name)
+	}
+
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var manifests []manifestDoc
+	for _, name := range names {
+		content := rendered[name]
+		base := path.Base(name)
+		if strings.HasSuffix(name, "NOTES.txt") {
+			// Only the notes of the top chart are kept.
+			if name == path.Join(rls.Chart.GetMetadata().GetName(), "templates", "NOTES.txt") {
+				rls.Info.Status.Notes = content
+			}
+			continue
+		}
+		if strings.HasPrefix(base, "_") || strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		for _, doc := range releaseutil.SplitManifests(content) {
+			obj, err := decodeManifest(doc)
+			if err != nil {
+				return errors.Wrapf(err, "decode manifest of template %s", name)
+			}
+			if obj == nil {
+				continue
+			}
+			if _, ok := obj.GetAnnotations()[hookAnnotation]; ok {
+				logger.WithField(log.FieldRelease, rls.Name).Warningf("Hook [%s] of release [%s] is skipped, hooks are not supported without tiller.", name, rls.Name)
+				continue
+			}
+			manifests = append(manifests, manifestDoc{source: name, kind: obj.GetKind(), content: doc})
+		}
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool { return kindOrder(manifests[i].kind) < kindOrder(manifests[j].kind) })
+	var buf bytes.Buffer
+	for _, m := range manifests {
+		fmt.Fprintf(&buf, "---\n# Source: %s\n%s\n", m.source, strings.TrimSpace(m.content))
+	}
+	rls.Manifest = buf.String()
+	return nil
+}
+
+// apply creates or patches the resources of the release, and deletes the ones which are not used any more.
+func (b *LocalBackend) apply(rls *release.Release, previous *release.Release) error {
+	objs, err := parseManifest(rls.Manifest)
+	if err != nil {
+		return err
+	}
+
+	var previousObjs []*unstructured.Unstructured
+	originals := map[string]*unstructured.Unstructured{}
+	if previous != nil {
+		if previousObjs, err = parseManifest(previous.Manifest); err != nil {
+			return err
+		}
+		for _, obj := range previousObjs {
+			originals[objectKey(obj, previous.Namespace)] = obj
+		}
+	}
+
+	applied := map[string]bool{}
+	for _, obj := range objs {
+		key := objectKey(obj, rls.Namespace)
+		applied[key] = true
+		if err := b.createOrPatch(obj, originals[key], rls.Namespace); err != nil {
+			return err
+		}
+	}
+
+	for i := len(previousObjs) - 1; i >= 0; i-- {
+		if applied[objectKey(previousObjs[i], previous.Namespace)] {
+			continue
+		}
+		if err := b.delete(previousObjs[i], previous.Namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createOrPatch creates the object, or patches the live one with the changes from the original object
+// of the previous manifest, which is nil if the object is new to the release.
+func (b *LocalBackend) createOrPatch(obj, original *unstructured.Unstructured, namespace string) error {
+	client, err := b.resourceClient(obj, namespace)
+	if err != nil {
+		return err
+	}
+
+	existing, err := client.Get(obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.Create(obj, metav1.CreateOptions{})
+		return errors.Wrapf(err, "create %s %s", obj.GetKind(), obj.GetName())
+	}
+	if err != nil {
+		return errors.Wrapf(err, "get %s %s", obj.GetKind(), obj.GetName())
+	}
+
+	patch, patchType, err := createPatch(original, obj, existing)
+	if err != nil {
+		return errors.Wrapf(err, "create patch of %s %s", obj.GetKind(), obj.GetName())
+	}
+	if string(patch) == "{}" {
+		return nil
+	}
+	_, err = client.Patch(obj.GetName(), patchType, patch, metav1.UpdateOptions{})
+	return errors.Wrapf(err, "patch %s %s", obj.GetKind(), obj.GetName())
+}
+
+// createPatch computes a three-way merge patch from the original, the modified and the current object as tiller does,
+// so the fields defaulted by the API server or set by other controllers, such as the cluster IP of a service or the
+// replicas scaled by an HPA, are kept unless the chart changes them. The kinds known by the kubernetes scheme get
+// a strategic merge patch, the others such as custom resources get a JSON merge patch.
+func createPatch(original, modified, current *unstructured.Unstructured) ([]byte, types.PatchType, error) {
+	var originalData []byte
+	if original != nil {
+		data, err := original.MarshalJSON()
+		if err != nil {
+			return nil, "", err
+		}
+		originalData = data
+	}
+	modifiedData, err := modified.MarshalJSON()
+	if err != nil {
+		return nil, "", err
+	}
+	currentData, err := current.MarshalJSON()
+	if err != nil {
+		return nil, "", err
+	}
+
+	versioned, err := scheme.Scheme.New(modified.GroupVersionKind())
+	if err != nil {
+		patch, err := jsonmergepatch.CreateThreeWayJSONMergePatch(originalData, modifiedData, currentData)
+		return patch, types.MergePatchType, err
+	}
+	patchMeta, err := strategicpatch.NewPatchMetaFromStruct(versioned)
+	if err != nil {
+		return nil, "", err
+	}
+	patch, err := strategicpatch.CreateThreeWayMergePatch(originalData, modifiedData, currentData, patchMeta, true)
+	return patch, types.StrategicMergePatchType, err
+}
+
+func (b *LocalBackend) delete(obj *unstructured.Unstructured, namespace string) error {
+	client, err := b.resourceClient(obj, namespace)
+	if err != nil {
+		return err
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err = client.Delete(obj.GetName(), &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "delete %s %s", obj.GetKind(), obj.GetName())
+	}
+	return nil
+}
+
+// resourceClient returns the dynamic client of the object, the namespace of the release is used
+// if the namespaced object has no namespace.
+func (b *LocalBackend) resourceClient(obj *unstructured.Unstructured, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := b.restMapping(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return b.dynamicClient.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	}
+	return b.dynamicClient.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// restMapping finds the resource of the object, the discovery information is refreshed once if the kind is unknown.
+func (b *LocalBackend) restMapping(obj *unstructured.Unstructured) (*meta.RESTMapping, error) {
+	b.mapperLock.Lock()
+	defer b.mapperLock.Unlock()
+
+	gvk := obj.GroupVersionKind()
+	for refreshed := false; ; refreshed = true {
+		if b.mapper == nil || refreshed {
+			groupResources, err := restmapper.GetAPIGroupResources(b.kubeClient.Discovery())
+			if err != nil {
+				return nil, errors.Wrap(err, "discover API resources")
+			}
+			b.mapper = restmapper.NewDiscoveryRESTMapper(groupResources)
+		}
+
+		mapping, err := b.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err == nil {
+			return mapping, nil
+		}
+		if !meta.IsNoMatchError(err) || refreshed {
+			return nil, errors.Wrapf(err, "find resource of %s", gvk.String())
+		}
+	}
+}
+
+type manifestDoc struct {
+	source  string
+	kind    string
+	content string
+}
+
+func kindOrder(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+func parseManifest(manifest string) ([]*unstructured.Unstructured, error) {
+	docs := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	// The keys are named as manifest-<index>, sort them by the index to keep the order.
+	sort.Slice(keys, func(i, j int) bool {
+		var a, b int
+		fmt.Sscanf(keys[i], "manifest-%d", &a)
+		fmt.Sscanf(keys[j], "manifest-%d", &b)
+		return a < b
+	})
+
+	var objs []*unstructured.Unstructured
+	for _, key := range keys {
+		obj, err := decodeManifest(docs[key])
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+func decodeManifest(doc string) (*unstructured.Unstructured, error) {
+	b, err := yaml.YAMLToJSON([]byte(doc))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 || string(b) == "null" {
+		return nil, nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// objectKey identifies an object of the release, the objects without namespace are keyed by the release namespace.
+func objectKey(obj *unstructured.Unstructured, namespace string) string {
+	if obj.GetNamespace() != "" {
+		namespace = obj.GetNamespace()
+	}
+	return fmt.Sprintf("%s/%s/%s", obj.GroupVersionKind().GroupKind().String(), namespace, obj.GetName())
+}
@@ -0,0 +1,282 @@
+package helm
+
+import (
+	"fmt"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const localStorageNamespace = "kube-system"
+
+var (
+	configMapsResource  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	servicesResource    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	deploymentsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+func newTestLocalBackend() (*LocalBackend, *dynamicfake.FakeDynamicClient) {
+	kubeClient := k8sfake.NewSimpleClientset()
+	discovery := kubeClient.Discovery().(*fakediscovery.FakeDiscovery)
+	discovery.FakedServerVersion = &version.Info{Major: "1", Minor: "13+"}
+	discovery.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Namespaced: true, Kind: "ConfigMap"},
+			{Name: "services", Namespaced: true, Kind: "Service"},
+		}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Namespaced: true, Kind: "Deployment"},
+		}},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	tracker := k8stesting.NewObjectTracker(runtime.NewScheme(), serializer.NewCodecFactory(runtime.NewScheme()).UniversalDecoder())
+	dynamicClient.PrependReactor("*", "*", patchReaction(tracker))
+	return &LocalBackend{
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		storage:       NewSecretStorage(kubeClient, localStorageNamespace),
+	}, dynamicClient
+}
+
+// patchReaction serves the objects from the tracker, the patches are applied to the unstructured objects with
+// the typed objects of their kinds as the API server does, which the fake dynamic client can not do by itself.
+func patchReaction(tracker k8stesting.ObjectTracker) k8stesting.ReactionFunc {
+	reaction := k8stesting.ObjectReaction(tracker)
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction, ok := action.(k8stesting.PatchAction)
+		if !ok {
+			return reaction(action)
+		}
+
+		obj, err := tracker.Get(action.GetResource(), action.GetNamespace(), patchAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		current, err := obj.(*unstructured.Unstructured).MarshalJSON()
+		if err != nil {
+			return true, nil, err
+		}
+
+		var patched []byte
+		switch patchAction.GetPatchType() {
+		case types.StrategicMergePatchType:
+			typed, err := scheme.Scheme.New(obj.GetObjectKind().GroupVersionKind())
+			if err != nil {
+				return true, nil, err
+			}
+			patched, err = strategicpatch.StrategicMergePatch(current, patchAction.GetPatch(), typed)
+			if err != nil {
+				return true, nil, err
+			}
+		case types.MergePatchType:
+			patched, err = jsonpatch.MergePatch(current, patchAction.GetPatch())
+			if err != nil {
+				return true, nil, err
+			}
+		default:
+			return true, nil, fmt.Errorf("patch type %s is not supported", patchAction.GetPatchType())
+		}
+
+		result := &unstructured.Unstructured{}
+		if err := result.UnmarshalJSON(patched); err != nil {
+			return true, nil, err
+		}
+		return true, result, tracker.Update(action.GetResource(), result, action.GetNamespace())
+	}
+}
+
+// newLocalTestChart renders a deployment and a configmap, the service is only rendered if it is enabled,
+// the replicas of the deployment are left to the HPA if autoscaling is enabled.
+func newLocalTestChart(t *testing.T) []byte {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "demo", Version: "0.1.0", ApiVersion: "v1"},
+		Templates: []*chart.Template{
+			{Name: "templates/deployment.yaml", Data: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: {{ .Release.Name }}\n" +
+				"spec:\n  minReadySeconds: 10\n{{ if not .Values.autoscaling }}  replicas: {{ .Values.replicaCount }}\n{{ end }}")},
+			{Name: "templates/configmap.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n")},
+			{Name: "templates/service.yaml", Data: []byte("{{ if .Values.service }}apiVersion: v1\nkind: Service\nmetadata:\n" +
+				"  name: {{ .Release.Name }}\nspec:\n  ports:\n  - port: {{ .Values.port }}\n{{ end }}")},
+			{Name: "templates/hook.yaml", Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}-hook\n" +
+				"  annotations:\n    helm.sh/hook: pre-install\n")},
+		},
+		Values: &chart.Config{Raw: "replicaCount: 1\nautoscaling: false\nservice: false\nport: 80\n"},
+	}
+	chartBytes, err := SaveChartByte(c)
+	if err != nil {
+		t.Fatalf("save chart: %v", err)
+	}
+	return chartBytes
+}
+
+// objectExists tells whether the object of the release has been applied in the test namespace.
+func objectExists(client *dynamicfake.FakeDynamicClient, resource schema.GroupVersionResource, name string) bool {
+	_, err := client.Resource(resource).Namespace(testNamespace).Get(name, metav1.GetOptions{})
+	return err == nil
+}
+
+func TestLocalBackendInstallRelease(t *testing.T) {
+	backend, dynamicClient := newTestLocalBackend()
+	chartBytes := newLocalTestChart(t)
+
+	rls, err := backend.InstallRelease(testNamespace, "demo-gz01-blue", chartBytes, "replicaCount: 2")
+	if err != nil {
+		t.Fatalf("install release: %v", err)
+	}
+	if rls.Version != 1 || rls.GetInfo().GetStatus().GetCode() != release.Status_DEPLOYED {
+		t.Errorf("expected version 1 to be deployed, got version %d %s", rls.Version, rls.GetInfo().GetStatus().GetCode())
+	}
+
+	deployment, err := dynamicClient.Resource(deploymentsResource).Namespace(testNamespace).Get("demo-gz01-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the deployment to be created: %v", err)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas"); replicas != 2 {
+		t.Errorf("expected the values to be rendered with 2 replicas, got %d", replicas)
+	}
+	if !objectExists(dynamicClient, configMapsResource, "demo-gz01-blue") {
+		t.Errorf("expected the configmap to be created")
+	}
+	if objectExists(dynamicClient, servicesResource, "demo-gz01-blue") {
+		t.Errorf("expected the disabled service not to be created")
+	}
+	if objectExists(dynamicClient, configMapsResource, "demo-gz01-blue-hook") {
+		t.Errorf("expected the hook to be skipped")
+	}
+
+	if _, err := backend.InstallRelease(testNamespace, "demo-gz01-blue", chartBytes, ""); err == nil {
+		t.Errorf("expected an error installing an existing release")
+	}
+}
+
+func TestLocalBackendUpdateReleasePrunesRemovedObjects(t *testing.T) {
+	backend, dynamicClient := newTestLocalBackend()
+	chartBytes := newLocalTestChart(t)
+	if _, err := backend.InstallRelease(testNamespace, "demo-gz01-blue", chartBytes, "service: true"); err != nil {
+		t.Fatalf("install release: %v", err)
+	}
+	if !objectExists(dynamicClient, servicesResource, "demo-gz01-blue") {
+		t.Fatalf("expected the enabled service to be created")
+	}
+
+	rls, err := backend.UpdateRelease("demo-gz01-blue", chartBytes, "replicaCount: 3")
+	if err != nil {
+		t.Fatalf("update release: %v", err)
+	}
+	if rls.Version != 2 || rls.GetInfo().GetStatus().GetCode() != release.Status_DEPLOYED {
+		t.Errorf("expected version 2 to be deployed, got version %d %s", rls.Version, rls.GetInfo().GetStatus().GetCode())
+	}
+	if objectExists(dynamicClient, servicesResource, "demo-gz01-blue") {
+		t.Errorf("expected the service removed from the chart to be deleted")
+	}
+	deployment, err := dynamicClient.Resource(deploymentsResource).Namespace(testNamespace).Get("demo-gz01-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the deployment to be kept: %v", err)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas"); replicas != 3 {
+		t.Errorf("expected the deployment to be updated to 3 replicas, got %d", replicas)
+	}
+
+	previous, err := backend.GetReleaseByVersion("demo-gz01-blue", 1)
+	if err != nil {
+		t.Fatalf("get version 1: %v", err)
+	}
+	if previous.GetInfo().GetStatus().GetCode() != release.Status_SUPERSEDED {
+		t.Errorf("expected version 1 to be superseded, got %s", previous.GetInfo().GetStatus().GetCode())
+	}
+}
+
+func TestLocalBackendUpdateReleaseKeepsLiveFields(t *testing.T) {
+	backend, dynamicClient := newTestLocalBackend()
+	chartBytes := newLocalTestChart(t)
+	if _, err := backend.InstallRelease(testNamespace, "demo-gz01-blue", chartBytes, "service: true\nautoscaling: true"); err != nil {
+		t.Fatalf("install release: %v", err)
+	}
+
+	// The API server allocates the cluster IP of the service and an HPA scales the deployment.
+	services := dynamicClient.Resource(servicesResource).Namespace(testNamespace)
+	service, err := services.Get("demo-gz01-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	if err := unstructured.SetNestedField(service.Object, "10.0.0.10", "spec", "clusterIP"); err != nil {
+		t.Fatalf("set cluster IP: %v", err)
+	}
+	if _, err := services.Update(service, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update service: %v", err)
+	}
+	deployments := dynamicClient.Resource(deploymentsResource).Namespace(testNamespace)
+	deployment, err := deployments.Get("demo-gz01-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get deployment: %v", err)
+	}
+	if err := unstructured.SetNestedField(deployment.Object, int64(5), "spec", "replicas"); err != nil {
+		t.Fatalf("set replicas: %v", err)
+	}
+	if _, err := deployments.Update(deployment, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update deployment: %v", err)
+	}
+
+	if _, err := backend.UpdateRelease("demo-gz01-blue", chartBytes, "service: true\nautoscaling: true\nport: 8080"); err != nil {
+		t.Fatalf("update release: %v", err)
+	}
+
+	service, err = services.Get("demo-gz01-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	if clusterIP, _, _ := unstructured.NestedString(service.Object, "spec", "clusterIP"); clusterIP != "10.0.0.10" {
+		t.Errorf("expected the cluster IP to be kept, got %q", clusterIP)
+	}
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	if len(ports) != 1 || ports[0].(map[string]interface{})["port"] != int64(8080) {
+		t.Errorf("expected the service port to be changed to 8080, got %v", ports)
+	}
+	deployment, err = deployments.Get("demo-gz01-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get deployment: %v", err)
+	}
+	if replicas, _, _ := unstructured.NestedInt64(deployment.Object, "spec", "replicas"); replicas != 5 {
+		t.Errorf("expected the replicas scaled by the HPA to be kept, got %d", replicas)
+	}
+}
+
+func TestLocalBackendUninstallRelease(t *testing.T) {
+	backend, dynamicClient := newTestLocalBackend()
+	chartBytes := newLocalTestChart(t)
+	if _, err := backend.InstallRelease(testNamespace, "demo-gz01-blue", chartBytes, "service: true"); err != nil {
+		t.Fatalf("install release: %v", err)
+	}
+	if _, err := backend.UpdateRelease("demo-gz01-blue", chartBytes, "service: true"); err != nil {
+		t.Fatalf("update release: %v", err)
+	}
+
+	if err := backend.UninstallRelease("demo-gz01-blue"); err != nil {
+		t.Fatalf("uninstall release: %v", err)
+	}
+	for _, resource := range []schema.GroupVersionResource{deploymentsResource, configMapsResource, servicesResource} {
+		if objectExists(dynamicClient, resource, "demo-gz01-blue") {
+			t.Errorf("expected the %s of the release to be deleted", resource.Resource)
+		}
+	}
+	if rls, err := backend.GetRelease("demo-gz01-blue"); err != nil || rls != nil {
+		t.Errorf("expected all versions to be purged, got %v, %v", rls, err)
+	}
+	if err := backend.UninstallRelease("demo-gz01-blue"); err == nil {
+		t.Errorf("expected an error uninstalling a missing release")
+	}
+}
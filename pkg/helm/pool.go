@@ -0,0 +1,240 @@
+package helm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// BackendFactory creates the backend which talks to the tiller in the namespace, the stop channel is closed once
+// the backend is evicted from the pool.
+type BackendFactory func(tillerNamespace string, stopCh <-chan struct{}) (ReleaseBackend, error)
+
+// ClientPool keeps a release backend for each tiller, they are created lazily, checked periodically and closed
+// once they are unhealthy or have not been used for a while. A backend evicted while it is checked out is closed
+// once the last caller has released it.
+type ClientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+
+	newBackend       BackendFactory
+	defaultNamespace string
+	idleTimeout      time.Duration
+	// shared is used for all tillers if it has been set, e.g. a tiller-less backend.
+	shared ReleaseBackend
+}
+
+type pooledClient struct {
+	backend  ReleaseBackend
+	lastUsed time.Time
+	stopCh   chan struct{}
+	// ready is closed once the backend has been created, err is set if it can not be created.
+	ready chan struct{}
+	err   error
+	// refs counts the callers of Get which have not released the backend yet.
+	refs int
+	// evicted is set once the client has been removed from the pool, it is closed once refs drops to 0.
+	evicted bool
+}
+
+// NewClientPool creates a pool of the backends for the tillers, the default namespace is used for the migrates
+// which have not specified a tiller namespace.
+func NewClientPool(defaultNamespace string, idleTimeout time.Duration, newBackend BackendFactory) *ClientPool {
+	if defaultNamespace == "" {
+		defaultNamespace = DefaultTillerNamespace
+	}
+	return &ClientPool{
+		clients:          map[string]*pooledClient{},
+		newBackend:       newBackend,
+		defaultNamespace: defaultNamespace,
+		idleTimeout:      idleTimeout,
+	}
+}
+
+// NewSharedClientPool creates a pool which returns the backend for all tiller namespaces.
+func NewSharedClientPool(backend ReleaseBackend) *ClientPool {
+	return &ClientPool{clients: map[string]*pooledClient{}, defaultNamespace: DefaultTillerNamespace, shared: backend}
+}
+
+// Get returns the backend for the tiller in the namespace, it is created if there is no one yet. The backend is
+// created without holding the lock so an unreachable tiller does not block the others, the concurrent callers for
+// the same tiller wait for it to be created. The backend is not closed until release is called, which is nil if an
+// error is returned.
+func (p *ClientPool) Get(tillerNamespace string) (backend ReleaseBackend, release func(), err error) {
+	if p.shared != nil {
+		return p.shared, func() {}, nil
+	}
+	if tillerNamespace == "" {
+		tillerNamespace = p.defaultNamespace
+	}
+
+	p.mu.Lock()
+	if client, ok := p.clients[tillerNamespace]; ok {
+		client.lastUsed = time.Now()
+		client.refs++
+		p.mu.Unlock()
+		<-client.ready
+		return p.checkout(client)
+	}
+	client := &pooledClient{lastUsed: time.Now(), stopCh: make(chan struct{}), ready: make(chan struct{}), refs: 1}
+	p.clients[tillerNamespace] = client
+	p.mu.Unlock()
+
+	logger.Infof("Create a helm client for the tiller in namespace [%s]", tillerNamespace)
+	backend, err = p.newBackend(tillerNamespace, client.stopCh)
+
+	p.mu.Lock()
+	// The pool may have been closed while the backend was being created, it is closed by the last release then.
+	closed := p.clients[tillerNamespace] != client
+	switch {
+	case err != nil:
+		close(client.stopCh)
+		client.err = errors.Wrapf(err, "create helm client for tiller in namespace %s", tillerNamespace)
+		client.evicted = true
+		if !closed {
+			delete(p.clients, tillerNamespace)
+		}
+	case closed:
+		client.backend = backend
+		client.err = errors.Errorf("the helm client pool has been closed")
+	default:
+		client.backend = backend
+	}
+	close(client.ready)
+	p.mu.Unlock()
+
+	return p.checkout(client)
+}
+
+// checkout returns the backend of the created client with the function releasing it, the client is released at
+// once if it has not been created.
+func (p *ClientPool) checkout(client *pooledClient) (ReleaseBackend, func(), error) {
+	if client.err != nil {
+		p.release(client)
+		return nil, nil, client.err
+	}
+	var once sync.Once
+	return client.backend, func() { once.Do(func() { p.release(client) }) }, nil
+}
+
+// release returns the backend checked out by a caller of Get, it is closed by the last caller once it is evicted.
+func (p *ClientPool) release(client *pooledClient) {
+	p.mu.Lock()
+	client.refs--
+	client.lastUsed = time.Now()
+	closing := client.evicted && client.refs == 0 && client.backend != nil
+	p.mu.Unlock()
+
+	if closing {
+		closeBackend(client)
+	}
+}
+
+// KeepLive checks the backend of the default tiller.
+func (p *ClientPool) KeepLive() error {
+	backend, release, err := p.Get("")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return backend.KeepLive()
+}
+
+// Run checks the backends every interval until the stop channel is closed, the idle and the unhealthy ones
+// are evicted and will be created again once they are needed.
+func (p *ClientPool) Run(interval time.Duration, stopCh <-chan struct{}) {
+	if p.shared != nil {
+		return
+	}
+	wait.Until(p.check, interval, stopCh)
+	p.closeAll()
+}
+
+func (p *ClientPool) check() {
+	// The last used time is copied while holding the lock, as it is updated by the callers of Get.
+	type checkedClient struct {
+		client   *pooledClient
+		lastUsed time.Time
+	}
+	p.mu.Lock()
+	clients := make(map[string]checkedClient, len(p.clients))
+	for namespace, client := range p.clients {
+		if isCreated(client) {
+			clients[namespace] = checkedClient{client: client, lastUsed: client.lastUsed}
+		}
+	}
+	p.mu.Unlock()
+
+	for namespace, c := range clients {
+		if namespace != p.defaultNamespace && p.idleTimeout > 0 && time.Since(c.lastUsed) > p.idleTimeout {
+			if p.evict(namespace, c.client, c.lastUsed) {
+				logger.Infof("The helm client for the tiller in namespace [%s] has been idle since %s, close it.",
+					namespace, c.lastUsed.Format(time.RFC3339))
+				continue
+			}
+			// It is still checked out or has been used again, so its health is checked as the others.
+		}
+		if err := c.client.backend.KeepLive(); err != nil {
+			logger.Errorf("The helm client for the tiller in namespace [%s] is unhealthy, close it : %s", namespace, err.Error())
+			p.evict(namespace, c.client, time.Time{})
+		}
+	}
+}
+
+// evict removes the client from the pool if it is still the one in it, and closes it unless it is checked out, then
+// it is closed by the last release. If the time it was idle since is given, the client is kept if it is checked out
+// or has been used after that. It returns true if the client has been evicted.
+func (p *ClientPool) evict(namespace string, client *pooledClient, idleSince time.Time) bool {
+	p.mu.Lock()
+	if p.clients[namespace] != client || (!idleSince.IsZero() && (client.refs > 0 || client.lastUsed.After(idleSince))) {
+		p.mu.Unlock()
+		return false
+	}
+	delete(p.clients, namespace)
+	client.evicted = true
+	closing := client.refs == 0
+	p.mu.Unlock()
+
+	if closing {
+		closeBackend(client)
+	}
+	return true
+}
+
+// closeAll closes the clients in the pool, the ones being created or checked out are closed by their last release.
+func (p *ClientPool) closeAll() {
+	p.mu.Lock()
+	clients := p.clients
+	p.clients = map[string]*pooledClient{}
+	var closing []*pooledClient
+	for _, client := range clients {
+		client.evicted = true
+		if isCreated(client) && client.refs == 0 {
+			closing = append(closing, client)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, client := range closing {
+		closeBackend(client)
+	}
+}
+
+// isCreated tells whether the backend of the client has been created, the failed ones are not kept in the pool.
+func isCreated(client *pooledClient) bool {
+	select {
+	case <-client.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func closeBackend(client *pooledClient) {
+	close(client.stopCh)
+	if closer, ok := client.backend.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,295 @@
+package helm
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubBackend is the backend created by fakeFactory, only its health is checked by the pool.
+type stubBackend struct {
+	ReleaseBackend
+	mu          sync.Mutex
+	keepLiveErr error
+}
+
+func (b *stubBackend) KeepLive() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.keepLiveErr
+}
+
+func (b *stubBackend) failKeepLive(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keepLiveErr = err
+}
+
+// fakeFactory creates fake backends and records their stop channels, the creation for a namespace waits until its
+// channel in block is closed.
+type fakeFactory struct {
+	mu       sync.Mutex
+	backends map[string]*stubBackend
+	stopChs  map[string]<-chan struct{}
+	created  int
+	block    map[string]chan struct{}
+	// dialing receives the namespaces whose backends are being created.
+	dialing chan string
+}
+
+func newFakeFactory() *fakeFactory {
+	return &fakeFactory{backends: map[string]*stubBackend{}, stopChs: map[string]<-chan struct{}{},
+		block: map[string]chan struct{}{}, dialing: make(chan string, 100)}
+}
+
+func (f *fakeFactory) newBackend(tillerNamespace string, stopCh <-chan struct{}) (ReleaseBackend, error) {
+	select {
+	case f.dialing <- tillerNamespace:
+	default:
+	}
+	f.mu.Lock()
+	block := f.block[tillerNamespace]
+	f.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	if tillerNamespace == "missing" {
+		return nil, fmt.Errorf("can not find a running tiller pod")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	backend := &stubBackend{}
+	f.backends[tillerNamespace] = backend
+	f.stopChs[tillerNamespace] = stopCh
+	return backend, nil
+}
+
+func isStopped(stopCh <-chan struct{}) bool {
+	select {
+	case <-stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// getReleased gets the backend and releases it at once, as a sync which has finished.
+func getReleased(pool *ClientPool, tillerNamespace string) (ReleaseBackend, error) {
+	backend, release, err := pool.Get(tillerNamespace)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return backend, nil
+}
+
+func TestClientPoolGet(t *testing.T) {
+	factory := newFakeFactory()
+	pool := NewClientPool("", time.Minute, factory.newBackend)
+
+	defaultBackend, err := getReleased(pool, "")
+	if err != nil {
+		t.Fatalf("get default client: %v", err)
+	}
+	if defaultBackend != factory.backends[DefaultTillerNamespace] {
+		t.Errorf("expected the client of the tiller in %s for an empty namespace", DefaultTillerNamespace)
+	}
+
+	teamBackend, err := getReleased(pool, "team-a")
+	if err != nil {
+		t.Fatalf("get client: %v", err)
+	}
+	if again, _ := getReleased(pool, "team-a"); again != teamBackend {
+		t.Errorf("expected the client to be reused")
+	}
+	if factory.created != 2 {
+		t.Errorf("expected 2 clients to be created, got %d", factory.created)
+	}
+
+	if _, err := getReleased(pool, "missing"); err == nil {
+		t.Errorf("expected an error if the client can not be created")
+	}
+}
+
+func TestClientPoolEvictsIdleClients(t *testing.T) {
+	factory := newFakeFactory()
+	pool := NewClientPool("", time.Millisecond, factory.newBackend)
+	getReleased(pool, "")
+	getReleased(pool, "team-a")
+
+	time.Sleep(5 * time.Millisecond)
+	pool.check()
+
+	if !isStopped(factory.stopChs["team-a"]) {
+		t.Errorf("expected the idle client to be closed")
+	}
+	if isStopped(factory.stopChs[DefaultTillerNamespace]) {
+		t.Errorf("expected the default client to be kept even if it is idle")
+	}
+
+	getReleased(pool, "team-a")
+	if factory.created != 3 {
+		t.Errorf("expected the evicted client to be created again, got %d clients", factory.created)
+	}
+}
+
+func TestClientPoolKeepsClientUsedWhileChecked(t *testing.T) {
+	factory := newFakeFactory()
+	pool := NewClientPool("", time.Millisecond, factory.newBackend)
+	getReleased(pool, "team-a")
+	pool.mu.Lock()
+	client := pool.clients["team-a"]
+	idleSince := client.lastUsed
+	pool.mu.Unlock()
+
+	// A sync gets the client after the check has found it idle.
+	time.Sleep(5 * time.Millisecond)
+	getReleased(pool, "team-a")
+	if pool.evict("team-a", client, idleSince) {
+		t.Errorf("expected the client used after it was found idle to be kept")
+	}
+	if isStopped(factory.stopChs["team-a"]) {
+		t.Errorf("expected the client used by the sync not to be closed")
+	}
+}
+
+func TestClientPoolDoesNotBlockOnUnreachableTiller(t *testing.T) {
+	factory := newFakeFactory()
+	factory.block["slow"] = make(chan struct{})
+	pool := NewClientPool("", time.Hour, factory.newBackend)
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := getReleased(pool, "slow")
+		errs <- err
+	}()
+	<-factory.dialing
+	go func() {
+		_, err := getReleased(pool, "slow")
+		errs <- err
+	}()
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := getReleased(pool, "team-a")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("get client: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the client of another tiller not to wait for the unreachable one")
+	}
+
+	close(factory.block["slow"])
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("get client: %v", err)
+		}
+	}
+	if factory.created != 2 {
+		t.Errorf("expected the client of the slow tiller to be created once, got %d clients", factory.created)
+	}
+}
+
+func TestClientPoolConcurrentGetAndCheck(t *testing.T) {
+	factory := newFakeFactory()
+	pool := NewClientPool("", time.Microsecond, factory.newBackend)
+
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				pool.check()
+			}
+		}
+	}()
+
+	var getters sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		getters.Add(1)
+		go func() {
+			defer getters.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := getReleased(pool, fmt.Sprintf("team-%d", j%3)); err != nil {
+					t.Errorf("get client: %v", err)
+				}
+			}
+		}()
+	}
+	getters.Wait()
+	close(stopCh)
+	wg.Wait()
+	pool.closeAll()
+}
+
+func TestClientPoolEvictsUnhealthyClients(t *testing.T) {
+	factory := newFakeFactory()
+	pool := NewClientPool("", time.Hour, factory.newBackend)
+	getReleased(pool, "team-a")
+	getReleased(pool, "team-b")
+	factory.backends["team-a"].failKeepLive(fmt.Errorf("tiller is down"))
+
+	pool.check()
+
+	if !isStopped(factory.stopChs["team-a"]) {
+		t.Errorf("expected the unhealthy client to be closed")
+	}
+	if isStopped(factory.stopChs["team-b"]) {
+		t.Errorf("expected the healthy client to be kept")
+	}
+}
+
+func TestSharedClientPool(t *testing.T) {
+	backend := &stubBackend{}
+	pool := NewSharedClientPool(backend)
+	for _, namespace := range []string{"", "team-a"} {
+		if got, err := getReleased(pool, namespace); err != nil || got != backend {
+			t.Errorf("expected the shared backend for namespace %q, got %v, %v", namespace, got, err)
+		}
+	}
+}
+
+func TestClientPoolClosesCheckedOutClientOnRelease(t *testing.T) {
+	factory := newFakeFactory()
+	pool := NewClientPool("", time.Millisecond, factory.newBackend)
+	_, release, err := pool.Get("team-a")
+	if err != nil {
+		t.Fatalf("get client: %v", err)
+	}
+	stopCh := factory.stopChs["team-a"]
+
+	// The client is not idle while a sync is using it.
+	time.Sleep(5 * time.Millisecond)
+	pool.check()
+	if isStopped(stopCh) {
+		t.Errorf("expected the checked out client not to be evicted as idle")
+	}
+
+	factory.backends["team-a"].failKeepLive(fmt.Errorf("tiller is down"))
+	pool.check()
+	if isStopped(stopCh) {
+		t.Errorf("expected the unhealthy client not to be closed while it is checked out")
+	}
+	if _, err := getReleased(pool, "team-a"); err != nil || factory.created != 2 {
+		t.Errorf("expected the unhealthy client to be replaced, got %d clients, %v", factory.created, err)
+	}
+
+	release()
+	if !isStopped(stopCh) {
+		t.Errorf("expected the evicted client to be closed once it is released")
+	}
+	// Releasing again must neither close it twice nor release the replacement.
+	release()
+}
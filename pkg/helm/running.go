@@ -0,0 +1,50 @@
+package helm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunningOperation is a helm operation of an instrumented backend which has not returned yet.
+type RunningOperation struct {
+	App       string    `json:"app"`
+	Operation string    `json:"operation"`
+	Release   string    `json:"release,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+// running holds the operations of all the instrumented backends in progress by their IDs.
+var running = &runningOperations{operations: map[uint64]RunningOperation{}}
+
+type runningOperations struct {
+	mu         sync.Mutex
+	nextID     uint64
+	operations map[uint64]RunningOperation
+}
+
+func (r *runningOperations) add(operation RunningOperation) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.operations[r.nextID] = operation
+	return r.nextID
+}
+
+func (r *runningOperations) remove(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.operations, id)
+}
+
+// RunningOperations returns the helm operations in progress, the longest running first.
+func RunningOperations() []RunningOperation {
+	running.mu.Lock()
+	operations := make([]RunningOperation, 0, len(running.operations))
+	for _, operation := range running.operations {
+		operations = append(operations, operation)
+	}
+	running.mu.Unlock()
+	sort.Slice(operations, func(i, j int) bool { return operations[i].Started.Before(operations[j].Started) })
+	return operations
+}
@@ -0,0 +1,211 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// The secrets are laid out in the same way as the secrets storage driver of tiller,
+// so the releases are still readable by tiller.
+const (
+	storageOwner = "TILLER"
+	releaseKey   = "release"
+)
+
+var magicGzip = []byte{0x1f, 0x8b, 0x08}
+
+// SecretStorage stores each version of the releases as a secret.
+type SecretStorage struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+}
+
+// NewSecretStorage
+func NewSecretStorage(kubeClient kubernetes.Interface, namespace string) *SecretStorage {
+	return &SecretStorage{kubeClient: kubeClient, namespace: namespace}
+}
+
+// Get returns the version of the release.
+func (s *SecretStorage) Get(rlsName string, version int32) (*release.Release, error) {
+	secret, err := s.kubeClient.CoreV1().Secrets(s.namespace).Get(secretKey(rlsName, version), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, &ReleaseNotFoundError{HelmError: err}
+		}
+		return nil, err
+	}
+
+	return DecodeRelease(string(secret.Data[releaseKey]))
+}
+
+// History returns all versions of the release, the newest first.
+func (s *SecretStorage) History(rlsName string) ([]*release.Release, error) {
+	rlses, err := s.list(labels.Set{"NAME": rlsName, "OWNER": storageOwner})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rlses, func(i, j int) bool { return rlses[i].Version > rlses[j].Version })
+	return rlses, nil
+}
+
+// Latest returns the latest version of each release.
+func (s *SecretStorage) Latest() ([]*release.Release, error) {
+	rlses, err := s.list(labels.Set{"OWNER": storageOwner})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := map[string]*release.Release{}
+	for _, rls := range rlses {
+		if l, ok := latest[rls.Name]; !ok || l.Version < rls.Version {
+			latest[rls.Name] = rls
+		}
+	}
+
+	result := make([]*release.Release, 0, len(latest))
+	for _, rls := range latest {
+		result = append(result, rls)
+	}
+	return result, nil
+}
+
+// Create stores a new version of the release.
+func (s *SecretStorage) Create(rls *release.Release) error {
+	secret, err := newReleaseSecret(rls)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kubeClient.CoreV1().Secrets(s.namespace).Create(secret)
+	return errors.Wrapf(err, "create release %s version %d", rls.Name, rls.Version)
+}
+
+// Update replaces an existing version of the release.
+func (s *SecretStorage) Update(rls *release.Release) error {
+	secret, err := newReleaseSecret(rls)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kubeClient.CoreV1().Secrets(s.namespace).Update(secret)
+	return errors.Wrapf(err, "update release %s version %d", rls.Name, rls.Version)
+}
+
+// Delete purges all versions of the release.
+func (s *SecretStorage) Delete(rlsName string) error {
+	rlses, err := s.History(rlsName)
+	if err != nil {
+		return err
+	}
+
+	for _, rls := range rlses {
+		err := s.kubeClient.CoreV1().Secrets(s.namespace).Delete(secretKey(rls.Name, rls.Version), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete release %s version %d", rls.Name, rls.Version)
+		}
+	}
+	return nil
+}
+
+func (s *SecretStorage) list(set labels.Set) ([]*release.Release, error) {
+	secrets, err := s.kubeClient.CoreV1().Secrets(s.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(set).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list release secrets")
+	}
+
+	rlses := make([]*release.Release, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		rls, err := DecodeRelease(string(secret.Data[releaseKey]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode release secret %s", secret.Name)
+		}
+		rlses = append(rlses, rls)
+	}
+	return rlses, nil
+}
+
+func newReleaseSecret(rls *release.Release) (*corev1.Secret, error) {
+	data, err := EncodeRelease(rls)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encode release %s version %d", rls.Name, rls.Version)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretKey(rls.Name, rls.Version),
+			Labels: map[string]string{
+				"NAME":    rls.Name,
+				"OWNER":   storageOwner,
+				"STATUS":  rls.GetInfo().GetStatus().GetCode().String(),
+				"VERSION": strconv.Itoa(int(rls.Version)),
+			},
+		},
+		Data: map[string][]byte{releaseKey: []byte(data)},
+	}, nil
+}
+
+func secretKey(rlsName string, version int32) string {
+	return fmt.Sprintf("%s.v%d", rlsName, version)
+}
+
+// EncodeRelease encodes a release in the same way as tiller: protobuf, gzipped, base64.
+func EncodeRelease(rls *release.Release) (string, error) {
+	b, err := proto.Marshal(rls)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(b); err != nil {
+		return "", err
+	}
+	w.Close()
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeRelease decodes a release encoded by tiller, the data may be not gzipped.
+func DecodeRelease(data string) (*release.Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(b, magicGzip) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if b, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var rls release.Release
+	if err := proto.Unmarshal(b, &rls); err != nil {
+		return nil, err
+	}
+	return &rls, nil
+}
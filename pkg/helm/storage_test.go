@@ -0,0 +1,129 @@
+package helm
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func newStoredRelease(name string, version int32, code release.Status_Code) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: testNamespace,
+		Version:   version,
+		Config:    &chart.Config{Raw: "replicaCount: 2"},
+		Manifest:  "kind: ConfigMap",
+		Info:      &release.Info{Status: &release.Status{Code: code}},
+	}
+}
+
+func TestSecretStorageRoundTrip(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset()
+	storage := NewSecretStorage(kubeClient, localStorageNamespace)
+	rls := newStoredRelease("demo-gz01-blue", 1, release.Status_PENDING_INSTALL)
+	if err := storage.Create(rls); err != nil {
+		t.Fatalf("create release: %v", err)
+	}
+	if err := storage.Create(rls); err == nil {
+		t.Errorf("expected an error creating an existing version")
+	}
+
+	rls.Info.Status.Code = release.Status_DEPLOYED
+	if err := storage.Update(rls); err != nil {
+		t.Fatalf("update release: %v", err)
+	}
+	stored, err := storage.Get("demo-gz01-blue", 1)
+	if err != nil {
+		t.Fatalf("get release: %v", err)
+	}
+	if !proto.Equal(stored, rls) {
+		t.Errorf("expected the stored release to be %v, got %v", rls, stored)
+	}
+
+	// The secrets are laid out as the ones of tiller.
+	secret, err := kubeClient.CoreV1().Secrets(localStorageNamespace).Get("demo-gz01-blue.v1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get secret: %v", err)
+	}
+	expectedLabels := map[string]string{"NAME": "demo-gz01-blue", "OWNER": "TILLER", "STATUS": "DEPLOYED", "VERSION": "1"}
+	for key, value := range expectedLabels {
+		if secret.Labels[key] != value {
+			t.Errorf("expected label %s=%s, got %q", key, value, secret.Labels[key])
+		}
+	}
+
+	if _, err := storage.Get("demo-gz01-blue", 2); err == nil {
+		t.Errorf("expected an error getting a missing version")
+	} else if _, ok := err.(*ReleaseNotFoundError); !ok {
+		t.Errorf("expected a ReleaseNotFoundError for a missing version, got %v", err)
+	}
+}
+
+func TestDecodeReleaseNotGzipped(t *testing.T) {
+	rls := newStoredRelease("demo-gz01-blue", 3, release.Status_DEPLOYED)
+	b, err := proto.Marshal(rls)
+	if err != nil {
+		t.Fatalf("marshal release: %v", err)
+	}
+
+	decoded, err := DecodeRelease(base64.StdEncoding.EncodeToString(b))
+	if err != nil {
+		t.Fatalf("decode release: %v", err)
+	}
+	if !proto.Equal(decoded, rls) {
+		t.Errorf("expected the release to be %v, got %v", rls, decoded)
+	}
+}
+
+func TestSecretStorageHistory(t *testing.T) {
+	storage := NewSecretStorage(k8sfake.NewSimpleClientset(), localStorageNamespace)
+	for _, rls := range []*release.Release{
+		newStoredRelease("demo-gz01-blue", 2, release.Status_SUPERSEDED),
+		newStoredRelease("demo-gz01-blue", 10, release.Status_DEPLOYED),
+		newStoredRelease("demo-gz01-blue", 1, release.Status_SUPERSEDED),
+		newStoredRelease("demo-gz01-green", 4, release.Status_FAILED),
+	} {
+		if err := storage.Create(rls); err != nil {
+			t.Fatalf("create release: %v", err)
+		}
+	}
+
+	history, err := storage.History("demo-gz01-blue")
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	var versions []int32
+	for _, rls := range history {
+		versions = append(versions, rls.Version)
+	}
+	if len(versions) != 3 || versions[0] != 10 || versions[1] != 2 || versions[2] != 1 {
+		t.Errorf("expected the versions of the release newest first, got %v", versions)
+	}
+
+	latest, err := storage.Latest()
+	if err != nil {
+		t.Fatalf("latest: %v", err)
+	}
+	latestVersions := map[string]int32{}
+	for _, rls := range latest {
+		latestVersions[rls.Name] = rls.Version
+	}
+	if len(latestVersions) != 2 || latestVersions["demo-gz01-blue"] != 10 || latestVersions["demo-gz01-green"] != 4 {
+		t.Errorf("expected the latest version of each release, got %v", latestVersions)
+	}
+
+	if err := storage.Delete("demo-gz01-blue"); err != nil {
+		t.Fatalf("delete release: %v", err)
+	}
+	if history, err := storage.History("demo-gz01-blue"); err != nil || len(history) != 0 {
+		t.Errorf("expected all versions to be purged, got %v, %v", history, err)
+	}
+	if history, err := storage.History("demo-gz01-green"); err != nil || len(history) != 1 {
+		t.Errorf("expected the other release to be kept, got %v, %v", history, err)
+	}
+}
@@ -0,0 +1,263 @@
+package helm
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+
+	"context"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	rls "k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/proto/hapi/version"
+)
+
+const fakeTillerVersion = "v2.13.1"
+
+// fakeTiller implements the release service of tiller with an in-memory release store, the charts are never
+// rendered or applied.
+type fakeTiller struct {
+	mu sync.Mutex
+	// releases holds the versions of each release, the oldest first.
+	releases map[string][]*release.Release
+	// clock orders the releases by their deployed time.
+	clock int64
+}
+
+// startFakeTiller serves a fake tiller on a random local port, it is stopped when the returned func is called.
+func startFakeTiller(t *testing.T, opts ...grpc.ServerOption) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen for the fake tiller: %v", err)
+	}
+
+	tiller := &fakeTiller{releases: map[string][]*release.Release{}}
+	server := grpc.NewServer(opts...)
+	rls.RegisterReleaseServiceServer(server, tiller)
+	go server.Serve(lis)
+
+	return lis.Addr().String(), server.Stop
+}
+
+func (f *fakeTiller) ListReleases(req *rls.ListReleasesRequest, stream rls.ReleaseService_ListReleasesServer) error {
+	f.mu.Lock()
+	var filter *regexp.Regexp
+	if req.Filter != "" {
+		var err error
+		if filter, err = regexp.Compile(req.Filter); err != nil {
+			f.mu.Unlock()
+			return status.Errorf(codes.InvalidArgument, "invalid filter %q: %v", req.Filter, err)
+		}
+	}
+	statuses := req.StatusCodes
+	if len(statuses) == 0 {
+		statuses = []release.Status_Code{release.Status_DEPLOYED}
+	}
+
+	var rlses []*release.Release
+	for name := range f.releases {
+		latest := f.latest(name)
+		if (filter == nil || filter.MatchString(name)) && hasStatus(latest, statuses) &&
+			(req.Namespace == "" || latest.Namespace == req.Namespace) {
+			rlses = append(rlses, latest)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Slice(rlses, func(i, j int) bool {
+		if req.SortBy == rls.ListSort_LAST_RELEASED {
+			return rlses[i].Info.LastDeployed.Seconds < rlses[j].Info.LastDeployed.Seconds
+		}
+		return rlses[i].Name < rlses[j].Name
+	})
+	if req.SortOrder == rls.ListSort_DESC {
+		for i, j := 0, len(rlses)-1; i < j; i, j = i+1, j-1 {
+			rlses[i], rlses[j] = rlses[j], rlses[i]
+		}
+	}
+	total := int64(len(rlses))
+	if req.Limit > 0 && int64(len(rlses)) > req.Limit {
+		rlses = rlses[:req.Limit]
+	}
+
+	return stream.Send(&rls.ListReleasesResponse{Count: int64(len(rlses)), Total: total, Releases: rlses})
+}
+
+func (f *fakeTiller) GetReleaseStatus(ctx context.Context, req *rls.GetReleaseStatusRequest) (*rls.GetReleaseStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, err := f.version(req.Name, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &rls.GetReleaseStatusResponse{Name: r.Name, Namespace: r.Namespace, Info: r.Info}, nil
+}
+
+func (f *fakeTiller) GetReleaseContent(ctx context.Context, req *rls.GetReleaseContentRequest) (*rls.GetReleaseContentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, err := f.version(req.Name, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &rls.GetReleaseContentResponse{Release: r}, nil
+}
+
+func (f *fakeTiller) UpdateRelease(ctx context.Context, req *rls.UpdateReleaseRequest) (*rls.UpdateReleaseResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.latest(req.Name)
+	if current == nil || current.Info.Status.Code == release.Status_DELETED {
+		return nil, releaseNotFound(req.Name)
+	}
+	return &rls.UpdateReleaseResponse{Release: f.deploy(current, req.Chart, req.Values)}, nil
+}
+
+func (f *fakeTiller) InstallRelease(ctx context.Context, req *rls.InstallReleaseRequest) (*rls.InstallReleaseResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if current := f.latest(req.Name); current != nil && current.Info.Status.Code != release.Status_DELETED {
+		return nil, status.Errorf(codes.AlreadyExists, "a release named %s already exists", req.Name)
+	}
+
+	f.clock++
+	r := &release.Release{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+		Version:   int32(len(f.releases[req.Name]) + 1),
+		Chart:     req.Chart,
+		Config:    req.Values,
+		Info: &release.Info{
+			Status:        &release.Status{Code: release.Status_DEPLOYED},
+			FirstDeployed: &timestamp.Timestamp{Seconds: f.clock},
+			LastDeployed:  &timestamp.Timestamp{Seconds: f.clock},
+		},
+	}
+	f.releases[req.Name] = append(f.releases[req.Name], r)
+	return &rls.InstallReleaseResponse{Release: r}, nil
+}
+
+func (f *fakeTiller) UninstallRelease(ctx context.Context, req *rls.UninstallReleaseRequest) (*rls.UninstallReleaseResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.latest(req.Name)
+	if current == nil {
+		return nil, releaseNotFound(req.Name)
+	}
+	current.Info.Status.Code = release.Status_DELETED
+	if req.Purge {
+		delete(f.releases, req.Name)
+	}
+	return &rls.UninstallReleaseResponse{Release: current}, nil
+}
+
+func (f *fakeTiller) GetVersion(ctx context.Context, req *rls.GetVersionRequest) (*rls.GetVersionResponse, error) {
+	return &rls.GetVersionResponse{Version: &version.Version{SemVer: fakeTillerVersion}}, nil
+}
+
+func (f *fakeTiller) RollbackRelease(ctx context.Context, req *rls.RollbackReleaseRequest) (*rls.RollbackReleaseResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current := f.latest(req.Name)
+	if current == nil {
+		return nil, releaseNotFound(req.Name)
+	}
+	target := req.Version
+	if target == 0 {
+		target = current.Version - 1
+	}
+	previous, err := f.version(req.Name, target)
+	if err != nil {
+		return nil, err
+	}
+	return &rls.RollbackReleaseResponse{Release: f.deploy(current, previous.Chart, previous.Config)}, nil
+}
+
+func (f *fakeTiller) GetHistory(ctx context.Context, req *rls.GetHistoryRequest) (*rls.GetHistoryResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	versions := f.releases[req.Name]
+	if len(versions) == 0 {
+		return nil, releaseNotFound(req.Name)
+	}
+	var history []*release.Release
+	for i := len(versions) - 1; i >= 0 && (req.Max <= 0 || len(history) < int(req.Max)); i-- {
+		history = append(history, versions[i])
+	}
+	return &rls.GetHistoryResponse{Releases: history}, nil
+}
+
+func (f *fakeTiller) RunReleaseTest(req *rls.TestReleaseRequest, stream rls.ReleaseService_RunReleaseTestServer) error {
+	return status.Error(codes.Unimplemented, "release tests are not supported by the fake tiller")
+}
+
+// deploy supersedes the current version of the release with a new deployed one.
+func (f *fakeTiller) deploy(current *release.Release, ch *chart.Chart, values *chart.Config) *release.Release {
+	f.clock++
+	current.Info.Status.Code = release.Status_SUPERSEDED
+	r := &release.Release{
+		Name:      current.Name,
+		Namespace: current.Namespace,
+		Version:   current.Version + 1,
+		Chart:     ch,
+		Config:    values,
+		Info: &release.Info{
+			Status:        &release.Status{Code: release.Status_DEPLOYED},
+			FirstDeployed: current.Info.FirstDeployed,
+			LastDeployed:  &timestamp.Timestamp{Seconds: f.clock},
+		},
+	}
+	f.releases[current.Name] = append(f.releases[current.Name], r)
+	return r
+}
+
+// version returns the version of the release, the latest one if the version is 0.
+func (f *fakeTiller) version(name string, version int32) (*release.Release, error) {
+	if version == 0 {
+		if r := f.latest(name); r != nil {
+			return r, nil
+		}
+		return nil, releaseNotFound(name)
+	}
+	for _, r := range f.releases[name] {
+		if r.Version == version {
+			return r, nil
+		}
+	}
+	return nil, releaseNotFound(name)
+}
+
+func (f *fakeTiller) latest(name string) *release.Release {
+	versions := f.releases[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+func hasStatus(r *release.Release, statuses []release.Status_Code) bool {
+	for _, code := range statuses {
+		if r.Info.Status.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseNotFound returns the same message as tiller, the client relies on it to tell a missing release.
+func releaseNotFound(name string) error {
+	return status.Errorf(codes.NotFound, "release: %q not found", name)
+}
@@ -0,0 +1,166 @@
+package helm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The keys of the certificates in the tls secret, they are the same as the secret created by helm init --tiller-tls.
+const (
+	TLSCACertKey = "ca.crt"
+	TLSCertKey   = "tls.crt"
+	TLSKeyKey    = "tls.key"
+)
+
+// TLSOptions configures how the operator and tiller authenticate each other.
+type TLSOptions struct {
+	// Enable connects to tiller with TLS.
+	Enable bool
+	// Verify verifies the certificate of tiller against the CA, it implies Enable.
+	Verify bool
+	// ServerName is used to verify the hostname of the certificate of tiller.
+	ServerName string
+
+	// The PEM files of the CA, the client certificate and its key.
+	CACertFile string
+	CertFile   string
+	KeyFile    string
+
+	// Secret references a secret holding ca.crt, tls.crt and tls.key as "namespace/name",
+	// the certificates are reloaded once it changes. It takes precedence over the files.
+	Secret string
+}
+
+// Enabled tells whether tiller should be connected with TLS.
+func (o *TLSOptions) Enabled() bool {
+	return o.Enable || o.Verify || o.Secret != ""
+}
+
+// ConfigureTLS sets the tls config of the client with the options, if a secret is referenced it is watched until
+// the stop channel is closed and the client is reconfigured each time the secret changes.
+func ConfigureTLS(client *Client, kubeClient kubernetes.Interface, opts TLSOptions, stopCh <-chan struct{}) error {
+	if opts.Secret == "" {
+		caCert, cert, key, err := readTLSFiles(opts)
+		if err != nil {
+			return err
+		}
+		cfg, err := newTLSConfig(caCert, cert, key, opts)
+		if err != nil {
+			return err
+		}
+		client.SetTLSConfig(cfg)
+		return nil
+	}
+
+	parts := strings.SplitN(opts.Secret, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return errors.Errorf("tls secret %q should be in the form of namespace/name", opts.Secret)
+	}
+	namespace, name := parts[0], parts[1]
+
+	// Load the secret before returning so that no request is sent to tiller without TLS.
+	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "get tls secret %s", opts.Secret)
+	}
+	if err := reloadTLSSecret(client, secret, opts); err != nil {
+		return err
+	}
+
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return kubeClient.CoreV1().Secrets(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return kubeClient.CoreV1().Secrets(namespace).Watch(options)
+		},
+	}
+	_, informer := cache.NewInformer(lw, &corev1.Secret{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			watchedTLSSecretChanged(client, obj, opts)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			if old.(*corev1.Secret).ResourceVersion != new.(*corev1.Secret).ResourceVersion {
+				watchedTLSSecretChanged(client, new, opts)
+			}
+		},
+	})
+	go informer.Run(stopCh)
+	return nil
+}
+
+func watchedTLSSecretChanged(client *Client, obj interface{}, opts TLSOptions) {
+	if err := reloadTLSSecret(client, obj.(*corev1.Secret), opts); err != nil {
+		// Keep the previous certificates, they may still work.
+		logger.Errorf("Reload the tls config of tiller from secret [%s] has an error : %s", opts.Secret, err.Error())
+	}
+}
+
+func reloadTLSSecret(client *Client, secret *corev1.Secret, opts TLSOptions) error {
+	cfg, err := newTLSConfig(secret.Data[TLSCACertKey], secret.Data[TLSCertKey], secret.Data[TLSKeyKey], opts)
+	if err != nil {
+		return errors.Wrapf(err, "load tls secret %s", opts.Secret)
+	}
+	client.SetTLSConfig(cfg)
+	logger.Infof("Loaded the tls config of tiller from secret [%s], resource version : %s", opts.Secret, secret.ResourceVersion)
+	return nil
+}
+
+func readTLSFiles(opts TLSOptions) (caCert, cert, key []byte, err error) {
+	read := func(file string) []byte {
+		if file == "" || err != nil {
+			return nil
+		}
+		var data []byte
+		if data, err = ioutil.ReadFile(file); err != nil {
+			err = errors.Wrapf(err, "read tls file %s", file)
+		}
+		return data
+	}
+	caCert, cert, key = read(opts.CACertFile), read(opts.CertFile), read(opts.KeyFile)
+	return caCert, cert, key, err
+}
+
+// newTLSConfig builds the tls config from the PEM encoded certificates, the client certificate is optional unless
+// tiller requires mutual authentication.
+func newTLSConfig(caCert, cert, key []byte, opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: !opts.Verify,
+		ServerName:         opts.ServerName,
+	}
+
+	if len(cert) != 0 || len(key) != 0 {
+		keyPair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{keyPair}
+	}
+
+	if opts.Verify {
+		if len(caCert) == 0 {
+			return nil, errors.New("a CA certificate is required to verify tiller")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no valid CA certificate is found")
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,207 @@
+package helm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testTillerServerName = "tiller-deploy"
+
+// testCA issues the certificates of tiller and the operator.
+type testCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	serial  int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tiller-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), serial: 1}
+}
+
+// issue returns the PEM encoded certificate and key for the server or the client.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// startTLSFakeTiller serves a fake tiller which requires the client certificates issued by the CA, it is stopped when
+// the returned func is called.
+func startTLSFakeTiller(t *testing.T, ca *testCA) (string, func()) {
+	certPEM, keyPEM := ca.issue(t, testTillerServerName, x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("load server certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	return startFakeTiller(t, grpc.Creds(creds))
+}
+
+func newTLSSecret(ca *testCA, cert, key []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tiller-secret", Namespace: DefaultTillerNamespace},
+		Data:       map[string][]byte{TLSCACertKey: ca.certPEM, TLSCertKey: cert, TLSKeyKey: key},
+	}
+}
+
+func TestConfigureTLSFromSecret(t *testing.T) {
+	ca := newTestCA(t)
+	addr, stop := startTLSFakeTiller(t, ca)
+	defer stop()
+	client := NewClientWithHost(addr)
+	client.connectTimeout = 1
+
+	if err := client.KeepLive(); err == nil {
+		t.Errorf("expected an error when connecting to tiller without TLS")
+	}
+
+	cert, key := ca.issue(t, "sym-operator", x509.ExtKeyUsageClientAuth)
+	kubeClient := fake.NewSimpleClientset(newTLSSecret(ca, cert, key))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	opts := TLSOptions{Verify: true, ServerName: testTillerServerName, Secret: DefaultTillerNamespace + "/tiller-secret"}
+	if err := ConfigureTLS(client, kubeClient, opts, stopCh); err != nil {
+		t.Fatalf("configure tls: %v", err)
+	}
+
+	if err := client.KeepLive(); err != nil {
+		t.Errorf("expected to connect to tiller with mutual TLS, got %v", err)
+	}
+}
+
+func TestConfigureTLSReloadsSecret(t *testing.T) {
+	ca := newTestCA(t)
+	addr, stop := startTLSFakeTiller(t, ca)
+	defer stop()
+	client := NewClientWithHost(addr)
+	client.connectTimeout = 1
+
+	// The client certificate is issued by an unknown CA at first.
+	cert, key := newTestCA(t).issue(t, "sym-operator", x509.ExtKeyUsageClientAuth)
+	kubeClient := fake.NewSimpleClientset(newTLSSecret(ca, cert, key))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	opts := TLSOptions{Verify: true, ServerName: testTillerServerName, Secret: DefaultTillerNamespace + "/tiller-secret"}
+	if err := ConfigureTLS(client, kubeClient, opts, stopCh); err != nil {
+		t.Fatalf("configure tls: %v", err)
+	}
+	if err := client.KeepLive(); err == nil {
+		t.Errorf("expected an error with an untrusted client certificate")
+	}
+
+	cert, key = ca.issue(t, "sym-operator", x509.ExtKeyUsageClientAuth)
+	secret := newTLSSecret(ca, cert, key)
+	secret.ResourceVersion = "2"
+	if _, err := kubeClient.CoreV1().Secrets(DefaultTillerNamespace).Update(secret); err != nil {
+		t.Fatalf("update secret: %v", err)
+	}
+
+	err := wait.PollImmediate(100*time.Millisecond, 10*time.Second, func() (bool, error) {
+		return client.KeepLive() == nil, nil
+	})
+	if err != nil {
+		t.Errorf("expected the rotated certificate to be reloaded: %v", err)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "sym-operator", x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name        string
+		caCert      []byte
+		cert        []byte
+		key         []byte
+		opts        TLSOptions
+		expectError bool
+	}{
+		{name: "skip verify without certificates", opts: TLSOptions{Enable: true}},
+		{name: "mutual authentication", caCert: ca.certPEM, cert: cert, key: key, opts: TLSOptions{Verify: true}},
+		{name: "verify without CA", cert: cert, key: key, opts: TLSOptions{Verify: true}, expectError: true},
+		{name: "invalid CA", caCert: []byte("invalid"), opts: TLSOptions{Verify: true}, expectError: true},
+		{name: "certificate without key", cert: cert, opts: TLSOptions{Enable: true}, expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := newTLSConfig(test.caCert, test.cert, test.key, test.opts)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.InsecureSkipVerify == test.opts.Verify {
+				t.Errorf("expected InsecureSkipVerify to be %v", !test.opts.Verify)
+			}
+			if len(test.cert) != 0 && len(cfg.Certificates) != 1 {
+				t.Errorf("expected the client certificate to be loaded")
+			}
+		})
+	}
+}
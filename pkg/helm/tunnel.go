@@ -0,0 +1,107 @@
+package helm
+
+import (
+	"fmt"
+	"time"
+
+	"context"
+	"github.com/goph/emperror"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	helmapi "k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/helm/portforwarder"
+)
+
+// DefaultTillerNamespace is the namespace which tiller is installed in by default.
+const DefaultTillerNamespace = "kube-system"
+
+const (
+	reconnectSuccess = "success"
+	reconnectFailure = "failure"
+)
+
+// defaultReconnectBackoff retries to rebuild the tunnel in about 30 seconds before giving up.
+var defaultReconnectBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+var (
+	tunnelReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sym_operator",
+		Subsystem: "tiller",
+		Name:      "tunnel_reconnects_total",
+		Help:      "The number of the attempts to rebuild the tunnel to tiller, partitioned by result.",
+	}, []string{"result"})
+	tunnelUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sym_operator",
+		Subsystem: "tiller",
+		Name:      "tunnel_up",
+		Help: "Whether the tunnel to tiller is established (1) or not (0), partitioned by the api server of the " +
+			"cluster and the namespace of tiller.",
+	}, []string{"cluster", "tiller_namespace"})
+)
+
+func init() {
+	prometheus.MustRegister(tunnelReconnects, tunnelUp)
+}
+
+// tillerDialer opens a connection to tiller, it returns the address to reach tiller and a func to close the connection.
+type tillerDialer func() (host string, closer func(), err error)
+
+// portForwardDialer reaches tiller through a port-forward to the tiller pod in the namespace.
+func portForwardDialer(cfg *restclient.Config, kubeClient kubernetes.Interface, tillerNamespace string) tillerDialer {
+	return func() (string, func(), error) {
+		tunnel, err := portforwarder.New(tillerNamespace, kubeClient, cfg)
+		if err != nil {
+			return "", nil, emperror.Wrap(err, "failed to create kubernetes tunnel")
+		}
+		return fmt.Sprintf("localhost:%d", tunnel.Local), tunnel.Close, nil
+	}
+}
+
+// reconnect rebuilds the connection to tiller with backoff, the failed client is used to tell whether the connection
+// has been rebuilt by another caller in the meantime.
+func (helmClient *Client) reconnect(failed *helmapi.Client) error {
+	helmClient.reconnectMu.Lock()
+	defer helmClient.reconnectMu.Unlock()
+
+	if helmClient.current() != failed {
+		return nil
+	}
+
+	var lastErr error
+	err := wait.ExponentialBackoff(helmClient.backoff, func() (bool, error) {
+		host, closer, err := helmClient.dial()
+		if err != nil {
+			lastErr = err
+			tunnelReconnects.WithLabelValues(reconnectFailure).Inc()
+			logger.Warningf("Rebuild the tunnel to tiller has an error : %s", err.Error())
+			return false, nil
+		}
+		helmClient.connect(host, closer)
+		tunnelReconnects.WithLabelValues(reconnectSuccess).Inc()
+		logger.Infof("Rebuilt the tunnel to tiller on address:%s", host)
+		return true, nil
+	})
+	if err != nil {
+		tunnelUp.With(helmClient.tunnelLabels).Set(0)
+		if lastErr == nil {
+			lastErr = err
+		}
+		return emperror.Wrap(lastErr, "failed to rebuild kubernetes tunnel")
+	}
+	return nil
+}
+
+// isUnreachable tells whether the error is caused by a broken connection to tiller rather than by tiller itself.
+// The connection may break after the request has been sent, tiller may have handled it in this case.
+func isUnreachable(err error) bool {
+	return err == context.DeadlineExceeded || status.Code(err) == codes.Unavailable
+}
@@ -0,0 +1,418 @@
+package helm3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"sigs.k8s.io/yaml"
+)
+
+var logger = log.WithComponent("helm3")
+
+const (
+	// DefaultTillerNamespace is where tiller stores its release configmaps by default.
+	DefaultTillerNamespace = "kube-system"
+
+	// SecretType is the type of the secrets which store helm 3 releases.
+	SecretType = "helm.sh/release.v1"
+
+	releaseKey = "release"
+)
+
+var magicGzip = []byte{0x1f, 0x8b, 0x08}
+
+// Converter converts the releases stored by tiller in configmaps to the helm 3 release secrets.
+type Converter struct {
+	kubeClient      kubernetes.Interface
+	tillerNamespace string
+}
+
+// NewConverter
+func NewConverter(kubeClient kubernetes.Interface, tillerNamespace string) *Converter {
+	if tillerNamespace == "" {
+		tillerNamespace = DefaultTillerNamespace
+	}
+	return &Converter{kubeClient: kubeClient, tillerNamespace: tillerNamespace}
+}
+
+// SecretName returns the name of the secret which stores the version of a helm 3 release.
+func SecretName(rlsName string, version int32) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", rlsName, version)
+}
+
+// V2Versions returns the versions of the release stored by tiller, sorted by version,
+// only the latest maxHistory versions are returned if maxHistory is positive.
+func (c *Converter) V2Versions(rlsName string, maxHistory int) ([]*release.Release, error) {
+	selector := labels.SelectorFromSet(labels.Set{"NAME": rlsName, "OWNER": "TILLER"})
+	configMaps, err := c.kubeClient.CoreV1().ConfigMaps(c.tillerNamespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list tiller configmaps of release %s", rlsName)
+	}
+
+	versions := make([]*release.Release, 0, len(configMaps.Items))
+	for _, cm := range configMaps.Items {
+		rls, err := helm.DecodeRelease(cm.Data[releaseKey])
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode tiller configmap %s", cm.Name)
+		}
+		versions = append(versions, rls)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+
+	if maxHistory > 0 && len(versions) > maxHistory {
+		versions = versions[len(versions)-maxHistory:]
+	}
+	return versions, nil
+}
+
+// Convert writes a helm 3 release secret for each version of the release, a secret which already exists is only
+// replaced if it does not match the tiller version. It returns the count of the converted versions.
+func (c *Converter) Convert(rlsName string, maxHistory int) (int, error) {
+	versions, err := c.V2Versions(rlsName, maxHistory)
+	if err != nil {
+		return 0, err
+	}
+	if len(versions) == 0 {
+		return 0, errors.Errorf("can not find any tiller configmap of release %s", rlsName)
+	}
+
+	for _, v2 := range versions {
+		secret, err := newSecret(v2)
+		if err != nil {
+			return 0, errors.Wrapf(err, "convert release %s version %d", rlsName, v2.Version)
+		}
+
+		_, err = c.kubeClient.CoreV1().Secrets(v2.Namespace).Create(secret)
+		if apierrors.IsAlreadyExists(err) {
+			err = c.replaceSecret(secret)
+		}
+		if err != nil {
+			return 0, err
+		}
+		logger.Infof("Release [%s] version [%d] has been converted to secret [%s/%s].", rlsName, v2.Version, v2.Namespace, secret.Name)
+	}
+
+	return len(versions), nil
+}
+
+// replaceSecret updates the existing secret with the converted one unless it holds the same release already.
+func (c *Converter) replaceSecret(secret *corev1.Secret) error {
+	existing, err := c.kubeClient.CoreV1().Secrets(secret.Namespace).Get(secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "get secret %s", secret.Name)
+	}
+	if existing.Type == secret.Type {
+		converted, err := decodeV3(string(secret.Data[releaseKey]))
+		if err != nil {
+			return errors.Wrapf(err, "decode secret %s", secret.Name)
+		}
+		if v3, err := decodeV3(string(existing.Data[releaseKey])); err == nil && sameRelease(v3, converted) {
+			return nil
+		}
+	}
+
+	logger.Warningf("Secret [%s/%s] does not match the tiller release, replace it.", secret.Namespace, secret.Name)
+	existing.Type = secret.Type
+	existing.Labels = secret.Labels
+	existing.Data = secret.Data
+	if _, err := c.kubeClient.CoreV1().Secrets(secret.Namespace).Update(existing); err != nil {
+		return errors.Wrapf(err, "update secret %s", secret.Name)
+	}
+	return nil
+}
+
+// Verify makes sure each converted version of the release can be decoded from its secret and matches the tiller one.
+func (c *Converter) Verify(rlsName string, maxHistory int) error {
+	versions, err := c.V2Versions(rlsName, maxHistory)
+	if err != nil {
+		return err
+	}
+
+	for _, v2 := range versions {
+		name := SecretName(rlsName, v2.Version)
+		secret, err := c.kubeClient.CoreV1().Secrets(v2.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "get secret %s", name)
+		}
+
+		v3, err := decodeV3(string(secret.Data[releaseKey]))
+		if err != nil {
+			return errors.Wrapf(err, "decode secret %s", name)
+		}
+		expected, err := ConvertRelease(v2)
+		if err != nil {
+			return err
+		}
+		if !sameRelease(v3, expected) {
+			return errors.Errorf("secret %s does not match release %s version %d", name, rlsName, v2.Version)
+		}
+	}
+
+	return nil
+}
+
+// sameRelease compares the parts of the releases which are deployed, empty values are omitted from the secret.
+func sameRelease(a, b *Release) bool {
+	return a.Name == b.Name && a.Version == b.Version && a.Namespace == b.Namespace && a.Manifest == b.Manifest &&
+		a.Info != nil && b.Info != nil && a.Info.Status == b.Info.Status &&
+		(len(a.Config) == 0 && len(b.Config) == 0 || reflect.DeepEqual(a.Config, b.Config))
+}
+
+// CleanupV2 deletes all the tiller configmaps of the release.
+func (c *Converter) CleanupV2(rlsName string) error {
+	selector := labels.SelectorFromSet(labels.Set{"NAME": rlsName, "OWNER": "TILLER"})
+	configMaps, err := c.kubeClient.CoreV1().ConfigMaps(c.tillerNamespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return errors.Wrapf(err, "list tiller configmaps of release %s", rlsName)
+	}
+
+	for _, cm := range configMaps.Items {
+		err := c.kubeClient.CoreV1().ConfigMaps(c.tillerNamespace).Delete(cm.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete tiller configmap %s", cm.Name)
+		}
+	}
+
+	return nil
+}
+
+// ConvertRelease maps a helm 2 release to a helm 3 one.
+func ConvertRelease(v2 *release.Release) (*Release, error) {
+	config, err := valuesToMap(v2.GetConfig().GetRaw())
+	if err != nil {
+		return nil, errors.Wrap(err, "parse release config")
+	}
+	chartValues, err := valuesToMap(v2.GetChart().GetValues().GetRaw())
+	if err != nil {
+		return nil, errors.Wrap(err, "parse chart values")
+	}
+
+	v3 := &Release{
+		Name:      v2.Name,
+		Config:    config,
+		Manifest:  v2.Manifest,
+		Version:   int(v2.Version),
+		Namespace: v2.Namespace,
+		Info: &Info{
+			FirstDeployed: toTime(v2.GetInfo().GetFirstDeployed()),
+			LastDeployed:  toTime(v2.GetInfo().GetLastDeployed()),
+			Deleted:       toTime(v2.GetInfo().GetDeleted()),
+			Description:   v2.GetInfo().GetDescription(),
+			Status:        convertStatus(v2.GetInfo().GetStatus().GetCode()),
+			Notes:         v2.GetInfo().GetStatus().GetNotes(),
+		},
+	}
+
+	if v2.Chart != nil {
+		metadata := v2.Chart.GetMetadata()
+		v3.Chart = &Chart{
+			Metadata: &Metadata{
+				Name:        metadata.GetName(),
+				Home:        metadata.GetHome(),
+				Sources:     metadata.GetSources(),
+				Version:     metadata.GetVersion(),
+				Description: metadata.GetDescription(),
+				Keywords:    metadata.GetKeywords(),
+				Icon:        metadata.GetIcon(),
+				APIVersion:  "v1",
+				Condition:   metadata.GetCondition(),
+				Tags:        metadata.GetTags(),
+				AppVersion:  metadata.GetAppVersion(),
+				Deprecated:  metadata.GetDeprecated(),
+				Annotations: metadata.GetAnnotations(),
+				KubeVersion: metadata.GetKubeVersion(),
+			},
+			Values: chartValues,
+		}
+		for _, m := range metadata.GetMaintainers() {
+			v3.Chart.Metadata.Maintainers = append(v3.Chart.Metadata.Maintainers,
+				&Maintainer{Name: m.GetName(), Email: m.GetEmail(), URL: m.GetUrl()})
+		}
+		for _, t := range v2.Chart.GetTemplates() {
+			v3.Chart.Templates = append(v3.Chart.Templates, &File{Name: t.GetName(), Data: t.GetData()})
+		}
+		for _, f := range v2.Chart.GetFiles() {
+			v3.Chart.Files = append(v3.Chart.Files, &File{Name: f.GetTypeUrl(), Data: f.GetValue()})
+		}
+	}
+
+	for _, h := range v2.GetHooks() {
+		hook := &Hook{
+			Name:     h.GetName(),
+			Kind:     h.GetKind(),
+			Path:     h.GetPath(),
+			Manifest: h.GetManifest(),
+			Weight:   int(h.GetWeight()),
+			LastRun:  HookExecution{StartedAt: toTime(h.GetLastRun()), CompletedAt: toTime(h.GetLastRun()), Phase: "Succeeded"},
+		}
+		if h.GetLastRun() == nil {
+			hook.LastRun.Phase = "Unknown"
+		}
+		for _, e := range h.GetEvents() {
+			if event := convertHookEvent(e); event != "" {
+				hook.Events = append(hook.Events, event)
+			}
+		}
+		for _, p := range h.GetDeletePolicies() {
+			if policy := convertHookDeletePolicy(p); policy != "" {
+				hook.DeletePolicies = append(hook.DeletePolicies, policy)
+			}
+		}
+		if len(hook.Events) > 0 {
+			v3.Hooks = append(v3.Hooks, hook)
+		}
+	}
+
+	return v3, nil
+}
+
+func newSecret(v2 *release.Release) (*corev1.Secret, error) {
+	v3, err := ConvertRelease(v2)
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodeV3(v3)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      SecretName(v2.Name, v2.Version),
+			Namespace: v2.Namespace,
+			Labels: map[string]string{
+				"name":    v3.Name,
+				"owner":   "helm",
+				"status":  v3.Info.Status,
+				"version": fmt.Sprintf("%d", v3.Version),
+			},
+		},
+		Type: SecretType,
+		Data: map[string][]byte{releaseKey: []byte(data)},
+	}, nil
+}
+
+// encodeV3 encodes a release the same way as helm 3: json, gzipped, base64.
+func encodeV3(rls *Release) (string, error) {
+	b, err := json.Marshal(rls)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(b); err != nil {
+		return "", err
+	}
+	w.Close()
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeV3(data string) (*Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = gunzip(b); err != nil {
+		return nil, err
+	}
+
+	var rls Release
+	if err := json.Unmarshal(b, &rls); err != nil {
+		return nil, err
+	}
+	return &rls, nil
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, magicGzip) {
+		return b, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func valuesToMap(raw string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if strings.TrimSpace(raw) == "" {
+		return values, nil
+	}
+	if err := yaml.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func toTime(ts *timestamp.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos))
+}
+
+func convertStatus(code release.Status_Code) string {
+	switch code {
+	case release.Status_DELETED:
+		return "uninstalled"
+	case release.Status_DELETING:
+		return "uninstalling"
+	default:
+		return enumToString(code.String())
+	}
+}
+
+func convertHookEvent(event release.Hook_Event) string {
+	switch event {
+	case release.Hook_RELEASE_TEST_SUCCESS:
+		return "test"
+	case release.Hook_RELEASE_TEST_FAILURE, release.Hook_CRD_INSTALL, release.Hook_UNKNOWN:
+		// These events are no longer supported by helm 3.
+		return ""
+	default:
+		return enumToString(event.String())
+	}
+}
+
+func convertHookDeletePolicy(policy release.Hook_DeletePolicy) string {
+	switch policy {
+	case release.Hook_SUCCEEDED:
+		return "hook-succeeded"
+	case release.Hook_FAILED:
+		return "hook-failed"
+	case release.Hook_BEFORE_HOOK_CREATION:
+		return "before-hook-creation"
+	default:
+		return ""
+	}
+}
+
+// enumToString converts the protobuf enum name such as PENDING_INSTALL to pending-install.
+func enumToString(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "-", -1))
+}
@@ -0,0 +1,183 @@
+package helm3
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	"github.com/yangyongzhi/sym-operator/pkg/helm/helmtest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const testRelease = "demo-gz01-blue"
+
+func newV2Release(version int32, code release.Status_Code, hooks ...*release.Hook) *release.Release {
+	rls := helmtest.NewFakeRelease(testRelease, metav1.NamespaceDefault, version, code)
+	rls.Config = &chart.Config{Raw: "replicaCount: 2"}
+	rls.Manifest = "kind: Deployment"
+	rls.Hooks = hooks
+	return rls
+}
+
+// newTillerConfigMap stores the release the same way as tiller.
+func newTillerConfigMap(t *testing.T, rls *release.Release) *corev1.ConfigMap {
+	data, err := helm.EncodeRelease(rls)
+	if err != nil {
+		t.Fatalf("encode release: %v", err)
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.v%d", rls.Name, rls.Version),
+			Namespace: DefaultTillerNamespace,
+			Labels:    map[string]string{"NAME": rls.Name, "OWNER": "TILLER"},
+		},
+		Data: map[string]string{releaseKey: data},
+	}
+}
+
+func TestConvertRelease(t *testing.T) {
+	tests := []struct {
+		name               string
+		v2                 *release.Release
+		expectedStatus     string
+		expectedSecretName string
+		// The events and delete policies of each converted hook.
+		expectedHooks map[string][2][]string
+	}{
+		{
+			name:               "deployed release",
+			v2:                 newV2Release(3, release.Status_DEPLOYED),
+			expectedStatus:     "deployed",
+			expectedSecretName: "sh.helm.release.v1.demo-gz01-blue.v3",
+		},
+		{
+			name:               "deleted release is uninstalled",
+			v2:                 newV2Release(1, release.Status_DELETED),
+			expectedStatus:     "uninstalled",
+			expectedSecretName: "sh.helm.release.v1.demo-gz01-blue.v1",
+		},
+		{
+			name:               "pending upgrade",
+			v2:                 newV2Release(12, release.Status_PENDING_UPGRADE),
+			expectedStatus:     "pending-upgrade",
+			expectedSecretName: "sh.helm.release.v1.demo-gz01-blue.v12",
+		},
+		{
+			name: "hook events and delete policies",
+			v2: newV2Release(2, release.Status_DEPLOYED,
+				&release.Hook{Name: "migrate-db", Events: []release.Hook_Event{release.Hook_PRE_INSTALL, release.Hook_POST_UPGRADE},
+					DeletePolicies: []release.Hook_DeletePolicy{release.Hook_BEFORE_HOOK_CREATION, release.Hook_SUCCEEDED}},
+				&release.Hook{Name: "smoke-test", Events: []release.Hook_Event{release.Hook_RELEASE_TEST_SUCCESS},
+					DeletePolicies: []release.Hook_DeletePolicy{release.Hook_FAILED}}),
+			expectedStatus:     "deployed",
+			expectedSecretName: "sh.helm.release.v1.demo-gz01-blue.v2",
+			expectedHooks: map[string][2][]string{
+				"migrate-db": {{"pre-install", "post-upgrade"}, {"before-hook-creation", "hook-succeeded"}},
+				"smoke-test": {{"test"}, {"hook-failed"}},
+			},
+		},
+		{
+			name: "hook without any helm 3 event is dropped",
+			v2: newV2Release(2, release.Status_DEPLOYED,
+				&release.Hook{Name: "crd", Events: []release.Hook_Event{release.Hook_CRD_INSTALL}}),
+			expectedStatus:     "deployed",
+			expectedSecretName: "sh.helm.release.v1.demo-gz01-blue.v2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v3, err := ConvertRelease(test.v2)
+			if err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			if v3.Info.Status != test.expectedStatus {
+				t.Errorf("expected status %s, got %s", test.expectedStatus, v3.Info.Status)
+			}
+			if v3.Version != int(test.v2.Version) || v3.Config["replicaCount"] != float64(2) {
+				t.Errorf("unexpected version %d or config %v", v3.Version, v3.Config)
+			}
+
+			hooks := map[string][2][]string{}
+			for _, h := range v3.Hooks {
+				hooks[h.Name] = [2][]string{h.Events, h.DeletePolicies}
+			}
+			if (len(hooks) > 0 || len(test.expectedHooks) > 0) && !reflect.DeepEqual(hooks, test.expectedHooks) {
+				t.Errorf("expected hooks %v, got %v", test.expectedHooks, hooks)
+			}
+
+			secret, err := newSecret(test.v2)
+			if err != nil {
+				t.Fatalf("new secret: %v", err)
+			}
+			if secret.Name != test.expectedSecretName || secret.Labels["status"] != test.expectedStatus ||
+				secret.Type != SecretType {
+				t.Errorf("expected secret %s with status %s, got %s %v", test.expectedSecretName, test.expectedStatus,
+					secret.Name, secret.Labels)
+			}
+			decoded, err := decodeV3(string(secret.Data[releaseKey]))
+			if err != nil {
+				t.Fatalf("decode secret: %v", err)
+			}
+			if !sameRelease(decoded, v3) {
+				t.Errorf("expected the secret to hold the converted release")
+			}
+		})
+	}
+}
+
+func TestConvertExistingSecret(t *testing.T) {
+	v2 := newV2Release(1, release.Status_DEPLOYED)
+	matching, err := newSecret(v2)
+	if err != nil {
+		t.Fatalf("new secret: %v", err)
+	}
+	stale, err := newSecret(newV2Release(1, release.Status_FAILED))
+	if err != nil {
+		t.Fatalf("new secret: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		existing       *corev1.Secret
+		expectedUpdate bool
+	}{
+		{
+			name:     "matching secret is kept",
+			existing: matching,
+		},
+		{
+			name:           "stale secret is replaced",
+			existing:       stale,
+			expectedUpdate: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(newTillerConfigMap(t, v2), test.existing)
+			converter := NewConverter(client, "")
+			if _, err := converter.Convert(testRelease, 0); err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+
+			updated := false
+			for _, action := range client.Actions() {
+				if action.Matches("update", "secrets") {
+					updated = true
+				}
+			}
+			if updated != test.expectedUpdate {
+				t.Errorf("expected the secret to be updated %t, got %t", test.expectedUpdate, updated)
+			}
+			if err := converter.Verify(testRelease, 0); err != nil {
+				t.Errorf("expected the converted secret to be verified: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package helm3
+
+import (
+	"time"
+)
+
+// The types below mirror the JSON layout of the helm 3 release records, only the fields which can be
+// converted from a helm 2 release are declared.
+
+// Release describes a deployment of a chart, together with the chart and the values used to deploy it.
+type Release struct {
+	Name      string                 `json:"name,omitempty"`
+	Info      *Info                  `json:"info,omitempty"`
+	Chart     *Chart                 `json:"chart,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Manifest  string                 `json:"manifest,omitempty"`
+	Hooks     []*Hook                `json:"hooks,omitempty"`
+	Version   int                    `json:"version,omitempty"`
+	Namespace string                 `json:"namespace,omitempty"`
+}
+
+// Info describes release information.
+type Info struct {
+	FirstDeployed time.Time `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time `json:"last_deployed,omitempty"`
+	Deleted       time.Time `json:"deleted"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+// Chart is a helm package that contains metadata, default values, templates and files.
+type Chart struct {
+	Metadata  *Metadata              `json:"metadata"`
+	Templates []*File                `json:"templates"`
+	Values    map[string]interface{} `json:"values"`
+	Files     []*File                `json:"files"`
+}
+
+// Metadata for a chart file.
+type Metadata struct {
+	Name         string            `json:"name,omitempty"`
+	Home         string            `json:"home,omitempty"`
+	Sources      []string          `json:"sources,omitempty"`
+	Version      string            `json:"version,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Maintainers  []*Maintainer     `json:"maintainers,omitempty"`
+	Icon         string            `json:"icon,omitempty"`
+	APIVersion   string            `json:"apiVersion,omitempty"`
+	Condition    string            `json:"condition,omitempty"`
+	Tags         string            `json:"tags,omitempty"`
+	AppVersion   string            `json:"appVersion,omitempty"`
+	Deprecated   bool              `json:"deprecated,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	KubeVersion  string            `json:"kubeVersion,omitempty"`
+	Type         string            `json:"type,omitempty"`
+	Dependencies []interface{}     `json:"dependencies,omitempty"`
+}
+
+// Maintainer describes a chart maintainer.
+type Maintainer struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// File represents a file as a name/value pair.
+type File struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// Hook defines a hook object.
+type Hook struct {
+	Name           string        `json:"name,omitempty"`
+	Kind           string        `json:"kind,omitempty"`
+	Path           string        `json:"path,omitempty"`
+	Manifest       string        `json:"manifest,omitempty"`
+	Events         []string      `json:"events,omitempty"`
+	LastRun        HookExecution `json:"last_run,omitempty"`
+	Weight         int           `json:"weight,omitempty"`
+	DeletePolicies []string      `json:"delete_policies,omitempty"`
+}
+
+// HookExecution records the result for the last execution of a hook for a given release.
+type HookExecution struct {
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+	Phase       string    `json:"phase"`
+}
@@ -0,0 +1,61 @@
+package history
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"github.com/yangyongzhi/sym-operator/pkg/metrics"
+)
+
+var logger = log.WithComponent("history")
+
+var (
+	deploymentsPerDayDesc = prometheus.NewDesc(metrics.Namespace+"_dora_deployments_per_day",
+		"The successful deployments per day of each app over the window.", []string{"app"}, nil)
+	leadTimeDesc = prometheus.NewDesc(metrics.Namespace+"_dora_lead_time_seconds",
+		"The median lead time from the change of a migrate to the deployment of its releases of each app over the window.",
+		[]string{"app"}, nil)
+	changeFailureRateDesc = prometheus.NewDesc(metrics.Namespace+"_dora_change_failure_rate",
+		"The ratio of the failed deployments of each app over the window.", []string{"app"}, nil)
+	timeToRestoreDesc = prometheus.NewDesc(metrics.Namespace+"_dora_time_to_restore_seconds",
+		"The median time from a failed deployment of a release to its next successful one of each app over the window.",
+		[]string{"app"}, nil)
+)
+
+// Collector exposes the DORA metrics of the apps of the store over the window when scraped. The lead time and the
+// time to restore of an app are only exposed if it has changes and restores in the window.
+type Collector struct {
+	store  *Store
+	window time.Duration
+}
+
+// NewCollector returns a collector of the DORA metrics of the apps of the store.
+func NewCollector(store *Store, window time.Duration) *Collector {
+	return &Collector{store: store, window: window}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- deploymentsPerDayDesc
+	ch <- leadTimeDesc
+	ch <- changeFailureRateDesc
+	ch <- timeToRestoreDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	reports, err := c.store.Reports("", c.window, time.Now())
+	if err != nil {
+		logger.Errorf("Compute the DORA metrics has an error : %s", err.Error())
+		return
+	}
+	for _, report := range reports {
+		ch <- prometheus.MustNewConstMetric(deploymentsPerDayDesc, prometheus.GaugeValue, report.DeploymentsPerDay, report.App)
+		ch <- prometheus.MustNewConstMetric(changeFailureRateDesc, prometheus.GaugeValue, report.ChangeFailureRate, report.App)
+		if report.Changes > 0 {
+			ch <- prometheus.MustNewConstMetric(leadTimeDesc, prometheus.GaugeValue, report.LeadTimeSeconds, report.App)
+		}
+		if report.Restores > 0 {
+			ch <- prometheus.MustNewConstMetric(timeToRestoreDesc, prometheus.GaugeValue, report.TimeToRestoreSeconds, report.App)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// Report holds the DORA metrics of an app over a window. The lead time and the time to restore are medians, they are
+// only meaningful if there are changes and restores.
+type Report struct {
+	App    string `json:"app"`
+	Window string `json:"window"`
+	// Deployments counts the deployments in the window, Failures the failed ones.
+	Deployments int `json:"deployments"`
+	Failures    int `json:"failures"`
+	// DeploymentsPerDay is the deployment frequency, only the successful deployments are counted.
+	DeploymentsPerDay float64 `json:"deploymentsPerDay"`
+	// ChangeFailureRate is the ratio of the failed deployments.
+	ChangeFailureRate float64 `json:"changeFailureRate"`
+	// LeadTimeSeconds is from the change of the migrate to the successful deployment of its releases, Changes counts
+	// the deployments whose change time is known.
+	LeadTimeSeconds float64 `json:"leadTimeSeconds"`
+	Changes         int     `json:"changes"`
+	// TimeToRestoreSeconds is from the first failed deployment of a release to its next successful one, Restores
+	// counts the releases which have been restored.
+	TimeToRestoreSeconds float64 `json:"timeToRestoreSeconds"`
+	Restores             int     `json:"restores"`
+}
+
+// Compute returns the DORA metrics of the app from its deployments which have finished in the window until now.
+func Compute(app string, deployments []Deployment, window time.Duration, now time.Time) Report {
+	report := Report{App: app, Window: window.String()}
+	since := now.Add(-window)
+	var inWindow []Deployment
+	for _, d := range deployments {
+		if !d.FinishedAt.Before(since) && !d.FinishedAt.After(now) {
+			inWindow = append(inWindow, d)
+		}
+	}
+	sort.SliceStable(inWindow, func(i, j int) bool { return inWindow[i].FinishedAt.Before(inWindow[j].FinishedAt) })
+
+	var leadTimes, restoreTimes []time.Duration
+	// failingSince holds the first failure of each release which has not been restored yet.
+	failingSince := map[string]time.Time{}
+	for _, d := range inWindow {
+		report.Deployments++
+		key := d.Cluster + "/" + d.Release
+		if !d.Succeeded {
+			report.Failures++
+			if _, ok := failingSince[key]; !ok {
+				failingSince[key] = d.FinishedAt
+			}
+			continue
+		}
+		if !d.ChangedAt.IsZero() && !d.FinishedAt.Before(d.ChangedAt) {
+			leadTimes = append(leadTimes, d.FinishedAt.Sub(d.ChangedAt))
+		}
+		if failed, ok := failingSince[key]; ok {
+			restoreTimes = append(restoreTimes, d.FinishedAt.Sub(failed))
+			delete(failingSince, key)
+		}
+	}
+
+	if window > 0 {
+		report.DeploymentsPerDay = float64(report.Deployments-report.Failures) / window.Hours() * 24
+	}
+	if report.Deployments > 0 {
+		report.ChangeFailureRate = float64(report.Failures) / float64(report.Deployments)
+	}
+	report.Changes, report.LeadTimeSeconds = len(leadTimes), median(leadTimes).Seconds()
+	report.Restores, report.TimeToRestoreSeconds = len(restoreTimes), median(restoreTimes).Seconds()
+	return report
+}
+
+func median(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	middle := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[middle-1] + durations[middle]) / 2
+	}
+	return durations[middle]
+}
+
+// Reports returns the DORA metrics of the apps of the store, or of the app only if it is set.
+func (s *Store) Reports(app string, window time.Duration, now time.Time) ([]Report, error) {
+	apps := []string{app}
+	if app == "" {
+		var err error
+		if apps, err = s.Apps(); err != nil {
+			return nil, err
+		}
+	}
+	reports := []Report{}
+	for _, app := range apps {
+		deployments, err := s.Deployments(app, now.Add(-window))
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, Compute(app, deployments, window, now))
+	}
+	return reports, nil
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	at := func(hours float64) time.Time { return now.Add(-time.Duration(hours * float64(time.Hour))) }
+	deployments := []Deployment{
+		// Before the window.
+		{Release: "demo-blue", Succeeded: false, FinishedAt: at(30 * 24)},
+		{Release: "demo-blue", Succeeded: true, ChangedAt: at(50), FinishedAt: at(48)},
+		{Release: "demo-green", Succeeded: true, ChangedAt: at(50), FinishedAt: at(46)},
+		// Restored after 3 hours, the second failure does not restart the outage.
+		{Release: "demo-blue", Succeeded: false, FinishedAt: at(10)},
+		{Release: "demo-blue", Succeeded: false, FinishedAt: at(8)},
+		{Release: "demo-blue", Succeeded: true, ChangedAt: at(7.5), FinishedAt: at(7)},
+		// The same release in another cluster is restored on its own.
+		{Release: "demo-blue", Cluster: "gz02", Succeeded: false, FinishedAt: at(2)},
+		{Release: "demo-blue", Cluster: "gz02", Succeeded: true, FinishedAt: at(1)},
+	}
+
+	report := Compute("demo", deployments, 7*24*time.Hour, now)
+	expected := Report{
+		App:                  "demo",
+		Window:               "168h0m0s",
+		Deployments:          7,
+		Failures:             3,
+		DeploymentsPerDay:    4.0 / 7,
+		ChangeFailureRate:    3.0 / 7,
+		LeadTimeSeconds:      (2 * time.Hour).Seconds(),
+		Changes:              3,
+		TimeToRestoreSeconds: (2 * time.Hour).Seconds(),
+		Restores:             2,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected the report\n%+v\ngot\n%+v", expected, report)
+	}
+
+	if report := Compute("idle", nil, 24*time.Hour, now); report.ChangeFailureRate != 0 || report.DeploymentsPerDay != 0 {
+		t.Errorf("expected no deployment and no failure, got %+v", report)
+	}
+}
+
+func TestCollectorAndHandler(t *testing.T) {
+	store, closeStore := openStore(t)
+	defer closeStore()
+	now := time.Now()
+	for _, d := range []Deployment{
+		{App: "demo", Release: "demo-blue", Succeeded: false, FinishedAt: now.Add(-2 * time.Hour)},
+		{App: "demo", Release: "demo-blue", Succeeded: true, ChangedAt: now.Add(-2 * time.Hour), FinishedAt: now.Add(-time.Hour)},
+	} {
+		if err := store.Record(d); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(store, 24*time.Hour))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			values[family.GetName()+","+m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	expected := map[string]float64{
+		"sym_operator_dora_deployments_per_day,demo":     1,
+		"sym_operator_dora_change_failure_rate,demo":     0.5,
+		"sym_operator_dora_lead_time_seconds,demo":       3600,
+		"sym_operator_dora_time_to_restore_seconds,demo": 3600,
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected the metrics %v, got %v", expected, values)
+	}
+
+	handler := Handler(store, 24*time.Hour)
+	tests := []struct {
+		url    string
+		status int
+		count  int
+	}{
+		{url: "/api/v1/dora", status: http.StatusOK, count: 1},
+		{url: "/api/v1/dora?app=other", status: http.StatusOK, count: 1},
+		{url: "/api/v1/dora?window=1d", status: http.StatusBadRequest},
+		{url: "/api/v1/deployments?app=demo&window=90m", status: http.StatusOK, count: 1},
+		{url: "/api/v1/deployments?app=other", status: http.StatusOK, count: 0},
+		{url: "/api/v1/deployments", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s: expected the status %d, got %d: %s", test.url, test.status, recorder.Code, recorder.Body)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &items); err != nil || len(items) != test.count {
+			t.Errorf("%s: expected %d items, got %s", test.url, test.count, recorder.Body)
+		}
+	}
+}
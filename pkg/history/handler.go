@@ -0,0 +1,75 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Handler serves the DORA metrics and the deployments of the store as JSON:
+//
+//	GET /api/v1/dora?app=<app>&window=<duration>
+//	GET /api/v1/deployments?app=<app>&window=<duration>
+//
+// The reports cover every app unless the app is set, the deployments require it. The window defaults to the one
+// of the store.
+func Handler(store *Store, window time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/dora", func(w http.ResponseWriter, r *http.Request) {
+		window, ok := parseWindow(w, r, window)
+		if !ok {
+			return
+		}
+		reports, err := store.Reports(r.URL.Query().Get("app"), window, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, reports)
+	})
+	mux.HandleFunc("/api/v1/deployments", func(w http.ResponseWriter, r *http.Request) {
+		window, ok := parseWindow(w, r, window)
+		if !ok {
+			return
+		}
+		app := r.URL.Query().Get("app")
+		if app == "" {
+			http.Error(w, "the app query parameter is required", http.StatusBadRequest)
+			return
+		}
+		deployments, err := store.Deployments(app, time.Now().Add(-window))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if deployments == nil {
+			deployments = []Deployment{}
+		}
+		writeJSON(w, deployments)
+	})
+	return mux
+}
+
+// parseWindow returns the window query parameter, or the default one if it is not set. It replies with an error
+// unless the request is a GET with a valid window.
+func parseWindow(w http.ResponseWriter, r *http.Request, window time.Duration) (time.Duration, bool) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "the window should be a positive duration, e.g. 168h", http.StatusBadRequest)
+			return 0, false
+		}
+		window = parsed
+	}
+	return window, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,214 @@
+// Package history persists the outcomes of the deployments of the releases in a bbolt file and computes the DORA
+// metrics of each app from them.
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// deploymentsBucket holds a bucket of deployments by app, keyed by their finish time then a sequence.
+	deploymentsBucket = []byte("deployments")
+	// changesBucket holds the time each generation of a migrate has been observed first, keyed by
+	// "<namespace>/<name>/<generation>".
+	changesBucket = []byte("changes")
+)
+
+// Deployment is the outcome of an install, an upgrade or a rollback of a release.
+type Deployment struct {
+	App     string `json:"app"`
+	Migrate string `json:"migrate"`
+	Release string `json:"release"`
+	// Cluster is empty for the cluster the operator runs in.
+	Cluster    string `json:"cluster,omitempty"`
+	Operation  string `json:"operation"`
+	Generation int64  `json:"generation"`
+	Succeeded  bool   `json:"succeeded"`
+	Message    string `json:"message,omitempty"`
+	// ChangedAt is when the generation of the migrate has been observed first, it is zero if it is unknown.
+	ChangedAt  time.Time `json:"changedAt,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// Store persists the deployments in a bbolt file, it is safe for concurrent use.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the store in the file, it is created if it does not exist. The file is locked until the store is
+// closed, Open fails if another process holds it for longer than a second.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open deployment history %s", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{deploymentsBucket, changesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "create the buckets of deployment history %s", path)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close releases the file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func changeKey(migrate string, generation int64) []byte {
+	return []byte(migrate + "/" + strconv.FormatInt(generation, 10))
+}
+
+func encodeTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func decodeTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+// ObserveChange records the time the generation of the migrate is observed unless it has been observed before, the
+// lead time of its deployments starts then.
+func (s *Store) ObserveChange(migrate string, generation int64, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		changes, key := tx.Bucket(changesBucket), changeKey(migrate, generation)
+		if changes.Get(key) != nil {
+			return nil
+		}
+		return changes.Put(key, encodeTime(at))
+	})
+}
+
+// Record persists the deployment, its change time is the one observed for its generation unless it is set.
+func (s *Store) Record(d Deployment) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if d.ChangedAt.IsZero() {
+			if observed := tx.Bucket(changesBucket).Get(changeKey(d.Migrate, d.Generation)); observed != nil {
+				d.ChangedAt = decodeTime(observed)
+			}
+		}
+		deployments, err := tx.Bucket(deploymentsBucket).CreateBucketIfNotExists([]byte(d.App))
+		if err != nil {
+			return err
+		}
+		seq, err := deployments.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 16)
+		copy(key, encodeTime(d.FinishedAt))
+		binary.BigEndian.PutUint64(key[8:], seq)
+		value, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return deployments.Put(key, value)
+	})
+}
+
+// Apps returns the apps which have deployments, sorted by name.
+func (s *Store) Apps() ([]string, error) {
+	var apps []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).ForEach(func(name, _ []byte) error {
+			apps = append(apps, string(name))
+			return nil
+		})
+	})
+	sort.Strings(apps)
+	return apps, err
+}
+
+// Deployments returns the deployments of the app which have finished since the time, all of them if it is zero, the
+// oldest first.
+func (s *Store) Deployments(app string, since time.Time) ([]Deployment, error) {
+	var deployments []Deployment
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deploymentsBucket).Bucket([]byte(app))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.First()
+		if !since.IsZero() {
+			k, v = c.Seek(encodeTime(since))
+		}
+		for ; k != nil; k, v = c.Next() {
+			var d Deployment
+			if err := json.Unmarshal(v, &d); err != nil {
+				return errors.Wrapf(err, "decode deployment of %s", app)
+			}
+			deployments = append(deployments, d)
+		}
+		return nil
+	})
+	return deployments, err
+}
+
+// Prune deletes the deployments which have finished and the changes which have been observed before the time. The
+// keys are copied before they are deleted as they are only valid until the bucket is modified.
+func (s *Store) Prune(before time.Time) error {
+	limit := before.UnixNano()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(deploymentsBucket)
+		var apps [][]byte
+		if err := root.ForEach(func(name, _ []byte) error {
+			apps = append(apps, append([]byte(nil), name...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, app := range apps {
+			bucket := root.Bucket(app)
+			var expired [][]byte
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil && decodeTime(k[:8]).UnixNano() < limit; k, _ = c.Next() {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			if k, _ := bucket.Cursor().First(); k == nil {
+				if err := root.DeleteBucket(app); err != nil {
+					return err
+				}
+			}
+		}
+
+		changes := tx.Bucket(changesBucket)
+		var expired [][]byte
+		if err := changes.ForEach(func(k, v []byte) error {
+			if decodeTime(v).UnixNano() < limit {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := changes.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
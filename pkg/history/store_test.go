@@ -0,0 +1,90 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openStore opens a store in a temporary directory, the returned function closes it and removes the directory.
+func openStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	store, err := Open(filepath.Join(dir, "history.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("open store: %v", err)
+	}
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRecordDeployments(t *testing.T) {
+	store, closeStore := openStore(t)
+	defer closeStore()
+	now := time.Unix(1600000000, 0)
+
+	if err := store.ObserveChange("default/demo", 2, now.Add(-time.Hour)); err != nil {
+		t.Fatalf("observe change: %v", err)
+	}
+	// The change is observed again by each sync of the generation, the first time is kept.
+	if err := store.ObserveChange("default/demo", 2, now.Add(-time.Minute)); err != nil {
+		t.Fatalf("observe change: %v", err)
+	}
+	deployments := []Deployment{
+		{App: "demo", Migrate: "default/demo", Release: "demo-blue", Generation: 1, Succeeded: true,
+			StartedAt: now.Add(-3 * time.Hour), FinishedAt: now.Add(-2 * time.Hour)},
+		{App: "demo", Migrate: "default/demo", Release: "demo-blue", Generation: 2, Succeeded: true,
+			StartedAt: now.Add(-time.Minute), FinishedAt: now},
+		{App: "other", Migrate: "default/other", Release: "other-blue", Generation: 1, Succeeded: false,
+			StartedAt: now.Add(-time.Minute), FinishedAt: now},
+	}
+	for _, d := range deployments {
+		if err := store.Record(d); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	if apps, err := store.Apps(); err != nil || !reflect.DeepEqual(apps, []string{"demo", "other"}) {
+		t.Errorf("expected the apps [demo other], got %v, %v", apps, err)
+	}
+	got, err := store.Deployments("demo", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("deployments: %v", err)
+	}
+	if len(got) != 1 || got[0].Generation != 2 {
+		t.Fatalf("expected the deployment of the generation 2, got %+v", got)
+	}
+	if !got[0].ChangedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("expected the change time of the generation %s, got %s", now.Add(-time.Hour), got[0].ChangedAt)
+	}
+
+	if err := store.Prune(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if got, _ := store.Deployments("demo", time.Time{}); len(got) != 1 {
+		t.Errorf("expected the deployment before the retention to be pruned, got %+v", got)
+	}
+	if err := store.Prune(now.Add(time.Hour)); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if apps, _ := store.Apps(); len(apps) != 0 {
+		t.Errorf("expected the apps without deployments to be pruned, got %v", apps)
+	}
+}
+
+func TestOpenLockedStore(t *testing.T) {
+	store, closeStore := openStore(t)
+	defer closeStore()
+
+	if _, err := Open(store.db.Path()); err == nil {
+		t.Errorf("expected opening the file held by another store to fail")
+	}
+}
@@ -9,6 +9,11 @@ const (
 	LabelClusterName = "clusterName"
 	LabelLdcName     = "ldc"
 	LabelAzName      = "az"
+
+	// The labels of the ReleaseOperation records.
+	LabelMigrateName = "migrateName"
+	LabelAppName     = "appName"
+	LabelReleaseName = "releaseName"
 )
 
 const (
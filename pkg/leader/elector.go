@@ -0,0 +1,120 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+var logger = log.WithComponent("leader")
+
+// Config is the configuration of the leader election.
+type Config struct {
+	// Namespace and Name of the lease.
+	Namespace string
+	Name      string
+	// Identity of this candidate, it should be unique among the replicas.
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Elector runs a function only while this replica holds the lease.
+type Elector struct {
+	elector *leaderelection.LeaderElector
+	run     func(stopCh <-chan struct{})
+
+	leading int32
+
+	// mu guards stopped and running, once the election is over no run is started any more.
+	mu      sync.Mutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+// NewElector creates an elector on the lease, run is called once the leadership is acquired and its stop channel
+// is closed once the leadership is lost.
+func NewElector(kubeClient kubernetes.Interface, config Config, run func(stopCh <-chan struct{})) (*Elector, error) {
+	e := &Elector{run: run}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &LeaseLock{
+			LeaseMeta:      metav1.ObjectMeta{Namespace: config.Namespace, Name: config.Name},
+			Client:         kubeClient.CoordinationV1beta1(),
+			HolderIdentity: config.Identity,
+		},
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.startLeading,
+			OnStoppedLeading: func() {
+				atomic.StoreInt32(&e.leading, 0)
+			},
+			OnNewLeader: func(identity string) {
+				logger.Infof("The leader of lease %s/%s is %s", config.Namespace, config.Name, identity)
+			},
+		},
+		Name: config.Name,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create leader elector")
+	}
+	e.elector = elector
+	return e, nil
+}
+
+// Run campaigns for the lease until the stop channel is closed or the leadership is lost, it returns after the
+// run function has returned. It can be called again to campaign once more.
+func (e *Elector) Run(stopCh <-chan struct{}) {
+	e.mu.Lock()
+	e.stopped = false
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	e.elector.Run(ctx)
+
+	e.mu.Lock()
+	e.stopped = true
+	e.mu.Unlock()
+	e.running.Wait()
+}
+
+// IsLeader tells whether this replica is leading.
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+func (e *Elector) startLeading(ctx context.Context) {
+	e.mu.Lock()
+	if e.stopped {
+		e.mu.Unlock()
+		return
+	}
+	e.running.Add(1)
+	e.mu.Unlock()
+	defer e.running.Done()
+
+	atomic.StoreInt32(&e.leading, 1)
+	defer atomic.StoreInt32(&e.leading, 0)
+	logger.Info("Started leading")
+	e.run(ctx.Done())
+	logger.Info("Stopped leading")
+}
@@ -0,0 +1,109 @@
+package leader
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestLeaseLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	lock := &LeaseLock{
+		LeaseMeta:      metav1.ObjectMeta{Namespace: "kube-system", Name: "sym-operator"},
+		Client:         client.CoordinationV1beta1(),
+		HolderIdentity: "replica-1",
+	}
+
+	if err := lock.Update(resourcelock.LeaderElectionRecord{}); err == nil {
+		t.Errorf("expected an error updating the lease before getting it")
+	}
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	if err := lock.Create(resourcelock.LeaderElectionRecord{HolderIdentity: "replica-1", LeaseDurationSeconds: 15,
+		AcquireTime: now, RenewTime: now}); err != nil {
+		t.Fatalf("create lease: %v", err)
+	}
+	if err := lock.Update(resourcelock.LeaderElectionRecord{HolderIdentity: "replica-2", LeaseDurationSeconds: 15,
+		AcquireTime: now, RenewTime: now, LeaderTransitions: 1}); err != nil {
+		t.Fatalf("update lease: %v", err)
+	}
+
+	record, err := lock.Get()
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if record.HolderIdentity != "replica-2" || record.LeaseDurationSeconds != 15 || record.LeaderTransitions != 1 ||
+		!record.RenewTime.Equal(&now) {
+		t.Errorf("unexpected record %+v", record)
+	}
+}
+
+func TestElector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	config := Config{Namespace: "kube-system", Name: "sym-operator", LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond, RetryPeriod: 100 * time.Millisecond}
+
+	newElector := func(identity string, started chan<- string) *Elector {
+		config.Identity = identity
+		e, err := NewElector(client, config, func(stopCh <-chan struct{}) {
+			started <- identity
+			<-stopCh
+		})
+		if err != nil {
+			t.Fatalf("create elector: %v", err)
+		}
+		return e
+	}
+
+	started := make(chan string, 2)
+	first, second := newElector("replica-1", started), newElector("replica-2", started)
+	firstStop, secondStop := make(chan struct{}), make(chan struct{})
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstStop)
+		close(firstDone)
+	}()
+	select {
+	case identity := <-started:
+		if identity != "replica-1" {
+			t.Fatalf("expected replica-1 to lead, got %s", identity)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected replica-1 to lead")
+	}
+	if !first.IsLeader() {
+		t.Errorf("expected replica-1 to report leading")
+	}
+
+	go second.Run(secondStop)
+	defer close(secondStop)
+	select {
+	case identity := <-started:
+		t.Fatalf("expected only one leader, %s started leading", identity)
+	case <-time.After(2 * config.LeaseDuration):
+	}
+	if second.IsLeader() {
+		t.Errorf("expected replica-2 not to report leading")
+	}
+
+	// The standby takes over once the leader stops renewing the lease.
+	close(firstStop)
+	select {
+	case <-firstDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected replica-1 to stop")
+	}
+	if first.IsLeader() {
+		t.Errorf("expected replica-1 to stop leading")
+	}
+	select {
+	case identity := <-started:
+		if identity != "replica-2" {
+			t.Fatalf("expected replica-2 to lead, got %s", identity)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected replica-2 to take over")
+	}
+}
@@ -0,0 +1,120 @@
+package leader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaseLock is a resource lock on a coordination Lease, the vendored client-go only has the configmap and
+// the endpoints locks.
+type LeaseLock struct {
+	// LeaseMeta holds the name and the namespace of the lease.
+	LeaseMeta metav1.ObjectMeta
+	Client    coordinationclient.LeasesGetter
+	// HolderIdentity is the identity of this candidate.
+	HolderIdentity string
+
+	lease *coordinationv1beta1.Lease
+}
+
+var _ resourcelock.Interface = &LeaseLock{}
+
+// Get returns the election record from the spec of the lease.
+func (ll *LeaseLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Get(ll.LeaseMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ll.lease = lease
+	return leaseSpecToRecord(&lease.Spec), nil
+}
+
+// Create creates the lease holding the election record.
+func (ll *LeaseLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Create(&coordinationv1beta1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ll.LeaseMeta.Name,
+			Namespace: ll.LeaseMeta.Namespace,
+		},
+		Spec: recordToLeaseSpec(&ler),
+	})
+	if err != nil {
+		return err
+	}
+	ll.lease = lease
+	return nil
+}
+
+// Update writes the election record to the lease got or created before.
+func (ll *LeaseLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if ll.lease == nil {
+		return errors.New("lease not initialized, call get or create first")
+	}
+	leaseCopy := ll.lease.DeepCopy()
+	leaseCopy.Spec = recordToLeaseSpec(&ler)
+	lease, err := ll.Client.Leases(ll.LeaseMeta.Namespace).Update(leaseCopy)
+	if err != nil {
+		return err
+	}
+	ll.lease = lease
+	return nil
+}
+
+// RecordEvent logs the transitions of the leadership, no event is recorded on the lease.
+func (ll *LeaseLock) RecordEvent(s string) {
+	logger.Infof("%s %s on lease %s", ll.HolderIdentity, s, ll.Describe())
+}
+
+// Describe returns the namespace/name of the lease.
+func (ll *LeaseLock) Describe() string {
+	return fmt.Sprintf("%s/%s", ll.LeaseMeta.Namespace, ll.LeaseMeta.Name)
+}
+
+// Identity returns the holder identity of this candidate.
+func (ll *LeaseLock) Identity() string {
+	return ll.HolderIdentity
+}
+
+func leaseSpecToRecord(spec *coordinationv1beta1.LeaseSpec) *resourcelock.LeaderElectionRecord {
+	record := &resourcelock.LeaderElectionRecord{}
+	if spec.HolderIdentity != nil {
+		record.HolderIdentity = *spec.HolderIdentity
+	}
+	if spec.LeaseDurationSeconds != nil {
+		record.LeaseDurationSeconds = int(*spec.LeaseDurationSeconds)
+	}
+	if spec.LeaseTransitions != nil {
+		record.LeaderTransitions = int(*spec.LeaseTransitions)
+	}
+	if spec.AcquireTime != nil {
+		record.AcquireTime = metav1.Time{Time: spec.AcquireTime.Time}
+	}
+	if spec.RenewTime != nil {
+		record.RenewTime = metav1.Time{Time: spec.RenewTime.Time}
+	}
+	return record
+}
+
+func recordToLeaseSpec(ler *resourcelock.LeaderElectionRecord) coordinationv1beta1.LeaseSpec {
+	holder := ler.HolderIdentity
+	duration := int32(ler.LeaseDurationSeconds)
+	transitions := int32(ler.LeaderTransitions)
+	return coordinationv1beta1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &duration,
+		AcquireTime:          microTime(ler.AcquireTime.Time),
+		RenewTime:            microTime(ler.RenewTime.Time),
+		LeaseTransitions:     &transitions,
+	}
+}
+
+func microTime(t time.Time) *metav1.MicroTime {
+	mt := metav1.NewMicroTime(t)
+	return &mt
+}
@@ -0,0 +1,42 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type levelResponse struct {
+	Level string `json:"level"`
+}
+
+// LevelHandler serves the level of the logger, a PUT with the level query parameter, or a JSON body with the level,
+// changes it, e.g. curl -X PUT localhost:44100/debug/loglevel?level=debug.
+func LevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level := r.URL.Query().Get("level")
+			if level == "" {
+				var request levelResponse
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					http.Error(w, "the level should be set by the level query parameter or a JSON body", http.StatusBadRequest)
+					return
+				}
+				level = request.Level
+			}
+			previous := Level()
+			if err := SetLevel(level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			WithFields(Fields{"previous": previous, "level": Level()}).Warning("Changed the log level")
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(levelResponse{Level: Level()})
+	}
+}
@@ -0,0 +1,176 @@
+// Package log is the structured logger of the operator, the lines are JSON by default and carry the fields
+// correlating them to the migrates, the releases and the reconciles.
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/klog"
+)
+
+// The fields correlating the log lines.
+const (
+	// FieldMigrate is the namespace/name key of the migrate.
+	FieldMigrate = "migrate"
+	FieldApp     = "app"
+	FieldRelease = "release"
+	// FieldReconcileID identifies a sync of a migrate, it is the ID of the trace of the sync.
+	FieldReconcileID = "reconcile_id"
+	// FieldOperation is the helm operation, e.g. install or update.
+	FieldOperation = "helm_operation"
+	// FieldCluster is the kubeconfig secret of the target cluster, it is absent for the cluster the operator runs in.
+	FieldCluster = "cluster"
+	// FieldComponent is the component logging the line, e.g. sharder or klog for the lines of the client libraries.
+	FieldComponent = "component"
+)
+
+// The output formats.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Fields are the fields of a log line.
+type Fields = logrus.Fields
+
+// Entry is a logger with fields, all the lines logged by it carry the fields.
+type Entry = logrus.Entry
+
+var logger = newLogger(os.Stderr)
+
+func newLogger(out io.Writer) *logrus.Logger {
+	l := logrus.New()
+	l.Out = out
+	l.Formatter = formatter(FormatJSON)
+	return l
+}
+
+func formatter(format string) logrus.Formatter {
+	if format == FormatText {
+		return &logrus.TextFormatter{FullTimestamp: true}
+	}
+	return &logrus.JSONFormatter{FieldMap: logrus.FieldMap{logrus.FieldKeyMsg: "message"}}
+}
+
+// Configure sets the output format, json or text, and the level of the logger.
+func Configure(format, level string) error {
+	if format != FormatJSON && format != FormatText {
+		return errors.Errorf("log format should be %s or %s, got %s", FormatJSON, FormatText, format)
+	}
+	if err := SetLevel(level); err != nil {
+		return err
+	}
+	logger.Formatter = formatter(format)
+	return nil
+}
+
+// SetLevel changes the level of the logger, e.g. debug or info.
+func SetLevel(level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return errors.Wrap(err, "log level")
+	}
+	logger.SetLevel(parsed)
+	return nil
+}
+
+// SetOutput sends the lines to the writer and returns the previous one.
+func SetOutput(out io.Writer) io.Writer {
+	previous := logger.Out
+	logger.SetOutput(out)
+	return previous
+}
+
+// Level returns the level of the logger.
+func Level() string {
+	return logger.GetLevel().String()
+}
+
+// Log returns the logger without fields.
+func Log() *Entry {
+	return logrus.NewEntry(logger)
+}
+
+// WithFields returns the logger with the fields.
+func WithFields(fields Fields) *Entry {
+	return logger.WithFields(fields)
+}
+
+// WithComponent returns the logger of the component.
+func WithComponent(component string) *Entry {
+	return logger.WithField(FieldComponent, component)
+}
+
+// Debugf logs at the debug level without fields.
+func Debugf(format string, args ...interface{}) { logger.Debugf(format, args...) }
+
+// Infof logs at the info level without fields.
+func Infof(format string, args ...interface{}) { logger.Infof(format, args...) }
+
+// Warningf logs at the warning level without fields.
+func Warningf(format string, args ...interface{}) { logger.Warnf(format, args...) }
+
+// Errorf logs at the error level without fields.
+func Errorf(format string, args ...interface{}) { logger.Errorf(format, args...) }
+
+// Fatalf logs at the fatal level without fields and exits.
+func Fatalf(format string, args ...interface{}) { logger.Fatalf(format, args...) }
+
+// RedirectKlog sends the lines of klog, which is used by the kubernetes client libraries, to the logger with the klog
+// component. Their verbosity is still controlled by the verbosity of klog.
+func RedirectKlog(verbosity string) error {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	for name, value := range map[string]string{"logtostderr": "false", "stderrthreshold": "FATAL", "v": verbosity} {
+		if err := fs.Set(name, value); err != nil {
+			return errors.Wrapf(err, "klog flag -%s", name)
+		}
+	}
+	// klog writes a line to the outputs of its severity and all the lower ones, so only the info output is kept.
+	klog.SetOutputBySeverity("INFO", &klogWriter{entry: WithComponent("klog")})
+	for _, severity := range []string{"WARNING", "ERROR", "FATAL"} {
+		klog.SetOutputBySeverity(severity, ioutil.Discard)
+	}
+	return nil
+}
+
+// klogHeader matches the header of a klog line, e.g. "E1018 16:09:53.123456   12345 reflector.go:134] ".
+var klogHeader = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ ([^\]]+)\] `)
+
+// klogWriter logs the lines written by klog at the level of their severity, with their caller.
+type klogWriter struct {
+	entry *Entry
+}
+
+func (w *klogWriter) Write(data []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		header := klogHeader.FindStringSubmatch(line)
+		if header == nil {
+			if strings.TrimSpace(line) != "" {
+				w.entry.Info(line)
+			}
+			continue
+		}
+		entry, message := w.entry.WithField("caller", header[2]), line[len(header[0]):]
+		switch header[1] {
+		case "W":
+			entry.Warn(message)
+		case "E", "F":
+			entry.Error(message)
+		default:
+			entry.Info(message)
+		}
+	}
+	return len(data), nil
+}
@@ -0,0 +1,131 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// capture logs to the returned buffer until the returned function is called.
+func capture(t *testing.T) (*bytes.Buffer, func()) {
+	out := &bytes.Buffer{}
+	previous, level := logger.Out, Level()
+	logger.Out = out
+	return out, func() {
+		logger.Out = previous
+		SetLevel(level)
+	}
+}
+
+func decodeLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("expected a JSON line, got %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestJSONFields(t *testing.T) {
+	out, restore := capture(t)
+	defer restore()
+
+	WithFields(Fields{FieldMigrate: "default/demo", FieldApp: "demo"}).WithField(FieldRelease, "demo-gz01-blue").
+		Info("Installed the release")
+	Debugf("Not logged at the info level")
+
+	lines := decodeLines(t, out)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %v", lines)
+	}
+	for key, value := range map[string]string{"level": "info", "message": "Installed the release", FieldMigrate: "default/demo",
+		FieldApp: "demo", FieldRelease: "demo-gz01-blue"} {
+		if lines[0][key] != value {
+			t.Errorf("expected %s %q, got %v", key, value, lines[0][key])
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	_, restore := capture(t)
+	defer restore()
+	defer Configure(FormatJSON, "info")
+
+	if err := Configure("xml", "info"); err == nil {
+		t.Errorf("expected the xml format to be rejected")
+	}
+	if err := Configure(FormatText, "verbose"); err == nil {
+		t.Errorf("expected the verbose level to be rejected")
+	}
+	if err := Configure(FormatText, "debug"); err != nil || Level() != "debug" {
+		t.Errorf("expected the debug level, got %s: %v", Level(), err)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	_, restore := capture(t)
+	defer restore()
+	SetLevel("info")
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           string
+		expectedStatus int
+		expectedLevel  string
+	}{
+		{name: "get", method: http.MethodGet, url: "/debug/loglevel", expectedStatus: http.StatusOK, expectedLevel: "info"},
+		{name: "put query", method: http.MethodPut, url: "/debug/loglevel?level=debug", expectedStatus: http.StatusOK, expectedLevel: "debug"},
+		{name: "put body", method: http.MethodPut, url: "/debug/loglevel", body: `{"level": "warning"}`,
+			expectedStatus: http.StatusOK, expectedLevel: "warning"},
+		{name: "invalid level", method: http.MethodPut, url: "/debug/loglevel?level=verbose", expectedStatus: http.StatusBadRequest,
+			expectedLevel: "warning"},
+		{name: "delete", method: http.MethodDelete, url: "/debug/loglevel", expectedStatus: http.StatusMethodNotAllowed,
+			expectedLevel: "warning"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		LevelHandler()(w, httptest.NewRequest(test.method, test.url, strings.NewReader(test.body)))
+		if w.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", test.name, test.expectedStatus, w.Code, w.Body.String())
+		}
+		if Level() != test.expectedLevel {
+			t.Errorf("%s: expected the level %s, got %s", test.name, test.expectedLevel, Level())
+		}
+	}
+}
+
+func TestKlogWriter(t *testing.T) {
+	out, restore := capture(t)
+	defer restore()
+
+	w := &klogWriter{entry: WithComponent("klog")}
+	w.Write([]byte("E1018 16:09:53.123456   12345 reflector.go:134] Failed to list *v1.Migrate: forbidden\n" +
+		"I1018 16:09:54.000001   12345 leaderelection.go:205] attempting to acquire leader lease\n"))
+
+	lines := decodeLines(t, out)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %v", lines)
+	}
+	expected := []map[string]string{
+		{"level": "error", "caller": "reflector.go:134", "message": "Failed to list *v1.Migrate: forbidden", FieldComponent: "klog"},
+		{"level": "info", "caller": "leaderelection.go:205", "message": "attempting to acquire leader lease", FieldComponent: "klog"},
+	}
+	for i := range expected {
+		for key, value := range expected[i] {
+			if lines[i][key] != value {
+				t.Errorf("line %d: expected %s %q, got %v", i, key, value, lines[i][key])
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Namespace prefixes the names of the metrics of the operator.
+const Namespace = "sym_operator"
+
+// The values of the result label.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// SyncDuration observes the duration of syncing a migrate by the action and the result.
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "The duration of syncing a migrate by the action and the result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"action", "result"})
+
+	// HelmOperationDuration observes the latency of each helm operation by the operation and the result.
+	HelmOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "helm_operation_duration_seconds",
+		Help:      "The latency of the helm operations by the operation and the result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"operation", "result"})
+
+	// ReleaseOperations counts the installs, the updates and the uninstalls of the releases of each app.
+	ReleaseOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "release_operations_total",
+		Help:      "The installs, the updates and the uninstalls of the releases by the app, the operation and the result.",
+	}, []string{"app", "operation", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(SyncDuration, HelmOperationDuration, ReleaseOperations)
+	workqueue.SetProvider(newQueueMetricsProvider(prometheus.DefaultRegisterer))
+}
+
+// Result returns the result label of the error.
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// ObserveSync records the duration of syncing a migrate since the start.
+func ObserveSync(action string, err error, start time.Time) {
+	SyncDuration.WithLabelValues(action, Result(err)).Observe(time.Since(start).Seconds())
+}
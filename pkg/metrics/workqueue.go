@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// queueMetricsProvider exposes the metrics of the work queues labeled by the name of the queue. The work queues
+// report the durations in microseconds, they are exposed in seconds.
+type queueMetricsProvider struct {
+	depth                   *prometheus.GaugeVec
+	adds                    *prometheus.CounterVec
+	latency                 *prometheus.HistogramVec
+	workDuration            *prometheus.HistogramVec
+	unfinishedWork          *prometheus.GaugeVec
+	longestRunningProcessor *prometheus.GaugeVec
+	retries                 *prometheus.CounterVec
+}
+
+func newQueueMetricsProvider(registerer prometheus.Registerer) *queueMetricsProvider {
+	p := &queueMetricsProvider{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_depth",
+			Help:      "The number of the items waiting in the work queue.",
+		}, []string{"name"}),
+		adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_adds_total",
+			Help:      "The number of the items added to the work queue.",
+		}, []string{"name"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_queue_duration_seconds",
+			Help:      "How long the items stay in the work queue before being processed.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"name"}),
+		workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_work_duration_seconds",
+			Help:      "How long processing an item from the work queue takes.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"name"}),
+		unfinishedWork: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_unfinished_work_seconds",
+			Help:      "The total duration of the items being processed, a growing value indicates stuck workers.",
+		}, []string{"name"}),
+		longestRunningProcessor: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_longest_running_processor_seconds",
+			Help:      "The duration of the longest running item being processed.",
+		}, []string{"name"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "workqueue_retries_total",
+			Help:      "The number of the items requeued with rate limiting after a failure.",
+		}, []string{"name"}),
+	}
+	registerer.MustRegister(p.depth, p.adds, p.latency, p.workDuration, p.unfinishedWork, p.longestRunningProcessor,
+		p.retries)
+	return p
+}
+
+func (p *queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return p.depth.WithLabelValues(name)
+}
+
+func (p *queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return p.adds.WithLabelValues(name)
+}
+
+func (p *queueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	return microseconds{p.latency.WithLabelValues(name)}
+}
+
+func (p *queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return microseconds{p.workDuration.WithLabelValues(name)}
+}
+
+func (p *queueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.unfinishedWork.WithLabelValues(name)
+}
+
+func (p *queueMetricsProvider) NewLongestRunningProcessorMicrosecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return microsecondsGauge{p.longestRunningProcessor.WithLabelValues(name)}
+}
+
+func (p *queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return p.retries.WithLabelValues(name)
+}
+
+// microseconds observes the durations reported in microseconds in seconds.
+type microseconds struct {
+	observer prometheus.Observer
+}
+
+func (m microseconds) Observe(value float64) {
+	m.observer.Observe(value / 1e6)
+}
+
+// microsecondsGauge sets the durations reported in microseconds in seconds.
+type microsecondsGauge struct {
+	gauge prometheus.Gauge
+}
+
+func (m microsecondsGauge) Set(value float64) {
+	m.gauge.Set(value / 1e6)
+}
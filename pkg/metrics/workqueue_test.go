@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestQueueMetricsProvider(t *testing.T) {
+	p := newQueueMetricsProvider(prometheus.NewRegistry())
+	depth := p.NewDepthMetric("test")
+	depth.Inc()
+	depth.Inc()
+	depth.Dec()
+	p.NewRetriesMetric("test").Inc()
+	// The work queues report the durations in microseconds.
+	p.NewLatencyMetric("test").Observe(1500000)
+	p.NewLongestRunningProcessorMicrosecondsMetric("test").Set(2000000)
+
+	m := &dto.Metric{}
+	p.depth.WithLabelValues("test").Write(m)
+	if value := m.GetGauge().GetValue(); value != 1 {
+		t.Errorf("expected depth 1, got %v", value)
+	}
+	m = &dto.Metric{}
+	p.retries.WithLabelValues("test").Write(m)
+	if value := m.GetCounter().GetValue(); value != 1 {
+		t.Errorf("expected 1 retry, got %v", value)
+	}
+	m = &dto.Metric{}
+	p.latency.WithLabelValues("test").(prometheus.Metric).Write(m)
+	if sum := m.GetHistogram().GetSampleSum(); sum != 1.5 {
+		t.Errorf("expected the latency in seconds 1.5, got %v", sum)
+	}
+	m = &dto.Metric{}
+	p.longestRunningProcessor.WithLabelValues("test").Write(m)
+	if value := m.GetGauge().GetValue(); value != 2 {
+		t.Errorf("expected the longest running processor in seconds 2, got %v", value)
+	}
+}
@@ -1,50 +1,39 @@
 package monitor
 
 import (
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/yangyongzhi/sym-operator/pkg/helm"
 	"net/http"
-)
-
-const MonitorAddr = ":44100"
+	"time"
 
-const (
-	isReadyMessage = "I am health."
-	isAliveMessage = "I am alive."
-	notLiveMessage = "The container is not live now."
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yangyongzhi/sym-operator/pkg/health"
 )
 
-type LivenessHandler struct {
-	helmClient *helm.Client
-}
-
-func NewLivenessHandler(helmClient *helm.Client) *LivenessHandler {
-	livenessHandler := &LivenessHandler{
-		helmClient: helmClient,
-	}
-	return livenessHandler
-}
-
-func (l *LivenessHandler) livenessProbe(w http.ResponseWriter, r *http.Request) {
-	err := l.helmClient.KeepLive()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(notLiveMessage))
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(isAliveMessage))
-	}
-}
-
-func readinessProbe(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(isReadyMessage))
+// Probes are the health checks served by the monitor.
+type Probes struct {
+	// Readiness decides whether the replica receives the traffic, e.g. the caches have synced and it is leading.
+	Readiness []health.Check
+	// Liveness decides whether the container is restarted, it should only check the process itself, so a slow
+	// dependency such as tiller does not restart the operator.
+	Liveness []health.Check
+	// All are listed by /healthz/verbose, including the dependencies which affect neither readiness nor liveness.
+	All []health.Check
+	// Timeout fails a check which has not returned in time.
+	Timeout time.Duration
 }
 
-func NewProbesMux(helmClient *helm.Client) *http.ServeMux {
+// NewProbesMux serves the probes: /readiness and /readyz for the readiness checks, /liveness, /livez and /healthz for
+// the liveness checks and /healthz/verbose for the result of each check. The results of the checks are listed with
+// the verbose query parameter.
+func NewProbesMux(probes Probes) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/readiness", readinessProbe)
-	mux.HandleFunc("/liveness", NewLivenessHandler(helmClient).livenessProbe)
+	readiness := health.Handler(probes.Readiness, probes.Timeout)
+	mux.HandleFunc("/readiness", readiness)
+	mux.HandleFunc("/readyz", readiness)
+	liveness := health.Handler(probes.Liveness, probes.Timeout)
+	mux.HandleFunc("/liveness", liveness)
+	mux.HandleFunc("/livez", liveness)
+	mux.HandleFunc("/healthz", liveness)
+	mux.HandleFunc("/healthz/verbose", health.VerboseHandler(probes.All, probes.Timeout))
 	return mux
 }
 
@@ -17,16 +17,17 @@ limitations under the License.
 package monitor
 
 import (
-	"k8s.io/klog"
 	"net/http"
 
 	_ "net/http/pprof"
+
+	"github.com/yangyongzhi/sym-operator/pkg/log"
 )
 
-const TraceAdd = ":44101"
+var logger = log.WithComponent("monitor")
 
-func StartTracing() {
-	klog.Infof("Tracing server is listening on [%s]\n", TraceAdd)
+func StartTracing(addr string) {
+	logger.Infof("Tracing server is listening on [%s]\n", addr)
 	//grpc.EnableTracing = true
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -39,8 +40,8 @@ func StartTracing() {
 	})
 
 	go func() {
-		if err := http.ListenAndServe(TraceAdd, nil); err != nil {
-			klog.Infof("tracing error: %s", err)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			logger.Infof("tracing error: %s", err)
 		}
 	}()
 }
@@ -53,6 +54,10 @@ const traceIndexHTML = `<!DOCTYPE html>
       <li><a href="/debug/events">events</a></li>
       <li><a href="/debug/pprof">pprof</a></li>
       <li><a href="/debug/vars">vars</a></li>
+      <li><a href="/debug/queue">work queue</a></li>
+      <li><a href="/debug/migrates">migrates</a></li>
+      <li><a href="/debug/helm">running helm operations</a></li>
+      <li><a href="/debug/releases">releases</a></li>
     </ul>
   </body>
 </html>
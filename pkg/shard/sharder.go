@@ -0,0 +1,427 @@
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
+)
+
+var logger = log.WithComponent("sharder")
+
+// MemberLabel is the label of the membership leases, its value is the name of the sharder.
+const MemberLabel = "sym-operator/shard-member"
+
+// Config is the configuration of the sharding.
+type Config struct {
+	// Namespace of the leases.
+	Namespace string
+	// Name is the prefix of the names of the leases.
+	Name string
+	// Identity of this replica, it should be unique among the replicas.
+	Identity string
+	// Shards is the number of the shards.
+	Shards int
+
+	// LeaseDuration is the duration after which the lease of a replica which has stopped renewing is taken over.
+	LeaseDuration time.Duration
+	// RenewPeriod is the interval to renew the leases, it should be much shorter than the lease duration.
+	RenewPeriod time.Duration
+}
+
+// Of returns the shard of the key.
+func Of(key string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// Sharder claims shards through leases. Each replica keeps a membership lease, the shards are spread over the live
+// members and a replica releases the shards which belong to other members after a rebalance, so they can be
+// acquired by them.
+type Sharder struct {
+	client coordinationclient.LeasesGetter
+	config Config
+	// onAcquired is called with each shard acquired by this replica.
+	onAcquired func(shard int)
+	now        func() time.Time
+
+	mu sync.RWMutex
+	// held records the last renew time of the shards held by this replica.
+	held map[int]time.Time
+	// releasing records when this replica began to release each shard, it is not processed any more meanwhile.
+	releasing map[int]time.Time
+	// syncing counts the keys of each shard which are being synced.
+	syncing map[int]int
+}
+
+// NewSharder creates a sharder with the lease client, onAcquired is called with each shard acquired.
+func NewSharder(client coordinationclient.LeasesGetter, config Config, onAcquired func(shard int)) *Sharder {
+	return &Sharder{
+		client:     client,
+		config:     config,
+		onAcquired: onAcquired,
+		now:        time.Now,
+		held:       map[int]time.Time{},
+		releasing:  map[int]time.Time{},
+		syncing:    map[int]int{},
+	}
+}
+
+// Run renews the leases until the stop channel is closed, then the leases are released once the keys being synced
+// are done, so the other replicas take over the shards without waiting for them to expire.
+func (s *Sharder) Run(stopCh <-chan struct{}) {
+	logger.Infof("Sharding the migrates into %d shards as %s", s.config.Shards, s.config.Identity)
+	wait.Until(s.sync, s.config.RenewPeriod, stopCh)
+	s.release()
+}
+
+// Owns tells whether this replica holds the shard of the key.
+func (s *Sharder) Owns(key string) bool {
+	return s.holds(s.ShardOf(key))
+}
+
+// Begin marks the key as being synced, the shard of it is not released by this replica until done is called. It
+// returns false if the shard is not held.
+func (s *Sharder) Begin(key string) (done func(), ok bool) {
+	shard := s.ShardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.holdsLocked(shard) {
+		return nil, false
+	}
+	s.syncing[shard]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.syncing[shard]--; s.syncing[shard] <= 0 {
+				delete(s.syncing, shard)
+			}
+		})
+	}, true
+}
+
+// Holds tells whether this replica holds the shard.
+func (s *Sharder) Holds(shard int) bool {
+	return s.holds(shard)
+}
+
+// Holding tells whether this replica holds any shard.
+func (s *Sharder) Holding() bool {
+	return len(s.Held()) > 0
+}
+
+// Held returns the shards held by this replica in order.
+func (s *Sharder) Held() []int {
+	var shards []int
+	for shard := 0; shard < s.config.Shards; shard++ {
+		if s.holds(shard) {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// holds tells whether the lease of the shard is held and is not being released, a shard whose lease could not be
+// renewed in time is not processed any more before the lease expires and another replica can take it over.
+func (s *Sharder) holds(shard int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.holdsLocked(shard)
+}
+
+func (s *Sharder) holdsLocked(shard int) bool {
+	renewed, ok := s.held[shard]
+	_, releasing := s.releasing[shard]
+	return ok && !releasing && s.now().Sub(renewed) < s.workDuration()
+}
+
+// claims tells whether this replica has held the shard and has not released it yet, even if it is not processed.
+func (s *Sharder) claims(shard int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.held[shard]
+	return ok
+}
+
+// workDuration is how long a shard is processed since its lease was renewed, a renew period shorter than the lease
+// duration, so the work has stopped by the time the lease can be taken over.
+func (s *Sharder) workDuration() time.Duration {
+	if duration := s.config.LeaseDuration - s.config.RenewPeriod; duration > 0 {
+		return duration
+	}
+	return s.config.LeaseDuration
+}
+
+// sync renews the membership, then acquires or renews the shards assigned to this replica and releases the others.
+func (s *Sharder) sync() {
+	if err := s.renewMembership(); err != nil {
+		logger.Errorf("Error renewing the membership of %s: %s", s.config.Identity, err.Error())
+		return
+	}
+	members, err := s.liveMembers()
+	if err != nil {
+		logger.Errorf("Error listing the members of %s: %s", s.config.Name, err.Error())
+		return
+	}
+	assigned := assign(members, s.config.Shards)[s.config.Identity]
+
+	for shard := 0; shard < s.config.Shards; shard++ {
+		if !assigned[shard] {
+			if s.claims(shard) {
+				s.releaseShard(shard)
+			}
+			continue
+		}
+		// The keys skipped while the shard was being released are enqueued again.
+		resumed := s.cancelRelease(shard)
+		acquired, err := s.acquireShard(shard)
+		if err != nil {
+			logger.Errorf("Error acquiring shard %d: %s", shard, err.Error())
+			continue
+		}
+		if (acquired || resumed) && s.onAcquired != nil {
+			s.onAcquired(shard)
+		}
+	}
+}
+
+// assign spreads the shards over the members sorted by identity.
+func assign(members []string, shards int) map[string]map[int]bool {
+	assigned := map[string]map[int]bool{}
+	if len(members) == 0 {
+		return assigned
+	}
+	sort.Strings(members)
+	for shard := 0; shard < shards; shard++ {
+		member := members[shard%len(members)]
+		if assigned[member] == nil {
+			assigned[member] = map[int]bool{}
+		}
+		assigned[member][shard] = true
+	}
+	return assigned
+}
+
+func (s *Sharder) memberLeaseName() string {
+	h := fnv.New32a()
+	h.Write([]byte(s.config.Identity))
+	return fmt.Sprintf("%s-member-%08x", s.config.Name, h.Sum32())
+}
+
+func (s *Sharder) shardLeaseName(shard int) string {
+	return fmt.Sprintf("%s-shard-%d", s.config.Name, shard)
+}
+
+func (s *Sharder) renewMembership() error {
+	leases := s.client.Leases(s.config.Namespace)
+	now := s.now()
+	lease, err := leases.Get(s.memberLeaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1beta1.Lease{ObjectMeta: metav1.ObjectMeta{
+			Name:      s.memberLeaseName(),
+			Namespace: s.config.Namespace,
+			Labels:    map[string]string{MemberLabel: s.config.Name},
+		}}
+		s.hold(lease, now)
+		_, err = leases.Create(lease)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease = lease.DeepCopy()
+	s.hold(lease, now)
+	_, err = leases.Update(lease)
+	return err
+}
+
+// liveMembers returns the identities of the members whose leases have not expired.
+func (s *Sharder) liveMembers() ([]string, error) {
+	list, err := s.client.Leases(s.config.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", MemberLabel, s.config.Name)})
+	if err != nil {
+		return nil, err
+	}
+	var members []string
+	for i := range list.Items {
+		lease := &list.Items[i]
+		if !expired(lease, s.now()) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+	return members, nil
+}
+
+// acquireShard acquires the shard if it is free or renews it if it is held by this replica, it returns true if
+// the shard has been acquired just now.
+func (s *Sharder) acquireShard(shard int) (bool, error) {
+	leases := s.client.Leases(s.config.Namespace)
+	name := s.shardLeaseName(shard)
+	now := s.now()
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1beta1.Lease{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.config.Namespace}}
+		s.hold(lease, now)
+		if _, err := leases.Create(lease); err != nil {
+			return false, errors.Wrapf(err, "create lease %s", name)
+		}
+		return s.renewed(shard, now), nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "get lease %s", name)
+	}
+
+	if holder := holderOf(lease); holder != s.config.Identity && holder != "" && !expired(lease, now) {
+		// The shard is released by its holder once it sees this replica in the members.
+		logger.Debugf("Shard %d is held by %s", shard, holder)
+		s.drop(shard)
+		return false, nil
+	}
+	lease = lease.DeepCopy()
+	s.hold(lease, now)
+	if _, err := leases.Update(lease); err != nil {
+		return false, errors.Wrapf(err, "update lease %s", name)
+	}
+	return s.renewed(shard, now), nil
+}
+
+// releaseShard stops processing the shard and clears the holder of its lease once its keys being synced are done, or
+// the lease duration has passed. The lease is renewed meanwhile so no other replica syncs them at the same time.
+func (s *Sharder) releaseShard(shard int) {
+	if s.startRelease(shard) {
+		if _, err := s.acquireShard(shard); err != nil {
+			logger.Errorf("Error renewing shard %d being released: %s", shard, err.Error())
+		}
+		return
+	}
+	s.drop(shard)
+	leases := s.client.Leases(s.config.Namespace)
+	name := s.shardLeaseName(shard)
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	if err != nil {
+		logger.Errorf("Error getting lease %s: %s", name, err.Error())
+		return
+	}
+	if holderOf(lease) != s.config.Identity {
+		return
+	}
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	if _, err := leases.Update(lease); err != nil {
+		logger.Errorf("Error releasing lease %s: %s", name, err.Error())
+		return
+	}
+	logger.Infof("Released shard %d", shard)
+}
+
+// startRelease stops processing the shard, it returns true if the release should wait for the keys being synced.
+func (s *Sharder) startRelease(shard int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	started, ok := s.releasing[shard]
+	if !ok {
+		started = now
+		s.releasing[shard] = now
+	}
+	syncing := s.syncing[shard]
+	if syncing == 0 {
+		return false
+	}
+	if now.Sub(started) >= s.config.LeaseDuration {
+		logger.Warningf("Release shard %d while %d keys of it are still being synced", shard, syncing)
+		return false
+	}
+	if !ok {
+		logger.Infof("Releasing shard %d once %d keys of it are synced", shard, syncing)
+	}
+	return true
+}
+
+// cancelRelease processes the shard being released again, it returns true if it was being released.
+func (s *Sharder) cancelRelease(shard int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.releasing[shard]
+	delete(s.releasing, shard)
+	return ok
+}
+
+// release releases all the shards held by this replica and deletes its membership.
+func (s *Sharder) release() {
+	wait.PollImmediateInfinite(s.config.RenewPeriod, func() (bool, error) {
+		released := true
+		for shard := 0; shard < s.config.Shards; shard++ {
+			if s.claims(shard) {
+				s.releaseShard(shard)
+				released = released && !s.claims(shard)
+			}
+		}
+		return released, nil
+	})
+	if err := s.client.Leases(s.config.Namespace).Delete(s.memberLeaseName(), nil); err != nil {
+		logger.Errorf("Error deleting the membership of %s: %s", s.config.Identity, err.Error())
+	}
+}
+
+// hold sets this replica as the holder of the lease.
+func (s *Sharder) hold(lease *coordinationv1beta1.Lease, now time.Time) {
+	identity := s.config.Identity
+	duration := int32(s.config.LeaseDuration / time.Second)
+	renewTime := metav1.NewMicroTime(now)
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &renewTime
+}
+
+// renewed records the renew time of the shard, it returns true if the shard was not held before.
+func (s *Sharder) renewed(shard int, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	renewed, ok := s.held[shard]
+	s.held[shard] = now
+	acquired := !ok || now.Sub(renewed) >= s.workDuration()
+	if acquired {
+		logger.Infof("Acquired shard %d", shard)
+	}
+	return acquired
+}
+
+func (s *Sharder) drop(shard int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.held, shard)
+	delete(s.releasing, shard)
+}
+
+func holderOf(lease *coordinationv1beta1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func expired(lease *coordinationv1beta1.Lease, now time.Time) bool {
+	if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return lease.Spec.RenewTime.Add(duration).Before(now)
+}
+
+// ShardOf returns the shard of the key.
+func (s *Sharder) ShardOf(key string) int {
+	return Of(key, s.config.Shards)
+}
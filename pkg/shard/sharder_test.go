@@ -0,0 +1,199 @@
+package shard
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+func TestOf(t *testing.T) {
+	for _, key := range []string{"demo", "nginx", "order-service"} {
+		shard := Of(key, 4)
+		if shard < 0 || shard >= 4 {
+			t.Errorf("expected the shard of %s in [0, 4), got %d", key, shard)
+		}
+		if again := Of(key, 4); again != shard {
+			t.Errorf("expected the shard of %s to be stable, got %d and %d", key, shard, again)
+		}
+	}
+}
+
+func TestSharderRebalance(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Now()
+	acquired := map[string][]int{}
+	newSharder := func(identity string) *Sharder {
+		s := NewSharder(client.CoordinationV1beta1(), Config{Namespace: "kube-system", Name: "sym-operator",
+			Identity: identity, Shards: 4, LeaseDuration: 15 * time.Second, RenewPeriod: 5 * time.Second},
+			func(shard int) {
+				acquired[identity] = append(acquired[identity], shard)
+			})
+		s.now = func() time.Time { return now }
+		return s
+	}
+	a, b := newSharder("replica-a"), newSharder("replica-b")
+
+	a.sync()
+	if held := a.Held(); !reflect.DeepEqual(held, []int{0, 1, 2, 3}) {
+		t.Fatalf("expected the only replica to hold all shards, got %v", held)
+	}
+
+	// The new replica waits until the shards assigned to it are released by their holder.
+	b.sync()
+	if held := b.Held(); len(held) != 0 {
+		t.Fatalf("expected the new replica to hold no shard before the rebalance, got %v", held)
+	}
+	a.sync()
+	b.sync()
+	if held := a.Held(); !reflect.DeepEqual(held, []int{0, 2}) {
+		t.Errorf("expected replica-a to hold shards [0 2] after the rebalance, got %v", held)
+	}
+	if held := b.Held(); !reflect.DeepEqual(held, []int{1, 3}) {
+		t.Errorf("expected replica-b to hold shards [1 3] after the rebalance, got %v", held)
+	}
+	if !b.Holding() || a.Owns("demo") == b.Owns("demo") {
+		t.Errorf("expected exactly one replica to own the migrates of demo")
+	}
+
+	// The shards of a replica which has stopped renewing are taken over once its leases expire.
+	now = now.Add(20 * time.Second)
+	if b.Holding() {
+		t.Errorf("expected replica-b to stop processing its shards once their leases expired")
+	}
+	a.sync()
+	if held := a.Held(); !reflect.DeepEqual(held, []int{0, 1, 2, 3}) {
+		t.Errorf("expected replica-a to take over all shards, got %v", held)
+	}
+	// The leases of replica-a have expired as well, so its own shards are acquired again.
+	if expected := []int{0, 1, 2, 3, 0, 1, 2, 3}; !reflect.DeepEqual(acquired["replica-a"], expected) {
+		t.Errorf("expected replica-a to acquire shards %v, got %v", expected, acquired["replica-a"])
+	}
+}
+
+func TestSharderReleasesOnStop(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := NewSharder(client.CoordinationV1beta1(), Config{Namespace: "kube-system", Name: "sym-operator",
+		Identity: "replica-a", Shards: 2, LeaseDuration: 15 * time.Second, RenewPeriod: time.Second}, nil)
+	s.sync()
+	s.release()
+
+	if s.Holding() {
+		t.Errorf("expected no shard to be held after the release")
+	}
+	members, err := s.liveMembers()
+	if err != nil {
+		t.Fatalf("list members: %v", err)
+	}
+	if len(members) != 0 {
+		t.Errorf("expected the membership to be deleted, got %v", members)
+	}
+}
+
+// keyOf returns a key in the shard.
+func keyOf(shard, shards int) string {
+	for i := 0; ; i++ {
+		if key := fmt.Sprintf("app-%d", i); Of(key, shards) == shard {
+			return key
+		}
+	}
+}
+
+func TestSharderReleasesAfterSyncing(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Now()
+	newSharder := func(identity string) *Sharder {
+		s := NewSharder(client.CoordinationV1beta1(), Config{Namespace: "kube-system", Name: "sym-operator",
+			Identity: identity, Shards: 4, LeaseDuration: 15 * time.Second, RenewPeriod: 5 * time.Second}, nil)
+		s.now = func() time.Time { return now }
+		return s
+	}
+	a, b := newSharder("replica-a"), newSharder("replica-b")
+	a.sync()
+	b.sync()
+
+	// Shard 1 is assigned to replica-b once it joins, while a key of it is being synced by replica-a.
+	key := keyOf(1, 4)
+	done, ok := a.Begin(key)
+	if !ok {
+		t.Fatalf("expected replica-a to sync the key of a held shard")
+	}
+	a.sync()
+	if a.Owns(key) {
+		t.Errorf("expected replica-a to stop processing the shard being released")
+	}
+	if _, ok := a.Begin(key); ok {
+		t.Errorf("expected no new sync of the shard being released")
+	}
+	b.sync()
+	if held := b.Held(); !reflect.DeepEqual(held, []int{3}) {
+		t.Errorf("expected replica-b to wait for shard 1 being synced, got %v", held)
+	}
+
+	done()
+	a.sync()
+	b.sync()
+	if held := b.Held(); !reflect.DeepEqual(held, []int{1, 3}) {
+		t.Errorf("expected replica-b to acquire shard 1 once it is synced, got %v", held)
+	}
+}
+
+func TestSharderReleasesAfterTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Now()
+	newSharder := func(identity string) *Sharder {
+		s := NewSharder(client.CoordinationV1beta1(), Config{Namespace: "kube-system", Name: "sym-operator",
+			Identity: identity, Shards: 2, LeaseDuration: 15 * time.Second, RenewPeriod: 5 * time.Second}, nil)
+		s.now = func() time.Time { return now }
+		return s
+	}
+	a, b := newSharder("replica-a"), newSharder("replica-b")
+	a.sync()
+	b.sync()
+	if _, ok := a.Begin(keyOf(1, 2)); !ok {
+		t.Fatalf("expected replica-a to sync the key of a held shard")
+	}
+	a.sync()
+	b.sync()
+	if held := b.Held(); len(held) != 0 {
+		t.Errorf("expected replica-b to wait for shard 1 being synced, got %v", held)
+	}
+
+	// The shard is released even if the sync never ends.
+	for i := 0; i < 3; i++ {
+		now = now.Add(5 * time.Second)
+		a.sync()
+		b.sync()
+	}
+	if held := b.Held(); !reflect.DeepEqual(held, []int{1}) {
+		t.Errorf("expected replica-b to acquire shard 1 once the release has timed out, got %v", held)
+	}
+}
+
+func TestSharderStopsWhenRenewalFails(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Now()
+	s := NewSharder(client.CoordinationV1beta1(), Config{Namespace: "kube-system", Name: "sym-operator",
+		Identity: "replica-a", Shards: 2, LeaseDuration: 15 * time.Second, RenewPeriod: 5 * time.Second}, nil)
+	s.now = func() time.Time { return now }
+	s.sync()
+
+	client.PrependReactor("*", "leases", func(action core.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("apiserver is down")
+	})
+	now = now.Add(5 * time.Second)
+	s.sync()
+	if !s.Holding() {
+		t.Errorf("expected the shards to be processed while their leases are far from expiring")
+	}
+	// The leases are not expired yet, but they may be before the next renewal.
+	now = now.Add(6 * time.Second)
+	s.sync()
+	if s.Holding() {
+		t.Errorf("expected the shards not to be processed once the renewal has failed for close to the lease duration")
+	}
+}
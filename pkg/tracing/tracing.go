@@ -0,0 +1,220 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"golang.org/x/net/trace"
+)
+
+var logger = log.WithComponent("tracing")
+
+// maxPendingSpans bounds the spans waiting to be exported, the spans ended beyond it are dropped.
+const maxPendingSpans = 4096
+
+// TraceID identifies a trace, it is shared by all the spans of the trace.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid reports whether the trace ID is set.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// SpanID identifies a span within its trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the span ID is set.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// Attribute is a key value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns the attribute of the key.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData is the snapshot of an ended span handed to the exporter.
+type SpanData struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Name         string
+	Start, End   time.Time
+	Attributes   []Attribute
+	// Err is the error the span ended with, nil means the span succeeded.
+	Err error
+}
+
+// Exporter sends the ended spans to a collector.
+type Exporter interface {
+	ExportSpans(spans []SpanData) error
+}
+
+// Tracer starts the traces, the ended spans are batched and exported periodically by Run. Each trace is also shown on
+// the /debug/requests page of the default serve mux.
+type Tracer struct {
+	exporter Exporter
+
+	mu      sync.Mutex
+	pending []SpanData
+	dropped int
+}
+
+// NewTracer returns a tracer exporting the spans with the exporter, the spans are only shown on /debug/requests if
+// the exporter is nil.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start starts the root span of a new trace, the family groups the traces on /debug/requests.
+func (t *Tracer) Start(family, name string, attributes ...Attribute) *Span {
+	s := &Span{tracer: t, name: name, start: time.Now(), attributes: attributes}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+	s.events = trace.New(family, name)
+	s.events.LazyPrintf("trace %s", s.traceID)
+	s.logStart()
+	return s
+}
+
+// Run exports the ended spans every interval until the stop channel is closed, the remaining spans are exported
+// before it returns.
+func (t *Tracer) Run(interval time.Duration, stopCh <-chan struct{}) {
+	if t.exporter == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush()
+		case <-stopCh:
+			t.Flush()
+			return
+		}
+	}
+}
+
+// Flush exports the ended spans.
+func (t *Tracer) Flush() {
+	t.mu.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		logger.Warningf("Dropped %d spans, more than %d spans were waiting to be exported", dropped, maxPendingSpans)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.ExportSpans(spans); err != nil {
+		logger.Errorf("Export %d spans has an error : %s", len(spans), err.Error())
+	}
+}
+
+func (t *Tracer) export(data SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, data)
+}
+
+// Span is a timed operation of a trace. The methods of a nil span do nothing, so the code which may run outside of a
+// trace need not check it.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	traceID  TraceID
+	spanID   SpanID
+	parentID SpanID
+	start    time.Time
+	// events is the /debug/requests trace shared by all the spans of the trace.
+	events trace.Trace
+
+	mu         sync.Mutex
+	attributes []Attribute
+	ended      bool
+}
+
+// StartChild starts a span within the trace of the span.
+func (s *Span) StartChild(name string, attributes ...Attribute) *Span {
+	if s == nil {
+		return nil
+	}
+	child := &Span{tracer: s.tracer, name: name, traceID: s.traceID, parentID: s.spanID, start: time.Now(),
+		attributes: attributes, events: s.events}
+	rand.Read(child.spanID[:])
+	child.logStart()
+	return child
+}
+
+// TraceID returns the ID of the trace of the span, it is invalid for a nil span.
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.traceID
+}
+
+// SetAttributes adds the attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// End ends the span with the error of the operation, ending a span twice does nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{TraceID: s.traceID, SpanID: s.spanID, ParentSpanID: s.parentID, Name: s.name, Start: s.start,
+		End: time.Now(), Attributes: append([]Attribute(nil), s.attributes...), Err: err}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.events.LazyPrintf("%s failed after %s: %s", s.name, data.End.Sub(data.Start), err.Error())
+		s.events.SetError()
+	} else {
+		s.events.LazyPrintf("%s finished after %s", s.name, data.End.Sub(data.Start))
+	}
+	if !s.parentID.IsValid() {
+		s.events.Finish()
+	}
+	s.tracer.export(data)
+}
+
+func (s *Span) logStart() {
+	if len(s.attributes) == 0 {
+		s.events.LazyPrintf("%s started", s.name)
+		return
+	}
+	s.events.LazyPrintf("%s started %s", s.name, fmt.Sprint(s.attributes))
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type recordingExporter struct {
+	spans []SpanData
+}
+
+func (e *recordingExporter) ExportSpans(spans []SpanData) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+
+	root := tracer.Start("sync", "default/demo", String("app", "demo"))
+	child := root.StartChild("helm.install", String("helm.release", "demo-gz01-blue"))
+	child.End(fmt.Errorf("timed out"))
+	child.End(nil)
+	root.SetAttributes(String("result", "error"))
+	root.End(nil)
+	tracer.Flush()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %v", exporter.spans)
+	}
+	exportedChild, exportedRoot := exporter.spans[0], exporter.spans[1]
+	if !exportedRoot.TraceID.IsValid() || exportedChild.TraceID != exportedRoot.TraceID {
+		t.Errorf("expected the spans in the same trace, got %s and %s", exportedChild.TraceID, exportedRoot.TraceID)
+	}
+	if exportedRoot.ParentSpanID.IsValid() || exportedChild.ParentSpanID != exportedRoot.SpanID {
+		t.Errorf("expected the child of the root span %s, got %s", exportedRoot.SpanID, exportedChild.ParentSpanID)
+	}
+	if exportedChild.Err == nil || exportedRoot.Err != nil {
+		t.Errorf("expected only the child to fail, got %v and %v", exportedChild.Err, exportedRoot.Err)
+	}
+	if len(exportedRoot.Attributes) != 2 {
+		t.Errorf("expected 2 attributes of the root span, got %v", exportedRoot.Attributes)
+	}
+
+	tracer.Flush()
+	if len(exporter.spans) != 2 {
+		t.Errorf("expected the spans to be exported once, got %v", exporter.spans)
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	var span *Span
+	child := span.StartChild("helm.install")
+	child.SetAttributes(String("app", "demo"))
+	child.End(nil)
+	if child != nil || span.TraceID().IsValid() {
+		t.Errorf("expected a nil span to trace nothing")
+	}
+}
+
+func TestPendingSpansAreBounded(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+	root := tracer.Start("sync", "default/demo")
+	for i := 0; i < maxPendingSpans+10; i++ {
+		root.StartChild("helm.get").End(nil)
+	}
+	tracer.Flush()
+	if len(exporter.spans) != maxPendingSpans {
+		t.Errorf("expected %d spans, got %d", maxPendingSpans, len(exporter.spans))
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("expected the spans posted to /v1/traces, got %s", r.URL.Path)
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decode the spans: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL+"/", "sym-operator", time.Second)
+	span := SpanData{TraceID: TraceID{1}, SpanID: SpanID{2}, ParentSpanID: SpanID{3}, Name: "helm.update",
+		Start: time.Unix(0, 1), End: time.Unix(0, 2), Attributes: []Attribute{String("app", "demo")}, Err: fmt.Errorf("timed out")}
+	if err := exporter.ExportSpans([]SpanData{span}); err != nil {
+		t.Fatalf("export spans: %v", err)
+	}
+
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "sym-operator" {
+		t.Errorf("expected the service name sym-operator, got %v", service)
+	}
+	exported := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	expected := map[string]interface{}{
+		"traceId":           "01000000000000000000000000000000",
+		"spanId":            "0200000000000000",
+		"parentSpanId":      "0300000000000000",
+		"name":              "helm.update",
+		"startTimeUnixNano": "1",
+		"endTimeUnixNano":   "2",
+	}
+	for key, value := range expected {
+		if exported[key] != value {
+			t.Errorf("expected %s %v, got %v", key, value, exported[key])
+		}
+	}
+	if s := exported["status"].(map[string]interface{}); s["code"] != float64(statusCodeError) || s["message"] != "timed out" {
+		t.Errorf("expected the error status, got %v", s)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := exporter.ExportSpans([]SpanData{span}); err == nil {
+		t.Errorf("expected the export to fail once the collector is unavailable")
+	}
+}
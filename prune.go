@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	PrunedRelease      = "PrunedRelease"
+	KeptRelease        = "KeptRelease"
+	PruneLimitExceeded = "PruneLimitExceeded"
+	InvalidPruneLimit  = "InvalidPruneLimit"
+)
+
+// pruneDecision records what has been done with a running release which is not defined in the migrate.
+type pruneDecision struct {
+	name   string
+	pruned bool
+	reason string
+}
+
+// prune handles the running releases of the target which have not been defined in the migrate according to its
+// prune policy, the running releases of all targets are listed by cluster. The reasons of the kept releases are
+// recorded in kept. It returns true if any release has been purged in this sync.
+func (c *Controller) prune(t *target, migrate *v1.Migrate, targets []*target, clusterRlses map[string][]*release.Release,
+	kept map[string]string) bool {
+	var undefinedRlses []*release.Release
+	for _, runningRls := range clusterRlses[t.cluster] {
+		// A release which has been moved to another cluster is not defined in this one any more.
+		if !t.releases[runningRls.Name] {
+			undefinedRlses = append(undefinedRlses, runningRls)
+			delete(kept, runningRls.Name)
+		}
+	}
+	if len(undefinedRlses) == 0 {
+		return false
+	}
+
+	policy := prunePolicy(migrate, c.policy.get().DefaultPrunePolicy)
+	confirmed := confirmedReleases(migrate)
+	var decisions []*pruneDecision
+	var candidates []*release.Release
+	for _, rls := range undefinedRlses {
+		// The release is only removed from its old cluster once it has replaced it in the new one.
+		moveErr := movedReleaseAvailable(migrate, targets, clusterRlses, rls.Name)
+		switch {
+		case moveErr != nil:
+			decisions = append(decisions, &pruneDecision{name: rls.Name, reason: moveErr.Error()})
+		case policy == v1.PrunePolicyOrphan:
+			decisions = append(decisions, &pruneDecision{name: rls.Name, reason: "prune policy is Orphan"})
+		case policy != v1.PrunePolicyDelete && policy != v1.PrunePolicyConfirm:
+			decisions = append(decisions, &pruneDecision{name: rls.Name, reason: fmt.Sprintf("unknown prune policy %q", policy)})
+		case isProtected(t, rls):
+			decisions = append(decisions, &pruneDecision{name: rls.Name,
+				reason: fmt.Sprintf("its deployment has been annotated with %s", constant.ProtectedAnnotation)})
+		case policy == v1.PrunePolicyConfirm && !confirmed[rls.Name]:
+			decisions = append(decisions, &pruneDecision{name: rls.Name,
+				reason: fmt.Sprintf("waiting for confirmation in annotation %s", constant.ConfirmPruneAnnotation)})
+		default:
+			candidates = append(candidates, rls)
+		}
+	}
+
+	limit := pruneLimit(migrate)
+	if limit < 0 {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, InvalidPruneLimit,
+			fmt.Sprintf("The prune limit %d is negative, no release will be pruned", limit))
+		limit = 0
+	}
+	if len(candidates) > limit {
+		reason := fmt.Sprintf("prune limit %d is exceeded", limit)
+		refused := false
+		for _, rls := range candidates {
+			refused = refused || migrate.Status.KeptReleases[rls.Name] != reason
+			decisions = append(decisions, &pruneDecision{name: rls.Name, reason: reason})
+		}
+		// The refusal has been recorded if all the releases have been kept for it since the previous syncs.
+		if refused {
+			c.recorder.Event(migrate, corev1.EventTypeWarning, PruneLimitExceeded,
+				fmt.Sprintf("Refuse to prune %d releases %v, the prune limit is %d", len(candidates), releaseNames(candidates), limit))
+		}
+		candidates = nil
+	}
+
+	pruned := false
+	for _, rls := range candidates {
+		t.logFor(c.logFor(migrate)).WithField(log.FieldRelease, rls.Name).Info("Uninstall the release not defined in the migrate")
+		if err := t.helmClient.UninstallRelease(rls.Name); err != nil {
+			c.recorder.Event(migrate, corev1.EventTypeWarning, ErrDeleteRelease,
+				fmt.Sprintf("Delete release [%s] has an error : %s", rls.Name, err.Error()))
+			decisions = append(decisions, &pruneDecision{name: rls.Name, reason: fmt.Sprintf("uninstall failed: %s", err.Error())})
+			continue
+		}
+		pruned = true
+		decisions = append(decisions, &pruneDecision{name: rls.Name, pruned: true,
+			reason: fmt.Sprintf("not defined in migrate, prune policy is %s", policy)})
+	}
+
+	c.recordPruneDecisions(migrate, decisions, kept)
+	return pruned
+}
+
+// recordPruneDecisions records an event for each decision, and a summary listing all of them. The reasons of the
+// kept releases are recorded in kept, a kept release has no event unless its reason has changed since the status.
+func (c *Controller) recordPruneDecisions(migrate *v1.Migrate, decisions []*pruneDecision, kept map[string]string) {
+	var prunedNames, keptNames []string
+	for _, d := range decisions {
+		if d.pruned {
+			prunedNames = append(prunedNames, d.name)
+			c.recorder.Event(migrate, corev1.EventTypeNormal, PrunedRelease,
+				fmt.Sprintf("Release [%s] has been pruned: %s", d.name, d.reason))
+		} else {
+			keptNames = append(keptNames, d.name)
+			kept[d.name] = d.reason
+			if migrate.Status.KeptReleases[d.name] != d.reason {
+				c.recorder.Event(migrate, corev1.EventTypeNormal, KeptRelease,
+					fmt.Sprintf("Release [%s] is kept: %s", d.name, d.reason))
+			}
+		}
+	}
+
+	c.logFor(migrate).Infof("Pruned releases %v, kept %v", prunedNames, keptNames)
+}
+
+// keptReleases returns the reasons of the releases kept by the previous syncs which may still be kept, i.e. they
+// are running in a target which they are not defined in, all of them are if the releases of any target could not
+// be listed.
+func keptReleases(migrate *v1.Migrate, targets []*target, clusterRlses map[string][]*release.Release, listedAll bool) map[string]string {
+	undefined := map[string]bool{}
+	for _, t := range targets {
+		for _, rls := range clusterRlses[t.cluster] {
+			if !t.releases[rls.Name] {
+				undefined[rls.Name] = true
+			}
+		}
+	}
+	kept := map[string]string{}
+	for name, reason := range migrate.Status.KeptReleases {
+		if undefined[name] || !listedAll {
+			kept[name] = reason
+		}
+	}
+	return kept
+}
+
+// movedReleaseAvailable returns an error unless the release which has been moved to another target is deployed and
+// its deployments are available there, a release which is not defined in the migrate any more has not been moved.
+func movedReleaseAvailable(migrate *v1.Migrate, targets []*target, clusterRlses map[string][]*release.Release, rlsName string) error {
+	rlsConfig := findReleaseConfig(migrate, rlsName)
+	to := targetOf(targets, rlsName)
+	if rlsConfig == nil || to == nil {
+		return nil
+	}
+
+	deployed := false
+	for _, rls := range clusterRlses[to.cluster] {
+		if rls.Name == rlsName && rls.GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED {
+			deployed = true
+		}
+	}
+	if !deployed {
+		return errors.Errorf("waiting for it to be deployed%s", to.location())
+	}
+	deployments, err := to.listDeployments(rlsConfig.Namespace,
+		labels.SelectorFromSet(labels.Set{constant.ReleaseLabel: rlsName}))
+	if err != nil {
+		return errors.Wrapf(err, "list its deployments%s", to.location())
+	}
+	if len(deployments) == 0 {
+		return errors.Errorf("waiting for its deployments to be available%s", to.location())
+	}
+	for _, deploy := range deployments {
+		if !deploymentAvailable(deploy, rlsConfig.Replicas) {
+			return errors.Errorf("waiting for its deployment %s to be available%s", deploy.Name, to.location())
+		}
+	}
+	return nil
+}
+
+// isProtected tells whether any deployment of the release in the target cluster has been annotated as protected.
+func isProtected(t *target, rls *release.Release) bool {
+	selector := labels.SelectorFromSet(labels.Set{constant.ReleaseLabel: rls.Name})
+	deployments, err := t.listDeployments(rls.Namespace, selector)
+	if err != nil {
+		// Keep the release if we can not make sure whether it is protected or not.
+		t.logFor(log.WithFields(log.Fields{log.FieldRelease: rls.Name})).WithError(err).
+			Warning("List the deployments of the release has an error, keep it")
+		return true
+	}
+
+	for _, deploy := range deployments {
+		if deploy.Annotations[constant.ProtectedAnnotation] == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// prunePolicy returns the prune policy of the migrate, default to the one of the OperatorConfig, then Delete.
+func prunePolicy(migrate *v1.Migrate, defaultPolicy v1.PrunePolicyType) v1.PrunePolicyType {
+	if migrate.Spec.PrunePolicy == "" && defaultPolicy == "" {
+		return v1.PrunePolicyDelete
+	}
+	if migrate.Spec.PrunePolicy == "" {
+		return defaultPolicy
+	}
+	return migrate.Spec.PrunePolicy
+}
+
+func pruneLimit(migrate *v1.Migrate) int {
+	if migrate.Spec.PruneLimit == nil {
+		return constant.DefaultPruneLimit
+	}
+	return int(*migrate.Spec.PruneLimit)
+}
+
+func confirmedReleases(migrate *v1.Migrate) map[string]bool {
+	confirmed := map[string]bool{}
+	for _, name := range strings.Split(migrate.Annotations[constant.ConfirmPruneAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			confirmed[name] = true
+		}
+	}
+	return confirmed
+}
+
+func findReleaseConfig(migrate *v1.Migrate, rlsName string) *v1.ReleasesConfig {
+	for _, rls := range migrate.Spec.Releases {
+		if rls.Name == rlsName {
+			return rls
+		}
+	}
+	return nil
+}
+
+func releaseNames(rlses []*release.Release) []string {
+	names := make([]string, 0, len(rlses))
+	for _, rls := range rlses {
+		names = append(names, rls.Name)
+	}
+	return names
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// The reasons of the events of the relocation.
+const (
+	ReasonRelocationCopied     = "RelocationCopied"
+	ReasonRelocationInstalled  = "RelocationInstalled"
+	ReasonRelocationAvailable  = "RelocationAvailable"
+	ReasonRelocationCompleted  = "RelocationCompleted"
+	ReasonRelocationRolledBack = "RelocationRolledBack"
+	ReasonRelocationWaiting    = "RelocationWaiting"
+	ReasonRelocationFailed     = "RelocationFailed"
+)
+
+const defaultRelocationTimeout = 600 * time.Second
+
+var relocationReasons = map[v1.RelocationPhase]string{
+	v1.RelocationCopied:     ReasonRelocationCopied,
+	v1.RelocationInstalled:  ReasonRelocationInstalled,
+	v1.RelocationAvailable:  ReasonRelocationAvailable,
+	v1.RelocationCompleted:  ReasonRelocationCompleted,
+	v1.RelocationRolledBack: ReasonRelocationRolledBack,
+}
+
+// relocate moves the releases of the migrate to the target namespace step by step: copy the referenced configmaps
+// and secrets, install the releases under the new names, wait for them to be available and uninstall the old ones.
+// The new releases and the copies are deleted if they are not available within the timeout.
+func (c *Controller) relocate(migrate *v1.Migrate) error {
+	if migrate.Status.Finished == constant.ConditionStatusTrue {
+		c.logFor(migrate).Info("The relocation has finished, nothing to do")
+		return nil
+	}
+
+	config := migrate.Spec.Relocation
+	if config == nil || config.TargetNamespace == "" {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ReasonRelocationFailed, "The target namespace of the relocation is required")
+		return nil
+	}
+	names, err := relocatedNames(migrate)
+	if err != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ReasonRelocationFailed, err.Error())
+		return nil
+	}
+	local, err := c.localTarget(migrate)
+	if err != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, ErrHelmClient,
+			fmt.Sprintf("Can not connect to the tiller in namespace [%s] : %s", migrate.Spec.TillerNamespace, err.Error()))
+		return err
+	}
+	defer local.release()
+
+	migrateCopy := migrate.DeepCopy()
+	status := migrateCopy.Status.Relocation
+	if status == nil {
+		status = &v1.RelocationStatus{}
+		migrateCopy.Status.Relocation = status
+	}
+	status.Releases = names
+
+	// Each step moves on to the next one once it has been done, the relocation stops at the first step waiting
+	// for something and continues in the next sync.
+	for done := false; !done && status.Phase != v1.RelocationCompleted && status.Phase != v1.RelocationRolledBack; {
+		switch status.Phase {
+		case "":
+			done = c.advanceRelocation(migrateCopy, v1.RelocationCopied, c.copyReferences(migrateCopy, local))
+		case v1.RelocationCopied:
+			err := c.installRelocated(migrateCopy, local)
+			if err == nil {
+				now := metav1.Now()
+				status.InstalledTime = &now
+			}
+			done = c.advanceRelocation(migrateCopy, v1.RelocationInstalled, err)
+		case v1.RelocationInstalled:
+			err := c.checkRelocated(migrateCopy)
+			if err != nil && relocationTimedOut(migrateCopy) {
+				c.recorder.Event(migrateCopy, corev1.EventTypeWarning, ReasonRelocationFailed,
+					fmt.Sprintf("The relocated releases are not available in time, roll back : %s", err.Error()))
+				done = c.advanceRelocation(migrateCopy, v1.RelocationRolledBack, c.rollbackRelocation(migrateCopy, local))
+				continue
+			}
+			done = c.advanceRelocation(migrateCopy, v1.RelocationAvailable, err)
+		case v1.RelocationAvailable:
+			done = c.advanceRelocation(migrateCopy, v1.RelocationCompleted, c.uninstallRelocated(migrateCopy, local))
+		default:
+			c.recorder.Event(migrate, corev1.EventTypeWarning, ReasonRelocationFailed,
+				fmt.Sprintf("Unknown phase [%s] of the relocation", status.Phase))
+			return nil
+		}
+	}
+
+	now := metav1.Now()
+	migrateCopy.Status.LastUpdateTime = &now
+	if status.Phase == v1.RelocationCompleted || status.Phase == v1.RelocationRolledBack {
+		migrateCopy.Status.Finished = constant.ConditionStatusTrue
+	} else {
+		migrateCopy.Status.Finished = constant.ConditionStatusFalse
+	}
+
+	return c.traceWrite(migrate, "update", "migrates", migrate.Namespace, migrate.Name, func() error {
+		_, err := c.symclientset.DevopsV1().Migrates(migrate.Namespace).Update(migrateCopy)
+		return err
+	})
+}
+
+// advanceRelocation moves the relocation to the phase if the step has no error, it returns true if the relocation
+// should stop and wait for the next sync.
+func (c *Controller) advanceRelocation(migrateCopy *v1.Migrate, phase v1.RelocationPhase, err error) bool {
+	status := migrateCopy.Status.Relocation
+	if err != nil {
+		status.Message = err.Error()
+		c.logFor(migrateCopy).WithField("phase", status.Phase).Infof("The relocation is waiting : %s", err.Error())
+		c.recorder.Event(migrateCopy, corev1.EventTypeNormal, ReasonRelocationWaiting, status.Message)
+		return true
+	}
+
+	now := metav1.Now()
+	status.Phase = phase
+	status.LastTransitionTime = &now
+	status.Message = fmt.Sprintf("The relocation has reached phase %s", phase)
+	c.logFor(migrateCopy).WithField("phase", phase).Info("The relocation has reached the phase")
+	eventType := corev1.EventTypeNormal
+	if phase == v1.RelocationRolledBack {
+		eventType = corev1.EventTypeWarning
+	}
+	c.recorder.Event(migrateCopy, eventType, relocationReasons[phase], status.Message)
+	return false
+}
+
+// relocatedNames returns the names of the releases in the target namespace, they should still be recognized as the
+// releases of the app.
+func relocatedNames(migrate *v1.Migrate) (map[string]string, error) {
+	config := migrate.Spec.Relocation
+	pattern := regexp.MustCompile(fmt.Sprintf("^%s(-gz|-rz).*(-%s|-%s)$",
+		regexp.QuoteMeta(migrate.Spec.AppName), constant.BlueGroup, constant.GreenGroup))
+	names := map[string]string{}
+	for _, rls := range migrate.Spec.Releases {
+		name, ok := config.ReleaseNames[rls.Name]
+		if !ok {
+			name = rls.Name
+			for _, group := range []string{constant.BlueGroup, constant.GreenGroup} {
+				if strings.HasSuffix(rls.Name, "-"+group) {
+					name = fmt.Sprintf("%s-%s-%s", strings.TrimSuffix(rls.Name, "-"+group), config.TargetNamespace, group)
+				}
+			}
+		}
+		if name == rls.Name || !pattern.MatchString(name) {
+			return nil, errors.Errorf("the new name %q of release %s should be different and match %s", name, rls.Name, pattern)
+		}
+		names[rls.Name] = name
+	}
+	return names, nil
+}
+
+// copyReferences copies the configmaps and the secrets referenced by the deployments of the releases to the target
+// namespace, the existing ones are left untouched.
+func (c *Controller) copyReferences(migrateCopy *v1.Migrate, local *target) error {
+	status := migrateCopy.Status.Relocation
+	targetNamespace := migrateCopy.Spec.Relocation.TargetNamespace
+	for _, rls := range migrateCopy.Spec.Releases {
+		selector := labels.SelectorFromSet(labels.Set{constant.ReleaseLabel: rls.Name})
+		deployments, err := local.listDeployments(rls.Namespace, selector)
+		if err != nil {
+			return errors.Wrapf(err, "list deployments of release %s", rls.Name)
+		}
+		for _, deploy := range deployments {
+			configMaps, secrets := podReferences(&deploy.Spec.Template.Spec)
+			for _, name := range configMaps {
+				copied, err := c.copyConfigMap(migrateCopy, rls.Namespace, targetNamespace, name)
+				if err != nil {
+					return err
+				}
+				if copied {
+					status.CopiedConfigMaps = append(status.CopiedConfigMaps, name)
+				}
+			}
+			for _, name := range secrets {
+				copied, err := c.copySecret(migrateCopy, rls.Namespace, targetNamespace, name)
+				if err != nil {
+					return err
+				}
+				if copied {
+					status.CopiedSecrets = append(status.CopiedSecrets, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Controller) copyConfigMap(migrate *v1.Migrate, namespace, targetNamespace, name string) (bool, error) {
+	if _, err := c.kubeclientset.CoreV1().ConfigMaps(targetNamespace).Get(name, metav1.GetOptions{}); err == nil {
+		return false, nil
+	}
+	cm, err := c.kubeclientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "get configmap %s/%s", namespace, name)
+	}
+	cmCopy := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: targetNamespace, Labels: cm.Labels, Annotations: cm.Annotations},
+		Data:       cm.Data,
+		BinaryData: cm.BinaryData,
+	}
+	err = c.traceWrite(migrate, "create", "configmaps", targetNamespace, name, func() error {
+		_, err := c.kubeclientset.CoreV1().ConfigMaps(targetNamespace).Create(cmCopy)
+		return err
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "copy configmap %s to namespace %s", name, targetNamespace)
+	}
+	return true, nil
+}
+
+func (c *Controller) copySecret(migrate *v1.Migrate, namespace, targetNamespace, name string) (bool, error) {
+	if _, err := c.kubeclientset.CoreV1().Secrets(targetNamespace).Get(name, metav1.GetOptions{}); err == nil {
+		return false, nil
+	}
+	secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "get secret %s/%s", namespace, name)
+	}
+	// The service account tokens are issued for the target namespace by kubernetes itself.
+	if secret.Type == corev1.SecretTypeServiceAccountToken {
+		return false, nil
+	}
+	secretCopy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: targetNamespace, Labels: secret.Labels, Annotations: secret.Annotations},
+		Type:       secret.Type,
+		Data:       secret.Data,
+	}
+	err = c.traceWrite(migrate, "create", "secrets", targetNamespace, name, func() error {
+		_, err := c.kubeclientset.CoreV1().Secrets(targetNamespace).Create(secretCopy)
+		return err
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "copy secret %s to namespace %s", name, targetNamespace)
+	}
+	return true, nil
+}
+
+// podReferences returns the names of the configmaps and the secrets referenced by the volumes, the environments and
+// the image pull secrets of the pod.
+func podReferences(spec *corev1.PodSpec) (configMaps, secrets []string) {
+	cms, ss := map[string]bool{}, map[string]bool{}
+	for _, volume := range spec.Volumes {
+		if volume.ConfigMap != nil {
+			cms[volume.ConfigMap.Name] = true
+		}
+		if volume.Secret != nil {
+			ss[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					cms[source.ConfigMap.Name] = true
+				}
+				if source.Secret != nil {
+					ss[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				cms[envFrom.ConfigMapRef.Name] = true
+			}
+			if envFrom.SecretRef != nil {
+				ss[envFrom.SecretRef.Name] = true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				cms[env.ValueFrom.ConfigMapKeyRef.Name] = true
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				ss[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+	}
+	for _, pullSecret := range spec.ImagePullSecrets {
+		ss[pullSecret.Name] = true
+	}
+	return sortedKeys(cms), sortedKeys(ss)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// installRelocated installs the releases which are not running under the new names yet in the target namespace.
+func (c *Controller) installRelocated(migrateCopy *v1.Migrate, local *target) error {
+	status := migrateCopy.Status.Relocation
+	running, err := runningReleaseNames(migrateCopy, local)
+	if err != nil {
+		return err
+	}
+	for _, rls := range migrateCopy.Spec.Releases {
+		name := status.Releases[rls.Name]
+		if running[name] {
+			continue
+		}
+		installed, err := local.helmClient.InstallRelease(migrateCopy.Spec.Relocation.TargetNamespace, name, migrateCopy.Spec.Chart, rls.Raw)
+		if err != nil {
+			return errors.Wrapf(err, "install release %s", name)
+		}
+		c.recorder.Event(migrateCopy, corev1.EventTypeNormal, SuccessInstalledStatus,
+			fmt.Sprintf("Install release [%s] in namespace [%s] successfully, version : %d",
+				name, migrateCopy.Spec.Relocation.TargetNamespace, installed.Version))
+	}
+	return nil
+}
+
+// checkRelocated checks that the deployments of all new releases are available. They are read through the API
+// instead of the informers, as the target namespace may be not watched by the operator.
+func (c *Controller) checkRelocated(migrateCopy *v1.Migrate) error {
+	status := migrateCopy.Status.Relocation
+	targetNamespace := migrateCopy.Spec.Relocation.TargetNamespace
+	for _, rls := range migrateCopy.Spec.Releases {
+		name := status.Releases[rls.Name]
+		selector := labels.SelectorFromSet(labels.Set{constant.ReleaseLabel: name})
+		deployments, err := c.kubeclientset.AppsV1().Deployments(targetNamespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return errors.Wrapf(err, "list deployments of release %s", name)
+		}
+		available := len(deployments.Items) > 0
+		for i := range deployments.Items {
+			available = available && deploymentAvailable(&deployments.Items[i], rls.Replicas)
+		}
+		if !available {
+			return errors.Errorf("the deployment of release %s is not available yet", name)
+		}
+	}
+	return nil
+}
+
+func relocationTimedOut(migrateCopy *v1.Migrate) bool {
+	timeout := defaultRelocationTimeout
+	if seconds := migrateCopy.Spec.Relocation.TimeoutSeconds; seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	installed := migrateCopy.Status.Relocation.InstalledTime
+	return installed != nil && time.Since(installed.Time) > timeout
+}
+
+// rollbackRelocation uninstalls the new releases and deletes the copied configmaps and secrets.
+func (c *Controller) rollbackRelocation(migrateCopy *v1.Migrate, local *target) error {
+	status := migrateCopy.Status.Relocation
+	targetNamespace := migrateCopy.Spec.Relocation.TargetNamespace
+	running, err := runningReleaseNames(migrateCopy, local)
+	if err != nil {
+		return err
+	}
+	for _, rls := range migrateCopy.Spec.Releases {
+		if name := status.Releases[rls.Name]; running[name] {
+			if err := local.helmClient.UninstallRelease(name); err != nil {
+				return errors.Wrapf(err, "uninstall release %s", name)
+			}
+		}
+	}
+	for _, name := range status.CopiedConfigMaps {
+		err := c.traceWrite(migrateCopy, "delete", "configmaps", targetNamespace, name, func() error {
+			return c.kubeclientset.CoreV1().ConfigMaps(targetNamespace).Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete configmap %s/%s", targetNamespace, name)
+		}
+	}
+	for _, name := range status.CopiedSecrets {
+		err := c.traceWrite(migrateCopy, "delete", "secrets", targetNamespace, name, func() error {
+			return c.kubeclientset.CoreV1().Secrets(targetNamespace).Delete(name, &metav1.DeleteOptions{})
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete secret %s/%s", targetNamespace, name)
+		}
+	}
+	return nil
+}
+
+// uninstallRelocated uninstalls the old releases once the new ones are available.
+func (c *Controller) uninstallRelocated(migrateCopy *v1.Migrate, local *target) error {
+	running, err := runningReleaseNames(migrateCopy, local)
+	if err != nil {
+		return err
+	}
+	for _, rls := range migrateCopy.Spec.Releases {
+		if !running[rls.Name] {
+			continue
+		}
+		if err := local.helmClient.UninstallRelease(rls.Name); err != nil {
+			return errors.Wrapf(err, "uninstall release %s", rls.Name)
+		}
+		c.recorder.Event(migrateCopy, corev1.EventTypeNormal, PrunedRelease,
+			fmt.Sprintf("Release [%s] has been uninstalled after relocating to [%s]", rls.Name, migrateCopy.Status.Relocation.Releases[rls.Name]))
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const (
+	relocationNamespace = "team-b"
+	relocatedRelease    = "demo-gz01-team-b-blue"
+)
+
+func newRelocation(releases ...string) *v1.Migrate {
+	migrate := newMigrate(releases...)
+	migrate.Spec.Action = v1.MigrateActionRelocate
+	migrate.Spec.Relocation = &v1.RelocationConfig{TargetNamespace: relocationNamespace, TimeoutSeconds: 60}
+	return migrate
+}
+
+// newReferencingDeployment returns the deployment of the release referencing a configmap and a secret.
+func newReferencingDeployment(rlsName string) *apps.Deployment {
+	deploy := newDeployment(rlsName, 2)
+	deploy.Spec.Template.Spec = corev1.PodSpec{
+		Volumes: []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "demo-config"}}}}},
+		Containers: []corev1.Container{{Name: "demo", EnvFrom: []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "demo-secret"}}}}}},
+	}
+	return deploy
+}
+
+func newRelocatedDeployment(available int32) *apps.Deployment {
+	deploy := newDeployment(relocatedRelease, available)
+	deploy.Namespace = relocationNamespace
+	return deploy
+}
+
+func TestRelocate(t *testing.T) {
+	references := []*corev1.ConfigMap{{ObjectMeta: metav1.ObjectMeta{Name: "demo-config", Namespace: metav1.NamespaceDefault},
+		Data: map[string]string{"key": "value"}}}
+	secrets := []*corev1.Secret{{ObjectMeta: metav1.ObjectMeta{Name: "demo-secret", Namespace: metav1.NamespaceDefault},
+		Data: map[string][]byte{"password": []byte("secret")}}}
+
+	tests := []struct {
+		name        string
+		migrate     *v1.Migrate
+		running     []*release.Release
+		deployments []*apps.Deployment
+		// The deployments which are only found through the API, their namespace is not watched.
+		unwatchedDeployments []*apps.Deployment
+		// The actions expected to happen on the release backend.
+		expectedActions []string
+		expectedPhase   v1.RelocationPhase
+		// Whether the configmap is expected in the target namespace after the sync.
+		expectedCopy bool
+	}{
+		{
+			name:            "relocate to target namespace",
+			migrate:         newRelocation(blueRelease),
+			running:         []*release.Release{runningRelease(blueRelease, 1)},
+			deployments:     []*apps.Deployment{newReferencingDeployment(blueRelease), newRelocatedDeployment(2)},
+			expectedActions: []string{"list", "install/" + relocatedRelease, "list", "uninstall/" + blueRelease},
+			expectedPhase:   v1.RelocationCompleted,
+			expectedCopy:    true,
+		},
+		{
+			name:                 "relocate to namespace which is not watched",
+			migrate:              newRelocation(blueRelease),
+			running:              []*release.Release{runningRelease(blueRelease, 1)},
+			deployments:          []*apps.Deployment{newReferencingDeployment(blueRelease)},
+			unwatchedDeployments: []*apps.Deployment{newRelocatedDeployment(2)},
+			expectedActions:      []string{"list", "install/" + relocatedRelease, "list", "uninstall/" + blueRelease},
+			expectedPhase:        v1.RelocationCompleted,
+			expectedCopy:         true,
+		},
+		{
+			name:            "wait for relocated release",
+			migrate:         newRelocation(blueRelease),
+			running:         []*release.Release{runningRelease(blueRelease, 1)},
+			deployments:     []*apps.Deployment{newReferencingDeployment(blueRelease), newRelocatedDeployment(1)},
+			expectedActions: []string{"list", "install/" + relocatedRelease},
+			expectedPhase:   v1.RelocationInstalled,
+			expectedCopy:    true,
+		},
+		{
+			name: "roll back once timed out",
+			migrate: withRelocationStatus(newRelocation(blueRelease), &v1.RelocationStatus{
+				Phase:            v1.RelocationInstalled,
+				InstalledTime:    &metav1.Time{Time: time.Now().Add(-time.Hour)},
+				CopiedConfigMaps: []string{"demo-config"},
+				CopiedSecrets:    []string{"demo-secret"},
+			}),
+			running:         []*release.Release{runningRelease(blueRelease, 1), runningRelease(relocatedRelease, 1)},
+			deployments:     []*apps.Deployment{newReferencingDeployment(blueRelease), newRelocatedDeployment(1)},
+			expectedActions: []string{"list", "uninstall/" + relocatedRelease},
+			expectedPhase:   v1.RelocationRolledBack,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.running...)
+			for _, d := range test.deployments {
+				f.addDeployment(d)
+			}
+			for _, d := range test.unwatchedDeployments {
+				f.kubeobjects = append(f.kubeobjects, d)
+			}
+			f.kubeobjects = append(f.kubeobjects, references[0].DeepCopy(), secrets[0].DeepCopy())
+			if test.migrate.Status.Relocation != nil {
+				// The copies made by the previous syncs.
+				cm := references[0].DeepCopy()
+				cm.Namespace = relocationNamespace
+				f.kubeobjects = append(f.kubeobjects, cm)
+			}
+			f.addMigrate(test.migrate)
+
+			updated := f.run(test.migrate)
+			checkActions(t, test.expectedActions, f.backend.Actions)
+			if updated == nil || updated.Status.Relocation == nil {
+				t.Fatalf("expected the relocation status to be updated")
+			}
+			if phase := updated.Status.Relocation.Phase; phase != test.expectedPhase {
+				t.Errorf("expected phase %s, got %s: %s", test.expectedPhase, phase, updated.Status.Relocation.Message)
+			}
+			if rls := f.backend.Release(relocatedRelease); test.expectedPhase == v1.RelocationCompleted &&
+				(rls == nil || rls.Namespace != relocationNamespace) {
+				t.Errorf("expected release %s to be installed in namespace %s", relocatedRelease, relocationNamespace)
+			}
+
+			_, err := f.kubeclient.CoreV1().ConfigMaps(relocationNamespace).Get("demo-config", metav1.GetOptions{})
+			if copied := err == nil; copied != test.expectedCopy {
+				t.Errorf("expected the configmap to be copied: %v, got %v", test.expectedCopy, copied)
+			}
+			_, err = f.kubeclient.CoreV1().Secrets(relocationNamespace).Get("demo-secret", metav1.GetOptions{})
+			if copied := err == nil; copied != test.expectedCopy {
+				t.Errorf("expected the secret to be copied: %v, got %v", test.expectedCopy, copied)
+			}
+		})
+	}
+}
+
+func TestRelocateWithInvalidName(t *testing.T) {
+	f := newFixture(t, runningRelease(blueRelease, 1))
+	migrate := newRelocation(blueRelease)
+	migrate.Spec.Relocation.ReleaseNames = map[string]string{blueRelease: "other-app-blue"}
+	f.addMigrate(migrate)
+
+	if updated := f.run(migrate); updated != nil {
+		t.Errorf("expected the migrate not to be updated")
+	}
+	checkActions(t, nil, f.backend.Actions)
+	checkEvents(t, []string{ReasonRelocationFailed}, f.events())
+}
+
+func TestPodReferences(t *testing.T) {
+	spec := &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls"}}},
+			{VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "projected"}}}}}}},
+		},
+		InitContainers: []corev1.Container{{Env: []corev1.EnvVar{{ValueFrom: &corev1.EnvVarSource{
+			ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "init"}}}}}}},
+		Containers: []corev1.Container{{Env: []corev1.EnvVar{{Value: "plain"}, {ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}}}}}}},
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+	}
+
+	configMaps, secrets := podReferences(spec)
+	if expected := []string{"init", "projected"}; !reflect.DeepEqual(expected, configMaps) {
+		t.Errorf("expected configmaps %v, got %v", expected, configMaps)
+	}
+	if expected := []string{"db", "registry", "tls"}; !reflect.DeepEqual(expected, secrets) {
+		t.Errorf("expected secrets %v, got %v", expected, secrets)
+	}
+}
+
+func withRelocationStatus(migrate *v1.Migrate, status *v1.RelocationStatus) *v1.Migrate {
+	migrate.Status.Relocation = status
+	return migrate
+}
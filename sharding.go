@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// shardOwner tells which shards of the migrates are held by this replica, the migrates are sharded by app name.
+type shardOwner interface {
+	Owns(appName string) bool
+	ShardOf(appName string) int
+	// Begin marks the migrates of the app name as being synced, their shard is not released until done is called.
+	Begin(appName string) (done func(), ok bool)
+}
+
+// ownsMigrate tells whether the migrate is synced by this replica, all migrates are when they are not sharded.
+func (c *Controller) ownsMigrate(migrate *v1.Migrate) bool {
+	return c.shards == nil || c.shards.Owns(migrate.Spec.AppName)
+}
+
+// beginSync marks the migrate as being synced if it is owned by this replica, its shard is kept until done is called.
+func (c *Controller) beginSync(migrate *v1.Migrate) (done func(), ok bool) {
+	if c.shards == nil {
+		return func() {}, true
+	}
+	return c.shards.Begin(migrate.Spec.AppName)
+}
+
+// enqueueShard enqueues the migrates of a shard which has just been acquired by this replica.
+func (c *Controller) enqueueShard(shard int) {
+	migrates, err := c.symLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, migrate := range migrates {
+		if c.shards.ShardOf(migrate.Spec.AppName) == shard {
+			c.enqueueMigrate(migrate)
+		}
+	}
+	log.WithComponent("sharder").Infof("Enqueued the migrates of shard %d", shard)
+}
+
+// runWhileHolding runs the function while holding returns true, until the stop channel is closed. The channel passed
+// to the function is closed once holding turns false, and it is run again once holding turns true again.
+func runWhileHolding(holding func() bool, run func(<-chan struct{}), period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if !holding() {
+			return
+		}
+		holdingCh := make(chan struct{})
+		go func() {
+			defer close(holdingCh)
+			wait.PollImmediateUntil(period, func() (bool, error) { return !holding(), nil }, stopCh)
+		}()
+		run(holdingCh)
+	}, period, stopCh)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fakeShards holds the shards in the set, each app name is in the shard of the same name.
+type fakeShards map[string]bool
+
+func (s fakeShards) Owns(appName string) bool {
+	return s[appName]
+}
+
+func (s fakeShards) Begin(appName string) (func(), bool) {
+	return func() {}, s[appName]
+}
+
+func (s fakeShards) ShardOf(appName string) int {
+	if s[appName] {
+		return 1
+	}
+	return 0
+}
+
+func TestSyncOnlyOwnedMigrates(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards fakeShards
+		// The actions expected to happen on the release backend.
+		expectedActions []string
+	}{
+		{
+			name:            "sync migrate of held shard",
+			shards:          fakeShards{testApp: true},
+			expectedActions: []string{"list", "install/" + blueRelease},
+		},
+		{
+			name:   "skip migrate of other shard",
+			shards: fakeShards{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.shards = test.shards
+			migrate := newMigrate(blueRelease)
+			f.addMigrate(migrate)
+
+			f.run(migrate)
+			checkActions(t, test.expectedActions, f.backend.Actions)
+		})
+	}
+}
+
+func TestEnqueueShard(t *testing.T) {
+	f := newFixture(t)
+	f.shards = fakeShards{testApp: true}
+	f.addMigrate(newMigrate(blueRelease))
+	c, _, _ := f.newController()
+
+	c.enqueueShard(0)
+	if c.workqueue.Len() != 0 {
+		t.Errorf("expected no migrate of shard 0 to be enqueued, got %d", c.workqueue.Len())
+	}
+	c.enqueueShard(1)
+	if c.workqueue.Len() != 1 {
+		t.Errorf("expected the migrate of shard 1 to be enqueued, got %d", c.workqueue.Len())
+	}
+}
+
+func TestRunWhileHolding(t *testing.T) {
+	var mu sync.Mutex
+	holding := false
+	setHolding := func(h bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		holding = h
+	}
+	started := make(chan struct{}, 2)
+	stopped := make(chan struct{}, 2)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go runWhileHolding(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return holding
+	}, func(holdingCh <-chan struct{}) {
+		started <- struct{}{}
+		<-holdingCh
+		stopped <- struct{}{}
+	}, 10*time.Millisecond, stopCh)
+
+	expect := func(ch chan struct{}, what string) {
+		select {
+		case <-ch:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("expected the function to be %s", what)
+		}
+	}
+	select {
+	case <-started:
+		t.Fatalf("expected the function not to run before the shard is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	setHolding(true)
+	expect(started, "started once the shard is held")
+	setHolding(false)
+	expect(stopped, "stopped once the shard is released")
+	setHolding(true)
+	expect(started, "started again once the shard is held again")
+}
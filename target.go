@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/helm"
+	symlabels "github.com/yangyongzhi/sym-operator/pkg/labels"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const RescheduledRelease = "RescheduledRelease"
+
+// target is a cluster which the releases of a migrate are deployed to.
+type target struct {
+	// cluster is the name of the kubeconfig secret, it is empty for the cluster the operator runs in.
+	cluster string
+	// releases are the names of the releases deployed to the cluster.
+	releases   map[string]bool
+	helmClient helm.ReleaseBackend
+	// listDeployments lists the deployments in the namespace of the cluster.
+	listDeployments func(namespace string, selector labels.Selector) ([]*appsv1.Deployment, error)
+	// release returns the helm client to its pool once the target is not used any more.
+	release func()
+}
+
+// location describes the cluster in the messages, it is empty for the cluster the operator runs in.
+func (t *target) location() string {
+	if t.cluster == "" {
+		return ""
+	}
+	return fmt.Sprintf(" in cluster [%s]", t.cluster)
+}
+
+// logFor adds the cluster to the logger, it is not added for the cluster the operator runs in.
+func (t *target) logFor(logger *log.Entry) *log.Entry {
+	if t.cluster == "" {
+		return logger
+	}
+	return logger.WithField(log.FieldCluster, t.cluster)
+}
+
+// resolveTargets returns the clusters which the releases of the migrate are deployed to, the cluster the operator
+// runs in always comes first so that the releases removed from it can be pruned. The registered clusters which the
+// releases have been scheduled to are returned by the release names. The targets are released by the caller.
+func (c *Controller) resolveTargets(migrate *v1.Migrate) ([]*target, map[string]string, error) {
+	local, err := c.localTarget(migrate)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets := []*target{local}
+
+	scheduled := map[string]string{}
+	for _, rls := range migrate.Spec.Releases {
+		secretName, tillerNamespace := rls.TargetCluster, migrate.Spec.TillerNamespace
+		if secretName == "" && rls.ClusterSelector != nil {
+			registered, err := c.scheduleRelease(migrate, rls)
+			if err != nil {
+				releaseTargets(targets)
+				return nil, nil, err
+			}
+			scheduled[rls.Name] = registered.Name
+			secretName = registered.Spec.KubeconfigSecret
+			if registered.Spec.TillerNamespace != "" {
+				tillerNamespace = registered.Spec.TillerNamespace
+			}
+		}
+
+		t := findTarget(targets, secretName)
+		if t == nil {
+			if t, err = c.newRemoteTarget(migrate, secretName, tillerNamespace); err != nil {
+				releaseTargets(targets)
+				return nil, nil, errors.Wrapf(err, "release %s", rls.Name)
+			}
+			targets = append(targets, t)
+		}
+		t.releases[rls.Name] = true
+	}
+	return targets, scheduled, nil
+}
+
+// releaseTargets returns the helm clients of the targets to their pools.
+func releaseTargets(targets []*target) {
+	for _, t := range targets {
+		t.release()
+	}
+}
+
+// clusterTarget returns the cluster whose kubeconfig is held by the secret, or the cluster the operator runs in if
+// the secret name is empty.
+func (c *Controller) clusterTarget(migrate *v1.Migrate, secretName string) (*target, error) {
+	if secretName == "" {
+		return c.localTarget(migrate)
+	}
+	return c.newRemoteTarget(migrate, secretName, migrate.Spec.TillerNamespace)
+}
+
+// localTarget returns the cluster the operator runs in with the tiller of the migrate, the helm operations on the
+// releases of the migrate are recorded in the metrics and traced within the sync, and audited. The deployments of
+// the namespaces which are not watched are read through the API, so a release outside the watched namespaces is not
+// taken as having no deployments.
+func (c *Controller) localTarget(migrate *v1.Migrate) (*target, error) {
+	helmClient, release, err := c.helmClients.Get(migrate.Spec.TillerNamespace)
+	if err != nil {
+		return nil, err
+	}
+	listFromAPI := clientDeploymentLister(c.kubeclientset)
+	return &target{
+		release:    release,
+		releases:   map[string]bool{},
+		helmClient: c.audited(migrate, "", helm.Instrument(helmClient, migrate.Spec.AppName, c.traceOf(migrate))),
+		listDeployments: func(namespace string, selector labels.Selector) ([]*appsv1.Deployment, error) {
+			deployments, err := c.deploymentsLister.Deployments(namespace).List(selector)
+			if isNamespaceNotWatched(err) {
+				return listFromAPI(namespace, selector)
+			}
+			return deployments, err
+		},
+	}, nil
+}
+
+func (c *Controller) newRemoteTarget(migrate *v1.Migrate, secretName, tillerNamespace string) (*target, error) {
+	if c.clusters == nil {
+		return nil, errors.Errorf("target cluster %s is not supported, multi-cluster is not enabled", secretName)
+	}
+	cluster, err := c.clusters.Get(migrate.Namespace, secretName)
+	if err != nil {
+		return nil, err
+	}
+	helmClient, release, err := cluster.HelmClients.Get(tillerNamespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cluster %s", secretName)
+	}
+	return &target{
+		release:         release,
+		cluster:         secretName,
+		releases:        map[string]bool{},
+		helmClient:      c.audited(migrate, secretName, helm.Instrument(helmClient, migrate.Spec.AppName, c.traceOf(migrate))),
+		listDeployments: clientDeploymentLister(cluster.KubeClient),
+	}, nil
+}
+
+// scheduleRelease picks the registered cluster for the release, a scheduled release stays in its cluster as long as
+// the cluster is still selected, healthy and schedulable, otherwise the first healthy and schedulable one is picked
+// by name. A release stays in its unhealthy or unschedulable cluster if no other one can be picked.
+func (c *Controller) scheduleRelease(migrate *v1.Migrate, rls *v1.ReleasesConfig) (*v1.Cluster, error) {
+	if c.clustersSynced == nil {
+		return nil, errors.Errorf("release %s has a cluster selector but the cluster registry is disabled, see -cluster-registry",
+			rls.Name)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(rls.ClusterSelector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cluster selector of release %s", rls.Name)
+	}
+
+	// current is the selected cluster which the release has been scheduled to, but which is not available any more.
+	var current *v1.Cluster
+	if name, ok := migrate.Status.ReleaseClusters[rls.Name]; ok {
+		registered, err := c.clustersLister.Clusters(migrate.Namespace).Get(name)
+		if err == nil && selector.Matches(clusterLabels(registered)) {
+			if schedulable(registered) {
+				return registered, nil
+			}
+			current = registered
+		}
+	}
+
+	registered, err := c.clustersLister.Clusters(migrate.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*v1.Cluster
+	for _, cluster := range registered {
+		if schedulable(cluster) && selector.Matches(clusterLabels(cluster)) {
+			candidates = append(candidates, cluster)
+		}
+	}
+	if len(candidates) == 0 {
+		if current != nil {
+			c.logFor(migrate).WithField(log.FieldRelease, rls.Name).Warningf(
+				"The release stays in cluster [%s] which is unhealthy or unschedulable, no other cluster matches its cluster selector",
+				current.Name)
+			return current, nil
+		}
+		return nil, errors.Errorf("no healthy cluster matches the cluster selector %q of release %s", selector, rls.Name)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	if current != nil {
+		c.recorder.Event(migrate, corev1.EventTypeWarning, RescheduledRelease,
+			fmt.Sprintf("Release [%s] is rescheduled from cluster [%s] which is unhealthy or unschedulable to [%s]",
+				rls.Name, current.Name, candidates[0].Name))
+	}
+	return candidates[0], nil
+}
+
+// schedulable tells whether releases can be scheduled to the registered cluster.
+func schedulable(cluster *v1.Cluster) bool {
+	return cluster.Status.Healthy && !cluster.Spec.Unschedulable
+}
+
+// clusterLabels returns the labels of the registered cluster with the ldc and az of its spec.
+func clusterLabels(cluster *v1.Cluster) labels.Set {
+	set := labels.Set{}
+	for k, v := range cluster.Labels {
+		set[k] = v
+	}
+	if cluster.Spec.LDC != "" {
+		set[symlabels.LabelLdcName] = cluster.Spec.LDC
+	}
+	if cluster.Spec.AZ != "" {
+		set[symlabels.LabelAzName] = cluster.Spec.AZ
+	}
+	return set
+}
+
+// clientDeploymentLister lists the deployments from the api server, there are no informers for the remote clusters
+// so their status is refreshed with the resync of the migrates.
+func clientDeploymentLister(kubeClient kubernetes.Interface) func(string, labels.Selector) ([]*appsv1.Deployment, error) {
+	return func(namespace string, selector labels.Selector) ([]*appsv1.Deployment, error) {
+		list, err := kubeClient.AppsV1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		deployments := make([]*appsv1.Deployment, 0, len(list.Items))
+		for i := range list.Items {
+			deployments = append(deployments, &list.Items[i])
+		}
+		return deployments, nil
+	}
+}
+
+func findTarget(targets []*target, cluster string) *target {
+	for _, t := range targets {
+		if t.cluster == cluster {
+			return t
+		}
+	}
+	return nil
+}
+
+// targetOf returns the cluster which the release is deployed to.
+func targetOf(targets []*target, rlsName string) *target {
+	for _, t := range targets {
+		if t.releases[rlsName] {
+			return t
+		}
+	}
+	return nil
+}
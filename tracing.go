@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"github.com/yangyongzhi/sym-operator/pkg/tracing"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// TraceIDAnnotation annotates the events recorded while syncing a migrate with the ID of the trace of the sync.
+const TraceIDAnnotation = "sym-operator.dmall.com/trace-id"
+
+// activeTraces holds the root span of each migrate being synced by its namespace/name key. The work queue never
+// hands a key to two workers at once, so a key has at most one active trace.
+type activeTraces struct {
+	mu    sync.RWMutex
+	spans map[string]*tracing.Span
+}
+
+func newActiveTraces() *activeTraces {
+	return &activeTraces{spans: map[string]*tracing.Span{}}
+}
+
+func (a *activeTraces) set(key string, span *tracing.Span) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spans[key] = span
+}
+
+func (a *activeTraces) delete(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.spans, key)
+}
+
+// get returns the span of the object being synced, it is nil if the object is not being synced.
+func (a *activeTraces) get(obj interface{}) *tracing.Span {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.spans[key]
+}
+
+// traceOf returns the span of the sync of the migrate, the helm calls and the writes of the sync are its children.
+func (c *Controller) traceOf(migrate *v1.Migrate) *tracing.Span {
+	return c.traces.get(migrate)
+}
+
+// logFor returns the logger of the migrate, its lines carry the key, the app and the reconcile ID of the sync in
+// progress, which is the ID of its trace.
+func (c *Controller) logFor(migrate *v1.Migrate) *log.Entry {
+	fields := log.Fields{log.FieldMigrate: migrate.Namespace + "/" + migrate.Name, log.FieldApp: migrate.Spec.AppName}
+	if span := c.traceOf(migrate); span != nil {
+		fields[log.FieldReconcileID] = span.TraceID().String()
+	}
+	return log.WithFields(fields)
+}
+
+// traceWrite records the write to the Kubernetes API as a child span of the sync of the migrate.
+func (c *Controller) traceWrite(migrate *v1.Migrate, verb, resource, namespace, name string, write func() error) error {
+	span := c.traceOf(migrate).StartChild("kubernetes."+verb,
+		tracing.String("k8s.resource", resource), tracing.String("k8s.namespace", namespace), tracing.String("k8s.name", name))
+	err := write()
+	span.End(err)
+	return err
+}
+
+// tracedRecorder appends the trace ID to the events of the migrates being synced and annotates them with it, so a
+// slow sync can be looked up in the collector from its events.
+type tracedRecorder struct {
+	record.EventRecorder
+	traces *activeTraces
+}
+
+func newTracedRecorder(recorder record.EventRecorder, traces *activeTraces) record.EventRecorder {
+	return &tracedRecorder{EventRecorder: recorder, traces: traces}
+}
+
+func (r *tracedRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.Eventf(object, eventtype, reason, "%s", message)
+}
+
+func (r *tracedRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	span := r.traces.get(object)
+	if span == nil {
+		r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+		return
+	}
+	traceID := span.TraceID().String()
+	message := fmt.Sprintf("%s (trace %s)", fmt.Sprintf(messageFmt, args...), traceID)
+	r.EventRecorder.AnnotatedEventf(object, map[string]string{TraceIDAnnotation: traceID}, eventtype, reason, "%s", message)
+}
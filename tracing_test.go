@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yangyongzhi/sym-operator/pkg/apis/devops/v1"
+	"github.com/yangyongzhi/sym-operator/pkg/log"
+	"github.com/yangyongzhi/sym-operator/pkg/tracing"
+)
+
+// collector is a local OTLP collector recording the names of the exported spans by their trace IDs.
+type collector struct {
+	mu    sync.Mutex
+	spans map[string][]string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID string `json:"traceId"`
+					Name    string `json:"name"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				c.spans[span.TraceID] = append(c.spans[span.TraceID], span.Name)
+			}
+		}
+	}
+}
+
+func TestSyncIsTraced(t *testing.T) {
+	received := &collector{spans: map[string][]string{}}
+	server := httptest.NewServer(received)
+	defer server.Close()
+
+	f := newFixture(t)
+	migrate := newMigrate(blueRelease)
+	f.migrateLister = append(f.migrateLister, migrate)
+	f.objects = append(f.objects, migrate)
+	c, i, k8sI := f.newController()
+	c.tracer = tracing.NewTracer(tracing.NewOTLPExporter(server.URL, "sym-operator", time.Second))
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
+
+	if err := c.syncHandler(getKey(migrate, t)); err != nil {
+		t.Fatalf("error syncing migrate: %v", err)
+	}
+	c.tracer.Flush()
+
+	if len(received.spans) != 1 {
+		t.Fatalf("expected the spans of one trace, got %v", received.spans)
+	}
+	for traceID, spans := range received.spans {
+		expected := []string{"helm.list", "helm.install", "kubernetes.update", getKey(migrate, t)}
+		if !reflect.DeepEqual(spans, expected) {
+			t.Errorf("expected the spans %v, got %v", expected, spans)
+		}
+		events := drainEvents(f)
+		if len(events) == 0 {
+			t.Errorf("expected the events of the sync")
+		}
+		for _, event := range events {
+			if !strings.Contains(event, "(trace "+traceID+")") {
+				t.Errorf("expected the trace %s in the event %q", traceID, event)
+			}
+		}
+	}
+	if span := c.traceOf(migrate); span != nil {
+		t.Errorf("expected no active trace once synced, got %s", span.TraceID())
+	}
+}
+
+func TestSyncLogsCarryReconcileID(t *testing.T) {
+	out := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(out))
+
+	f := newFixture(t)
+	migrate := newMigrate(blueRelease)
+	f.migrateLister = append(f.migrateLister, migrate)
+	f.objects = append(f.objects, migrate)
+	c, i, k8sI := f.newController()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	i.Start(stopCh)
+	k8sI.Start(stopCh)
+
+	key := getKey(migrate, t)
+	if err := c.syncHandler(key); err != nil {
+		t.Fatalf("error syncing migrate: %v", err)
+	}
+	reconcileID := c.syncStates.get(key).LastTraceID
+	if reconcileID == "" {
+		t.Fatalf("expected the trace ID of the sync")
+	}
+
+	var migrateLines, helmLines int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("expected a JSON line, got %q: %v", line, err)
+		}
+		if fields[log.FieldReconcileID] != reconcileID {
+			continue
+		}
+		if fields[log.FieldApp] != migrate.Spec.AppName {
+			t.Errorf("expected the app %s in the line %q", migrate.Spec.AppName, line)
+		}
+		if fields[log.FieldMigrate] == key {
+			migrateLines++
+		}
+		if fields[log.FieldOperation] == "install" && fields[log.FieldRelease] == blueRelease {
+			helmLines++
+		}
+	}
+	if migrateLines == 0 || helmLines != 1 {
+		t.Errorf("expected the lines of the migrate and of the install of %s with the reconcile ID %s, got:\n%s",
+			blueRelease, reconcileID, out.String())
+	}
+}
+
+func TestEventsOutsideOfSyncHaveNoTrace(t *testing.T) {
+	f := newFixture(t)
+	c, _, _ := f.newController()
+	c.recorder.Event(newMigrate(), "Normal", SuccessSynced, MessageResourceSynced)
+	c.recorder.Event(&v1.Migrate{}, "Normal", SuccessSynced, MessageResourceSynced)
+
+	for _, event := range drainEvents(f) {
+		if strings.Contains(event, "trace") {
+			t.Errorf("expected no trace in the event %q", event)
+		}
+	}
+}
+
+func drainEvents(f *fixture) []string {
+	var events []string
+	for {
+		select {
+		case event := <-f.recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}